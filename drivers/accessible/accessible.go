@@ -0,0 +1,271 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package accessible implements a syscall driver for screen readers and
+// braille displays. Instead of redrawing the screen in place, it writes
+// plain lines of text that describe what is going on:
+//
+//	Loaded Pong.
+//	Waiting for a key.
+//	Score: 3.
+//	Beep.
+//
+// Announced events are the program's title when it's loaded, beeps, the
+// program starting to wait for a key, changes to the numbers that the
+// program draws with the usual score drawing idiom (see
+// hachi.ScoreDisplays) and errors that halt the emulator.
+//
+// The screen itself can also be written as high contrast text, with # for
+// lit pixels and . for the others, every time it stops changing for half a
+// second. This is enough to follow title screens and menus, and is enabled
+// through SetDriverData("display", "text"). The default is "off".
+//
+// The driver takes over stdin and stdout. The caller must call Tick() on the
+// emulator until the channel returned by GetDriverData("quit") is closed,
+// which happens when the user presses Ctrl-C, and then call Shutdown() to
+// restore the terminal.
+//
+// Keys are bound according to the key layout from the settings (or the octo
+// layout if none is set). The layout can be changed at runtime through
+// SetDriverData("key_layout", name).
+//
+// The beep is silent by default, a sound backend can be picked through
+// SetDriverData("beeper", name), for example "bell" to ring the terminal bell
+// (see package beep).
+package accessible
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Francesco149/go-hachi/drivers/beep"
+	"github.com/Francesco149/go-hachi/drivers/internal/term"
+	"github.com/Francesco149/go-hachi/hachi"
+	"log"
+	"os"
+	"reflect"
+	"time"
+)
+
+// how long the screen must stay the same before it's written out
+const settleTime = time.Second / 2
+
+// beeps closer than this to the previous one are not announced
+const beepInterval = time.Second / 4
+
+// An AccessibleDriver is a terminal driver that describes the emulator's
+// output as lines of text.
+type AccessibleDriver struct {
+	hachi.Driver
+	t        *term.Terminal
+	beeper   beep.Beeper
+	display  bool
+	dirty    bool
+	changed  time.Time
+	lastBeep time.Time
+	waiting  bool
+	// LD [I],BCD VX instructions of the score displays, mapped to X
+	scores map[uint16]uint8
+	// last announced value of each score display
+	announced map[uint16]uint8
+	buf       bytes.Buffer
+}
+
+func (d *AccessibleDriver) OnInit(c *hachi.Chip8) {
+	d.beeper = beep.Silent{}
+	layout := c.KeyLayout()
+	if layout == nil {
+		layout = hachi.KeyLayouts["octo"]
+	}
+
+	// a driver instance is shared by every emulator that uses it, so clean
+	// up after the previous one
+	if d.t != nil {
+		d.t.Close()
+	}
+	d.t = term.New(os.Stdin, os.Stdout, layout)
+	d.scores = nil
+	d.announced = make(map[uint16]uint8)
+	d.waiting = false
+
+	c.Logger().Println("AccessibleDriver initialized")
+}
+
+// say writes a line of text. The terminal is in raw mode, so lines must end
+// with \r\n.
+func (d *AccessibleDriver) say(format string, args ...interface{}) {
+	fmt.Fprintf(d.t, format+"\r\n", args...)
+}
+
+// OnLoad announces the program and looks for its score displays.
+func (d *AccessibleDriver) OnLoad(c *hachi.Chip8, info hachi.RomInfo) {
+	if title := info.Title(); title != "" {
+		d.say("Loaded %s.", title)
+	} else {
+		d.say("Loaded a program.")
+	}
+	end := int(info.Base) + info.Size
+	scores, err := hachi.ScoreDisplays(c.Memory[info.Base:end], info.Base)
+	if err != nil {
+		c.Logger().Println("AccessibleDriver:", err)
+	}
+	d.scores = scores
+	d.announced = make(map[uint16]uint8)
+}
+
+// Cls doesn't need to do anything, the screen buffer is cleared by the
+// emulator and UpdateScreen will be called on the next draw.
+func (d *AccessibleDriver) Cls() {}
+
+func (d *AccessibleDriver) OnUpdate(c *hachi.Chip8) {
+	c.Keyboard = d.t.Keyboard()
+
+	waiting := c.WaitingForKey()
+	if waiting && !d.waiting {
+		d.say("Waiting for a key.")
+	}
+	d.waiting = waiting
+
+	// OnUpdate runs before the instruction at PC, so a score display's
+	// register still holds the number about to be drawn
+	if x, ok := d.scores[c.PC]; ok && !waiting {
+		value, seen := d.announced[c.PC]
+		if !seen || value != c.V[x] {
+			d.announced[c.PC] = c.V[x]
+			if len(d.scores) == 1 {
+				d.say("Score: %d.", c.V[x])
+			} else {
+				d.say("Score V%X: %d.", x, c.V[x])
+			}
+		}
+	}
+
+	if d.display && d.dirty && time.Since(d.changed) >= settleTime {
+		d.dirty = false
+		d.writeScreen(c)
+	}
+}
+
+// writeScreen writes the screen as lines of # and .
+func (d *AccessibleDriver) writeScreen(c *hachi.Chip8) {
+	w, h := c.DisplaySize()
+	screen := c.DisplayScreen()
+	d.buf.Reset()
+	d.buf.WriteString("Screen:\r\n")
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if screen[y*w/8+x/8]&(0x80>>uint(x%8)) != 0 {
+				d.buf.WriteByte('#')
+			} else {
+				d.buf.WriteByte('.')
+			}
+		}
+		d.buf.WriteString("\r\n")
+	}
+	d.t.Write(d.buf.Bytes())
+}
+
+// UpdateScreen only takes note of the change, the screen is written once it
+// settles in OnUpdate.
+func (d *AccessibleDriver) UpdateScreen(c *hachi.Chip8) {
+	d.dirty = true
+	d.changed = time.Now()
+}
+
+func (d *AccessibleDriver) Beep() { d.beeper.Beep() }
+
+// OnSoundStart announces the beep, unless it follows another one closely.
+func (d *AccessibleDriver) OnSoundStart(c *hachi.Chip8, frames int) {
+	if time.Since(d.lastBeep) >= beepInterval {
+		d.say("Beep.")
+	}
+	d.lastBeep = time.Now()
+}
+
+func (d *AccessibleDriver) OnSoundStop(c *hachi.Chip8) {}
+
+// OnHalt announces the error.
+func (d *AccessibleDriver) OnHalt(c *hachi.Chip8, err error) {
+	d.say("Emulator halted: %v", err)
+}
+
+// OnReconfigure switches to the new key layout, if any.
+func (d *AccessibleDriver) OnReconfigure(c *hachi.Chip8,
+	old *hachi.Chip8Settings) {
+
+	if layout := c.KeyLayout(); layout != nil {
+		d.t.SetKeyLayout(layout)
+	}
+}
+
+// OnShutdown restores the terminal.
+func (d *AccessibleDriver) OnShutdown(c *hachi.Chip8) { d.t.Close() }
+
+func (d *AccessibleDriver) GetData(key string) interface{} {
+	switch key {
+	case "quit":
+		return d.t.Quit()
+	}
+	return nil
+}
+
+func (d *AccessibleDriver) SetData(key string, value interface{}) error {
+	switch key {
+	case "beeper":
+		b, err := beep.FromData(value, d.t)
+		if err != nil {
+			return err
+		}
+		d.beeper = b
+		return nil
+	case "key_layout":
+		name, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("Invalid type %s for key_layout.",
+				reflect.TypeOf(value))
+		}
+		layout, err := hachi.GetKeyLayout(name)
+		if err != nil {
+			return err
+		}
+		d.t.SetKeyLayout(layout)
+		return nil
+	case "display":
+		mode, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("Invalid type %s for display.",
+				reflect.TypeOf(value))
+		}
+		switch mode {
+		case "off":
+			d.display = false
+		case "text":
+			d.display = true
+			d.dirty = true
+		default:
+			return fmt.Errorf("Unknown display mode '%s'.", mode)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("accessible", &AccessibleDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
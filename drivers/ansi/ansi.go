@@ -0,0 +1,174 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package ansi implements a minimal syscall driver that renders the screen
+// with half-block characters through raw ANSI escape codes and reads keys from
+// stdin in raw mode. It has no dependencies outside of the standard library,
+// so it works in constrained environments such as containers and serial
+// consoles, and it doubles as a reference implementation for new drivers.
+//
+// The driver takes over stdin and stdout. The caller must call Tick() on the
+// emulator until the channel returned by GetDriverData("quit") is closed,
+// which happens when the user presses Ctrl-C, and then call Shutdown() to
+// restore the terminal. If the emulator halts on an error, the error is shown
+// below the screen:
+//
+//	defer ha.Shutdown()
+//	quit := ha.GetDriverData("quit").(<-chan struct{})
+//	for {
+//		select {
+//		case <-quit:
+//			return
+//		default:
+//			if err := ha.Tick(); err != nil {
+//				return
+//			}
+//		}
+//	}
+//
+// Keys are bound according to the key layout from the settings (or the octo
+// layout if none is set). The layout can be changed at runtime through
+// SetDriverData("key_layout", name).
+//
+// The beep is silent by default, a sound backend can be picked through
+// SetDriverData("beeper", name), for example "bell" to ring the terminal bell
+// (see package beep).
+package ansi
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Francesco149/go-hachi/drivers/beep"
+	"github.com/Francesco149/go-hachi/drivers/internal/term"
+	"github.com/Francesco149/go-hachi/hachi"
+	"log"
+	"os"
+	"reflect"
+	"time"
+)
+
+// An ANSIDriver is a dependency-free terminal driver.
+type ANSIDriver struct {
+	hachi.Driver
+	t          *term.Terminal
+	dirty      bool
+	lastUpdate time.Time
+	buf        bytes.Buffer
+	beeper     beep.Beeper
+}
+
+func (d *ANSIDriver) OnInit(c *hachi.Chip8) {
+	d.beeper = beep.Silent{}
+	layout := c.KeyLayout()
+	if layout == nil {
+		layout = hachi.KeyLayouts["octo"]
+	}
+
+	// a driver instance is shared by every emulator that uses it, so clean
+	// up after the previous one
+	if d.t != nil {
+		d.t.Close()
+	}
+	d.t = term.New(os.Stdin, os.Stdout, layout)
+
+	d.dirty = true
+	c.Logger().Println("ANSIDriver initialized")
+}
+
+// Cls doesn't need to do anything, the screen buffer is cleared by the
+// emulator and UpdateScreen will be called on the next draw.
+func (d *ANSIDriver) Cls() {}
+
+func (d *ANSIDriver) OnUpdate(c *hachi.Chip8) {
+	// the terminal keeps track of which keys are held
+	c.Keyboard = d.t.Keyboard()
+
+	// programs can draw thousands of times per second, but redrawing the
+	// terminal more than 60 times per second is wasted work
+	if !d.dirty || time.Since(d.lastUpdate) < time.Second/60 {
+		return
+	}
+	d.lastUpdate = time.Now()
+	d.dirty = false
+
+	d.buf.Reset()
+	w, h := c.DisplaySize()
+	term.RenderHalfBlocks(&d.buf, c.DisplayScreen(), w, h)
+	d.t.Write(d.buf.Bytes())
+}
+
+// UpdateScreen only marks the screen as dirty, the actual rendering is
+// throttled in OnUpdate.
+func (d *ANSIDriver) UpdateScreen(c *hachi.Chip8) { d.dirty = true }
+
+func (d *ANSIDriver) Beep() { d.beeper.Beep() }
+
+// OnHalt prints the error below the screen, where it stays visible after the
+// terminal is restored.
+func (d *ANSIDriver) OnHalt(c *hachi.Chip8, err error) {
+	fmt.Fprintf(d.t, "\x1b[0m\r\nEmulator halted: %v\r\n", err)
+}
+
+// OnReconfigure switches to the new key layout, if any.
+func (d *ANSIDriver) OnReconfigure(c *hachi.Chip8, old *hachi.Chip8Settings) {
+	if layout := c.KeyLayout(); layout != nil {
+		d.t.SetKeyLayout(layout)
+	}
+}
+
+// OnShutdown restores the terminal.
+func (d *ANSIDriver) OnShutdown(c *hachi.Chip8) { d.t.Close() }
+
+func (d *ANSIDriver) GetData(key string) interface{} {
+	switch key {
+	case "quit":
+		return d.t.Quit()
+	}
+	return nil
+}
+
+func (d *ANSIDriver) SetData(key string, value interface{}) error {
+	switch key {
+	case "beeper":
+		b, err := beep.FromData(value, d.t)
+		if err != nil {
+			return err
+		}
+		d.beeper = b
+		return nil
+	case "key_layout":
+		name, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("Invalid type %s for key_layout.",
+				reflect.TypeOf(value))
+		}
+		layout, err := hachi.GetKeyLayout(name)
+		if err != nil {
+			return err
+		}
+		d.t.SetKeyLayout(layout)
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("ansi", &ANSIDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
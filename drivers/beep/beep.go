@@ -0,0 +1,128 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package beep implements the sound backends that drivers can use to play the
+// CHIP-8 beep.
+//
+// Drivers let the user pick a backend through SetDriverData("beeper", value),
+// where value is either the name of a registered backend or a Beeper. The
+// built-in backends are "silent" (the default) and "bell", which rings the
+// terminal bell. Importing package otobeep registers "audio", which plays a
+// real square wave.
+package beep
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// A Beeper plays the CHIP-8 beep. Beep is called every 1/60th of a second for
+// as long as the sound timer is non-zero, so implementations are expected to
+// keep the sound going between calls.
+type Beeper interface {
+	Beep()
+}
+
+// A Factory creates a Beeper. w is the driver's terminal output, or nil if the
+// driver has none.
+type Factory func(w io.Writer) (Beeper, error)
+
+var factories = map[string]Factory{
+	"silent": func(w io.Writer) (Beeper, error) { return Silent{}, nil },
+	"bell": func(w io.Writer) (Beeper, error) {
+		if w == nil {
+			return nil, fmt.Errorf("The bell requires a terminal.")
+		}
+		return NewBell(w), nil
+	},
+}
+
+// Register registers a Beeper factory to a name.
+// This is not thread-safe, so it should be called in init().
+func Register(name string, f Factory) error {
+	if factories[name] != nil {
+		return fmt.Errorf("Beeper %s already exists.", name)
+	}
+	factories[name] = f
+	return nil
+}
+
+// Names returns the sorted names of all the registered backends.
+func Names() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the backend registered under name.
+func New(name string, w io.Writer) (Beeper, error) {
+	f := factories[name]
+	if f == nil {
+		return nil, fmt.Errorf("Unknown beeper '%s' (available: %v).",
+			name, Names())
+	}
+	return f(w)
+}
+
+// FromData resolves the value of a "beeper" SetData call, which can be either
+// a backend name or a Beeper.
+func FromData(value interface{}, w io.Writer) (Beeper, error) {
+	switch v := value.(type) {
+	case string:
+		return New(v, w)
+	case Beeper:
+		return v, nil
+	}
+	return nil, fmt.Errorf("Invalid type %s for beeper.", reflect.TypeOf(value))
+}
+
+// -----------------------------------------------------------------------------
+
+// Silent is a Beeper that ignores all beeps.
+type Silent struct{}
+
+func (Silent) Beep() {}
+
+// DefaultBellInterval is the default minimum interval between two rings of
+// the terminal bell.
+const DefaultBellInterval = time.Millisecond * 250
+
+// A Bell is a Beeper that rings the terminal bell. Since the bell can't be
+// held, it's rung at most once every Interval no matter how often Beep is
+// called.
+type Bell struct {
+	W        io.Writer
+	Interval time.Duration
+	last     time.Time
+}
+
+// NewBell creates a Bell that writes to w with the default interval.
+func NewBell(w io.Writer) *Bell {
+	return &Bell{W: w, Interval: DefaultBellInterval}
+}
+
+func (b *Bell) Beep() {
+	if time.Since(b.last) < b.Interval {
+		return
+	}
+	b.last = time.Now()
+	io.WriteString(b.W, "\a")
+}
@@ -0,0 +1,133 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package otobeep implements a beep backend that plays a real square wave
+// through the oto audio library. Importing it registers the "audio" backend
+// in package beep, which plays the tone at DefaultFrequency.
+package otobeep
+
+import (
+	"github.com/Francesco149/go-hachi/drivers/beep"
+	"github.com/ebitengine/oto/v3"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultFrequency is the pitch of the tone, in hz, of new Audio beepers and
+// of the "audio" backend. Set it before creating them.
+var DefaultFrequency = 440
+
+const (
+	sampleRate = 44100
+	volume     = 0x1000
+	// how long the tone keeps playing after a Beep call. Slightly longer
+	// than the 1/60th of a second between calls to avoid gaps.
+	holdTime = time.Second / 30
+)
+
+// oto only allows one context per process
+var (
+	context     *oto.Context
+	contextErr  error
+	contextOnce sync.Once
+)
+
+func getContext() (*oto.Context, error) {
+	contextOnce.Do(func() {
+		var ready chan struct{}
+		context, ready, contextErr = oto.NewContext(&oto.NewContextOptions{
+			SampleRate:   sampleRate,
+			ChannelCount: 1,
+			Format:       oto.FormatSignedInt16LE,
+		})
+		if contextErr == nil {
+			<-ready
+		}
+	})
+	return context, contextErr
+}
+
+// An Audio is a Beeper that plays a square wave through the sound card.
+type Audio struct {
+	player *oto.Player
+	until  int64 // unix nanoseconds at which the tone stops
+	period int64 // samples per period of the wave
+	phase  int64
+}
+
+// New opens the audio device and creates an Audio beeper.
+func New() (*Audio, error) {
+	ctx, err := getContext()
+	if err != nil {
+		return nil, err
+	}
+	a := &Audio{}
+	a.SetFrequency(DefaultFrequency)
+	a.player = ctx.NewPlayer(a)
+	a.player.Play()
+	return a, nil
+}
+
+// SetFrequency changes the pitch of the tone, in hz. It's clamped to what the
+// sample rate can play.
+func (a *Audio) SetFrequency(hz int) {
+	if hz < 1 {
+		hz = 1
+	}
+	period := int64(sampleRate / hz)
+	if period < 2 {
+		period = 2
+	}
+	atomic.StoreInt64(&a.period, period)
+}
+
+func (a *Audio) Beep() {
+	atomic.StoreInt64(&a.until, time.Now().Add(holdTime).UnixNano())
+}
+
+// Read generates the signed 16-bit little endian samples for the player,
+// which is silence unless a beep is being held.
+func (a *Audio) Read(buf []byte) (int, error) {
+	on := time.Now().UnixNano() < atomic.LoadInt64(&a.until)
+	period := atomic.LoadInt64(&a.period)
+	n := len(buf) / 2 * 2
+	for i := 0; i < n; i += 2 {
+		var sample int16
+		if on {
+			sample = volume
+			if a.phase >= period/2 {
+				sample = -volume
+			}
+			a.phase = (a.phase + 1) % period
+		}
+		buf[i] = byte(sample)
+		buf[i+1] = byte(uint16(sample) >> 8)
+	}
+	return n, nil
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := beep.Register("audio", func(w io.Writer) (beep.Beeper, error) {
+		return New()
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
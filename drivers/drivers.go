@@ -14,12 +14,22 @@
 */
 
 // Package drivers contains various syscall drivers for hachi.
-// Importing this package loads and registers all drivers. If you only need to
-// use one of them (which is usually the case), just import the specific driver
-// package.
+// Importing this package loads and registers all of the drivers that are
+// pure Go and light on dependencies. The windowed drivers (gio, pixel,
+// sdl2), which need cgo and system libraries, and the network ones (ssh,
+// websocket) are only added when building with the hachi_extra tag.
+// notcurses is never imported, as it needs the notcurses library. If you
+// only need to use one driver (which is usually the case), just import the
+// specific driver package.
 // To implement your own drivers, see the Driver interface in package hachi.
 package drivers
 
 import (
-	_ "github.com/Francesco149/go-hachi/hachi/drivers/termloop"
+	_ "github.com/Francesco149/go-hachi/drivers/accessible"
+	_ "github.com/Francesco149/go-hachi/drivers/ansi"
+	_ "github.com/Francesco149/go-hachi/drivers/framedump"
+	_ "github.com/Francesco149/go-hachi/drivers/headless"
+	_ "github.com/Francesco149/go-hachi/drivers/kitty"
+	_ "github.com/Francesco149/go-hachi/drivers/sixel"
+	_ "github.com/Francesco149/go-hachi/drivers/termloop"
 )
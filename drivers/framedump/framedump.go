@@ -0,0 +1,165 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package framedump implements a headless syscall driver that writes every
+// screen update to a numbered PNG file (000000.png, 000001.png, ...).
+// This is useful for encoding videos offline, visually diffing the output of
+// different emulator versions and taking screenshots for documentation.
+//
+// The driver can be configured through SetDriverData:
+//
+//	"dir"   (string) output directory, created if missing. Default: "frames"
+//	"skip"  (int)    number of frames to skip after each written frame.
+//	                 Default: 0
+//	"scale" (int)    size of a CHIP-8 pixel in image pixels. Default: 1
+//
+// Errors are logged and the last one can be retrieved through
+// GetDriverData("error").
+package framedump
+
+import (
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// A FrameDumpDriver is a headless driver that dumps frames as PNG files.
+type FrameDumpDriver struct {
+	hachi.Driver
+	dir     string
+	skip    int
+	scale   int
+	frame   int // index of the next screen update
+	written int // number of files written
+	err     error
+	logger  *log.Logger
+}
+
+func (d *FrameDumpDriver) OnInit(c *hachi.Chip8) {
+	if d.dir == "" {
+		d.dir = "frames"
+	}
+	if d.scale == 0 {
+		d.scale = 1
+	}
+	d.frame = 0
+	d.written = 0
+	d.err = nil
+	d.logger = c.Logger()
+	d.logger.Println("FrameDumpDriver initialized")
+}
+
+func (d *FrameDumpDriver) Cls()                    {}
+func (d *FrameDumpDriver) OnUpdate(c *hachi.Chip8) {}
+
+func (d *FrameDumpDriver) UpdateScreen(c *hachi.Chip8) {
+	frame := d.frame
+	d.frame++
+	if frame%(d.skip+1) != 0 {
+		return
+	}
+
+	if err := d.write(c); err != nil {
+		d.err = err
+		d.logger.Println("FrameDumpDriver:", err)
+	}
+}
+
+// write encodes the current screen to the next numbered file.
+func (d *FrameDumpDriver) write(c *hachi.Chip8) error {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+
+	display := c.DisplayImage()
+	w, h := display.Bounds().Dx()*d.scale, display.Bounds().Dy()*d.scale
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, display.At(x/d.scale, y/d.scale))
+		}
+	}
+
+	path := filepath.Join(d.dir, fmt.Sprintf("%06d.png", d.written))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = png.Encode(f, img); err != nil {
+		return err
+	}
+	d.written++
+	return nil
+}
+
+func (d *FrameDumpDriver) Beep() {}
+
+func (d *FrameDumpDriver) GetData(key string) interface{} {
+	switch key {
+	case "dir":
+		return d.dir
+	case "skip":
+		return d.skip
+	case "scale":
+		return d.scale
+	case "written":
+		return d.written
+	case "error":
+		return d.err
+	}
+	return nil
+}
+
+func (d *FrameDumpDriver) SetData(key string, value interface{}) error {
+	switch key {
+	case "dir":
+		dir, ok := value.(string)
+		if !ok || dir == "" {
+			return fmt.Errorf("Invalid directory %v.", value)
+		}
+		d.dir = dir
+		return nil
+	case "skip":
+		skip, ok := value.(int)
+		if !ok || skip < 0 {
+			return fmt.Errorf("Invalid frame skip %v.", value)
+		}
+		d.skip = skip
+		return nil
+	case "scale":
+		scale, ok := value.(int)
+		if !ok || scale < 1 {
+			return fmt.Errorf("Invalid scale %v.", value)
+		}
+		d.scale = scale
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("framedump", &FrameDumpDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,263 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package gio implements a windowed syscall driver for the Gio UI toolkit,
+// which is pure Go, GPU-accelerated and also runs on mobile.
+//
+// Gio needs the main goroutine for its own event loop, so the emulator must
+// run on a separate goroutine. The driver opens the window in OnInit and
+// exposes a channel through GetDriverData("closed") which is closed when the
+// window is destroyed:
+//
+//	ha, _ := hachi.New("gio", nil)
+//	// load program...
+//	closed := ha.GetDriverData("closed").(chan struct{})
+//	go func() {
+//		for {
+//			select {
+//			case <-closed:
+//				os.Exit(0)
+//			default:
+//				ha.Tick()
+//			}
+//		}
+//	}()
+//	app.Main()
+//
+// The screen is scaled by the largest integer factor that fits the window and
+// centered. Keys are bound according to the key layout from the settings, or
+// the octo layout if none is set.
+//
+// The beep is silent by default, a sound backend can be picked through
+// SetDriverData("beeper", name) (see package beep).
+package gio
+
+import (
+	"fmt"
+	"gioui.org/app"
+	"gioui.org/f32"
+	"gioui.org/io/event"
+	"gioui.org/io/input"
+	"gioui.org/io/key"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"github.com/Francesco149/go-hachi/drivers/beep"
+	"github.com/Francesco149/go-hachi/hachi"
+	"image"
+	"image/color"
+	"log"
+	"strings"
+	"sync"
+)
+
+// A GioDriver is a windowed driver that uses the Gio toolkit.
+type GioDriver struct {
+	hachi.Driver
+	win    *app.Window
+	closed chan struct{}
+	beeper beep.Beeper
+
+	// shared between the emulator and the window goroutine
+	mutex         sync.Mutex
+	screen        []byte
+	width, height int
+	keyboard      uint16
+	keyMap        map[key.Name]uint16
+}
+
+// foreground and background colors
+var (
+	fgColor = color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	bgColor = color.NRGBA{0x00, 0x00, 0x00, 0xFF}
+)
+
+// keyName returns the Gio key name for a character used by hachi.KeyLayouts.
+func keyName(r rune) key.Name {
+	if r == '\r' {
+		return key.NameReturn
+	}
+	return key.Name(strings.ToUpper(string(r)))
+}
+
+func (d *GioDriver) OnInit(c *hachi.Chip8) {
+	d.beeper = beep.Silent{}
+	layout := c.KeyLayout()
+	if layout == nil {
+		layout = hachi.KeyLayouts["octo"]
+	}
+
+	d.mutex.Lock()
+	d.keyMap = make(map[key.Name]uint16)
+	for r, k := range layout {
+		d.keyMap[keyName(r)] = k
+	}
+	d.width, d.height = c.DisplaySize()
+	d.screen = make([]byte, d.width*d.height/8)
+	d.keyboard = 0
+	d.mutex.Unlock()
+
+	d.closed = make(chan struct{})
+	d.win = new(app.Window)
+	d.win.Option(
+		app.Title("hachi"),
+		app.Size(unit.Dp(float32(d.width*10)), unit.Dp(float32(d.height*10))),
+	)
+	go d.loop()
+
+	c.Logger().Println("GioDriver initialized")
+}
+
+// loop runs the window's event loop until it's destroyed.
+func (d *GioDriver) loop() {
+	defer close(d.closed)
+
+	var ops op.Ops
+	for {
+		switch e := d.win.Event().(type) {
+		case app.DestroyEvent:
+			return
+		case app.FrameEvent:
+			gtx := app.NewContext(&ops, e)
+			d.handleKeys(gtx.Source)
+			d.draw(gtx.Ops, e.Size)
+			e.Frame(gtx.Ops)
+		}
+	}
+}
+
+// handleKeys updates the keyboard state from the pending key events.
+func (d *GioDriver) handleKeys(src input.Source) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	filters := make([]event.Filter, 0, len(d.keyMap))
+	for name := range d.keyMap {
+		filters = append(filters, key.Filter{Name: name})
+	}
+
+	for {
+		ev, ok := src.Event(filters...)
+		if !ok {
+			break
+		}
+		e, ok := ev.(key.Event)
+		if !ok {
+			continue
+		}
+		if e.State == key.Press {
+			d.keyboard |= d.keyMap[e.Name]
+		} else {
+			d.keyboard &= ^d.keyMap[e.Name]
+		}
+	}
+}
+
+// draw paints the last screen buffer, scaled by the largest integer factor
+// that fits size.
+func (d *GioDriver) draw(ops *op.Ops, size image.Point) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	paint.Fill(ops, bgColor)
+
+	scale := size.X / d.width
+	if s := size.Y / d.height; s < scale {
+		scale = s
+	}
+	if scale < 1 {
+		scale = 1
+	}
+
+	// center the screen in the window
+	off := image.Pt((size.X-d.width*scale)/2, (size.Y-d.height*scale)/2)
+	defer op.Offset(off).Push(ops).Pop()
+
+	// batch every lit pixel into a single path
+	var path clip.Path
+	path.Begin(ops)
+	byteWidth := d.width / 8
+	for y := 0; y < d.height; y++ {
+		for x := 0; x < d.width; x++ {
+			if d.screen[y*byteWidth+x/8]&(0x80>>uint(x%8)) == 0 {
+				continue
+			}
+			fx, fy, fs := float32(x*scale), float32(y*scale), float32(scale)
+			path.MoveTo(f32.Pt(fx, fy))
+			path.LineTo(f32.Pt(fx+fs, fy))
+			path.LineTo(f32.Pt(fx+fs, fy+fs))
+			path.LineTo(f32.Pt(fx, fy+fs))
+			path.Close()
+		}
+	}
+	paint.FillShape(ops, fgColor, clip.Outline{Path: path.End()}.Op())
+}
+
+// OnLoad shows the program's title in the window title.
+func (d *GioDriver) OnLoad(c *hachi.Chip8, info hachi.RomInfo) {
+	if info.Title() != "" {
+		d.win.Option(app.Title("hachi - " + info.Title()))
+	}
+}
+
+func (d *GioDriver) Cls() {}
+
+func (d *GioDriver) OnUpdate(c *hachi.Chip8) {
+	d.mutex.Lock()
+	c.Keyboard = d.keyboard
+	d.mutex.Unlock()
+}
+
+func (d *GioDriver) UpdateScreen(c *hachi.Chip8) {
+	d.mutex.Lock()
+	copy(d.screen, c.DisplayScreen())
+	d.mutex.Unlock()
+	d.win.Invalidate()
+}
+
+func (d *GioDriver) Beep() { d.beeper.Beep() }
+
+func (d *GioDriver) GetData(key string) interface{} {
+	switch key {
+	case "window":
+		return d.win
+	case "closed":
+		return d.closed
+	}
+	return nil
+}
+
+func (d *GioDriver) SetData(key string, value interface{}) error {
+	switch key {
+	case "beeper":
+		b, err := beep.FromData(value, nil)
+		if err != nil {
+			return err
+		}
+		d.beeper = b
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("gio", &GioDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
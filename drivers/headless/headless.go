@@ -0,0 +1,271 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package headless implements a syscall driver for automated tests: every
+// screen update is captured into an in-memory frame list, Beep and Cls calls
+// are recorded and the keyboard follows a script instead of a real device.
+//
+//	ha, _ := hachi.New("headless", &settings)
+//	script, _ := headless.ParseKeyScript("60:5 62:-")
+//	ha.SetDriverData("script", script)
+//	// load program and run it...
+//	frames := ha.GetDriverData("frames").([]headless.Frame)
+//	fmt.Print(frames[len(frames)-1].Text())
+//
+// The driver can be configured through SetDriverData:
+//
+//	"script"     ([]KeyEvent or string, see ParseKeyScript) keys to press,
+//	             replaces the current script
+//	"max_frames" (int) only keep this many of the latest frames. Default: 0,
+//	             which keeps them all
+//
+// And queried through GetDriverData:
+//
+//	"frames" ([]Frame) captured screen updates
+//	"events" ([]Event) Beep and Cls calls
+//	"beeps"  (int)     number of Beep calls
+//	"cls"    (int)     number of Cls calls
+//
+// The recordings and the script position are reset by OnInit. Like all
+// drivers, a single instance is shared by every emulator using it, so it
+// should only drive one emulator at a time.
+package headless
+
+import (
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A Frame is a captured screen update.
+type Frame struct {
+	// Cycles is how many instructions ran before the update.
+	Cycles        uint64
+	Width, Height int
+	// Screen is a copy of Chip8.DisplayScreen, one bit per pixel.
+	Screen []byte
+}
+
+// Pixel returns true if the pixel at x, y is lit.
+func (f *Frame) Pixel(x, y int) bool {
+	return hachi.NewBitmap(f.Screen, f.Width, f.Height).Lit(x, y)
+}
+
+// Text renders the frame as ASCII art, one line per row, with # for lit
+// pixels and . for unlit ones.
+func (f *Frame) Text() string {
+	var b strings.Builder
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			if f.Pixel(x, y) {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// An EventKind is a driver call recorded by the headless driver.
+type EventKind int
+
+const (
+	// EventBeep is a Beep call, made every 1/60th of a second while the
+	// sound timer is non-zero.
+	EventBeep EventKind = iota
+	// EventCls is a Cls call.
+	EventCls
+)
+
+var eventKindNames = map[EventKind]string{
+	EventBeep: "beep",
+	EventCls:  "cls",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// An Event is a recorded Beep or Cls call.
+type Event struct {
+	Kind EventKind
+	// Cycles is how many instructions ran before the call.
+	Cycles uint64
+}
+
+// A KeyEvent sets the keys held down (a combination of hachi.Key0...KeyF)
+// once the timers have ticked Frame times, which is deterministic with
+// FixedTimestep, unlike the wall clock, and keeps counting while the program
+// waits for a key, unlike instructions. The keys stay down until the next
+// event.
+type KeyEvent struct {
+	Frame uint64
+	Keys  uint16
+}
+
+// ParseKeyScript parses a space separated list of frame:keys events, where
+// frame is a decimal timer tick count (see KeyEvent) and keys is a list of
+// hex keypad digits, or - for no keys. For example, "60:5 62:- 120:46"
+// presses 5 after one second, releases it two frames later and presses 4 and
+// 6 together after two seconds. The events must be in order.
+func ParseKeyScript(script string) (res []KeyEvent, err error) {
+	for _, field := range strings.Fields(script) {
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid key event '%s'.", field)
+		}
+		var e KeyEvent
+		e.Frame, err = strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid frame in '%s'.", field)
+		}
+		if len(res) != 0 && e.Frame < res[len(res)-1].Frame {
+			return nil, fmt.Errorf("Key event '%s' is out of order.", field)
+		}
+		if parts[1] != "-" {
+			for _, r := range parts[1] {
+				n, perr := strconv.ParseUint(string(r), 16, 4)
+				if perr != nil {
+					return nil, fmt.Errorf("Invalid key '%c' in '%s'.",
+						r, field)
+				}
+				e.Keys |= 1 << n
+			}
+		}
+		res = append(res, e)
+	}
+	return
+}
+
+// A HeadlessDriver records what the emulator does for automated tests.
+type HeadlessDriver struct {
+	hachi.Driver
+	frames    []Frame
+	maxFrames int
+	events    []Event
+	beeps     int
+	cls       int
+	script    []KeyEvent
+	next      int // index of the next script event
+	cycles    uint64
+}
+
+// frame returns how many times the timers ticked.
+func frame(c *hachi.Chip8) uint64 {
+	return uint64(c.Stats().Uptime / c.TimerInterval)
+}
+
+func (d *HeadlessDriver) OnInit(c *hachi.Chip8) {
+	d.frames = nil
+	d.events = nil
+	d.beeps = 0
+	d.cls = 0
+	d.next = 0
+	d.cycles = 0
+	c.Logger().Println("HeadlessDriver initialized")
+}
+
+func (d *HeadlessDriver) Cls() {
+	d.cls++
+	d.events = append(d.events, Event{EventCls, d.cycles})
+}
+
+// OnUpdate applies the script events that are due.
+func (d *HeadlessDriver) OnUpdate(c *hachi.Chip8) {
+	d.cycles = c.Stats().Cycles
+	now := frame(c)
+	for d.next < len(d.script) && d.script[d.next].Frame <= now {
+		c.Keyboard = d.script[d.next].Keys
+		d.next++
+	}
+}
+
+func (d *HeadlessDriver) UpdateScreen(c *hachi.Chip8) {
+	w, h := c.DisplaySize()
+	screen := append([]byte(nil), c.DisplayScreen()...)
+	d.frames = append(d.frames, Frame{c.Stats().Cycles, w, h, screen})
+	if d.maxFrames > 0 && len(d.frames) > d.maxFrames {
+		d.frames = append(d.frames[:0], d.frames[1:]...)
+	}
+}
+
+func (d *HeadlessDriver) Beep() {
+	d.beeps++
+	d.events = append(d.events, Event{EventBeep, d.cycles})
+}
+
+func (d *HeadlessDriver) GetData(key string) interface{} {
+	switch key {
+	case "frames":
+		return d.frames
+	case "events":
+		return d.events
+	case "beeps":
+		return d.beeps
+	case "cls":
+		return d.cls
+	case "script":
+		return d.script
+	case "max_frames":
+		return d.maxFrames
+	}
+	return nil
+}
+
+func (d *HeadlessDriver) SetData(key string, value interface{}) error {
+	switch key {
+	case "script":
+		switch v := value.(type) {
+		case string:
+			script, err := ParseKeyScript(v)
+			if err != nil {
+				return err
+			}
+			d.script = script
+		case []KeyEvent:
+			d.script = v
+		default:
+			return fmt.Errorf("Invalid type %s for script.",
+				reflect.TypeOf(value))
+		}
+		d.next = 0
+		return nil
+	case "max_frames":
+		n, ok := value.(int)
+		if !ok || n < 0 {
+			return fmt.Errorf("Invalid frame limit %v.", value)
+		}
+		d.maxFrames = n
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("headless", &HeadlessDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,42 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package term
+
+import "bytes"
+
+// half-block characters indexed by top pixel | bottom pixel<<1
+var halfBlocks = [4]string{" ", "▀", "▄", "█"}
+
+// RenderHalfBlocks appends a character-cell rendering of a CHIP-8 screen
+// buffer to b, starting at the top left corner of the terminal. Each cell
+// holds two vertically stacked pixels, so the output is width*height/2 cells.
+func RenderHalfBlocks(b *bytes.Buffer, screen []byte, width, height int) {
+	byteWidth := width / 8
+	pixel := func(x, y int) int {
+		if y >= height || screen[y*byteWidth+x/8]&(0x80>>uint(x%8)) == 0 {
+			return 0
+		}
+		return 1
+	}
+
+	b.WriteString("\x1b[H") // cursor home
+	for y := 0; y < height; y += 2 {
+		for x := 0; x < width; x++ {
+			b.WriteString(halfBlocks[pixel(x, y)|pixel(x, y+1)<<1])
+		}
+		b.WriteString("\r\n")
+	}
+}
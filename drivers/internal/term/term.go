@@ -0,0 +1,149 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package term contains the plumbing shared by the drivers that render
+// straight to a terminal: raw mode, key input and synchronized output.
+package term
+
+import (
+	"github.com/Francesco149/go-hachi/hachi"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// KeyTimeout is how long a key is held after the terminal reports it.
+// Terminals only report key presses, so keys are released automatically
+// unless the terminal keeps repeating them.
+const KeyTimeout = time.Millisecond * 100
+
+// A Terminal is a raw-mode terminal connection that drivers render to.
+// Writing to it is safe from any goroutine, and becomes a no-op once the
+// terminal is closed.
+type Terminal struct {
+	out     io.Writer
+	restore func() error
+
+	mutex   sync.Mutex
+	closed  bool
+	quit    chan struct{}
+	keyMap  hachi.KeyLayout
+	pressed map[uint16]time.Time
+}
+
+// New starts reading keys from in, which is put in raw mode if it's a tty,
+// and prepares out for rendering by hiding the cursor.
+// Keys are mapped according to layout, and Ctrl-C closes the terminal.
+func New(in io.Reader, out io.Writer, layout hachi.KeyLayout) *Terminal {
+	t := &Terminal{
+		out:     out,
+		quit:    make(chan struct{}),
+		keyMap:  layout,
+		pressed: make(map[uint16]time.Time),
+	}
+
+	if f, ok := in.(*os.File); ok {
+		// not being able to enter raw mode isn't fatal, keys will just
+		// be delayed until enter is pressed
+		t.restore, _ = makeRaw(int(f.Fd()))
+	}
+
+	// clear screen and hide cursor
+	io.WriteString(out, "\x1b[2J\x1b[?25l")
+
+	go t.readKeys(in)
+	return t
+}
+
+func (t *Terminal) readKeys(in io.Reader) {
+	buf := make([]byte, 64)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			t.Close()
+			return
+		}
+
+		t.mutex.Lock()
+		for _, b := range buf[:n] {
+			if b == 0x03 { // Ctrl-C
+				t.mutex.Unlock()
+				t.Close()
+				return
+			}
+			if key := t.keyMap[unicode.ToLower(rune(b))]; key != 0 {
+				t.pressed[key] = time.Now()
+			}
+		}
+		t.mutex.Unlock()
+	}
+}
+
+// Keyboard returns the keys that are currently held, as a hachi.Chip8
+// Keyboard bitfield.
+func (t *Terminal) Keyboard() (res uint16) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for key, pressed := range t.pressed {
+		if time.Since(pressed) > KeyTimeout {
+			delete(t.pressed, key)
+			continue
+		}
+		res |= key
+	}
+	return
+}
+
+// SetKeyLayout replaces the key mappings.
+func (t *Terminal) SetKeyLayout(layout hachi.KeyLayout) {
+	t.mutex.Lock()
+	t.keyMap = layout
+	t.mutex.Unlock()
+}
+
+// Write writes p to the terminal's output.
+func (t *Terminal) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return len(p), nil
+	}
+	return t.out.Write(p)
+}
+
+// Quit returns a channel that is closed when the terminal is closed, either
+// by the user pressing Ctrl-C or by calling Close.
+func (t *Terminal) Quit() <-chan struct{} { return t.quit }
+
+// Close restores the terminal to its original state.
+func (t *Terminal) Close() (err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+
+	// reset colors and show cursor
+	io.WriteString(t.out, "\x1b[0m\x1b[?25h\r\n")
+	if t.restore != nil {
+		err = t.restore()
+	}
+	close(t.quit)
+	return
+}
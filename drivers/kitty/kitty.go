@@ -0,0 +1,251 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package kitty implements a syscall driver that blits the screen as a real
+// image through the kitty terminal graphics protocol. On terminals that don't
+// support it, the driver automatically falls back to rendering the screen with
+// half-block characters.
+//
+// Support is detected from the environment (kitty, WezTerm, ghostty and
+// Konsole are recognized). The detection can be overridden through
+// SetDriverData("renderer", "kitty") or SetDriverData("renderer", "cells").
+//
+// The driver takes over stdin and stdout. The caller must call Tick() on the
+// emulator until the channel returned by GetDriverData("quit") is closed,
+// which happens when the user presses Ctrl-C, and then call Shutdown() to
+// restore the terminal.
+//
+// Keys are bound according to the key layout from the settings (or the octo
+// layout if none is set). The layout can be changed at runtime through
+// SetDriverData("key_layout", name).
+//
+// The beep is silent by default, a sound backend can be picked through
+// SetDriverData("beeper", name), for example "bell" to ring the terminal bell
+// (see package beep).
+package kitty
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/Francesco149/go-hachi/drivers/beep"
+	"github.com/Francesco149/go-hachi/drivers/internal/term"
+	"github.com/Francesco149/go-hachi/hachi"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// A KittyDriver is a terminal driver that renders the screen as an image
+// through the kitty graphics protocol.
+type KittyDriver struct {
+	hachi.Driver
+	t          *term.Terminal
+	kitty      bool
+	scale      int
+	dirty      bool
+	lastUpdate time.Time
+	buf        bytes.Buffer
+	png        bytes.Buffer
+	beeper     beep.Beeper
+}
+
+// detect guesses whether the terminal supports the kitty graphics protocol.
+func detect() bool {
+	if os.Getenv("KITTY_WINDOW_ID") != "" ||
+		os.Getenv("KONSOLE_VERSION") != "" {
+		return true
+	}
+	if strings.Contains(os.Getenv("TERM"), "kitty") ||
+		strings.Contains(os.Getenv("TERM"), "ghostty") {
+		return true
+	}
+	switch os.Getenv("TERM_PROGRAM") {
+	case "WezTerm", "ghostty":
+		return true
+	}
+	return false
+}
+
+func (d *KittyDriver) OnInit(c *hachi.Chip8) {
+	d.beeper = beep.Silent{}
+	layout := c.KeyLayout()
+	if layout == nil {
+		layout = hachi.KeyLayouts["octo"]
+	}
+	if d.t != nil {
+		d.t.Close()
+	}
+	d.t = term.New(os.Stdin, os.Stdout, layout)
+
+	d.kitty = detect()
+	if d.scale == 0 {
+		d.scale = 8
+	}
+	d.dirty = true
+	c.Logger().Println("KittyDriver initialized, kitty graphics:", d.kitty)
+}
+
+func (d *KittyDriver) Cls() {}
+
+func (d *KittyDriver) OnUpdate(c *hachi.Chip8) {
+	c.Keyboard = d.t.Keyboard()
+
+	// don't flood the terminal, 60hz is plenty
+	if !d.dirty || time.Since(d.lastUpdate) < time.Second/60 {
+		return
+	}
+	d.lastUpdate = time.Now()
+	d.dirty = false
+
+	d.buf.Reset()
+	if d.kitty {
+		d.render(c)
+	} else {
+		w, h := c.DisplaySize()
+		term.RenderHalfBlocks(&d.buf, c.DisplayScreen(), w, h)
+	}
+	d.t.Write(d.buf.Bytes())
+}
+
+// render encodes the screen as a png and appends the kitty graphics commands
+// that display it to d.buf.
+func (d *KittyDriver) render(c *hachi.Chip8) {
+	dw, dh := c.DisplaySize()
+	w, h := dw*d.scale, dh*d.scale
+	img := image.NewPaletted(image.Rect(0, 0, w, h),
+		color.Palette{color.Black, color.White})
+
+	screen := hachi.NewBitmap(c.DisplayScreen(), dw, dh)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if screen.Lit(x/d.scale, y/d.scale) {
+				img.Pix[y*img.Stride+x] = 1
+			}
+		}
+	}
+
+	d.png.Reset()
+	png.Encode(&d.png, img)
+	payload := base64.StdEncoding.EncodeToString(d.png.Bytes())
+
+	// transmit and display as image 1, placement 1 which replaces the
+	// previous frame. q=2 suppresses the terminal's responses, which would
+	// otherwise end up in stdin.
+	d.buf.WriteString("\x1b[H") // cursor home
+	const chunkSize = 4096
+	for i := 0; i < len(payload); i += chunkSize {
+		end := i + chunkSize
+		more := 1
+		if end >= len(payload) {
+			end = len(payload)
+			more = 0
+		}
+		if i == 0 {
+			fmt.Fprintf(&d.buf, "\x1b_Ga=T,f=100,i=1,p=1,q=2,C=1,m=%d;",
+				more)
+		} else {
+			fmt.Fprintf(&d.buf, "\x1b_Gm=%d;", more)
+		}
+		d.buf.WriteString(payload[i:end])
+		d.buf.WriteString("\x1b\\")
+	}
+}
+
+func (d *KittyDriver) UpdateScreen(c *hachi.Chip8) { d.dirty = true }
+
+func (d *KittyDriver) Beep() { d.beeper.Beep() }
+
+// OnHalt prints the error after the last frame, with either renderer.
+func (d *KittyDriver) OnHalt(c *hachi.Chip8, err error) {
+	fmt.Fprintf(d.t, "\x1b[0m\r\nEmulator halted: %v\r\n", err)
+}
+
+// OnShutdown gives the terminal back to the shell.
+func (d *KittyDriver) OnShutdown(c *hachi.Chip8) { d.t.Close() }
+
+func (d *KittyDriver) GetData(key string) interface{} {
+	switch key {
+	case "quit":
+		return d.t.Quit()
+	case "renderer":
+		if d.kitty {
+			return "kitty"
+		}
+		return "cells"
+	case "scale":
+		return d.scale
+	}
+	return nil
+}
+
+func (d *KittyDriver) SetData(key string, value interface{}) error {
+	switch key {
+	case "beeper":
+		b, err := beep.FromData(value, d.t)
+		if err != nil {
+			return err
+		}
+		d.beeper = b
+		return nil
+	case "renderer":
+		switch value {
+		case "kitty":
+			d.kitty = true
+		case "cells":
+			d.kitty = false
+		default:
+			return fmt.Errorf("Invalid renderer %v.", value)
+		}
+		d.t.Write([]byte("\x1b[2J")) // clear leftovers of the old renderer
+		d.dirty = true
+		return nil
+	case "scale":
+		scale, ok := value.(int)
+		if !ok || scale < 1 {
+			return fmt.Errorf("Invalid scale %v.", value)
+		}
+		d.scale = scale
+		d.dirty = true
+		return nil
+	case "key_layout":
+		name, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("Invalid type %s for key_layout.",
+				reflect.TypeOf(value))
+		}
+		layout, err := hachi.GetKeyLayout(name)
+		if err != nil {
+			return err
+		}
+		d.t.SetKeyLayout(layout)
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("kitty", &KittyDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
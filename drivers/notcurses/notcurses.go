@@ -0,0 +1,277 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package notcurses implements a terminal syscall driver on top of the
+// notcurses C library.
+//
+// The screen is blitted as a true color image. When the terminal supports a
+// pixel graphics protocol (sixel, kitty, ...) the image is drawn with real
+// pixels, otherwise notcurses picks the best character-cell blitter available
+// (sextants, quadrants, half blocks, ...). Refreshes are damage-tracked by
+// notcurses, so they are a lot smoother than termloop.
+//
+// This package links against the system notcurses library through cgo, so
+// it's not imported by package drivers.
+//
+// The caller must call Tick() on the emulator until the channel returned by
+// GetDriverData("quit") is closed, which happens when the user presses Ctrl-C.
+// Keys are bound according to the key layout from the settings (or the octo
+// layout if none is set).
+package notcurses
+
+/*
+#cgo pkg-config: notcurses-core
+#include <notcurses/notcurses.h>
+
+static struct notcurses* hachi_init(void) {
+	struct notcurses_options opts = {0};
+	opts.flags = NCOPTION_SUPPRESS_BANNERS;
+	return notcurses_core_init(&opts, NULL);
+}
+
+// creates a plane covering the whole terminal to blit the screen on
+static struct ncplane* hachi_plane(struct notcurses* nc) {
+	unsigned rows, cols;
+	struct ncplane* std = notcurses_stddim_yx(nc, &rows, &cols);
+	struct ncplane_options nopts = {0};
+	nopts.rows = rows;
+	nopts.cols = cols;
+	return ncplane_create(std, &nopts);
+}
+
+static int hachi_blit(struct notcurses* nc, struct ncplane* p,
+                      const void* rgba, int rows, int cols, int pixel) {
+	struct ncvisual* ncv = ncvisual_from_rgba(rgba, rows, cols * 4, cols);
+	if (!ncv) {
+		return -1;
+	}
+	struct ncvisual_options vopts = {0};
+	vopts.n = p;
+	vopts.scaling = NCSCALE_SCALE;
+	vopts.blitter = pixel ? NCBLIT_PIXEL : NCBLIT_DEFAULT;
+	vopts.flags = NCVISUAL_OPTION_NOINTERPOLATE;
+	struct ncplane* res = ncvisual_blit(nc, ncv, &vopts);
+	ncvisual_destroy(ncv);
+	if (!res) {
+		return -1;
+	}
+	return notcurses_render(nc);
+}
+
+// returns the next pending key (0 if none) and whether it's a release.
+// enter is returned as '\r' and Ctrl-C as 3.
+static uint32_t hachi_key(struct notcurses* nc, int* release) {
+	ncinput ni;
+	uint32_t id = notcurses_get_nblock(nc, &ni);
+	if (id == (uint32_t)-1) {
+		return 0;
+	}
+	*release = ni.evtype == NCTYPE_RELEASE;
+	if (id == NCKEY_ENTER) {
+		return '\r';
+	}
+	if (ncinput_ctrl_p(&ni) && (id == 'c' || id == 'C')) {
+		return 3;
+	}
+	return id;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"github.com/Francesco149/go-hachi/drivers/internal/term"
+	"github.com/Francesco149/go-hachi/hachi"
+	"log"
+	"time"
+	"unicode"
+	"unsafe"
+)
+
+// A NotcursesDriver is a terminal driver that uses the notcurses library.
+type NotcursesDriver struct {
+	hachi.Driver
+	nc         *C.struct_notcurses
+	plane      *C.struct_ncplane
+	pixel      bool
+	rgba       []byte
+	keyMap     hachi.KeyLayout
+	pressed    map[uint16]time.Time
+	quit       chan struct{}
+	dirty      bool
+	lastUpdate time.Time
+}
+
+// foreground and background colors as RGBA
+var (
+	fgColor = [4]byte{0xFF, 0xFF, 0xFF, 0xFF}
+	bgColor = [4]byte{0x00, 0x00, 0x00, 0xFF}
+)
+
+func (d *NotcursesDriver) OnInit(c *hachi.Chip8) {
+	d.stop()
+
+	d.keyMap = c.KeyLayout()
+	if d.keyMap == nil {
+		d.keyMap = hachi.KeyLayouts["octo"]
+	}
+	d.pressed = make(map[uint16]time.Time)
+	d.quit = make(chan struct{})
+	w, h := c.DisplaySize()
+	d.rgba = make([]byte, w*h*4)
+
+	d.nc = C.hachi_init()
+	if d.nc == nil {
+		c.Logger().Println("NotcursesDriver failed to initialize notcurses")
+		close(d.quit)
+		return
+	}
+	d.plane = C.hachi_plane(d.nc)
+	d.pixel = C.notcurses_check_pixel_support(d.nc) > 0
+
+	d.dirty = true
+	c.Logger().Println("NotcursesDriver initialized, pixel graphics:", d.pixel)
+}
+
+// stop restores the terminal and signals the caller to quit.
+func (d *NotcursesDriver) stop() {
+	if d.nc == nil {
+		return
+	}
+	C.notcurses_stop(d.nc)
+	d.nc = nil
+	d.plane = nil
+	close(d.quit)
+}
+
+func (d *NotcursesDriver) Cls() {}
+
+// pollKeys updates the held keys from the pending input events.
+// Terminals that support the kitty keyboard protocol report releases, the
+// others only report presses, so keys are also released automatically after
+// term.KeyTimeout.
+func (d *NotcursesDriver) pollKeys() {
+	for {
+		var release C.int
+		r := rune(C.hachi_key(d.nc, &release))
+		if r == 0 {
+			break
+		}
+		if r == 3 { // Ctrl-C
+			d.stop()
+			return
+		}
+		key := d.keyMap[unicode.ToLower(r)]
+		if key == 0 {
+			continue
+		}
+		if release != 0 {
+			delete(d.pressed, key)
+		} else {
+			d.pressed[key] = time.Now()
+		}
+	}
+
+	for key, t := range d.pressed {
+		if time.Since(t) > term.KeyTimeout {
+			delete(d.pressed, key)
+		}
+	}
+}
+
+func (d *NotcursesDriver) OnUpdate(c *hachi.Chip8) {
+	if d.nc == nil {
+		return
+	}
+
+	d.pollKeys()
+	if d.nc == nil {
+		return
+	}
+	c.Keyboard = 0
+	for key := range d.pressed {
+		c.Keyboard |= key
+	}
+
+	// don't flood the terminal, 60hz is plenty
+	if !d.dirty || time.Since(d.lastUpdate) < time.Second/60 {
+		return
+	}
+	d.lastUpdate = time.Now()
+	d.dirty = false
+
+	screen := c.DisplayScreen()
+	w, h := c.DisplaySize()
+	byteWidth := w / 8
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			px := d.rgba[(y*w+x)*4:]
+			if screen[y*byteWidth+x/8]&(0x80>>uint(x%8)) != 0 {
+				copy(px, fgColor[:])
+			} else {
+				copy(px, bgColor[:])
+			}
+		}
+	}
+
+	pixel := C.int(0)
+	if d.pixel {
+		pixel = 1
+	}
+	C.hachi_blit(d.nc, d.plane, unsafe.Pointer(&d.rgba[0]),
+		C.int(h), C.int(w), pixel)
+}
+
+func (d *NotcursesDriver) UpdateScreen(c *hachi.Chip8) { d.dirty = true }
+
+func (d *NotcursesDriver) Beep() {}
+
+// OnShutdown restores the terminal if the user didn't quit already.
+func (d *NotcursesDriver) OnShutdown(c *hachi.Chip8) { d.stop() }
+
+func (d *NotcursesDriver) GetData(key string) interface{} {
+	switch key {
+	case "quit":
+		return (<-chan struct{})(d.quit)
+	case "pixel":
+		return d.pixel
+	}
+	return nil
+}
+
+func (d *NotcursesDriver) SetData(key string, value interface{}) error {
+	switch key {
+	case "pixel":
+		// allows forcing the character-cell blitters on pixel terminals
+		pixel, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("Invalid value %v for pixel.", value)
+		}
+		d.pixel = pixel && d.nc != nil &&
+			C.notcurses_check_pixel_support(d.nc) > 0
+		d.dirty = true
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("notcurses", &NotcursesDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
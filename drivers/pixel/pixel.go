@@ -0,0 +1,270 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package pixel implements a windowed syscall driver for the pixel game
+// library.
+//
+// Pixel requires all window calls to happen on the main thread, so the
+// emulator must be created and run inside opengl.Run. The driver opens the
+// window in OnInit, and the caller can then retrieve it from
+// GetDriverData("window") and call Tick() on the emulator until the window is
+// closed:
+//
+//	opengl.Run(func() {
+//		ha, _ := hachi.New("pixel", nil)
+//		// load program...
+//		win := ha.GetDriverData("window").(*opengl.Window)
+//		for !win.Closed() {
+//			ha.Tick()
+//		}
+//	})
+//
+// The screen is scaled by the largest integer factor that fits the window and
+// centered. F11 toggles fullscreen.
+//
+// Key mappings can be modified through SetDriverData("key_map", myMap), where
+// myMap is a map map[pixel.Button]uint16 with pixel buttons as keys and
+// Chip-8 keys (hachi.Key0...hachi.KeyF) as values. By default, the key layout
+// from the settings is used, or the octo layout if none is set.
+//
+// The beep is silent by default, a sound backend can be picked through
+// SetDriverData("beeper", name) (see package beep).
+package pixel
+
+import (
+	"fmt"
+	"github.com/Francesco149/go-hachi/drivers/beep"
+	"github.com/Francesco149/go-hachi/hachi"
+	"github.com/gopxl/pixel/v2"
+	"github.com/gopxl/pixel/v2/backends/opengl"
+	"github.com/gopxl/pixel/v2/ext/imdraw"
+	"log"
+	"reflect"
+	"time"
+)
+
+// A PixelDriver is a windowed driver that uses the pixel library.
+type PixelDriver struct {
+	hachi.Driver
+	win        *opengl.Window
+	imd        *imdraw.IMDraw
+	keyMap     map[pixel.Button]uint16
+	windowed   pixel.Rect
+	dirty      bool
+	lastUpdate time.Time
+	beeper     beep.Beeper
+	scale      int // initial size of a CHIP-8 pixel in window pixels
+}
+
+// buttons for the characters used by hachi.KeyLayouts
+var runeButtons = map[rune]pixel.Button{
+	'0': pixel.Key0, '1': pixel.Key1, '2': pixel.Key2, '3': pixel.Key3,
+	'4': pixel.Key4, '5': pixel.Key5, '6': pixel.Key6, '7': pixel.Key7,
+	'8': pixel.Key8, '9': pixel.Key9,
+	'a': pixel.KeyA, 'b': pixel.KeyB, 'c': pixel.KeyC, 'd': pixel.KeyD,
+	'e': pixel.KeyE, 'f': pixel.KeyF, 'g': pixel.KeyG, 'h': pixel.KeyH,
+	'i': pixel.KeyI, 'j': pixel.KeyJ, 'k': pixel.KeyK, 'l': pixel.KeyL,
+	'm': pixel.KeyM, 'n': pixel.KeyN, 'o': pixel.KeyO, 'p': pixel.KeyP,
+	'q': pixel.KeyQ, 'r': pixel.KeyR, 's': pixel.KeyS, 't': pixel.KeyT,
+	'u': pixel.KeyU, 'v': pixel.KeyV, 'w': pixel.KeyW, 'x': pixel.KeyX,
+	'y': pixel.KeyY, 'z': pixel.KeyZ,
+	'/': pixel.KeyKPDivide, '*': pixel.KeyKPMultiply,
+	'-': pixel.KeyKPSubtract, '+': pixel.KeyKPAdd,
+	'.': pixel.KeyKPDecimal, '\r': pixel.KeyKPEnter,
+}
+
+func (d *PixelDriver) setKeyLayout(layout hachi.KeyLayout) {
+	d.keyMap = make(map[pixel.Button]uint16)
+	for r, key := range layout {
+		if b, ok := runeButtons[r]; ok {
+			d.keyMap[b] = key
+		}
+	}
+}
+
+func (d *PixelDriver) OnInit(c *hachi.Chip8) {
+	d.beeper = beep.Silent{}
+	layout := c.KeyLayout()
+	if layout == nil {
+		layout = hachi.KeyLayouts["octo"]
+	}
+	d.setKeyLayout(layout)
+
+	if d.scale == 0 {
+		d.scale = 10
+	}
+
+	var err error
+	w, h := c.DisplaySize()
+	d.windowed = pixel.R(0, 0, float64(w*d.scale), float64(h*d.scale))
+	d.win, err = opengl.NewWindow(opengl.WindowConfig{
+		Title:     "hachi",
+		Bounds:    d.windowed,
+		VSync:     true,
+		Resizable: true,
+	})
+	if err != nil {
+		c.Logger().Println("PixelDriver failed to open window:", err)
+		d.win = nil
+		return
+	}
+
+	d.imd = imdraw.New(nil)
+	d.dirty = true
+	c.Logger().Println("PixelDriver initialized")
+}
+
+// OnLoad shows the program's title in the window title.
+func (d *PixelDriver) OnLoad(c *hachi.Chip8, info hachi.RomInfo) {
+	if d.win != nil && info.Title() != "" {
+		d.win.SetTitle("hachi - " + info.Title())
+	}
+}
+
+func (d *PixelDriver) Cls() {}
+
+// toggleFullscreen switches between windowed mode and fullscreen on the
+// primary monitor.
+func (d *PixelDriver) toggleFullscreen() {
+	if d.win.Monitor() != nil {
+		d.win.SetMonitor(nil)
+		d.win.SetBounds(d.windowed)
+		return
+	}
+	d.windowed = d.win.Bounds()
+	d.win.SetMonitor(opengl.PrimaryMonitor())
+}
+
+// draw batches all lit pixels into a single imdraw and blits it, scaled by
+// the largest integer factor that fits the window.
+func (d *PixelDriver) draw(c *hachi.Chip8) {
+	bounds := d.win.Bounds()
+	w, h := c.DisplaySize()
+	scale := int(bounds.W()) / w
+	if s := int(bounds.H()) / h; s < scale {
+		scale = s
+	}
+	if scale < 1 {
+		scale = 1
+	}
+
+	// center the screen in the window
+	fscale := float64(scale)
+	offX := (bounds.W() - float64(w)*fscale) / 2
+	offY := (bounds.H() - float64(h)*fscale) / 2
+
+	d.imd.Clear()
+
+	screen := c.DisplayScreen()
+	byteWidth := w / 8
+	for y := 0; y < h; y++ {
+		// pixel's origin is the bottom left corner
+		top := offY + float64(h-y)*fscale
+		for x := 0; x < w; x++ {
+			if screen[y*byteWidth+x/8]&(0x80>>uint(x%8)) == 0 {
+				continue
+			}
+			left := offX + float64(x)*fscale
+			d.imd.Color = c.PixelColor(x, y)
+			d.imd.Push(pixel.V(left, top-fscale), pixel.V(left+fscale, top))
+			d.imd.Rectangle(0)
+		}
+	}
+
+	d.win.Clear(c.BackgroundColor())
+	d.imd.Draw(d.win)
+}
+
+func (d *PixelDriver) OnUpdate(c *hachi.Chip8) {
+	if d.win == nil {
+		return
+	}
+
+	// updating the window is expensive, so only do it at 60hz
+	if time.Since(d.lastUpdate) < time.Second/60 {
+		return
+	}
+	d.lastUpdate = time.Now()
+
+	if d.win.JustPressed(pixel.KeyF11) {
+		d.toggleFullscreen()
+		d.dirty = true
+	}
+
+	c.Keyboard = 0
+	for button, key := range d.keyMap {
+		if d.win.Pressed(button) {
+			c.Keyboard |= key
+		}
+	}
+
+	if d.dirty {
+		d.draw(c)
+		d.dirty = false
+	}
+	d.win.Update()
+}
+
+func (d *PixelDriver) UpdateScreen(c *hachi.Chip8) { d.dirty = true }
+
+func (d *PixelDriver) Beep() { d.beeper.Beep() }
+
+func (d *PixelDriver) GetData(key string) interface{} {
+	switch key {
+	case "window":
+		return d.win
+	case "scale":
+		return d.scale
+	}
+	return nil
+}
+
+func (d *PixelDriver) SetData(key string, value interface{}) error {
+	switch key {
+	case "beeper":
+		b, err := beep.FromData(value, nil)
+		if err != nil {
+			return err
+		}
+		d.beeper = b
+		return nil
+	case "key_map":
+		newMap, ok := value.(map[pixel.Button]uint16)
+		if !ok {
+			return fmt.Errorf("Invalid type %s for key_map.",
+				reflect.TypeOf(value))
+		}
+		d.keyMap = newMap
+		return nil
+	case "scale":
+		// only takes effect on the next OnInit
+		scale, ok := value.(int)
+		if !ok || scale < 1 {
+			return fmt.Errorf("Invalid scale %v.", value)
+		}
+		d.scale = scale
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("pixel", &PixelDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
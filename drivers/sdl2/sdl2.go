@@ -0,0 +1,342 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package sdl2 implements a windowed syscall driver for SDL2, with real
+// key-down and key-up events and a square wave beep.
+//
+// SDL wants its calls to come from the main thread, so the emulator must run
+// on the main goroutine (the package locks it to the main thread in init).
+// The driver opens the window in OnInit and exposes a channel through
+// GetDriverData("closed") which is closed when the window is closed:
+//
+//	ha, _ := hachi.New("sdl2", nil)
+//	// load program...
+//	closed := ha.GetDriverData("closed").(chan struct{})
+//	for {
+//		select {
+//		case <-closed:
+//			ha.Shutdown()
+//			return
+//		default:
+//			ha.Tick()
+//		}
+//	}
+//
+// The screen is scaled by the largest integer factor that fits the window and
+// centered. F11 toggles fullscreen. Keys are bound according to the key layout
+// from the settings, or the octo layout if none is set.
+//
+// The beep is a square wave played through SDL's audio. Another sound backend
+// can be picked through SetDriverData("beeper", name) (see package beep).
+package sdl2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/Francesco149/go-hachi/drivers/beep"
+	"github.com/Francesco149/go-hachi/hachi"
+	"github.com/veandco/go-sdl2/sdl"
+	"log"
+	"runtime"
+	"time"
+)
+
+const (
+	sampleRate = 44100
+	frequency  = 440
+	volume     = 0x1000
+	period     = sampleRate / frequency // in samples
+	// samples per beep, a bit over one frame's worth so that it ends on a
+	// whole period and consecutive beeps join without clicking
+	beepSamples = (sampleRate/60 + period - 1) / period * period
+)
+
+// An SDL2Driver is a windowed driver that uses SDL2.
+type SDL2Driver struct {
+	hachi.Driver
+	win        *sdl.Window
+	renderer   *sdl.Renderer
+	audio      sdl.AudioDeviceID
+	tone       []byte // one frame of square wave
+	keyMap     map[sdl.Keycode]uint16
+	keys       uint16 // keys currently held down
+	closed     chan struct{}
+	dirty      bool
+	lastUpdate time.Time
+	beeper     beep.Beeper
+	scale      int // initial size of a CHIP-8 pixel in window pixels
+}
+
+// keycodes for the keypad characters used by hachi.KeyLayouts, letters and
+// digits are their own keycode
+var runeKeys = map[rune]sdl.Keycode{
+	'/': sdl.K_KP_DIVIDE, '*': sdl.K_KP_MULTIPLY,
+	'-': sdl.K_KP_MINUS, '+': sdl.K_KP_PLUS,
+	'.': sdl.K_KP_PERIOD, '\r': sdl.K_KP_ENTER,
+}
+
+func (d *SDL2Driver) setKeyLayout(layout hachi.KeyLayout) {
+	d.keyMap = make(map[sdl.Keycode]uint16)
+	for r, key := range layout {
+		code, ok := runeKeys[r]
+		if !ok {
+			code = sdl.Keycode(r)
+		}
+		d.keyMap[code] = key
+	}
+}
+
+func (d *SDL2Driver) OnInit(c *hachi.Chip8) {
+	layout := c.KeyLayout()
+	if layout == nil {
+		layout = hachi.KeyLayouts["octo"]
+	}
+	d.setKeyLayout(layout)
+	d.closed = make(chan struct{})
+	if d.scale == 0 {
+		d.scale = 10
+	}
+
+	err := sdl.Init(sdl.INIT_VIDEO | sdl.INIT_AUDIO)
+	if err != nil {
+		c.Logger().Println("SDL2Driver failed to initialize SDL:", err)
+		return
+	}
+	w, h := c.DisplaySize()
+	d.win, err = sdl.CreateWindow("hachi", sdl.WINDOWPOS_UNDEFINED,
+		sdl.WINDOWPOS_UNDEFINED, int32(w*d.scale), int32(h*d.scale),
+		sdl.WINDOW_RESIZABLE)
+	if err != nil {
+		c.Logger().Println("SDL2Driver failed to open window:", err)
+		d.win = nil
+		return
+	}
+	d.renderer, err = sdl.CreateRenderer(d.win, -1, sdl.RENDERER_ACCELERATED)
+	if err != nil {
+		c.Logger().Println("SDL2Driver failed to create renderer:", err)
+		d.win.Destroy()
+		d.win = nil
+		return
+	}
+	d.renderer.SetLogicalSize(int32(w), int32(h))
+	d.renderer.SetIntegerScale(true)
+
+	if d.beeper == nil {
+		d.openAudio(c)
+	}
+	d.dirty = true
+	c.Logger().Println("SDL2Driver initialized")
+}
+
+// openAudio opens the sound card for the square wave beep, or falls back to
+// a silent beep.
+func (d *SDL2Driver) openAudio(c *hachi.Chip8) {
+	d.beeper = beep.Silent{}
+	spec := sdl.AudioSpec{
+		Freq:     sampleRate,
+		Format:   sdl.AUDIO_S16LSB,
+		Channels: 1,
+		Samples:  512,
+	}
+	dev, err := sdl.OpenAudioDevice("", false, &spec, nil, 0)
+	if err != nil {
+		c.Logger().Println("SDL2Driver failed to open audio:", err)
+		return
+	}
+	d.audio = dev
+	d.tone = make([]byte, beepSamples*2)
+	for i := 0; i < beepSamples; i++ {
+		sample := int16(volume)
+		if i%period >= period/2 {
+			sample = -volume
+		}
+		binary.LittleEndian.PutUint16(d.tone[i*2:], uint16(sample))
+	}
+	sdl.PauseAudioDevice(dev, false)
+	d.beeper = nil
+}
+
+// OnLoad shows the program's title in the window title.
+func (d *SDL2Driver) OnLoad(c *hachi.Chip8, info hachi.RomInfo) {
+	if d.win != nil && info.Title() != "" {
+		d.win.SetTitle("hachi - " + info.Title())
+	}
+}
+
+func (d *SDL2Driver) Cls() {}
+
+// toggleFullscreen switches between windowed mode and fullscreen.
+func (d *SDL2Driver) toggleFullscreen() {
+	if d.win.GetFlags()&sdl.WINDOW_FULLSCREEN_DESKTOP != 0 {
+		d.win.SetFullscreen(0)
+	} else {
+		d.win.SetFullscreen(sdl.WINDOW_FULLSCREEN_DESKTOP)
+	}
+}
+
+// draw fills a rectangle for every lit pixel, the renderer's logical size
+// takes care of scaling and centering.
+func (d *SDL2Driver) draw(c *hachi.Chip8) {
+	bg := c.BackgroundColor()
+	d.renderer.SetDrawColor(0, 0, 0, 0xFF)
+	d.renderer.Clear()
+	w, h := c.DisplaySize()
+	d.renderer.SetDrawColor(bg.R, bg.G, bg.B, bg.A)
+	d.renderer.FillRect(&sdl.Rect{W: int32(w), H: int32(h)})
+
+	screen := c.DisplayScreen()
+	byteWidth := w / 8
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if screen[y*byteWidth+x/8]&(0x80>>uint(x%8)) == 0 {
+				continue
+			}
+			col := c.PixelColor(x, y)
+			d.renderer.SetDrawColor(col.R, col.G, col.B, col.A)
+			d.renderer.FillRect(&sdl.Rect{X: int32(x), Y: int32(y), W: 1,
+				H: 1})
+		}
+	}
+	d.renderer.Present()
+}
+
+// pollEvents handles the window and keyboard events.
+func (d *SDL2Driver) pollEvents() {
+	for e := sdl.PollEvent(); e != nil; e = sdl.PollEvent() {
+		switch e := e.(type) {
+		case *sdl.QuitEvent:
+			d.close()
+		case *sdl.WindowEvent:
+			d.dirty = true
+		case *sdl.KeyboardEvent:
+			if e.Repeat != 0 {
+				continue
+			}
+			if e.Type == sdl.KEYDOWN && e.Keysym.Sym == sdl.K_F11 {
+				d.toggleFullscreen()
+				continue
+			}
+			key := d.keyMap[e.Keysym.Sym]
+			if e.Type == sdl.KEYDOWN {
+				d.keys |= key
+			} else {
+				d.keys &^= key
+			}
+		}
+	}
+}
+
+func (d *SDL2Driver) close() {
+	select {
+	case <-d.closed:
+	default:
+		close(d.closed)
+	}
+}
+
+func (d *SDL2Driver) OnUpdate(c *hachi.Chip8) {
+	if d.win == nil {
+		return
+	}
+
+	// polling the window is expensive, so only do it at 60hz
+	if time.Since(d.lastUpdate) < time.Second/60 {
+		return
+	}
+	d.lastUpdate = time.Now()
+
+	d.pollEvents()
+	c.Keyboard = d.keys
+	if d.dirty {
+		d.draw(c)
+		d.dirty = false
+	}
+}
+
+func (d *SDL2Driver) UpdateScreen(c *hachi.Chip8) { d.dirty = true }
+
+// Beep queues one frame of square wave, unless enough is already queued to
+// last until the next call.
+func (d *SDL2Driver) Beep() {
+	if d.beeper != nil {
+		d.beeper.Beep()
+		return
+	}
+	if sdl.GetQueuedAudioSize(d.audio) < uint32(len(d.tone)) {
+		sdl.QueueAudio(d.audio, d.tone)
+	}
+}
+
+// OnShutdown closes the window and the audio device.
+func (d *SDL2Driver) OnShutdown(c *hachi.Chip8) {
+	if d.audio != 0 {
+		sdl.CloseAudioDevice(d.audio)
+		d.audio = 0
+	}
+	if d.renderer != nil {
+		d.renderer.Destroy()
+		d.renderer = nil
+	}
+	if d.win != nil {
+		d.win.Destroy()
+		d.win = nil
+	}
+	sdl.Quit()
+}
+
+func (d *SDL2Driver) GetData(key string) interface{} {
+	switch key {
+	case "window":
+		return d.win
+	case "closed":
+		return d.closed
+	case "scale":
+		return d.scale
+	}
+	return nil
+}
+
+func (d *SDL2Driver) SetData(key string, value interface{}) error {
+	switch key {
+	case "beeper":
+		b, err := beep.FromData(value, nil)
+		if err != nil {
+			return err
+		}
+		d.beeper = b
+		return nil
+	case "scale":
+		// only takes effect on the next OnInit
+		scale, ok := value.(int)
+		if !ok || scale < 1 {
+			return fmt.Errorf("Invalid scale %v.", value)
+		}
+		d.scale = scale
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	// SDL must be called from the main thread
+	runtime.LockOSThread()
+
+	err := hachi.RegisterDriver("sdl2", &SDL2Driver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
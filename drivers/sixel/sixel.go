@@ -0,0 +1,224 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package sixel implements a syscall driver that renders the screen as sixel
+// graphics, for terminals that support them (xterm, mlterm, foot, ...).
+// This gives pixel-perfect output in the terminal instead of character cells.
+//
+// The driver takes over stdin and stdout. The caller must call Tick() on the
+// emulator until the channel returned by GetDriverData("quit") is closed,
+// which happens when the user presses Ctrl-C, and then call Shutdown() to
+// restore the terminal:
+//
+//	defer ha.Shutdown()
+//	quit := ha.GetDriverData("quit").(<-chan struct{})
+//	for {
+//		select {
+//		case <-quit:
+//			return
+//		default:
+//			ha.Tick()
+//		}
+//	}
+//
+// The size of each CHIP-8 pixel can be changed through
+// SetDriverData("scale", n) and keys are bound according to the key layout
+// from the settings (or the octo layout if none is set). The layout can be
+// changed at runtime through SetDriverData("key_layout", name).
+//
+// The beep is silent by default, a sound backend can be picked through
+// SetDriverData("beeper", name), for example "bell" to ring the terminal bell
+// (see package beep).
+package sixel
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Francesco149/go-hachi/drivers/beep"
+	"github.com/Francesco149/go-hachi/drivers/internal/term"
+	"github.com/Francesco149/go-hachi/hachi"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"time"
+)
+
+// A SixelDriver is a terminal driver that renders the screen as sixels.
+type SixelDriver struct {
+	hachi.Driver
+	t          *term.Terminal
+	scale      int
+	dirty      bool
+	lastUpdate time.Time
+	buf        bytes.Buffer
+	beeper     beep.Beeper
+}
+
+func (d *SixelDriver) OnInit(c *hachi.Chip8) {
+	d.beeper = beep.Silent{}
+	layout := c.KeyLayout()
+	if layout == nil {
+		layout = hachi.KeyLayouts["octo"]
+	}
+	if d.t != nil {
+		d.t.Close()
+	}
+	d.t = term.New(os.Stdin, os.Stdout, layout)
+
+	if d.scale == 0 {
+		d.scale = 4
+	}
+	d.dirty = true
+	c.Logger().Println("SixelDriver initialized")
+}
+
+func (d *SixelDriver) Cls() {}
+
+func (d *SixelDriver) OnUpdate(c *hachi.Chip8) {
+	c.Keyboard = d.t.Keyboard()
+
+	// don't flood the terminal, 60hz is plenty
+	if !d.dirty || time.Since(d.lastUpdate) < time.Second/60 {
+		return
+	}
+	d.lastUpdate = time.Now()
+	d.dirty = false
+
+	d.buf.Reset()
+	d.buf.WriteString("\x1b[H") // cursor home
+	w, h := c.DisplaySize()
+	encode(&d.buf, c.DisplayScreen(), w, h, d.scale)
+	d.t.Write(d.buf.Bytes())
+}
+
+func (d *SixelDriver) UpdateScreen(c *hachi.Chip8) { d.dirty = true }
+
+func (d *SixelDriver) Beep() { d.beeper.Beep() }
+
+// OnHalt prints the error under the last frame.
+func (d *SixelDriver) OnHalt(c *hachi.Chip8, err error) {
+	fmt.Fprintf(d.t, "\x1b[0m\r\nEmulator halted: %v\r\n", err)
+}
+
+// OnShutdown leaves raw mode and shows the cursor again.
+func (d *SixelDriver) OnShutdown(c *hachi.Chip8) { d.t.Close() }
+
+func (d *SixelDriver) GetData(key string) interface{} {
+	switch key {
+	case "quit":
+		return d.t.Quit()
+	case "scale":
+		return d.scale
+	}
+	return nil
+}
+
+func (d *SixelDriver) SetData(key string, value interface{}) error {
+	switch key {
+	case "beeper":
+		b, err := beep.FromData(value, d.t)
+		if err != nil {
+			return err
+		}
+		d.beeper = b
+		return nil
+	case "scale":
+		scale, ok := value.(int)
+		if !ok || scale < 1 {
+			return fmt.Errorf("Invalid scale %v.", value)
+		}
+		d.scale = scale
+		d.dirty = true
+		return nil
+	case "key_layout":
+		name, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("Invalid type %s for key_layout.",
+				reflect.TypeOf(value))
+		}
+		layout, err := hachi.GetKeyLayout(name)
+		if err != nil {
+			return err
+		}
+		d.t.SetKeyLayout(layout)
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+// encode writes a CHIP-8 screen buffer to w as a sixel image, with each
+// CHIP-8 pixel being scale*scale sixel pixels.
+func encode(w io.Writer, screen []byte, width, height, scale int) {
+	sw, sh := width*scale, height*scale
+	byteWidth := width / 8
+
+	// DCS, 1:1 aspect ratio, raster attributes and a black/white palette
+	fmt.Fprintf(w, "\x1bP0;1;0q\"1;1;%d;%d#0;2;0;0;0#1;2;100;100;100",
+		sw, sh)
+
+	// each sixel is a column of 6 vertical pixels, so the image is encoded
+	// in bands of 6 rows, once per color
+	for band := 0; band < sh; band += 6 {
+		for color := 0; color < 2; color++ {
+			if color != 0 {
+				io.WriteString(w, "$") // back to the start of the band
+			}
+			fmt.Fprintf(w, "#%d", color)
+
+			// run-length encode identical sixels
+			var last byte
+			run := 0
+			for x := 0; x <= sw; x++ {
+				var sixel byte
+				if x < sw {
+					for bit := 0; bit < 6 && band+bit < sh; bit++ {
+						y := (band + bit) / scale
+						px := x / scale
+						lit := screen[y*byteWidth+px/8]&(0x80>>uint(px%8)) != 0
+						if lit == (color == 1) {
+							sixel |= 1 << uint(bit)
+						}
+					}
+					sixel += 63
+				}
+				if run > 0 && (sixel != last || x == sw) {
+					if run > 3 {
+						fmt.Fprintf(w, "!%d%c", run, last)
+					} else {
+						w.Write(bytes.Repeat([]byte{last}, run))
+					}
+					run = 0
+				}
+				last = sixel
+				run++
+			}
+		}
+		io.WriteString(w, "-") // next band
+	}
+
+	io.WriteString(w, "\x1b\\") // ST
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("sixel", &SixelDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,252 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package ssh implements a syscall driver that hosts the emulator over ssh.
+// Every connected session sees the screen rendered with half-block characters
+// and can press keys, so a single emulator instance can be shared and played
+// remotely with any ssh client:
+//
+//	ssh -p 2222 localhost
+//
+// The server starts on the first Tick(). The listening address defaults to
+// ":2222" and can be changed through SetDriverData("addr", addr) before that.
+// A host key is generated on startup unless one is set through
+// SetDriverData("host_key_file", path).
+//
+// The caller must call Tick() on the emulator as usual, the server runs in
+// the background until Shutdown() is called. Keys are bound according to the key layout from the settings
+// (or the octo layout if none is set) and Ctrl-C disconnects a session.
+//
+// The beep is silent by default, a sound backend can be picked through
+// SetDriverData("beeper", name), for example "bell" to ring the terminal bell
+// of every session (see package beep).
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Francesco149/go-hachi/drivers/beep"
+	"github.com/Francesco149/go-hachi/drivers/internal/term"
+	"github.com/Francesco149/go-hachi/hachi"
+	gssh "github.com/gliderlabs/ssh"
+	"log"
+	"sync"
+	"time"
+)
+
+// A SSHDriver is a driver that serves the emulator to ssh sessions.
+type SSHDriver struct {
+	hachi.Driver
+	addr        string
+	hostKeyFile string
+	server      *gssh.Server
+	layout      hachi.KeyLayout
+	logger      *log.Logger
+	dirty       bool
+	lastUpdate  time.Time
+	buf         bytes.Buffer
+	beeper      beep.Beeper
+
+	mutex    sync.Mutex
+	sessions map[*term.Terminal]bool
+}
+
+func (d *SSHDriver) OnInit(c *hachi.Chip8) {
+	d.beeper = beep.Silent{}
+	d.layout = c.KeyLayout()
+	if d.layout == nil {
+		d.layout = hachi.KeyLayouts["octo"]
+	}
+	if d.addr == "" {
+		d.addr = ":2222"
+	}
+	d.logger = c.Logger()
+
+	if d.server != nil {
+		d.server.Close()
+		d.server = nil
+	}
+	d.mutex.Lock()
+	d.sessions = make(map[*term.Terminal]bool)
+	d.mutex.Unlock()
+
+	d.dirty = true
+	d.logger.Println("SSHDriver initialized")
+}
+
+// listen starts the ssh server in the background.
+func (d *SSHDriver) listen() {
+	d.server = &gssh.Server{Addr: d.addr, Handler: d.handle}
+	if d.hostKeyFile != "" {
+		err := d.server.SetOption(gssh.HostKeyFile(d.hostKeyFile))
+		if err != nil {
+			d.logger.Println("SSHDriver failed to load host key:", err)
+		}
+	}
+
+	go func(server *gssh.Server) {
+		err := server.ListenAndServe()
+		if err != nil && err != gssh.ErrServerClosed {
+			d.logger.Println("SSHDriver:", err)
+		}
+	}(d.server)
+
+	d.logger.Println("SSHDriver listening on", d.addr)
+}
+
+// handle serves a single ssh session until it disconnects.
+func (d *SSHDriver) handle(s gssh.Session) {
+	if _, _, isPty := s.Pty(); !isPty {
+		fmt.Fprintln(s.Stderr(), "A pty is required, try ssh -t.")
+		return
+	}
+
+	t := term.New(s, s, d.layout)
+	d.mutex.Lock()
+	d.sessions[t] = true
+	d.dirty = true // new sessions need a full frame
+	d.mutex.Unlock()
+	d.logger.Println("SSHDriver:", s.RemoteAddr(), "connected")
+
+	select {
+	case <-t.Quit():
+	case <-s.Context().Done():
+		t.Close()
+	}
+
+	d.mutex.Lock()
+	delete(d.sessions, t)
+	d.mutex.Unlock()
+	d.logger.Println("SSHDriver:", s.RemoteAddr(), "disconnected")
+}
+
+// broadcast writes to every connected session.
+type broadcast struct{ d *SSHDriver }
+
+func (b broadcast) Write(p []byte) (int, error) {
+	b.d.mutex.Lock()
+	defer b.d.mutex.Unlock()
+	for t := range b.d.sessions {
+		t.Write(p)
+	}
+	return len(p), nil
+}
+
+func (d *SSHDriver) Cls() {}
+
+func (d *SSHDriver) OnUpdate(c *hachi.Chip8) {
+	if d.server == nil {
+		d.listen()
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// everyone shares the same keypad
+	c.Keyboard = 0
+	for t := range d.sessions {
+		c.Keyboard |= t.Keyboard()
+	}
+
+	// don't flood the connections, 60hz is plenty
+	if !d.dirty || time.Since(d.lastUpdate) < time.Second/60 {
+		return
+	}
+	d.lastUpdate = time.Now()
+	d.dirty = false
+
+	d.buf.Reset()
+	w, h := c.DisplaySize()
+	term.RenderHalfBlocks(&d.buf, c.DisplayScreen(), w, h)
+	for t := range d.sessions {
+		t.Write(d.buf.Bytes())
+	}
+}
+
+func (d *SSHDriver) UpdateScreen(c *hachi.Chip8) {
+	d.mutex.Lock()
+	d.dirty = true
+	d.mutex.Unlock()
+}
+
+func (d *SSHDriver) Beep() { d.beeper.Beep() }
+
+// OnHalt tells every session why the game stopped.
+func (d *SSHDriver) OnHalt(c *hachi.Chip8, err error) {
+	fmt.Fprintf(broadcast{d}, "\x1b[0m\r\nEmulator halted: %v\r\n", err)
+}
+
+// OnShutdown disconnects every session and stops the server.
+func (d *SSHDriver) OnShutdown(c *hachi.Chip8) {
+	if d.server != nil {
+		d.server.Close()
+		d.server = nil
+	}
+	d.mutex.Lock()
+	for t := range d.sessions {
+		t.Close()
+	}
+	d.mutex.Unlock()
+}
+
+func (d *SSHDriver) GetData(key string) interface{} {
+	switch key {
+	case "server":
+		return d.server
+	case "addr":
+		return d.addr
+	case "sessions":
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		return len(d.sessions)
+	}
+	return nil
+}
+
+func (d *SSHDriver) SetData(key string, value interface{}) error {
+	switch key {
+	case "beeper":
+		b, err := beep.FromData(value, broadcast{d})
+		if err != nil {
+			return err
+		}
+		d.beeper = b
+		return nil
+	case "addr":
+		addr, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("Invalid address %v.", value)
+		}
+		d.addr = addr
+		return nil
+	case "host_key_file":
+		path, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("Invalid host key file %v.", value)
+		}
+		d.hostKeyFile = path
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("ssh", &SSHDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
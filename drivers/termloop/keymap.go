@@ -0,0 +1,141 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package termloop
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	tl "github.com/JoelOtter/termloop"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A KeyMap binds termloop keys and printable characters (which termloop
+// reports as runes rather than keys) to Chip-8 keys. It can be passed to
+// SetDriverData("key_map", keyMap).
+type KeyMap struct {
+	Keys  map[tl.Key]uint16
+	Chars map[rune]uint16
+}
+
+// names of the special keys accepted by ParseKeyMap
+var keyNames = map[string]tl.Key{
+	"tab":       tl.KeyTab,
+	"enter":     tl.KeyEnter,
+	"space":     tl.KeySpace,
+	"backspace": tl.KeyBackspace2,
+	"esc":       tl.KeyEsc,
+	"up":        tl.KeyArrowUp,
+	"down":      tl.KeyArrowDown,
+	"left":      tl.KeyArrowLeft,
+	"right":     tl.KeyArrowRight,
+	"insert":    tl.KeyInsert,
+	"delete":    tl.KeyDelete,
+	"home":      tl.KeyHome,
+	"end":       tl.KeyEnd,
+	"pgup":      tl.KeyPgup,
+	"pgdn":      tl.KeyPgdn,
+	"f1":        tl.KeyF1,
+	"f2":        tl.KeyF2,
+	"f3":        tl.KeyF3,
+	"f4":        tl.KeyF4,
+	"f5":        tl.KeyF5,
+	"f6":        tl.KeyF6,
+	"f7":        tl.KeyF7,
+	"f8":        tl.KeyF8,
+	"f9":        tl.KeyF9,
+	"f10":       tl.KeyF10,
+	"f11":       tl.KeyF11,
+	"f12":       tl.KeyF12,
+}
+
+// ParseKeyMap parses a key map from a list of host=chip8 bindings separated
+// by commas or new lines, for example "1=Key1,q=Key4,up=Key8".
+// host is either a single character or the name of a special key (tab,
+// enter, space, backspace, esc, up, down, left, right, insert, delete, home,
+// end, pgup, pgdn, f1...f12 or ctrl-a...ctrl-z, case insensitive).
+// chip8 is a hex digit, optionally prefixed with Key.
+// Empty lines and lines starting with # are ignored, so key maps can be kept
+// in files (see ReadKeyMap).
+func ParseKeyMap(spec string) (*KeyMap, error) {
+	return ReadKeyMap(strings.NewReader(spec))
+}
+
+// ReadKeyMap reads a key map in the ParseKeyMap format.
+func ReadKeyMap(r io.Reader) (*KeyMap, error) {
+	m := &KeyMap{Keys: make(map[tl.Key]uint16), Chars: make(map[rune]uint16)}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		for _, binding := range strings.Split(text, ",") {
+			binding = strings.TrimSpace(binding)
+			if binding == "" {
+				continue
+			}
+			err := m.bind(binding)
+			if err != nil {
+				return nil, fmt.Errorf("Key map line %d: %v", line, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// bind adds a host=chip8 binding to the key map.
+func (m *KeyMap) bind(binding string) error {
+	// split on the last = so that = itself can be bound
+	i := strings.LastIndex(binding, "=")
+	if i <= 0 {
+		return fmt.Errorf("Invalid binding '%s', expected host=chip8.",
+			binding)
+	}
+	host, target := strings.TrimSpace(binding[:i]),
+		strings.TrimSpace(binding[i+1:])
+
+	digit := strings.TrimPrefix(strings.ToLower(target), "key")
+	n, err := strconv.ParseUint(digit, 16, 8)
+	if err != nil || len(digit) != 1 {
+		return fmt.Errorf("Invalid Chip-8 key '%s' in '%s'.", target,
+			binding)
+	}
+	key := hachi.KeyFlags[n]
+
+	if utf8.RuneCountInString(host) == 1 {
+		r, _ := utf8.DecodeRuneInString(host)
+		m.Chars[r] = key
+		return nil
+	}
+	name := strings.ToLower(host)
+	if k, ok := keyNames[name]; ok {
+		m.Keys[k] = key
+		return nil
+	}
+	if len(name) == 6 && strings.HasPrefix(name, "ctrl-") &&
+		name[5] >= 'a' && name[5] <= 'z' {
+		m.Keys[tl.Key(name[5]-'a'+1)] = key
+		return nil
+	}
+	return fmt.Errorf("Unknown key '%s' in '%s'.", host, binding)
+}
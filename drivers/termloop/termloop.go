@@ -17,19 +17,51 @@
 //
 // The driver initializes a termloop context which can then be retrieved from
 // GetDriverData("ctx"). The caller must then set up an entity that calls
-// Tick() on the emulator instance on every Draw call.
+// Frame() (or Tick()) on the emulator instance on every Draw call. If the
+// emulator halts on an error, the error is shown in the pane's syscall log
+// so the caller can keep the game running and report it after termloop
+// exits.
+//
+// Paused emulators don't receive key presses, so that the front-end can use
+// the keyboard for something else, like a debugger.
 //
 // Key mappings can be modified through SetDriverData("key_map", myMap), where
 // myMap is a map map[termloop.Key]uint16 with termloop keys as keys and
-// Chip-8 keys (hachi.Key0...hachi.KeyF) as values.
+// Chip-8 keys (hachi.Key0...hachi.KeyF) as values, a *KeyMap which can also
+// bind printable characters, or a string in the ParseKeyMap format such as
+// "1=Key1,q=Key4,up=Key8".
+//
+// Alternatively, one of the built-in hachi.KeyLayouts can be selected either
+// through the KeyLayout setting or at runtime through
+// SetDriverData("key_layout", name).
+//
+// The beep is silent by default, a sound backend can be picked through
+// SetDriverData("beeper", name), for example "bell" to ring the terminal bell
+// (see package beep). Either way, the border around the screen flashes while
+// the sound is on and a bar under the screen shows how much of it is left.
+//
+// Split screen: after calling SetDriverData("split", true), every emulator
+// instance that is created with this driver is added as a new pane to the
+// right of the existing ones instead of replacing them. Each pane uses the
+// key layout from its own settings, so two players can share a keyboard by
+// picking layouts that don't overlap (octo and numpad for example). Key
+// mappings set through SetDriverData apply to every pane.
+//
+// The screen is drawn with the basic terminal colors closest to the Palette
+// setting (see hachi.Palettes). Without a palette, lit pixels are white on
+// the terminal's background.
 package termloop
 
 import (
 	"fmt"
+	"github.com/Francesco149/go-hachi/drivers/beep"
 	"github.com/Francesco149/go-hachi/hachi"
 	tl "github.com/JoelOtter/termloop"
+	"image/color"
 	"log"
+	"os"
 	"reflect"
+	"strings"
 	"time"
 )
 
@@ -37,7 +69,19 @@ import (
 // It shows the current emulator state in real time and the screen.
 type TermloopDriver struct {
 	hachi.Driver
-	g                 *tl.Game
+	g      *tl.Game
+	panes  []*pane
+	split  bool
+	beeper beep.Beeper
+	// the pane whose emulator is currently ticking, used for the calls that
+	// don't pass the emulator instance
+	current *pane
+}
+
+// a pane holds the widgets and input state for one emulator instance
+type pane struct {
+	c                 *hachi.Chip8
+	x                 int // horizontal offset on screen
 	memory            *tl.Text
 	registers         *tl.Text
 	pointersAndTimers *tl.Text
@@ -45,49 +89,77 @@ type TermloopDriver struct {
 	stack             []*tl.Text
 	syscalls          [10]*tl.Text
 	screen            [][]*tl.Rectangle
+	background        *tl.Rectangle // behind the screen's pixels
 	lastScreen        []byte
+	soundMeter        *tl.Text
+	border            [4]*tl.Rectangle // flashes while the sound is on
+	borderShown       bool
+	soundFrames       int // length of the current tone
 	keyMap            map[tl.Key]uint16
+	chMap             map[rune]uint16
+	timers            map[uint16]time.Time
+	// since termbox only polls for keydown events we need to add a timer to
+	// automatically release those keys
 }
 
-func (d *TermloopDriver) printSyscall(s string) {
+// paneWidth is the width of the pane of an emulator instance.
+func paneWidth(c *hachi.Chip8) int {
+	// the chip info text is about 60 characters wide
+	width, _ := c.DisplaySize()
+	if width < 62 {
+		width = 62
+	}
+	return 20 + width + 2
+}
+
+func (p *pane) printSyscall(s string) {
 	for i := 1; i < 10; i++ {
-		d.syscalls[i].SetText(d.syscalls[i-1].Text())
+		p.syscalls[i].SetText(p.syscalls[i-1].Text())
 	}
-	d.syscalls[0].SetText(s)
+	p.syscalls[0].SetText(s)
 }
 
 // just a wrapper entity to handle input
-type inputHandler struct {
-	c      *hachi.Chip8
-	d      *TermloopDriver
-	timers map[uint16]time.Time
-	// since termbox only polls for keydown events we need to add a timer to
-	// automatically release those keys
-}
+type inputHandler struct{ d *TermloopDriver }
 
 func (i *inputHandler) Draw(s *tl.Screen) {
-	for key, t := range i.timers {
-		if i.c.Keyboard&key == 0 {
-			continue
-		}
-		if time.Since(t) > time.Millisecond*100 {
-			i.c.Keyboard &= ^key
+	for _, p := range i.d.panes {
+		for key, t := range p.timers {
+			if time.Since(t) > time.Millisecond*100 {
+				p.c.KeyUp(key)
+				delete(p.timers, key)
+			}
 		}
 	}
 }
 
 func (i *inputHandler) Tick(ev tl.Event) {
-	if ev.Type == tl.EventKey {
-		keyMask := i.d.keyMap[ev.Key]
-		i.c.Keyboard |= keyMask
-		i.timers[keyMask] = time.Now()
+	if ev.Type != tl.EventKey {
+		return
+	}
+	for _, p := range i.d.panes {
+		if p.c.Paused() {
+			// the keys are for the front-end, a debugger for example
+			continue
+		}
+		keyMask := p.keyMap[ev.Key]
+		if ev.Key == 0 {
+			// printable characters are reported with a zero key
+			keyMask = p.chMap[ev.Ch]
+		}
+		if keyMask == 0 {
+			continue
+		}
+		p.c.KeyDown(keyMask)
+		p.timers[keyMask] = time.Now()
 	}
 }
 
-func (d *TermloopDriver) OnInit(c *hachi.Chip8) {
+// defaultKeyMap returns the driver's default key bindings.
+func defaultKeyMap() map[tl.Key]uint16 {
 	// hex keyboard with 16 keys.
 	// 8, 4, 6 and 2 are typically used for directional input.
-	d.keyMap = map[tl.Key]uint16{
+	return map[tl.Key]uint16{
 		tl.KeyTab:        hachi.Key0,
 		tl.KeyF2:         hachi.Key1,
 		tl.KeyF3:         hachi.Key2,
@@ -110,156 +182,433 @@ func (d *TermloopDriver) OnInit(c *hachi.Chip8) {
 		tl.KeyArrowUp:    hachi.Key8,
 		tl.KeyEnter:      hachi.Key5,
 	}
+}
 
-	// init termloop
-	d.g = tl.NewGame()
-	scr := d.g.Screen()
+func (d *TermloopDriver) OnInit(c *hachi.Chip8) {
+	if d.g == nil || !d.split {
+		// init termloop
+		d.g = tl.NewGame()
+		d.panes = nil
+		d.beeper = beep.Silent{}
+		d.g.Screen().AddEntity(&inputHandler{d})
+	}
+
+	x := 0
+	for _, p := range d.panes {
+		x += paneWidth(p.c)
+	}
+
+	p := &pane{
+		c:      c,
+		x:      x,
+		keyMap: defaultKeyMap(),
+		timers: make(map[uint16]time.Time),
+	}
+	if layout := c.KeyLayout(); layout != nil {
+		p.setKeyLayout(layout)
+	}
+	p.init(d.g.Screen())
+
+	d.panes = append(d.panes, p)
+	d.current = p
+	c.Logger().Println("TermloopDriver initialized")
+}
 
-	scr.AddEntity(&inputHandler{c, d, make(map[uint16]time.Time)})
-	scr.AddEntity(tl.NewText(0, 0, "Stack   Syscalls",
+// init creates the pane's widgets.
+func (p *pane) init(scr *tl.Screen) {
+	c := p.c
+	scr.AddEntity(tl.NewText(p.x, 0, "Stack   Syscalls",
 		tl.ColorDefault, tl.ColorDefault))
 
 	// stack
-	d.stack = make([]*tl.Text, len(c.Stack))
-	for i := 0; i < len(d.stack); i++ {
-		d.stack[i] = tl.NewText(
-			0, i+1, "", tl.ColorDefault, tl.ColorDefault)
-		d.g.Screen().AddEntity(d.stack[i])
+	p.stack = make([]*tl.Text, len(c.Stack))
+	for i := 0; i < len(p.stack); i++ {
+		p.stack[i] = tl.NewText(
+			p.x, i+1, "", tl.ColorDefault, tl.ColorDefault)
+		scr.AddEntity(p.stack[i])
 	}
 
 	// syscall log
 	for i := 0; i < 10; i++ {
-		d.syscalls[i] = tl.NewText(
-			8, i+1, "", tl.ColorDefault, tl.ColorDefault)
-		d.g.Screen().AddEntity(d.syscalls[i])
+		p.syscalls[i] = tl.NewText(
+			p.x+8, i+1, "", tl.ColorDefault, tl.ColorDefault)
+		scr.AddEntity(p.syscalls[i])
 	}
 
 	// chip info
-	d.memory = tl.NewText(20, 0, "placeholder",
+	p.memory = tl.NewText(p.x+20, 0, "placeholder",
 		tl.ColorDefault, tl.ColorDefault)
-	scr.AddEntity(d.memory)
+	scr.AddEntity(p.memory)
 
-	d.registers = tl.NewText(20, 1, "placeholder",
+	p.registers = tl.NewText(p.x+20, 1, "placeholder",
 		tl.ColorDefault, tl.ColorDefault)
-	scr.AddEntity(d.registers)
+	scr.AddEntity(p.registers)
 
-	d.pointersAndTimers = tl.NewText(20, 2, "placeholder",
+	p.pointersAndTimers = tl.NewText(p.x+20, 2, "placeholder",
 		tl.ColorDefault, tl.ColorDefault)
-	scr.AddEntity(d.pointersAndTimers)
+	scr.AddEntity(p.pointersAndTimers)
 
-	d.devices = tl.NewText(20, 3, "placeholder",
+	p.devices = tl.NewText(p.x+20, 3, "placeholder",
 		tl.ColorDefault, tl.ColorDefault)
-	scr.AddEntity(d.devices)
+	scr.AddEntity(p.devices)
+
+	p.soundMeter = tl.NewText(0, 0, "", tl.ColorYellow, tl.ColorDefault)
+	scr.AddEntity(p.soundMeter)
+
+	p.initScreen(scr)
+}
+
+// termColors lists the basic terminal colors with their usual RGB values.
+var termColors = []struct {
+	attr tl.Attr
+	rgb  color.RGBA
+}{
+	{tl.ColorBlack, color.RGBA{0x00, 0x00, 0x00, 0xFF}},
+	{tl.ColorRed, color.RGBA{0x80, 0x00, 0x00, 0xFF}},
+	{tl.ColorGreen, color.RGBA{0x00, 0x80, 0x00, 0xFF}},
+	{tl.ColorYellow, color.RGBA{0x80, 0x80, 0x00, 0xFF}},
+	{tl.ColorBlue, color.RGBA{0x00, 0x00, 0x80, 0xFF}},
+	{tl.ColorMagenta, color.RGBA{0x80, 0x00, 0x80, 0xFF}},
+	{tl.ColorCyan, color.RGBA{0x00, 0x80, 0x80, 0xFF}},
+	{tl.ColorWhite, color.RGBA{0xC0, 0xC0, 0xC0, 0xFF}},
+}
+
+// termColor returns the basic terminal color closest to col.
+func termColor(col color.RGBA) tl.Attr {
+	best, bestDist := tl.ColorDefault, -1
+	for _, t := range termColors {
+		dr := int(col.R) - int(t.rgb.R)
+		dg := int(col.G) - int(t.rgb.G)
+		db := int(col.B) - int(t.rgb.B)
+		dist := dr*dr + dg*dg + db*db
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = t.attr, dist
+		}
+	}
+	return best
+}
+
+// colors returns the terminal colors of lit and unlit pixels. Without a
+// palette, the screen is white on the terminal's background.
+func (p *pane) colors() (fg, bg tl.Attr) {
+	if p.c.Settings().Palette == "" {
+		return tl.ColorWhite, tl.ColorDefault
+	}
+	palette := p.c.DisplayPalette()
+	return termColor(palette.Foreground()), termColor(palette.Background())
+}
+
+// initScreen creates the rectangles for the screen preview and adds its
+// background to scr.
+func (p *pane) initScreen(scr *tl.Screen) {
+	c := p.c
 
 	// screen preview at 20,5
-	d.screen = make([][]*tl.Rectangle, c.Width)
-	color := tl.ColorWhite // foreground
+	w, h := c.DisplaySize()
+	p.screen = make([][]*tl.Rectangle, w)
+	fg, bg := p.colors()
 
-	for i := uint8(0); i < c.Width; i++ {
-		d.screen[i] = make([]*tl.Rectangle, c.Height)
+	for i := 0; i < w; i++ {
+		p.screen[i] = make([]*tl.Rectangle, h)
 
-		for j := uint8(0); j < c.Height; j++ {
-			d.screen[i][j] = tl.NewRectangle(
-				20+int(i), 5+int(j),
-				1, 1, color,
+		for j := 0; j < h; j++ {
+			p.screen[i][j] = tl.NewRectangle(
+				p.x+20+i, 5+j,
+				1, 1, fg,
 			)
 		}
 	}
 
-	d.lastScreen = make([]byte, uint16(c.Width)*uint16(c.Height)/8)
-	log.Println("TermloopDriver initialized")
+	// entities are drawn in the order they're added, so the pixels, which
+	// are only added on screen updates, end up on top
+	p.background = tl.NewRectangle(p.x+20, 5, w, h, bg)
+	scr.AddEntity(p.background)
+
+	p.lastScreen = make([]byte, w*h/8)
+
+	// sound indicators, around and under the screen
+	p.border = [4]*tl.Rectangle{
+		tl.NewRectangle(p.x+19, 4, w+2, 1, tl.ColorYellow),
+		tl.NewRectangle(p.x+19, 5+h, w+2, 1, tl.ColorYellow),
+		tl.NewRectangle(p.x+19, 5, 1, h, tl.ColorYellow),
+		tl.NewRectangle(p.x+20+w, 5, 1, h, tl.ColorYellow),
+	}
+	p.soundMeter.SetPosition(p.x+20, 6+h)
 }
 
-func (d *TermloopDriver) cls() {
-	scr := d.g.Screen()
-	for i := 0; i < len(d.screen); i++ {
-		for j := 0; j < len(d.screen[i]); j++ {
-			scr.RemoveEntity(d.screen[i][j])
+// soundMeterWidth is the width of the sound timer bar in characters.
+const soundMeterWidth = 32
+
+// updateSound shows the sound timer as a bar that depletes as the tone
+// plays and flashes the border around the screen while it's on, so that
+// beeps can be seen with the silent beeper.
+func (p *pane) updateSound(scr *tl.Screen) {
+	st := int(p.c.ST)
+	if st > p.soundFrames {
+		// the timer was set without going through OnSoundStart
+		p.soundFrames = st
+	}
+
+	meter := ""
+	if st > 0 {
+		filled := (st*soundMeterWidth + p.soundFrames - 1) / p.soundFrames
+		meter = "ST " + strings.Repeat("#", filled) +
+			strings.Repeat("-", soundMeterWidth-filled)
+	}
+	if meter != p.soundMeter.Text() {
+		p.soundMeter.SetText(meter)
+	}
+
+	// toggle the border every 4 frames
+	show := st > 0 && st/4%2 == 0
+	if show == p.borderShown {
+		return
+	}
+	for _, r := range p.border {
+		if show {
+			scr.AddEntity(r)
+		} else {
+			scr.RemoveEntity(r)
+		}
+	}
+	p.borderShown = show
+}
+
+// setKeyLayout replaces the current key bindings with a hachi.KeyLayout.
+// Control characters are mapped to the termloop keys that share their code.
+func (p *pane) setKeyLayout(layout hachi.KeyLayout) {
+	p.keyMap = make(map[tl.Key]uint16)
+	p.chMap = make(map[rune]uint16)
+	for r, key := range layout {
+		if r < 0x20 || r == 0x7F {
+			p.keyMap[tl.Key(r)] = key
+		} else {
+			p.chMap[r] = key
+		}
+	}
+}
+
+// OnReconfigure switches the emulator's pane to its new key layout, if any,
+// and palette.
+func (d *TermloopDriver) OnReconfigure(c *hachi.Chip8, old *hachi.Chip8Settings) {
+	p := d.pane(c)
+	if p == nil {
+		return
+	}
+	if layout := c.KeyLayout(); layout != nil {
+		p.setKeyLayout(layout)
+	}
+	if c.Settings().Palette != old.Palette {
+		p.setColors()
+	}
+}
+
+// setColors recolors the screen preview after a palette change.
+func (p *pane) setColors() {
+	fg, bg := p.colors()
+	for _, col := range p.screen {
+		for _, r := range col {
+			r.SetColor(fg)
+		}
+	}
+	p.background.SetColor(bg)
+}
+
+// pane returns the pane for an emulator instance.
+func (d *TermloopDriver) pane(c *hachi.Chip8) *pane {
+	if d.current != nil && d.current.c == c {
+		return d.current
+	}
+	for _, p := range d.panes {
+		if p.c == c {
+			return p
 		}
 	}
+	return nil
+}
+
+func (p *pane) cls(scr *tl.Screen) {
+	for i := 0; i < len(p.screen); i++ {
+		for j := 0; j < len(p.screen[i]); j++ {
+			scr.RemoveEntity(p.screen[i][j])
+		}
+	}
+	scr.RemoveEntity(p.background)
+	if p.borderShown {
+		for _, r := range p.border {
+			scr.RemoveEntity(r)
+		}
+		p.borderShown = false
+	}
 }
 
 func (d *TermloopDriver) Cls() {
-	d.printSyscall("CLS")
-	//d.cls()
+	d.current.printSyscall("CLS")
+	//d.current.cls(d.g.Screen())
 	// removed because it causes graphical glitches
 }
 
 func (d *TermloopDriver) OnUpdate(c *hachi.Chip8) {
+	p := d.pane(c)
+	if p == nil {
+		return
+	}
+	d.current = p
+
 	// update chip info
-	d.memory.SetText(fmt.Sprintf("Memory: %v bytes", len(c.Memory)))
-	d.registers.SetText(fmt.Sprintf("Registers: % 02X", c.V))
-	d.pointersAndTimers.SetText(
+	p.memory.SetText(fmt.Sprintf("Memory: %v bytes", len(c.Memory)))
+	p.registers.SetText(fmt.Sprintf("Registers: % 02X", c.V))
+	p.pointersAndTimers.SetText(
 		fmt.Sprintf("I: %04X SP: %v, PC: %04X, DT: %02X, ST: %02X",
 			c.I, c.SP, c.PC, c.DT, c.ST))
 
-	d.devices.SetText(fmt.Sprintf("Keyboard: %016b, Screen: %v*%v",
+	p.devices.SetText(fmt.Sprintf("Keyboard: %016b, Screen: %v*%v",
 		c.Keyboard, c.Width, c.Height))
 
 	// update stack
 	for i := 0; i < len(c.Stack); i++ {
 		if i <= c.SP {
-			d.stack[i].SetText(fmt.Sprintf("%04X", c.Stack[i]))
+			p.stack[i].SetText(fmt.Sprintf("%04X", c.Stack[i]))
 		} else {
-			d.stack[i].SetText("")
+			p.stack[i].SetText("")
 		}
 	}
+
+	p.updateSound(d.g.Screen())
 }
 
 func (d *TermloopDriver) UpdateScreen(c *hachi.Chip8) {
-	d.printSyscall("DRW")
+	p := d.pane(c)
+	if p == nil {
+		return
+	}
+	p.printSyscall("DRW")
 
-	if len(c.Screen) != len(d.lastScreen) {
+	scr := d.g.Screen()
+	screen := c.DisplayScreen()
+	if len(screen) != len(p.lastScreen) {
 		// this should handle unlikely resolution changes at runtime
-		d.cls()
-		d.screen = make([][]*tl.Rectangle, c.Width)
-		d.lastScreen = make([]byte, uint16(c.Width)*uint16(c.Height)/8)
+		p.cls(scr)
+		p.initScreen(scr)
 	}
 
-	scr := d.g.Screen()
-	byteWidth := c.Width / 8
-	for i := uint8(0); i < byteWidth; i++ {
-		for j := uint8(0); j < c.Height; j++ {
+	w, h := c.DisplaySize()
+	byteWidth := w / 8
+	for i := 0; i < byteWidth; i++ {
+		for j := 0; j < h; j++ {
 			// index in the screen byte array
-			index := uint16(j)*uint16(byteWidth) + uint16(i)
+			index := j*byteWidth + i
 
-			b1 := d.lastScreen[index]
-			b2 := c.Screen[index]
+			b1 := p.lastScreen[index]
+			b2 := screen[index]
 
 			// iterate this group of 8 pixels/bits and see what changed
 			mask := uint8(0x80)
-			for bit := uint8(0); bit < 8; bit++ {
+			for bit := 0; bit < 8; bit++ {
 				if b2&mask > b1&mask {
 					// this pixel was activated
-					scr.AddEntity(d.screen[i*8+bit][j])
+					scr.AddEntity(p.screen[i*8+bit][j])
 				} else if b2&mask < b1&mask {
 					// this pixel was deactivated
-					scr.RemoveEntity(d.screen[i*8+bit][j])
+					scr.RemoveEntity(p.screen[i*8+bit][j])
 				}
 				mask >>= 1
 			}
 		}
 	}
 
-	copy(d.lastScreen, c.Screen)
+	copy(p.lastScreen, screen)
 }
 
-func (d *TermloopDriver) Beep() { d.printSyscall("BEEP") }
+func (d *TermloopDriver) Beep() {
+	d.current.printSyscall("BEEP")
+	d.beeper.Beep()
+}
+
+// OnSoundStart remembers the length of the tone for the sound timer bar.
+func (d *TermloopDriver) OnSoundStart(c *hachi.Chip8, frames int) {
+	if p := d.pane(c); p != nil {
+		p.soundFrames = frames
+	}
+}
+
+func (d *TermloopDriver) OnSoundStop(c *hachi.Chip8) {
+	if p := d.pane(c); p != nil {
+		p.soundFrames = 0
+		p.updateSound(d.g.Screen())
+	}
+}
+
+// OnHalt logs the error in the halted pane.
+func (d *TermloopDriver) OnHalt(c *hachi.Chip8, err error) {
+	if p := d.pane(c); p != nil {
+		p.printSyscall(fmt.Sprint("HALT: ", err))
+	}
+}
 
 func (d *TermloopDriver) GetData(key string) interface{} {
-	if key == "ctx" {
+	switch key {
+	case "ctx":
 		return d.g
+	case "split":
+		return d.split
 	}
 	return nil
 }
 
 func (d *TermloopDriver) SetData(key string, value interface{}) error {
-	if key == "key_map" {
-		newMap, ok := value.(map[tl.Key]uint16)
+	switch key {
+	case "key_map":
+		var newMap *KeyMap
+		switch v := value.(type) {
+		case map[tl.Key]uint16:
+			newMap = &KeyMap{Keys: v}
+		case *KeyMap:
+			newMap = v
+		case string:
+			var err error
+			newMap, err = ParseKeyMap(v)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Invalid type %s for key_map.",
+				reflect.TypeOf(value))
+		}
+		for _, p := range d.panes {
+			p.keyMap = newMap.Keys
+			p.chMap = newMap.Chars
+		}
+		return nil
+	case "key_layout":
+		name, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("Invalid type %s for key_layout.",
+				reflect.TypeOf(value))
+		}
+		layout, err := hachi.GetKeyLayout(name)
+		if err != nil {
+			return err
+		}
+		for _, p := range d.panes {
+			p.setKeyLayout(layout)
+		}
+		return nil
+	case "beeper":
+		b, err := beep.FromData(value, os.Stdout)
+		if err != nil {
+			return err
+		}
+		d.beeper = b
+		return nil
+	case "split":
+		split, ok := value.(bool)
 		if !ok {
-			fmt.Errorf("Invalid type %s for key_map.", reflect.TypeOf(value))
+			return fmt.Errorf("Invalid type %s for split.",
+				reflect.TypeOf(value))
 		}
-		d.keyMap = newMap
+		d.split = split
+		return nil
 	}
 	return fmt.Errorf("Unknown data key '%s'.", key)
 }
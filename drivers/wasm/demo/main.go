@@ -0,0 +1,62 @@
+//go:build js && wasm
+
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// This is a demo page that runs CHIP-8 programs in the browser with the wasm
+// driver. The page loads the program into hachiROM before starting the
+// emulator, see index.html.
+package main
+
+import (
+	_ "github.com/Francesco149/go-hachi/drivers/wasm"
+	"github.com/Francesco149/go-hachi/hachi"
+	"syscall/js"
+)
+
+func main() {
+	rom := js.Global().Get("hachiROM")
+	program := make([]byte, rom.Get("length").Int())
+	js.CopyBytesToGo(program, rom)
+
+	ha, err := hachi.New("wasm", nil)
+	if err != nil {
+		panic(err)
+	}
+	if err = ha.LoadRaw(program); err != nil {
+		panic(err)
+	}
+
+	// run one emulator frame for every 1/60th of a second, whatever the
+	// display's refresh rate is
+	var last float64
+	var frame js.Func
+	frame = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		now := args[0].Float()
+		if last == 0 {
+			last = now
+		}
+		for ; now-last >= 1000.0/60; last += 1000.0 / 60 {
+			if err := ha.Frame(); err != nil {
+				js.Global().Get("console").Call("error", err.Error())
+				return nil
+			}
+		}
+		js.Global().Call("requestAnimationFrame", frame)
+		return nil
+	})
+	js.Global().Call("requestAnimationFrame", frame)
+	select {}
+}
@@ -0,0 +1,255 @@
+//go:build js && wasm
+
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package wasm implements a syscall driver for WebAssembly builds that runs
+// in the browser: the screen is drawn to an HTML canvas, browser keyboard
+// events are mapped to the hex keypad and the beep is a square wave played
+// through WebAudio.
+//
+// The canvas is the element with id "hachi", or a new one added to the page
+// if there is none. Another canvas can be passed through
+// SetDriverData("canvas", value), which takes effect on the next screen
+// update. It's sized to the CHIP-8 screen and scaled up with CSS, so it can be
+// styled freely.
+//
+// Browsers only allow sound after the user interacted with the page, so the
+// beep is silent until the first key press.
+//
+// See the demo directory for a page that runs ROMs with this driver.
+package wasm
+
+import (
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	"log"
+	"strings"
+	"syscall/js"
+)
+
+const (
+	frequency = 440
+	volume    = 0.1
+)
+
+// A WasmDriver is a driver that runs in the browser.
+type WasmDriver struct {
+	hachi.Driver
+	canvas    js.Value
+	ctx       js.Value // 2d context
+	image     js.Value // ImageData of the screen
+	pixels    []byte   // RGBA copy of the screen
+	audio     js.Value // AudioContext
+	gain      js.Value
+	keyMap    map[string]uint16
+	keys      uint16 // keys currently held down
+	listeners []js.Func
+}
+
+func (d *WasmDriver) setKeyLayout(layout hachi.KeyLayout) {
+	d.keyMap = make(map[string]uint16)
+	for r, key := range layout {
+		name := string(r)
+		if r == '\r' {
+			name = "enter" // lowercase like the other keys
+		}
+		d.keyMap[name] = key
+	}
+}
+
+func (d *WasmDriver) OnInit(c *hachi.Chip8) {
+	layout := c.KeyLayout()
+	if layout == nil {
+		layout = hachi.KeyLayouts["octo"]
+	}
+	d.setKeyLayout(layout)
+
+	if d.canvas.IsUndefined() || d.canvas.IsNull() {
+		doc := js.Global().Get("document")
+		d.canvas = doc.Call("getElementById", "hachi")
+		if d.canvas.IsNull() {
+			d.canvas = doc.Call("createElement", "canvas")
+			doc.Get("body").Call("appendChild", d.canvas)
+		}
+	}
+	d.setupCanvas(c)
+
+	onKey := func(down bool) js.Func {
+		return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			e := args[0]
+			key, ok := d.keyMap[strings.ToLower(e.Get("key").String())]
+			if !ok {
+				return nil
+			}
+			e.Call("preventDefault")
+			if down {
+				d.keys |= key
+				d.startAudio()
+			} else {
+				d.keys &^= key
+			}
+			return nil
+		})
+	}
+	d.listeners = []js.Func{onKey(true), onKey(false)}
+	window := js.Global().Get("window")
+	window.Call("addEventListener", "keydown", d.listeners[0])
+	window.Call("addEventListener", "keyup", d.listeners[1])
+	c.Logger().Println("WasmDriver initialized")
+}
+
+// setupCanvas sizes the canvas to the screen and allocates the image data.
+func (d *WasmDriver) setupCanvas(c *hachi.Chip8) {
+	w, h := c.DisplaySize()
+	d.canvas.Set("width", w)
+	d.canvas.Set("height", h)
+	style := d.canvas.Get("style")
+	style.Set("imageRendering", "pixelated")
+	if style.Get("width").String() == "" {
+		style.Set("width", fmt.Sprintf("%dpx", w*10))
+	}
+	d.ctx = d.canvas.Call("getContext", "2d")
+	d.image = d.ctx.Call("createImageData", w, h)
+	d.pixels = make([]byte, w*h*4)
+}
+
+// startAudio creates the oscillator on the first key press, as browsers don't
+// allow sound before the user interacts with the page.
+func (d *WasmDriver) startAudio() {
+	if !d.audio.IsUndefined() {
+		return
+	}
+	ctor := js.Global().Get("AudioContext")
+	if ctor.IsUndefined() {
+		ctor = js.Global().Get("webkitAudioContext")
+	}
+	if ctor.IsUndefined() {
+		d.audio = js.Null()
+		return
+	}
+	d.audio = ctor.New()
+	osc := d.audio.Call("createOscillator")
+	osc.Set("type", "square")
+	osc.Get("frequency").Set("value", frequency)
+	d.gain = d.audio.Call("createGain")
+	d.gain.Get("gain").Set("value", 0)
+	osc.Call("connect", d.gain)
+	d.gain.Call("connect", d.audio.Get("destination"))
+	osc.Call("start")
+}
+
+// setTone turns the square wave on or off.
+func (d *WasmDriver) setTone(on bool) {
+	if d.gain.IsUndefined() {
+		return
+	}
+	v := 0.0
+	if on {
+		v = volume
+	}
+	d.gain.Get("gain").Set("value", v)
+}
+
+// OnLoad shows the program's title in the page title.
+func (d *WasmDriver) OnLoad(c *hachi.Chip8, info hachi.RomInfo) {
+	if info.Title() != "" {
+		js.Global().Get("document").Set("title", "hachi - "+info.Title())
+	}
+}
+
+func (d *WasmDriver) Cls() {}
+
+func (d *WasmDriver) OnUpdate(c *hachi.Chip8) { c.Keyboard = d.keys }
+
+// UpdateScreen copies the screen to the canvas.
+func (d *WasmDriver) UpdateScreen(c *hachi.Chip8) {
+	w, h := c.DisplaySize()
+	if len(d.pixels) != w*h*4 {
+		// the resolution changed
+		d.setupCanvas(c)
+	}
+	screen := c.DisplayScreen()
+	byteWidth := w / 8
+	bg := c.BackgroundColor()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			col := bg
+			if screen[y*byteWidth+x/8]&(0x80>>uint(x%8)) != 0 {
+				col = c.PixelColor(x, y)
+			}
+			p := d.pixels[(y*w+x)*4:]
+			p[0], p[1], p[2], p[3] = col.R, col.G, col.B, 0xFF
+		}
+	}
+	js.CopyBytesToJS(d.image.Get("data"), d.pixels)
+	d.ctx.Call("putImageData", d.image, 0, 0)
+}
+
+// Beep does nothing, the tone follows OnSoundStart and OnSoundStop instead.
+func (d *WasmDriver) Beep() {}
+
+// OnSoundStart turns the tone on.
+func (d *WasmDriver) OnSoundStart(c *hachi.Chip8, frames int) {
+	d.setTone(true)
+}
+
+// OnSoundStop turns the tone off.
+func (d *WasmDriver) OnSoundStop(c *hachi.Chip8) { d.setTone(false) }
+
+// OnShutdown removes the keyboard listeners and closes the audio context.
+func (d *WasmDriver) OnShutdown(c *hachi.Chip8) {
+	window := js.Global().Get("window")
+	if len(d.listeners) == 2 {
+		window.Call("removeEventListener", "keydown", d.listeners[0])
+		window.Call("removeEventListener", "keyup", d.listeners[1])
+	}
+	for _, f := range d.listeners {
+		f.Release()
+	}
+	d.listeners = nil
+	if !d.audio.IsUndefined() && !d.audio.IsNull() {
+		d.audio.Call("close")
+	}
+}
+
+func (d *WasmDriver) GetData(key string) interface{} {
+	if key == "canvas" {
+		return d.canvas
+	}
+	return nil
+}
+
+func (d *WasmDriver) SetData(key string, value interface{}) error {
+	if key != "canvas" {
+		return fmt.Errorf("Unknown data key '%s'.", key)
+	}
+	canvas, ok := value.(js.Value)
+	if !ok {
+		return fmt.Errorf("Invalid canvas %v.", value)
+	}
+	d.canvas = canvas
+	d.pixels = nil // set up on the next UpdateScreen
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("wasm", &WasmDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,406 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package websocket implements a syscall driver that streams the emulator over
+// WebSocket, so that remote web UIs can be built on top of a headless
+// instance. Every connected client receives the screen and the machine state
+// and can press keys, like the ssh driver.
+//
+// The server starts on the first Tick(). The listening address defaults to
+// ":8080" and can be changed through SetDriverData("addr", addr) before that.
+// Connections from any origin are accepted, so don't expose the port to
+// people you don't want playing.
+//
+// The screen is sent as binary messages, in the layout of
+// hachi.Chip8.DisplayScreen (one bit per pixel, most significant bit first,
+// rows of width/8 bytes):
+//
+//	0x00 width height screen...          full frame
+//	0x01 (offset:uint16be value:byte)... changed bytes since the last frame
+//
+// New clients get a full frame, then only diffs are sent. Everything else is
+// a JSON text message with a "type" field:
+//
+//	{"type": "state", "pc": 512, "i": 0, "v": [...], "sp": 0, "stack": [...],
+//	 "dt": 0, "st": 0, "keyboard": 0}
+//	{"type": "sound", "frames": 10}   (frames is 0 when the tone stops)
+//	{"type": "halt", "error": "..."}
+//
+// Screen and state updates are sent at most 60 times per second, and the state
+// only when it changed. Clients press and release keys (0-15) with:
+//
+//	{"type": "keydown", "key": 5}
+//	{"type": "keyup", "key": 5}
+//
+// The keys held by a client are released when it disconnects.
+package websocket
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	gws "github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	frameFull = 0x00
+	frameDiff = 0x01
+)
+
+// clients that take longer than this to accept a message are dropped
+const writeTimeout = time.Second
+
+// A WebSocketDriver is a driver that streams the emulator to WebSocket
+// clients.
+type WebSocketDriver struct {
+	hachi.Driver
+	addr       string
+	server     *http.Server
+	logger     *log.Logger
+	c          *hachi.Chip8
+	dirty      bool
+	lastUpdate time.Time
+
+	mutex   sync.Mutex
+	clients map[*client]bool
+	screen  []byte // last frame sent, in the DisplayScreen layout
+	width   int
+	height  int
+	state   stateMessage // last state sent
+}
+
+// a client is a connection and the keys it's holding
+type client struct {
+	conn *gws.Conn
+	keys uint16
+}
+
+type stateMessage struct {
+	Type     string    `json:"type"`
+	PC       uint16    `json:"pc"`
+	I        uint16    `json:"i"`
+	V        [16]uint8 `json:"v"`
+	SP       int       `json:"sp"`
+	Stack    []uint16  `json:"stack"`
+	DT       uint8     `json:"dt"`
+	ST       uint8     `json:"st"`
+	Keyboard uint16    `json:"keyboard"`
+}
+
+// equal compares everything but the stack contents, which can only change
+// along with SP or PC.
+func (m *stateMessage) equal(o *stateMessage) bool {
+	return m.PC == o.PC && m.I == o.I && m.V == o.V && m.SP == o.SP &&
+		m.DT == o.DT && m.ST == o.ST && m.Keyboard == o.Keyboard
+}
+
+type soundMessage struct {
+	Type   string `json:"type"`
+	Frames int    `json:"frames"`
+}
+
+type haltMessage struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+type keyMessage struct {
+	Type string `json:"type"`
+	Key  int    `json:"key"`
+}
+
+func (d *WebSocketDriver) OnInit(c *hachi.Chip8) {
+	if d.addr == "" {
+		d.addr = ":8080"
+	}
+	d.logger = c.Logger()
+	d.c = c
+
+	if d.server != nil {
+		d.server.Close()
+		d.server = nil
+	}
+	d.mutex.Lock()
+	d.clients = make(map[*client]bool)
+	d.screen = nil
+	d.state = stateMessage{}
+	d.mutex.Unlock()
+
+	d.dirty = true
+	d.logger.Println("WebSocketDriver initialized")
+}
+
+// listen starts the http server in the background.
+func (d *WebSocketDriver) listen() {
+	upgrader := &gws.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	d.server = &http.Server{
+		Addr: d.addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				// the upgrader already replied with an error
+				return
+			}
+			d.handle(conn)
+		}),
+	}
+
+	go func(server *http.Server) {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			d.logger.Println("WebSocketDriver:", err)
+		}
+	}(d.server)
+
+	d.logger.Println("WebSocketDriver listening on", d.addr)
+}
+
+// handle reads the key events of a client until it disconnects.
+func (d *WebSocketDriver) handle(conn *gws.Conn) {
+	cl := &client{conn: conn}
+	d.mutex.Lock()
+	d.clients[cl] = true
+	if d.screen != nil {
+		d.send(cl, gws.BinaryMessage, d.fullFrame())
+	}
+	d.mutex.Unlock()
+	d.logger.Println("WebSocketDriver:", conn.RemoteAddr(), "connected")
+
+	for {
+		var msg keyMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			break
+		}
+		if msg.Key < 0 || msg.Key >= len(hachi.KeyFlags) {
+			continue
+		}
+		key := hachi.KeyFlags[msg.Key]
+		switch msg.Type {
+		case "keydown":
+			cl.keys |= key
+			d.c.KeyDown(key)
+		case "keyup":
+			cl.keys &^= key
+			if !d.held(key) {
+				d.c.KeyUp(key)
+			}
+		}
+	}
+
+	d.mutex.Lock()
+	delete(d.clients, cl)
+	d.mutex.Unlock()
+	conn.Close()
+	for _, key := range hachi.KeyFlags {
+		if cl.keys&key != 0 && !d.held(key) {
+			d.c.KeyUp(key)
+		}
+	}
+	d.logger.Println("WebSocketDriver:", conn.RemoteAddr(), "disconnected")
+}
+
+// held returns true if any client is holding key.
+func (d *WebSocketDriver) held(key uint16) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for cl := range d.clients {
+		if cl.keys&key != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// send writes a message to a client, dropping it if it fails. Must be called
+// with the mutex held.
+func (d *WebSocketDriver) send(cl *client, kind int, data []byte) {
+	cl.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := cl.conn.WriteMessage(kind, data); err != nil {
+		// the read loop in handle notices and cleans up
+		cl.conn.Close()
+		delete(d.clients, cl)
+	}
+}
+
+// broadcast writes a message to every client. Must be called with the mutex
+// held.
+func (d *WebSocketDriver) broadcast(kind int, data []byte) {
+	for cl := range d.clients {
+		d.send(cl, kind, data)
+	}
+}
+
+// broadcastJSON sends v to every client as a text message.
+func (d *WebSocketDriver) broadcastJSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		d.logger.Println("WebSocketDriver:", err)
+		return
+	}
+	d.mutex.Lock()
+	d.broadcast(gws.TextMessage, data)
+	d.mutex.Unlock()
+}
+
+// fullFrame encodes the last frame. Must be called with the mutex held.
+func (d *WebSocketDriver) fullFrame() []byte {
+	msg := []byte{frameFull, byte(d.width), byte(d.height)}
+	return append(msg, d.screen...)
+}
+
+// frame returns the message that brings clients up to date with screen, or
+// nil if nothing changed. Must be called with the mutex held.
+func (d *WebSocketDriver) frame(screen []byte, width, height int) []byte {
+	if len(d.screen) != len(screen) || d.width != width ||
+		d.height != height {
+
+		d.screen = append(d.screen[:0], screen...)
+		d.width, d.height = width, height
+		return d.fullFrame()
+	}
+	var msg []byte
+	for i, b := range screen {
+		if d.screen[i] == b {
+			continue
+		}
+		if msg == nil {
+			msg = []byte{frameDiff}
+		}
+		msg = binary.BigEndian.AppendUint16(msg, uint16(i))
+		msg = append(msg, b)
+		d.screen[i] = b
+	}
+	return msg
+}
+
+func (d *WebSocketDriver) Cls() {}
+
+func (d *WebSocketDriver) OnUpdate(c *hachi.Chip8) {
+	if d.server == nil {
+		d.listen()
+	}
+
+	// 60hz is plenty
+	if time.Since(d.lastUpdate) < time.Second/60 {
+		return
+	}
+	d.lastUpdate = time.Now()
+
+	state := stateMessage{
+		Type:     "state",
+		PC:       c.PC,
+		I:        c.I,
+		V:        c.V,
+		SP:       c.SP,
+		DT:       c.DT,
+		ST:       c.ST,
+		Keyboard: c.Keyboard,
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.dirty {
+		d.dirty = false
+		w, h := c.DisplaySize()
+		if msg := d.frame(c.DisplayScreen(), w, h); msg != nil {
+			d.broadcast(gws.BinaryMessage, msg)
+		}
+	}
+	if !state.equal(&d.state) {
+		state.Stack = append([]uint16(nil), c.Stack...)
+		d.state = state
+		data, err := json.Marshal(&state)
+		if err != nil {
+			d.logger.Println("WebSocketDriver:", err)
+			return
+		}
+		d.broadcast(gws.TextMessage, data)
+	}
+}
+
+func (d *WebSocketDriver) UpdateScreen(c *hachi.Chip8) { d.dirty = true }
+
+func (d *WebSocketDriver) Beep() {}
+
+// OnSoundStart tells every client how long the tone will play.
+func (d *WebSocketDriver) OnSoundStart(c *hachi.Chip8, frames int) {
+	d.broadcastJSON(&soundMessage{"sound", frames})
+}
+
+// OnSoundStop tells every client that the tone stopped.
+func (d *WebSocketDriver) OnSoundStop(c *hachi.Chip8) {
+	d.broadcastJSON(&soundMessage{"sound", 0})
+}
+
+// OnHalt tells every client why the game stopped.
+func (d *WebSocketDriver) OnHalt(c *hachi.Chip8, err error) {
+	d.broadcastJSON(&haltMessage{"halt", err.Error()})
+}
+
+// OnShutdown disconnects every client and stops the server.
+func (d *WebSocketDriver) OnShutdown(c *hachi.Chip8) {
+	if d.server != nil {
+		d.server.Close()
+		d.server = nil
+	}
+	d.mutex.Lock()
+	for cl := range d.clients {
+		cl.conn.Close()
+	}
+	d.mutex.Unlock()
+}
+
+func (d *WebSocketDriver) GetData(key string) interface{} {
+	switch key {
+	case "server":
+		return d.server
+	case "addr":
+		return d.addr
+	case "clients":
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		return len(d.clients)
+	}
+	return nil
+}
+
+func (d *WebSocketDriver) SetData(key string, value interface{}) error {
+	switch key {
+	case "addr":
+		addr, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("Invalid address %v.", value)
+		}
+		d.addr = addr
+		return nil
+	}
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
+
+// -----------------------------------------------------------------------------
+
+func init() {
+	err := hachi.RegisterDriver("websocket", &WebSocketDriver{})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
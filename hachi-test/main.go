@@ -0,0 +1,96 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	"github.com/Francesco149/go-hachi/testsuite"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	log.SetOutput(os.Stdout)
+	runner := &testsuite.Runner{}
+	var verbose bool
+	var only, quirks string
+	flag.StringVar(&runner.Golden, "golden", "golden",
+		"directory containing the reference hashes and screens")
+	flag.BoolVar(&runner.Record, "record", false,
+		"save the hashes and screens of the final framebuffers")
+	flag.IntVar(&runner.CyclesPerFrame, "speed",
+		hachi.DefaultSettings.CyclesPerFrame, "instructions per frame")
+	flag.StringVar(&quirks, "quirks", "modern",
+		"comma separated quirks or presets (modern, vip, schip, chip48)")
+	flag.BoolVar(&verbose, "v", false, "print the screen of failed tests")
+	flag.StringVar(&only, "test", "", "only run the test with this name")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] path/to/chip8-test-suite/bin\n",
+			filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	runner.Dir = flag.Arg(0)
+
+	settings := *hachi.DefaultSettings
+	q, err := hachi.ParseQuirks(quirks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	settings.Quirks = q
+	runner.Settings = &settings
+
+	if runner.Record {
+		err := os.MkdirAll(runner.Golden, 0755)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var results []testsuite.Result
+	for i := range testsuite.Tests {
+		t := &testsuite.Tests[i]
+		if only != "" && t.Name != only {
+			continue
+		}
+		results = append(results, runner.Run(t))
+	}
+
+	passed := testsuite.WriteReport(os.Stdout, results)
+	if verbose {
+		for _, res := range results {
+			if res.Status == testsuite.Fail {
+				fmt.Printf("\n%s:\n%s", res.Test.Name, res.Screen)
+			}
+		}
+	}
+	skipped := 0
+	for _, res := range results {
+		if res.Status == testsuite.Skipped {
+			skipped++
+		}
+	}
+	if !runner.Record && passed != len(results)-skipped {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// A MemoryAccess is how many times the program read and wrote a byte of
+// memory through I. Instruction fetches aren't counted, see
+// EnableExecCounters for those.
+type MemoryAccess struct {
+	Address       int
+	Reads, Writes uint64
+}
+
+// accessCounter collects MemoryAccesses. Maps keep it small on MegaChip,
+// which has 16MB of memory.
+type accessCounter struct {
+	reads, writes map[int]uint64
+}
+
+// EnableAccessCounters starts counting the reads and writes of every byte of
+// memory, which shows where a program keeps its variables and sprites.
+// Calling it again resets the counters.
+func (c *Chip8) EnableAccessCounters() {
+	c.accesses = &accessCounter{make(map[int]uint64), make(map[int]uint64)}
+}
+
+// readAddr is memAddr for reads, which are counted if enabled.
+func (c *Chip8) readAddr(off int, protected bool) int {
+	addr := c.memAddr(off, protected)
+	if c.accesses != nil && addr >= 0 {
+		c.accesses.reads[addr]++
+	}
+	return addr
+}
+
+// writeAddr is memAddr for writes, which are counted if enabled.
+func (c *Chip8) writeAddr(off int, protected bool) int {
+	addr := c.memAddr(off, protected)
+	if c.accesses != nil && addr >= 0 {
+		c.accesses.writes[addr]++
+	}
+	return addr
+}
+
+// MemoryAccesses returns every byte of memory that was read or written since
+// EnableAccessCounters was called, by address. Returns nil if counters are
+// disabled.
+func (c *Chip8) MemoryAccesses() (res []MemoryAccess) {
+	if c.accesses == nil {
+		return nil
+	}
+	seen := make(map[int]bool)
+	for _, counts := range []map[int]uint64{c.accesses.reads,
+		c.accesses.writes} {
+
+		for addr := range counts {
+			if !seen[addr] {
+				seen[addr] = true
+				res = append(res, MemoryAccess{addr,
+					c.accesses.reads[addr], c.accesses.writes[addr]})
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Address < res[j].Address
+	})
+	return
+}
+
+// WriteMemoryAccessCSV writes memory accesses as a comma separated table.
+func WriteMemoryAccessCSV(w io.Writer, accesses []MemoryAccess) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"addr", "reads", "writes"})
+	for _, a := range accesses {
+		cw.Write([]string{fmt.Sprintf("%04X", a.Address),
+			fmt.Sprint(a.Reads), fmt.Sprint(a.Writes)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// MemoryAccessImage renders memory accesses as a heatmap of size bytes
+// starting at start, width bytes per row and each byte scale pixels wide.
+// Reads are drawn in green and writes in red, brighter the more accesses
+// there were relative to the busiest byte, so variables that are read and
+// written show up yellow. Bytes that were never touched are black.
+func MemoryAccessImage(accesses []MemoryAccess, start, size, width,
+	scale int) *image.RGBA {
+
+	if width <= 0 {
+		width = 64
+	}
+	if scale <= 0 {
+		scale = 1
+	}
+	rows := (size + width - 1) / width
+	img := image.NewRGBA(image.Rect(0, 0, width*scale, rows*scale))
+	for i := range img.Pix {
+		if i%4 == 3 {
+			img.Pix[i] = 0xFF
+		}
+	}
+
+	var maxReads, maxWrites uint64
+	for _, a := range accesses {
+		if a.Reads > maxReads {
+			maxReads = a.Reads
+		}
+		if a.Writes > maxWrites {
+			maxWrites = a.Writes
+		}
+	}
+	heat := func(count, max uint64) uint8 {
+		if count == 0 {
+			return 0
+		}
+		// never-touched bytes must stand out from rarely touched ones
+		return uint8(0x40 + 0xBF*count/max)
+	}
+
+	for _, a := range accesses {
+		off := a.Address - start
+		if off < 0 || off >= size {
+			continue
+		}
+		col := color.RGBA{heat(a.Writes, maxWrites),
+			heat(a.Reads, maxReads), 0, 0xFF}
+		x, y := off%width*scale, off/width*scale
+		for dy := 0; dy < scale; dy++ {
+			for dx := 0; dx < scale; dx++ {
+				img.SetRGBA(x+dx, y+dy, col)
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,216 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// bcdDigitNames names the digits stored by LD [I],BCD VX, in memory order.
+var bcdDigitNames = []string{"hundreds", "tens", "ones"}
+
+// keyName returns the name of CHIP-8 key n: its hex keypad digit and the
+// key it's on in the octo layout.
+func keyName(n uint8) string {
+	for r, key := range KeyLayouts["octo"] {
+		if key == 1<<n {
+			return fmt.Sprintf("key %X (%s)", n,
+				strings.ToUpper(string(r)))
+		}
+	}
+	return fmt.Sprintf("key %X", n)
+}
+
+// annotator follows register values through a disassembly to explain what
+// some instructions do. It only knows a value when it's set by a constant
+// in straight-line code, anything that can be reached by a jump, a call or
+// a skip is treated as unknown.
+type annotator struct {
+	known [16]int // constant value of each register, -1 if unknown
+	// digit[r] is the BCD digit that register r was loaded with by the
+	// score drawing idiom, or nil
+	digit [16]*bcdDigit
+	bcd   *bcdDigit // last LD [I],BCD VX while I is unchanged
+}
+
+type bcdDigit struct {
+	index    int // instruction index of LD [I],BCD VX
+	address  uint16
+	register uint8
+	digit    int
+}
+
+func (a *annotator) forget() {
+	for r := range a.known {
+		a.known[r] = -1
+		a.digit[r] = nil
+	}
+	a.bcd = nil
+}
+
+// set records the value written to register r, -1 if unknown.
+func (a *annotator) set(r uint8, value int) {
+	a.known[r] = value
+	a.digit[r] = nil
+}
+
+// Annotate explains instructions of a disassembly starting at address start,
+// one note per instruction, empty if there's nothing to add:
+//   - LD I,CHAR VX names the digit when VX is a known constant
+//   - SKP VX and SKNP VX name the key when VX is a known constant
+//   - LD [I],BCD VX followed by LD VX,[I] and LD I,CHAR is recognized as
+//     drawing the decimal digits of a number, usually the score
+func Annotate(disassembly []Instruction, start uint16) []string {
+	notes, _ := annotate(disassembly, start)
+	return notes
+}
+
+// ScoreDisplays finds the numbers that a program loaded at start draws with
+// the score drawing idiom (see Annotate). It returns the addresses of their
+// LD [I],BCD VX instructions, mapped to X.
+func ScoreDisplays(program []byte, start uint16) (map[uint16]uint8, error) {
+	disassembly, err := disassembleListing(program)
+	if err != nil {
+		return nil, err
+	}
+	_, scores := annotate(disassembly, start)
+	return scores, nil
+}
+
+// annotate returns the notes for Annotate and the score displays for
+// ScoreDisplays.
+func annotate(disassembly []Instruction,
+	start uint16) ([]string, map[uint16]uint8) {
+
+	notes := make([]string, len(disassembly))
+	scores := map[uint16]uint8{}
+
+	// anything that can be jumped or called to starts with unknown values
+	targets := map[uint16]bool{}
+	for _, in := range disassembly {
+		switch i := in.(type) {
+		case Jp:
+			targets[i.Address()] = true
+		case Call:
+			targets[i.Address()] = true
+		}
+	}
+
+	a := &annotator{}
+	a.forget()
+	address := start
+	skipped := false // whether the previous instruction can skip this one
+	for n, in := range disassembly {
+		if targets[address] {
+			a.forget()
+		}
+		here := address
+		address += uint16(in.Size())
+
+		// a skipped write leaves the register unknown
+		conditional := skipped
+		skipped = false
+		value := func(v int) int {
+			if conditional {
+				return -1
+			}
+			return v
+		}
+
+		switch i := in.(type) {
+		case Ld:
+			a.set(i.Register(), value(int(i.Value())))
+		case Add:
+			v := -1
+			if a.known[i.Register()] >= 0 {
+				v = (a.known[i.Register()] + int(i.Value())) & 0xFF
+			}
+			a.set(i.Register(), value(v))
+		case LdRegister:
+			a.set(i.Register1(), value(a.known[i.Register2()]))
+		case Or, And, Xor, AddRegister, SubRegister, Shr, Subn, Shl:
+			a.set(uint8(in.Opcode()>>8&0xF), -1)
+			a.set(0xF, -1)
+		case Rnd:
+			a.set(i.Register(), -1)
+		case LdDelayTimer:
+			a.set(i.Register(), -1)
+		case LdKeyboard:
+			a.set(i.Register(), -1)
+		case Drw:
+			a.set(0xF, -1)
+
+		case Se, Sne, SeRegister, SneRegister:
+			skipped = true
+		case Skp:
+			skipped = true
+			if v := a.known[i.Register()]; v >= 0 {
+				notes[n] = "skips if " + keyName(uint8(v)&0xF) +
+					" is pressed"
+			}
+		case Sknp:
+			skipped = true
+			if v := a.known[i.Register()]; v >= 0 {
+				notes[n] = "skips if " + keyName(uint8(v)&0xF) +
+					" is not pressed"
+			}
+
+		case LdI, AddI:
+			a.bcd = nil
+		case LdBcd:
+			a.bcd = &bcdDigit{index: n, address: here,
+				register: i.Register()}
+		case LdMemory:
+			for r := uint8(0); r <= i.Register(); r++ {
+				a.set(r, -1)
+				if a.bcd != nil && r < 3 {
+					d := *a.bcd
+					d.digit = int(r)
+					a.digit[r] = &d
+				}
+			}
+		case LdFlags:
+			for r := uint8(0); r <= i.Register(); r++ {
+				a.set(r, -1)
+			}
+		case LdBigFont:
+			a.bcd = nil
+		case LdFont:
+			r := i.Register()
+			if d := a.digit[r]; d != nil {
+				notes[n] = fmt.Sprintf("%s digit of V%X",
+					bcdDigitNames[d.digit], d.register)
+				notes[d.index] = fmt.Sprintf("decimal digits of V%X, drawn "+
+					"with LD I,CHAR (score display)", d.register)
+				scores[d.address] = d.register
+			} else if v := a.known[r]; v >= 0 && v < 16 {
+				notes[n] = fmt.Sprintf("digit %X", v)
+			}
+			a.bcd = nil
+
+		case Jp, JpV0, Call:
+			a.forget()
+		case Sys:
+			// anything but CLS returns or runs machine code
+			if i.Address() != 0x0E0 {
+				a.forget()
+			}
+		}
+	}
+
+	return notes, scores
+}
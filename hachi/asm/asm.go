@@ -0,0 +1,392 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package asm assembles the pseudo-asm syntax emitted by the go-hachi
+// disassembler back into CHIP-8 programs.
+//
+// Every line holds an optional label definition ("loop:"), an optional
+// instruction and an optional comment starting with ';'. Anything in
+// parentheses is ignored as well, so disassembler output such as
+// "SYS 0E0 (CLS)" can be fed back as is. Numbers are hexadecimal, like in the
+// disassembly, and can also be written with a 0x, # or $ prefix. Wherever an
+// address or a byte is expected, a label can be used instead. Labels can't be
+// valid hex numbers, register names or keywords.
+//
+// Besides the instructions, DB emits raw bytes ("DB 12 34" or "DB 12,34") and
+// DW emits big-endian 16-bit words, which can be labels.
+// CLS, RET and the SUPER-CHIP instructions SCR, SCL, EXIT, LOW, HIGH and SCD N
+// are accepted as shorthands for their SYS forms. LD F,VX and LD B,VX are
+// accepted as aliases for LD I,CHAR VX and LD [I],BCD VX.
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// a statement is one line of source with an instruction or data
+type statement struct {
+	line     int
+	addr     uint16
+	mnemonic string
+	operands []string
+}
+
+// size returns how many bytes the statement assembles to.
+func (s *statement) size() int {
+	switch s.mnemonic {
+	case "DB":
+		return len(s.operands)
+	case "DW":
+		return len(s.operands) * 2
+	}
+	return 2
+}
+
+// an assembler holds the state of the two passes
+type assembler struct {
+	labels map[string]uint16
+	stmts  []statement
+}
+
+// Assemble reads source code and returns the assembled program, which will be
+// loaded at start (normally 0x200).
+// Returns an error with the offending line number if the source is invalid.
+func Assemble(r io.Reader, start uint16) ([]byte, error) {
+	a := &assembler{labels: map[string]uint16{}}
+	if err := a.parse(r, start); err != nil {
+		return nil, err
+	}
+
+	var program []byte
+	for i := range a.stmts {
+		s := &a.stmts[i]
+		b, err := a.encode(s)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", s.line, err)
+		}
+		program = append(program, b...)
+	}
+	return program, nil
+}
+
+// AssembleString is Assemble for source code held in a string.
+func AssembleString(src string, start uint16) ([]byte, error) {
+	return Assemble(strings.NewReader(src), start)
+}
+
+// parse is the first pass, which splits the source into statements and
+// assigns addresses to the labels.
+func (a *assembler) parse(r io.Reader, start uint16) error {
+	addr := int(start)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexAny(text, ";("); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+
+		if i := strings.Index(text, ":"); i >= 0 {
+			label := strings.TrimSpace(text[:i])
+			if err := a.define(label, addr); err != nil {
+				return fmt.Errorf("Line %d: %v", line, err)
+			}
+			text = strings.TrimSpace(text[i+1:])
+		}
+		if text == "" {
+			continue
+		}
+
+		s := statement{line: line, addr: uint16(addr)}
+		fields := strings.SplitN(text, " ", 2)
+		s.mnemonic = strings.ToUpper(fields[0])
+		if len(fields) == 2 {
+			s.operands = splitOperands(s.mnemonic, fields[1])
+		}
+		addr += s.size()
+		if addr > 0x1000 {
+			return fmt.Errorf("Line %d: Program doesn't fit in memory.",
+				line)
+		}
+		a.stmts = append(a.stmts, s)
+	}
+	return scanner.Err()
+}
+
+// splitOperands splits the operands by commas, or also by spaces for data.
+func splitOperands(mnemonic, text string) (res []string) {
+	split := func(r rune) bool { return r == ',' }
+	if mnemonic == "DB" || mnemonic == "DW" {
+		split = func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }
+	}
+	for _, op := range strings.FieldsFunc(text, split) {
+		if op = strings.TrimSpace(op); op != "" {
+			res = append(res, op)
+		}
+	}
+	return
+}
+
+// define adds a label at addr.
+func (a *assembler) define(label string, addr int) error {
+	switch {
+	case !isIdentifier(label):
+		return fmt.Errorf("Invalid label name '%s'.", label)
+	case isNumber(label), isKeyword(label):
+		return fmt.Errorf("Label '%s' is a number or a reserved word.",
+			label)
+	}
+	if _, ok := a.labels[label]; ok {
+		return fmt.Errorf("Label '%s' is already defined.", label)
+	}
+	a.labels[label] = uint16(addr)
+	return nil
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		switch {
+		case r == '_' || r == '.',
+			r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
+			i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isNumber(s string) bool {
+	_, err := parseNumber(s)
+	return err == nil
+}
+
+var keywords = map[string]bool{
+	"I": true, "[I]": true, "DT": true, "ST": true, "K": true, "HF": true,
+	"R": true, "F": true, "B": true, "CHAR": true, "BCD": true,
+}
+
+func isKeyword(s string) bool {
+	_, reg := register(s)
+	return reg || keywords[strings.ToUpper(s)]
+}
+
+// parseNumber parses a hex number with an optional 0x, # or $ prefix.
+func parseNumber(s string) (uint64, error) {
+	lower := strings.ToLower(s)
+	for _, prefix := range []string{"0x", "#", "$"} {
+		if strings.HasPrefix(lower, prefix) {
+			lower = lower[len(prefix):]
+			break
+		}
+	}
+	return strconv.ParseUint(lower, 16, 16)
+}
+
+// register parses a register name such as V1 or vA.
+func register(s string) (uint8, bool) {
+	if len(s) != 2 || (s[0] != 'V' && s[0] != 'v') {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s[1:], 16, 4)
+	return uint8(n), err == nil
+}
+
+// value resolves a number or a label, which must be <= max.
+func (a *assembler) value(s string, max uint16) (uint16, error) {
+	n, err := parseNumber(s)
+	if err != nil {
+		addr, ok := a.labels[s]
+		if !ok {
+			return 0, fmt.Errorf("Unknown label or invalid number '%s'.", s)
+		}
+		n = uint64(addr)
+	}
+	if n > uint64(max) {
+		return 0, fmt.Errorf("Value '%s' is out of range (max %X).", s, max)
+	}
+	return uint16(n), nil
+}
+
+// -----------------------------------------------------------------------------
+
+// an operand pattern is matched against the operands of an instruction.
+// 'x' and 'y' are registers, 'n' is a 4-bit value, 'b' a byte and 'a' an
+// address. Anything else must match the operand exactly (case-insensitive).
+type form struct {
+	operands []string
+	opcode   uint16
+}
+
+// forms lists the operand patterns of every instruction, the matched values
+// are or-ed into the opcode.
+var forms = map[string][]form{
+	"CLS":  {{nil, 0x00E0}},
+	"RET":  {{nil, 0x00EE}},
+	"SCR":  {{nil, 0x00FB}},
+	"SCL":  {{nil, 0x00FC}},
+	"EXIT": {{nil, 0x00FD}},
+	"LOW":  {{nil, 0x00FE}},
+	"HIGH": {{nil, 0x00FF}},
+	"SCD":  {{[]string{"n"}, 0x00C0}},
+	"SYS":  {{[]string{"a"}, 0x0000}},
+	"JP": {
+		{[]string{"V0", "a"}, 0xB000},
+		{[]string{"a"}, 0x1000},
+	},
+	"CALL": {{[]string{"a"}, 0x2000}},
+	"SE": {
+		{[]string{"x", "y"}, 0x5000},
+		{[]string{"x", "b"}, 0x3000},
+	},
+	"SNE": {
+		{[]string{"x", "y"}, 0x9000},
+		{[]string{"x", "b"}, 0x4000},
+	},
+	"LD": {
+		{[]string{"x", "y"}, 0x8000},
+		{[]string{"x", "DT"}, 0xF007},
+		{[]string{"x", "K"}, 0xF00A},
+		{[]string{"x", "[I]"}, 0xF065},
+		{[]string{"x", "R"}, 0xF085},
+		{[]string{"x", "b"}, 0x6000},
+		{[]string{"DT", "x"}, 0xF015},
+		{[]string{"ST", "x"}, 0xF018},
+		{[]string{"I", "CHAR x"}, 0xF029},
+		{[]string{"F", "x"}, 0xF029},
+		{[]string{"HF", "x"}, 0xF030},
+		{[]string{"[I]", "BCD x"}, 0xF033},
+		{[]string{"B", "x"}, 0xF033},
+		{[]string{"[I]", "x"}, 0xF055},
+		{[]string{"R", "x"}, 0xF075},
+		{[]string{"I", "a"}, 0xA000},
+	},
+	"ADD": {
+		{[]string{"I", "x"}, 0xF01E},
+		{[]string{"x", "y"}, 0x8004},
+		{[]string{"x", "b"}, 0x7000},
+	},
+	"OR":   {{[]string{"x", "y"}, 0x8001}},
+	"AND":  {{[]string{"x", "y"}, 0x8002}},
+	"XOR":  {{[]string{"x", "y"}, 0x8003}},
+	"SUB":  {{[]string{"x", "y"}, 0x8005}},
+	"SHR":  {{[]string{"x", "y"}, 0x8006}, {[]string{"x"}, 0x8006}},
+	"SUBN": {{[]string{"x", "y"}, 0x8007}},
+	"SHL":  {{[]string{"x", "y"}, 0x800E}, {[]string{"x"}, 0x800E}},
+	"RND":  {{[]string{"x", "b"}, 0xC000}},
+	"DRW":  {{[]string{"x", "y", "n"}, 0xD000}},
+	"SKP":  {{[]string{"x"}, 0xE09E}},
+	"SKNP": {{[]string{"x"}, 0xE0A1}},
+}
+
+// encode is the second pass, which assembles one statement.
+func (a *assembler) encode(s *statement) ([]byte, error) {
+	switch s.mnemonic {
+	case "DB", "DW":
+		return a.data(s)
+	}
+
+	candidates, ok := forms[s.mnemonic]
+	if !ok {
+		return nil, fmt.Errorf("Unknown instruction '%s'.", s.mnemonic)
+	}
+	for _, f := range candidates {
+		opcode, matched, err := a.match(f, s.operands)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			return []byte{byte(opcode >> 8), byte(opcode)}, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid operands for %s: '%s'.", s.mnemonic,
+		strings.Join(s.operands, ","))
+}
+
+// match tries to assemble the operands with a form. Returns false if they
+// don't fit the form, or an error if they do but a value is invalid.
+func (a *assembler) match(f form, operands []string) (uint16, bool, error) {
+	if len(operands) != len(f.operands) {
+		return 0, false, nil
+	}
+	opcode := f.opcode
+	for i, pattern := range f.operands {
+		op := operands[i]
+		// "CHAR x" and "BCD x" patterns
+		if sp := strings.IndexByte(pattern, ' '); sp >= 0 {
+			fields := strings.Fields(op)
+			if len(fields) != 2 ||
+				!strings.EqualFold(fields[0], pattern[:sp]) {
+				return 0, false, nil
+			}
+			pattern, op = pattern[sp+1:], fields[1]
+		}
+
+		switch pattern {
+		case "x", "y":
+			r, ok := register(op)
+			if !ok {
+				return 0, false, nil
+			}
+			if pattern == "x" {
+				opcode |= uint16(r) << 8
+			} else {
+				opcode |= uint16(r) << 4
+			}
+		case "n", "b", "a":
+			if !isNumber(op) && isKeyword(op) {
+				return 0, false, nil
+			}
+			max := map[string]uint16{"n": 0xF, "b": 0xFF, "a": 0xFFF}[pattern]
+			v, err := a.value(op, max)
+			if err != nil {
+				return 0, true, err
+			}
+			opcode |= v
+		default:
+			if !strings.EqualFold(op, pattern) {
+				return 0, false, nil
+			}
+		}
+	}
+	return opcode, true, nil
+}
+
+// data assembles DB and DW.
+func (a *assembler) data(s *statement) (res []byte, err error) {
+	if len(s.operands) == 0 {
+		return nil, fmt.Errorf("%s needs at least one value.", s.mnemonic)
+	}
+	for _, op := range s.operands {
+		if s.mnemonic == "DB" {
+			var v uint16
+			if v, err = a.value(op, 0xFF); err != nil {
+				return
+			}
+			res = append(res, byte(v))
+			continue
+		}
+		var v uint16
+		if v, err = a.value(op, 0xFFFF); err != nil {
+			return
+		}
+		res = append(res, byte(v>>8), byte(v))
+	}
+	return
+}
@@ -0,0 +1,78 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import "fmt"
+
+// A BadCodePolicy decides what happens when the emulator runs into an
+// instruction it doesn't know.
+type BadCodePolicy int
+
+const (
+	// BadCodeError halts the emulator with a BadCodeErr.
+	BadCodeError BadCodePolicy = iota
+	// BadCodeNop logs the instruction and carries on with the next one.
+	BadCodeNop
+	// BadCodeSkip logs the instruction and also skips the 2 bytes after it,
+	// for extensions with 4-byte instructions such as XO-CHIP's F000 NNNN.
+	BadCodeSkip
+)
+
+var badCodePolicyNames = map[BadCodePolicy]string{
+	BadCodeError: "error",
+	BadCodeNop:   "nop",
+	BadCodeSkip:  "skip",
+}
+
+func (p BadCodePolicy) String() string {
+	if name, ok := badCodePolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("BadCodePolicy(%d)", int(p))
+}
+
+// ParseBadCodePolicy returns the policy with the given name (see
+// BadCodePolicy.String).
+func ParseBadCodePolicy(name string) (BadCodePolicy, error) {
+	for p, n := range badCodePolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown bad code policy '%s'.", name)
+}
+
+// badCode applies the bad code policy to the invalid opcode which was just
+// fetched. PC already points to the next instruction.
+// Each address is only logged once so loops don't flood the log.
+func (c *Chip8) badCode(opcode []byte) error {
+	if c.badCodePolicy == BadCodeError {
+		return &BadCodeErr{}
+	}
+	addr := c.PC - 2
+	if c.badCodeSeen == nil {
+		c.badCodeSeen = make(map[uint16]bool)
+	}
+	if !c.badCodeSeen[addr] {
+		c.badCodeSeen[addr] = true
+		c.logger.Printf("Skipping invalid instruction %02X%02X at %03X.",
+			opcode[0], opcode[1], addr)
+	}
+	if c.badCodePolicy == BadCodeSkip {
+		c.PC += 2
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ROMExtensions are the file extensions recognized as CHIP-8 programs.
+var ROMExtensions = []string{".ch8", ".c8", ".c8x"}
+
+// IsROM returns whether path has one of the ROMExtensions.
+func IsROM(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range ROMExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// BatchOptions configures DisassembleDir.
+type BatchOptions struct {
+	// Format of the listings. Defaults to ListingText.
+	Format ListingFormat
+	// Start is the address programs are loaded at. Defaults to 0x200.
+	Start uint16
+	// Workers is the amount of ROMs disassembled in parallel. Defaults to
+	// the number of CPUs.
+	Workers int
+}
+
+// A BatchResult is the outcome of disassembling one ROM.
+type BatchResult struct {
+	// ROM is the path of the program.
+	ROM string
+	// Listing is the path of the listing that was written.
+	Listing string
+	Err     error
+}
+
+// DisassembleDir disassembles every ROM (see IsROM) in the src directory tree
+// into a listing in the dst directory, which mirrors the structure of src.
+// A nil opts uses the defaults.
+// Returns the result of every ROM, in the order they were found, and an error
+// if src couldn't be walked.
+func DisassembleDir(src, dst string, opts *BatchOptions) ([]BatchResult,
+	error) {
+
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+	format := opts.Format
+	if format == "" {
+		format = ListingText
+	}
+	if _, err := ParseListingFormat(string(format)); err != nil {
+		return nil, err
+	}
+	start := opts.Start
+	if start == 0 {
+		start = 0x200
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var results []BatchResult
+	err := filepath.Walk(src, func(path string, fi os.FileInfo,
+		err error) error {
+
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || !IsROM(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		listing := filepath.Join(dst,
+			strings.TrimSuffix(rel, filepath.Ext(rel))+format.Ext())
+		results = append(results, BatchResult{ROM: path, Listing: listing})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make(chan *BatchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				r.Err = disassembleFile(r.ROM, r.Listing, start, format)
+			}
+		}()
+	}
+	for i := range results {
+		jobs <- &results[i]
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results, nil
+}
+
+// disassembleFile writes the listing of the ROM at src to dst.
+func disassembleFile(src, dst string, start uint16,
+	format ListingFormat) error {
+
+	program, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	err = WriteListing(f, program, start, format)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
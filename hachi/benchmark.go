@@ -0,0 +1,197 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// the opcode classes reported by Benchmark, by the first hex digit
+var opcodeClasses = [16]string{
+	"0NNN CLS, RET, SYS", "1NNN JP", "2NNN CALL", "3XNN SE", "4XNN SNE",
+	"5XY0 SE", "6XNN LD", "7XNN ADD", "8XYN ALU", "9XY0 SNE", "ANNN LD I",
+	"BNNN JP V0", "CXNN RND", "DXYN DRW", "EXNN SKP, SKNP", "FXNN misc",
+}
+
+// BenchmarkOptions configures Benchmark.
+type BenchmarkOptions struct {
+	// Settings to run the program with. FixedTimestep is always enabled. If
+	// nil, DefaultSettings are used.
+	Settings *Chip8Settings
+	// Cycles is how many instructions to run. Defaults to 10 million.
+	Cycles uint64
+	// Keys are pressed and released every other frame while the program
+	// waits for a key, so that menus and title screens don't stall the
+	// benchmark. Defaults to Key5.
+	Keys uint16
+}
+
+// An OpcodeTiming is how much time an opcode class took in a benchmark.
+type OpcodeTiming struct {
+	// Class is the opcode pattern and the instructions it covers.
+	Class string
+	Count uint64
+	Time  time.Duration
+}
+
+// A BenchmarkResult is what Benchmark measured.
+type BenchmarkResult struct {
+	Cycles  uint64
+	Frames  uint64 // screen updates pushed to the driver
+	Elapsed time.Duration
+	// IPS is Cycles per second of Elapsed.
+	IPS float64
+	// Opcodes is the time spent on each opcode class, measured in a second
+	// run that times every instruction. Timing adds overhead, so the times
+	// are only meaningful relative to each other.
+	Opcodes []OpcodeTiming
+}
+
+// Benchmark runs a program headlessly for opts.Cycles instructions as fast
+// as possible and reports how fast it went. A nil opts uses the defaults.
+// Returns the result so far and the error if the program crashes.
+func Benchmark(rom []byte, opts *BenchmarkOptions) (*BenchmarkResult, error) {
+	if opts == nil {
+		opts = &BenchmarkOptions{}
+	}
+	settings := *DefaultSettings
+	if opts.Settings != nil {
+		settings = *opts.Settings
+	}
+	settings.FixedTimestep = true
+	o := *opts
+	o.Settings = &settings
+	if o.Cycles == 0 {
+		o.Cycles = 10000000
+	}
+	if o.Keys == 0 {
+		o.Keys = Key5
+	}
+
+	res := &BenchmarkResult{}
+	c, elapsed, err := benchmarkRun(rom, &o, nil)
+	if c == nil {
+		return nil, err
+	}
+	stats := c.Stats()
+	res.Cycles, res.Frames, res.Elapsed = stats.Cycles, stats.Frames, elapsed
+	if elapsed > 0 {
+		res.IPS = float64(res.Cycles) / elapsed.Seconds()
+	}
+	if err != nil {
+		return res, err
+	}
+
+	t := &opcodeTimer{class: -1}
+	if _, _, err = benchmarkRun(rom, &o, t); err != nil {
+		return res, err
+	}
+	for i, name := range opcodeClasses {
+		if t.counts[i] != 0 {
+			res.Opcodes = append(res.Opcodes,
+				OpcodeTiming{name, t.counts[i], t.times[i]})
+		}
+	}
+	return res, nil
+}
+
+// opcodeTimer times every instruction until the next one starts, as a
+// TraceFunc.
+type opcodeTimer struct {
+	counts [16]uint64
+	times  [16]time.Duration
+	class  int // of the instruction being timed, -1 for none
+	start  time.Time
+}
+
+func (t *opcodeTimer) trace(pc uint16, opcode uint16, in Instruction) {
+	now := time.Now()
+	t.stop(now)
+	t.class = int(opcode >> 12)
+	t.counts[t.class]++
+	t.start = now
+}
+
+// stop ends the timing of the current instruction, which is also done at
+// the end of every frame so the timers and screen updates aren't counted.
+func (t *opcodeTimer) stop(now time.Time) {
+	if t.class >= 0 {
+		t.times[t.class] += now.Sub(t.start)
+	}
+	t.class = -1
+}
+
+// benchmarkRun runs the program for one benchmark pass, timing every
+// instruction with t if it's not nil.
+func benchmarkRun(rom []byte, opts *BenchmarkOptions, t *opcodeTimer) (
+	c *Chip8, elapsed time.Duration, err error) {
+
+	if c, err = New("null", opts.Settings); err != nil {
+		return nil, 0, err
+	}
+	if err = c.LoadRaw(rom); err != nil {
+		return nil, 0, err
+	}
+	if t != nil {
+		c.TraceFunc = t.trace
+	}
+	start := time.Now()
+	for c.stats.Cycles < opts.Cycles {
+		switch {
+		case c.wii == nil || c.Keyboard&opts.Keys != 0:
+			c.ReleaseKey(opts.Keys)
+			if c.wii != nil {
+				// keys held when the wait began don't count otherwise
+				c.wii.zeroBits |= opts.Keys
+			}
+		default:
+			c.PressKey(opts.Keys)
+		}
+		err = c.AdvanceFrame()
+		if t != nil {
+			t.stop(time.Now())
+		}
+		if err != nil {
+			break
+		}
+	}
+	return c, time.Since(start), err
+}
+
+// WriteBenchmark writes the benchmark results in a human readable form.
+func WriteBenchmark(w io.Writer, r *BenchmarkResult) error {
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 8, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "cycles: %d in %v (%.0f per second)\nframes: %d\n\n",
+		r.Cycles, r.Elapsed, r.IPS, r.Frames)
+	var total time.Duration
+	for _, o := range r.Opcodes {
+		total += o.Time
+	}
+	fmt.Fprintln(tw, "opcode class\tcount\ttime\tshare\tper instruction\t")
+	for _, o := range r.Opcodes {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(o.Time) / float64(total)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%.1f%%\t%v\t\n", o.Class, o.Count,
+			o.Time, share, o.Time/time.Duration(o.Count))
+	}
+	return tw.Flush()
+}
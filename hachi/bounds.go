@@ -0,0 +1,89 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import "fmt"
+
+// A BoundsPolicy decides what happens when LD [I],BCD VX, LD [I],VX,
+// LD VX,[I] or DRW access memory past its end, or when the memory
+// instructions touch the interpreter area below 0x200.
+type BoundsPolicy int
+
+const (
+	// BoundsError halts the emulator with an AccessErr.
+	BoundsError BoundsPolicy = iota
+	// BoundsWrap wraps addresses around the end of memory. The interpreter
+	// area is not protected, like on the original hardware.
+	BoundsWrap
+	// BoundsIgnore skips the bytes that are out of bounds: writes are
+	// dropped, LD VX,[I] leaves the registers unchanged and DRW reads them
+	// as empty sprite rows.
+	BoundsIgnore
+)
+
+var boundsPolicyNames = map[BoundsPolicy]string{
+	BoundsError:  "error",
+	BoundsWrap:   "wrap",
+	BoundsIgnore: "ignore",
+}
+
+func (p BoundsPolicy) String() string {
+	if name, ok := boundsPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("BoundsPolicy(%d)", int(p))
+}
+
+// ParseBoundsPolicy returns the policy with the given name (see
+// BoundsPolicy.String).
+func ParseBoundsPolicy(name string) (BoundsPolicy, error) {
+	for p, n := range boundsPolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown out of bounds policy '%s'.", name)
+}
+
+// checkAccess checks an access of size bytes at I by op. protected is true
+// for the instructions that may not touch the interpreter area.
+// Only the BoundsError policy ever fails.
+func (c *Chip8) checkAccess(op string, size int, protected bool) error {
+	if c.bounds != BoundsError {
+		return nil
+	}
+	addr := c.addrI()
+	if addr+size > len(c.Memory) || (protected && addr < 0x200) {
+		return &AccessErr{op, c.I, size, c.bounds}
+	}
+	return nil
+}
+
+// memAddr returns the index in Memory of I+off according to the policy, or
+// -1 if the byte must be skipped. Under BoundsError, checkAccess must have
+// been called first.
+func (c *Chip8) memAddr(off int, protected bool) int {
+	addr := c.addrI() + off
+	switch c.bounds {
+	case BoundsWrap:
+		return addr % len(c.Memory)
+	case BoundsIgnore:
+		if addr >= len(c.Memory) || (protected && addr < 0x200) {
+			return -1
+		}
+	}
+	return addr
+}
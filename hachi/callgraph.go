@@ -0,0 +1,146 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A Subroutine is a node of a call graph.
+type Subroutine struct {
+	Address uint16
+	// Callers are the addresses of the subroutines that call this one.
+	Callers []uint16
+	// Callees are the addresses of the subroutines called by this one.
+	Callees []uint16
+	// CallSites are the addresses of the CALL instructions that call this
+	// subroutine.
+	CallSites []uint16
+}
+
+// Subroutines is a call graph, indexed by subroutine address.
+type Subroutines map[uint16]*Subroutine
+
+// CallGraph finds the subroutines of a program loaded at start by following
+// its control flow like Disassemble, and how they call each other. The main
+// program is included as a subroutine at start. A subroutine is made of
+// everything it can reach without going through a CALL or RET, so code that
+// is shared by several subroutines counts for all of them. Calls to
+// addresses outside the program are included but not followed.
+func CallGraph(program []byte, start uint16) Subroutines {
+	res := Subroutines{}
+	get := func(addr uint16) *Subroutine {
+		if res[addr] == nil {
+			res[addr] = &Subroutine{Address: addr}
+		}
+		return res[addr]
+	}
+
+	pending := []uint16{start}
+	get(start)
+	for len(pending) != 0 {
+		sub := get(pending[len(pending)-1])
+		pending = pending[:len(pending)-1]
+
+		visited := map[int]bool{}
+		walk := []int{int(sub.Address)}
+		for len(walk) != 0 {
+			addr := walk[len(walk)-1]
+			walk = walk[:len(walk)-1]
+			offset := addr - int(start)
+			if visited[addr] || offset < 0 || offset+1 >= len(program) {
+				continue
+			}
+			visited[addr] = true
+
+			in := decode(program[offset : offset+2])
+			next, targets, ok := flow(in, addr)
+			if !ok {
+				continue
+			}
+			if call, isCall := in.(Call); isCall {
+				callee := call.Address()
+				if res[callee] == nil {
+					pending = append(pending, callee)
+				}
+				c := get(callee)
+				c.CallSites = appendUnique(c.CallSites, uint16(addr))
+				c.Callers = appendUnique(c.Callers, sub.Address)
+				sub.Callees = appendUnique(sub.Callees, callee)
+				targets = nil
+			}
+			if next >= 0 {
+				walk = append(walk, next)
+			}
+			walk = append(walk, targets...)
+		}
+	}
+
+	for _, sub := range res {
+		for _, list := range [][]uint16{sub.Callers, sub.Callees,
+			sub.CallSites} {
+
+			sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+		}
+	}
+	return res
+}
+
+func appendUnique(list []uint16, addr uint16) []uint16 {
+	for _, a := range list {
+		if a == addr {
+			return list
+		}
+	}
+	return append(list, addr)
+}
+
+// Sorted returns the subroutines sorted by address.
+func (s Subroutines) Sorted() (res []*Subroutine) {
+	for _, sub := range s {
+		res = append(res, sub)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Address < res[j].Address
+	})
+	return
+}
+
+// WriteDOT writes the call graph in the DOT format of Graphviz. Subroutines
+// are named like the labels of ApplyLabels.
+func (s Subroutines) WriteDOT(w io.Writer) error {
+	if _, err := io.WriteString(w, "digraph calls {\n"+
+		"\tnode [shape=box, fontname=monospace];\n"); err != nil {
+		return err
+	}
+	for _, sub := range s.Sorted() {
+		_, err := fmt.Fprintf(w, "\tL_%04X;\n", sub.Address)
+		if err != nil {
+			return err
+		}
+		for _, callee := range sub.Callees {
+			_, err = fmt.Fprintf(w, "\tL_%04X -> L_%04X;\n", sub.Address,
+				callee)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
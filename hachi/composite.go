@@ -0,0 +1,221 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A CompositeDriver combines several registered drivers into one, for
+// example a video driver with a recorder, by forwarding every call to all of
+// them in order. The optional driver interfaces (ShutdownDriver, HaltDriver,
+// SoundDriver, LoadDriver, ReconfigureDriver, ColorDriver, SampleDriver) are
+// forwarded to the drivers that implement them.
+//
+// A composite driver must be registered like any other driver:
+//
+//	d, err := hachi.NewCompositeDriver("termloop", "framedump")
+//	// handle err...
+//	err = hachi.RegisterDriver("termloop+framedump", d)
+//	ha, err := hachi.New("termloop+framedump", nil)
+//
+// Driver data keys can be prefixed with the name of a driver to reach only
+// that one, such as "framedump.dir". Otherwise, GetData returns the first
+// non-nil value and SetData sets the value on every driver, failing only if
+// none of them accepts it.
+type CompositeDriver struct {
+	Driver
+	names []string
+	parts []Driver
+}
+
+// NewCompositeDriver combines the drivers registered under names.
+// Returns an error if one of them isn't registered.
+func NewCompositeDriver(names ...string) (*CompositeDriver, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("A composite driver needs at least one " +
+			"driver.")
+	}
+	d := &CompositeDriver{names: names}
+	for _, name := range names {
+		if drivers[name] == nil {
+			return nil, fmt.Errorf("Driver %s not found.", name)
+		}
+		d.parts = append(d.parts, drivers[name])
+	}
+	return d, nil
+}
+
+// Drivers returns the names of the combined drivers.
+func (d *CompositeDriver) Drivers() []string {
+	return append([]string(nil), d.names...)
+}
+
+func (d *CompositeDriver) OnInit(c *Chip8) {
+	for _, p := range d.parts {
+		p.OnInit(c)
+	}
+}
+
+func (d *CompositeDriver) Cls() {
+	for _, p := range d.parts {
+		p.Cls()
+	}
+}
+
+func (d *CompositeDriver) OnUpdate(c *Chip8) {
+	for _, p := range d.parts {
+		p.OnUpdate(c)
+	}
+}
+
+func (d *CompositeDriver) UpdateScreen(c *Chip8) {
+	for _, p := range d.parts {
+		p.UpdateScreen(c)
+	}
+}
+
+func (d *CompositeDriver) Beep() {
+	for _, p := range d.parts {
+		p.Beep()
+	}
+}
+
+// part returns the driver that a prefixed data key is meant for and the key
+// without the prefix, or nil if it has no prefix.
+func (d *CompositeDriver) part(key string) (Driver, string) {
+	if i := strings.Index(key, "."); i >= 0 {
+		for n, name := range d.names {
+			if name == key[:i] {
+				return d.parts[n], key[i+1:]
+			}
+		}
+	}
+	return nil, key
+}
+
+func (d *CompositeDriver) GetData(key string) interface{} {
+	if p, k := d.part(key); p != nil {
+		return p.GetData(k)
+	}
+	for _, p := range d.parts {
+		if v := p.GetData(key); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+func (d *CompositeDriver) SetData(key string, value interface{}) error {
+	if p, k := d.part(key); p != nil {
+		return p.SetData(k, value)
+	}
+	var first error
+	accepted := false
+	for _, p := range d.parts {
+		if err := p.SetData(key, value); err == nil {
+			accepted = true
+		} else if first == nil {
+			first = err
+		}
+	}
+	if accepted {
+		return nil
+	}
+	return first
+}
+
+// OnShutdown forwards to the ShutdownDrivers.
+func (d *CompositeDriver) OnShutdown(c *Chip8) {
+	for _, p := range d.parts {
+		if s, ok := p.(ShutdownDriver); ok {
+			s.OnShutdown(c)
+		}
+	}
+}
+
+// OnHalt forwards to the HaltDrivers.
+func (d *CompositeDriver) OnHalt(c *Chip8, err error) {
+	for _, p := range d.parts {
+		if h, ok := p.(HaltDriver); ok {
+			h.OnHalt(c, err)
+		}
+	}
+}
+
+// OnSoundStart forwards to the SoundDrivers.
+func (d *CompositeDriver) OnSoundStart(c *Chip8, frames int) {
+	for _, p := range d.parts {
+		if s, ok := p.(SoundDriver); ok {
+			s.OnSoundStart(c, frames)
+		}
+	}
+}
+
+// OnSoundStop forwards to the SoundDrivers.
+func (d *CompositeDriver) OnSoundStop(c *Chip8) {
+	for _, p := range d.parts {
+		if s, ok := p.(SoundDriver); ok {
+			s.OnSoundStop(c)
+		}
+	}
+}
+
+// OnLoad forwards to the LoadDrivers.
+func (d *CompositeDriver) OnLoad(c *Chip8, info RomInfo) {
+	for _, p := range d.parts {
+		if l, ok := p.(LoadDriver); ok {
+			l.OnLoad(c, info)
+		}
+	}
+}
+
+// OnReconfigure forwards to the ReconfigureDrivers.
+func (d *CompositeDriver) OnReconfigure(c *Chip8, old *Chip8Settings) {
+	for _, p := range d.parts {
+		if r, ok := p.(ReconfigureDriver); ok {
+			r.OnReconfigure(c, old)
+		}
+	}
+}
+
+// UpdateColorScreen forwards to the ColorDrivers.
+func (d *CompositeDriver) UpdateColorScreen(c *Chip8) {
+	for _, p := range d.parts {
+		if cd, ok := p.(ColorDriver); ok {
+			cd.UpdateColorScreen(c)
+		}
+	}
+}
+
+// OnSampleStart forwards to the SampleDrivers.
+func (d *CompositeDriver) OnSampleStart(c *Chip8, s *Sample) {
+	for _, p := range d.parts {
+		if sd, ok := p.(SampleDriver); ok {
+			sd.OnSampleStart(c, s)
+		}
+	}
+}
+
+// OnSampleStop forwards to the SampleDrivers.
+func (d *CompositeDriver) OnSampleStop(c *Chip8) {
+	for _, p := range d.parts {
+		if sd, ok := p.(SampleDriver); ok {
+			sd.OnSampleStop(c)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// A ReconfigureDriver is a Driver that wants to know when the settings
+// change at runtime, for example to pick up the new key layout.
+type ReconfigureDriver interface {
+	Driver
+	// Called by Chip8.Reconfigure after the new settings are applied.
+	// old holds the previous settings, the new ones are in c.Settings().
+	OnReconfigure(c *Chip8, old *Chip8Settings)
+}
+
+// Settings returns a copy of the settings the emulator is running with,
+// after defaults are applied (see WithDefaults).
+func (c *Chip8) Settings() *Chip8Settings {
+	s := c.settings
+	s.Persistent = append([]PersistentRegion(nil), c.settings.Persistent...)
+	return &s
+}
+
+// Reconfigure changes the settings of a running emulator. Zero-valued fields
+// fall back to their defaults, like in New.
+// Settings that define the machine's memory layout or how it's driven
+// (MemorySize, StackSize, Width, Height, Realistic, Variant, FixedTimestep,
+// Persistent and Transform, which drivers size their display after) can't
+// change at runtime and must be the same as in
+// Settings(), everything else takes effect from the next instruction.
+// Changing RandomSeed restarts the RandomVIP generator from the new seed.
+// The driver is notified through ReconfigureDriver.
+// This is not thread-safe, so call it from the goroutine that runs the
+// emulator.
+// Returns an error if the settings are invalid or try to change a setting
+// that is fixed, in which case nothing is changed.
+func (c *Chip8) Reconfigure(s *Chip8Settings) error {
+	s = s.WithDefaults()
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	old := c.settings
+	switch {
+	case s.MemorySize != old.MemorySize:
+		return fixedSettingErr("MemorySize")
+	case s.StackSize != old.StackSize:
+		return fixedSettingErr("StackSize")
+	case s.Width != old.Width || s.Height != old.Height:
+		return fixedSettingErr("Width and Height")
+	case s.Realistic != old.Realistic:
+		return fixedSettingErr("Realistic")
+	case s.Variant != old.Variant:
+		return fixedSettingErr("Variant")
+	case s.FixedTimestep != old.FixedTimestep:
+		return fixedSettingErr("FixedTimestep")
+	case !samePersistent(s.Persistent, old.Persistent):
+		return fixedSettingErr("Persistent")
+	case s.Transform != old.Transform:
+		return fixedSettingErr("Transform")
+	}
+
+	c.quirks = c.variant.quirks(s.Quirks)
+	c.pLdMemory = ldMemory[c.quirks.MemoryIncrement]
+	c.pLdSetMemory = ldSetMemory[c.quirks.MemoryIncrement]
+	c.pShr = shr[c.quirks.ShiftVY]
+	c.pShl = shl[c.quirks.ShiftVY]
+	c.logger = s.Logger
+	if c.logger == nil {
+		c.logger = log.New(io.Discard, "", 0)
+	}
+	c.keyLayout = KeyLayouts[s.KeyLayout]
+	c.palette = paletteFor(s)
+	if s.Palette != old.Palette {
+		c.updateScreen()
+	}
+	c.ScreenInterval = 0
+	if s.MaxFPS > 0 {
+		c.ScreenInterval = time.Second / time.Duration(s.MaxFPS)
+	}
+	if s.PixelDecay != old.PixelDecay {
+		c.decay = nil
+		if s.PixelDecay > 0 {
+			c.decay = newPixelDecay(s.PixelDecay, len(c.Screen))
+		}
+		c.updateScreen()
+	}
+	c.CyclesPerFrame = s.CyclesPerFrame
+	c.bounds = s.OutOfBounds
+	c.keyWaitTimeout = s.KeyWaitTimeout
+	c.romLookup = s.RomLookup
+	c.keyOrder = s.KeyOrder
+	c.randomSource = s.Random
+	c.rng = newRand(s.Rand)
+	if s.RandomSeed != old.RandomSeed {
+		c.randomSeed = s.RandomSeed
+	}
+	c.vipTiming = s.VIPTiming
+	c.cyclesPerSecond = s.CyclesPerSecond
+	c.stackWarning = s.StackWarning
+	c.badCodePolicy = s.BadCode
+	if s.RewindDepth != old.RewindDepth ||
+		s.RewindInterval != old.RewindInterval {
+		// the history is lost
+		c.rewind = nil
+		if s.RewindDepth > 0 {
+			c.rewind = newRewindBuffer(s.RewindDepth, s.RewindInterval)
+		}
+	}
+
+	c.settings = *s
+	c.settings.Persistent = old.Persistent
+	if d, ok := drivers[c.driver].(ReconfigureDriver); ok {
+		d.OnReconfigure(c, &old)
+	}
+	return nil
+}
+
+func fixedSettingErr(name string) error {
+	return fmt.Errorf("%s can't be changed at runtime.", name)
+}
+
+func samePersistent(a, b []PersistentRegion) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
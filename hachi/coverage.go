@@ -0,0 +1,71 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"io"
+)
+
+// A CoverageMap records which addresses an instruction was executed at, by
+// address. See EnableCoverage.
+type CoverageMap []bool
+
+// Executed returns true if an instruction was executed at addr.
+func (m CoverageMap) Executed(addr uint16) bool {
+	return int(addr) < len(m) && m[addr]
+}
+
+// Covered returns how many of the size bytes starting at start belong to an
+// instruction that was executed.
+func (m CoverageMap) Covered(start uint16, size int) (n int) {
+	for addr := int(start); addr < int(start)+size; addr++ {
+		if m.Executed(uint16(addr)) ||
+			(addr > 0 && m.Executed(uint16(addr-1))) {
+			n++
+		}
+	}
+	return
+}
+
+// EnableCoverage starts recording which addresses are executed, which tells
+// the code of a program apart from its data (see DisassembleCovered).
+// Calling it again clears the coverage map.
+func (c *Chip8) EnableCoverage() {
+	c.coverage = make(CoverageMap, 0x10000)
+}
+
+// Coverage returns the addresses executed since EnableCoverage was called, or
+// nil if coverage is disabled. The map is updated as the program runs.
+func (c *Chip8) Coverage() CoverageMap { return c.coverage }
+
+// WriteCoverage writes how much of a program loaded at start was executed,
+// followed by its listing where the code that never ran is shown as data.
+func WriteCoverage(w io.Writer, program []byte, start uint16,
+	cov CoverageMap) error {
+
+	covered := cov.Covered(start, len(program))
+	percent := 0.0
+	if len(program) > 0 {
+		percent = 100 * float64(covered) / float64(len(program))
+	}
+	_, err := fmt.Fprintf(w, "coverage: %d of %d bytes executed (%.1f%%)\n\n",
+		covered, len(program), percent)
+	if err != nil {
+		return err
+	}
+	return writeListingText(w, DisassembleCovered(program, start, cov), start)
+}
@@ -0,0 +1,135 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A BreakpointHit is returned by Tick, Frame, AdvanceFrame and Run when PC
+// reaches a breakpoint. Unlike other errors it doesn't halt the emulator, the
+// instruction at the breakpoint runs on the next call.
+type BreakpointHit struct {
+	Address uint16
+}
+
+func (e *BreakpointHit) Error() string {
+	return fmt.Sprintf("Breakpoint hit at %03X.", e.Address)
+}
+
+// AddBreakpoint makes execution stop right before the instruction at addr.
+// Breakpoints survive Reset.
+func (c *Chip8) AddBreakpoint(addr uint16) {
+	if c.breakpoints == nil {
+		c.breakpoints = make(map[uint16]bool)
+	}
+	c.breakpoints[addr] = true
+}
+
+// RemoveBreakpoint removes the breakpoint at addr, if any.
+func (c *Chip8) RemoveBreakpoint(addr uint16) {
+	delete(c.breakpoints, addr)
+}
+
+// Breakpoints returns the addresses of all the breakpoints, sorted.
+func (c *Chip8) Breakpoints() (res []uint16) {
+	for addr := range c.breakpoints {
+		res = append(res, addr)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return
+}
+
+// checkBreakpoint returns a BreakpointHit if the next instruction has a
+// breakpoint that wasn't just reported. Instructions aren't fetched while
+// waiting for a key, so neither are breakpoints checked.
+func (c *Chip8) checkBreakpoint() *BreakpointHit {
+	resume := c.breakResume
+	c.breakResume = nil
+	if c.wii != nil || !c.breakpoints[c.PC] {
+		return nil
+	}
+	if resume != nil && resume.Address == c.PC {
+		return nil
+	}
+	c.breakResume = &BreakpointHit{c.PC}
+	return c.breakResume
+}
+
+// Step runs exactly one instruction, even if it has a breakpoint. Nothing
+// runs while the program waits for a key, Step only polls the input.
+// With FixedTimestep, the timers tick once every CyclesPerFrame steps,
+// otherwise they follow the wall clock like with Tick.
+// Returns an error if any.
+func (c *Chip8) Step() error {
+	if c.halted != nil {
+		return c.halted
+	}
+	c.breakResume = nil
+	if err := c.step(); err != nil {
+		return c.halt(err)
+	}
+	if !c.fixedTimestep {
+		c.updateTimers()
+		return nil
+	}
+	c.stepCycles++
+	if c.stepCycles >= c.CyclesPerFrame {
+		c.stepCycles = 0
+		c.tickTimers()
+	}
+	return nil
+}
+
+// StepOver is Step, except that a CALL runs until the subroutine returns.
+// Returns a *BreakpointHit if a breakpoint is reached before that.
+func (c *Chip8) StepOver() error {
+	depth := c.SP
+	call := c.wii == nil && int(c.PC)+1 < len(c.Memory) &&
+		c.Memory[c.PC]&0xF0 == 0x20
+	if err := c.Step(); err != nil || !call {
+		return err
+	}
+	return c.runUntil(func() bool { return c.SP <= depth })
+}
+
+// StepOut runs until the current subroutine returns.
+// Returns a *BreakpointHit if a breakpoint is reached before that, or an
+// error if PC isn't in a subroutine.
+func (c *Chip8) StepOut() error {
+	depth := c.SP
+	if depth < 0 {
+		return fmt.Errorf("Not in a subroutine.")
+	}
+	if err := c.Step(); err != nil {
+		return err
+	}
+	return c.runUntil(func() bool { return c.SP < depth })
+}
+
+// runUntil steps until done returns true, stopping at breakpoints.
+func (c *Chip8) runUntil(done func() bool) error {
+	for !done() {
+		if hit := c.checkBreakpoint(); hit != nil {
+			return hit
+		}
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,494 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package debughttp exposes an emulator to debugging tools over HTTP, so that
+// it can be scripted from any language. Every endpoint takes and returns JSON.
+// Addresses and numbers in the query can be decimal or hex with a 0x prefix.
+//
+//	GET    /state                   registers, timers, keys and run status
+//	PUT    /state                   sets any of pc, i, v, sp, dt and st
+//	GET    /memory?addr=&len=       reads memory as a hex string
+//	PUT    /memory?addr=            writes {"data": "hex"} to memory
+//	GET    /breakpoints             lists the breakpoints
+//	PUT    /breakpoints?addr=       adds a breakpoint
+//	DELETE /breakpoints?addr=       removes a breakpoint
+//	POST   /step, /step-over, /step-out, /pause, /resume, /reset
+//	GET    /disassembly?addr=&before=&after=
+//	                                the instructions around addr (PC by
+//	                                default)
+//
+// The actions return the state afterwards, with an "error" field if the
+// action failed or stopped at a breakpoint. Malformed requests get a 400 and
+// {"error": "..."}.
+//
+// The emulator isn't thread-safe, so requests are queued and run by Poll,
+// which the front-end must call from the goroutine that runs the emulator,
+// for example once per frame. The front-end should also pause the emulator
+// when Frame or Tick return a *hachi.BreakpointHit, so that the breakpoint can
+// be inspected.
+package debughttp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// requests that aren't polled within this time fail, in case the front-end
+// is stuck or not polling at all
+const pollTimeout = 5 * time.Second
+
+// A Server serves the debug API for an emulator.
+type Server struct {
+	c        *hachi.Chip8
+	mux      *http.ServeMux
+	requests chan *request
+	server   *http.Server
+}
+
+// a request is a function to run on the emulator's goroutine
+type request struct {
+	fn   func(c *hachi.Chip8) (interface{}, error)
+	res  interface{}
+	err  error
+	done chan struct{}
+}
+
+// A badRequest is an error caused by the request rather than the emulator.
+type badRequest struct{ error }
+
+// State is the response of /state and of the actions.
+type State struct {
+	PC            uint16    `json:"pc"`
+	I             uint16    `json:"i"`
+	V             [16]uint8 `json:"v"`
+	SP            int       `json:"sp"`
+	Stack         []uint16  `json:"stack"`
+	DT            uint8     `json:"dt"`
+	ST            uint8     `json:"st"`
+	Keyboard      uint16    `json:"keyboard"`
+	Paused        bool      `json:"paused"`
+	WaitingForKey bool      `json:"waiting_for_key"`
+	Halted        string    `json:"halted,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Registers is the body of PUT /state. Fields that are left out are not
+// changed.
+type Registers struct {
+	PC *uint16    `json:"pc"`
+	I  *uint16    `json:"i"`
+	V  *[16]uint8 `json:"v"`
+	SP *int       `json:"sp"`
+	DT *uint8     `json:"dt"`
+	ST *uint8     `json:"st"`
+}
+
+// Memory is the response of GET /memory and the body of PUT /memory.
+type Memory struct {
+	Address uint16 `json:"addr"`
+	Data    string `json:"data"` // hex
+}
+
+// A Line is one instruction in the response of /disassembly.
+type Line struct {
+	Address     uint16 `json:"addr"`
+	Opcode      uint16 `json:"opcode"`
+	Mnemonic    string `json:"mnemonic"`
+	Description string `json:"description"`
+	Breakpoint  bool   `json:"breakpoint,omitempty"`
+	Current     bool   `json:"current,omitempty"`
+}
+
+// New creates a debug server for c. It doesn't listen until ListenAndServe
+// is called, Handler can be used to mount it on another server instead.
+func New(c *hachi.Chip8) *Server {
+	s := &Server{
+		c:        c,
+		mux:      http.NewServeMux(),
+		requests: make(chan *request, 16),
+	}
+	s.mux.HandleFunc("/state", s.handleState)
+	s.mux.HandleFunc("/memory", s.handleMemory)
+	s.mux.HandleFunc("/breakpoints", s.handleBreakpoints)
+	s.mux.HandleFunc("/disassembly", s.handleDisassembly)
+	actions := map[string]func(c *hachi.Chip8) error{
+		"/step":      (*hachi.Chip8).Step,
+		"/step-over": (*hachi.Chip8).StepOver,
+		"/step-out":  (*hachi.Chip8).StepOut,
+		"/reset":     (*hachi.Chip8).Reset,
+		"/pause": func(c *hachi.Chip8) error {
+			c.Pause()
+			return nil
+		},
+		"/resume": func(c *hachi.Chip8) error {
+			c.Resume()
+			return nil
+		},
+	}
+	for path, action := range actions {
+		s.mux.HandleFunc(path, s.actionHandler(action))
+	}
+	return s
+}
+
+// Handler returns the http handler that serves the API.
+func (s *Server) Handler() http.Handler { return s.mux }
+
+// ListenAndServe starts listening on addr in the background.
+// Returns an error if the address can't be listened on.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.server = &http.Server{Handler: s.mux}
+	go func(server *http.Server) {
+		err := server.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			s.c.Logger().Println("debughttp:", err)
+		}
+	}(s.server)
+	s.c.Logger().Println("debughttp: listening on", l.Addr())
+	return nil
+}
+
+// Close stops the server started by ListenAndServe.
+func (s *Server) Close() error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+// Poll runs the pending requests. It must be called from the goroutine that
+// runs the emulator.
+func (s *Server) Poll() {
+	for {
+		select {
+		case r := <-s.requests:
+			r.res, r.err = r.fn(s.c)
+			close(r.done)
+		default:
+			return
+		}
+	}
+}
+
+// do runs fn on the emulator's goroutine and writes its result.
+func (s *Server) do(w http.ResponseWriter,
+	fn func(c *hachi.Chip8) (interface{}, error)) {
+
+	r := &request{fn: fn, done: make(chan struct{})}
+	timeout := time.After(pollTimeout)
+	select {
+	case s.requests <- r:
+	case <-timeout:
+		writeError(w, http.StatusServiceUnavailable,
+			fmt.Errorf("The emulator is not responding."))
+		return
+	}
+	select {
+	case <-r.done:
+	case <-timeout:
+		// the request stays queued and runs whenever Poll gets to it
+		writeError(w, http.StatusServiceUnavailable,
+			fmt.Errorf("The emulator is not responding."))
+		return
+	}
+	if r.err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := r.err.(badRequest); ok {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, r.err)
+		return
+	}
+	writeJSON(w, http.StatusOK, r.res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed,
+		fmt.Errorf("Method %s not allowed.", r.Method))
+}
+
+// queryNumber parses the query parameter name as a number of at most bits
+// bits, or returns def if it's missing.
+func queryNumber(r *http.Request, name string, bits int,
+	def uint64) (uint64, error) {
+
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(str, 0, bits)
+	if err != nil {
+		return 0, badRequest{fmt.Errorf("Invalid %s '%s'.", name, str)}
+	}
+	return n, nil
+}
+
+// queryAddress parses the addr query parameter, which is required.
+func queryAddress(r *http.Request) (uint16, error) {
+	if r.URL.Query().Get("addr") == "" {
+		return 0, badRequest{fmt.Errorf("Missing addr.")}
+	}
+	addr, err := queryNumber(r, "addr", 16, 0)
+	return uint16(addr), err
+}
+
+// state returns the current state, with err as the error if not nil.
+func state(c *hachi.Chip8, err error) *State {
+	s := &State{
+		PC:            c.PC,
+		I:             c.I,
+		V:             c.V,
+		SP:            c.SP,
+		Stack:         append([]uint16(nil), c.Stack...),
+		DT:            c.DT,
+		ST:            c.ST,
+		Keyboard:      c.Keyboard,
+		Paused:        c.Paused(),
+		WaitingForKey: c.WaitingForKey(),
+	}
+	if herr := c.Halted(); herr != nil {
+		s.Halted = herr.Error()
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	return s
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.do(w, func(c *hachi.Chip8) (interface{}, error) {
+			return state(c, nil), nil
+		})
+	case http.MethodPut:
+		var regs Registers
+		if err := json.NewDecoder(r.Body).Decode(&regs); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.do(w, func(c *hachi.Chip8) (interface{}, error) {
+			if err := setRegisters(c, &regs); err != nil {
+				return nil, err
+			}
+			return state(c, nil), nil
+		})
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// setRegisters applies regs to c, after checking that all of them are valid.
+func setRegisters(c *hachi.Chip8, regs *Registers) error {
+	switch {
+	case regs.PC != nil && int(*regs.PC)+2 > len(c.Memory):
+		return badRequest{fmt.Errorf("PC %03X is out of memory.", *regs.PC)}
+	case regs.SP != nil && (*regs.SP < -1 || *regs.SP >= len(c.Stack)):
+		return badRequest{fmt.Errorf("SP %d is out of the stack.", *regs.SP)}
+	}
+	if regs.PC != nil {
+		c.PC = *regs.PC
+	}
+	if regs.I != nil {
+		c.I = *regs.I
+	}
+	if regs.V != nil {
+		c.V = *regs.V
+	}
+	if regs.SP != nil {
+		c.SP = *regs.SP
+	}
+	if regs.DT != nil {
+		c.DT = *regs.DT
+	}
+	if regs.ST != nil {
+		c.ST = *regs.ST
+	}
+	return nil
+}
+
+func (s *Server) handleMemory(w http.ResponseWriter, r *http.Request) {
+	addr, err := queryAddress(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		n, err := queryNumber(r, "len", 16, 16)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.do(w, func(c *hachi.Chip8) (interface{}, error) {
+			end := int(addr) + int(n)
+			if end > len(c.Memory) {
+				end = len(c.Memory)
+			}
+			if int(addr) > end {
+				return nil, badRequest{
+					fmt.Errorf("Address %03X is out of memory.", addr)}
+			}
+			return &Memory{addr, hex.EncodeToString(c.Memory[addr:end])},
+				nil
+		})
+	case http.MethodPut:
+		var m Memory
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		data, err := hex.DecodeString(m.Data)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.do(w, func(c *hachi.Chip8) (interface{}, error) {
+			if int(addr)+len(data) > len(c.Memory) {
+				return nil, badRequest{fmt.Errorf(
+					"%d bytes at %03X don't fit in memory.", len(data), addr)}
+			}
+			copy(c.Memory[addr:], data)
+			return &Memory{addr, hex.EncodeToString(data)}, nil
+		})
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+func (s *Server) handleBreakpoints(w http.ResponseWriter, r *http.Request) {
+	var change func(c *hachi.Chip8, addr uint16)
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		change = (*hachi.Chip8).AddBreakpoint
+	case http.MethodDelete:
+		change = (*hachi.Chip8).RemoveBreakpoint
+	default:
+		methodNotAllowed(w, r)
+		return
+	}
+	var addr uint16
+	if change != nil {
+		var err error
+		if addr, err = queryAddress(r); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	s.do(w, func(c *hachi.Chip8) (interface{}, error) {
+		if change != nil {
+			change(c, addr)
+		}
+		res := c.Breakpoints()
+		if res == nil {
+			res = []uint16{}
+		}
+		return res, nil
+	})
+}
+
+func (s *Server) handleDisassembly(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	addr, err := queryNumber(r, "addr", 16, 1<<16)
+	var before, after uint64
+	if err == nil {
+		before, err = queryNumber(r, "before", 8, 8)
+	}
+	if err == nil {
+		after, err = queryNumber(r, "after", 8, 8)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.do(w, func(c *hachi.Chip8) (interface{}, error) {
+		at := int(c.PC)
+		if addr < 1<<16 {
+			at = int(addr)
+		}
+		start := at - 2*int(before)
+		for start < 0 {
+			start += 2
+		}
+		end := at + 2*int(after) + 2
+		for end > len(c.Memory) {
+			end -= 2
+		}
+		if start >= end {
+			return nil, badRequest{
+				fmt.Errorf("Address %03X is out of memory.", at)}
+		}
+		disassembly, err := hachi.DisassembleSimple(c.Memory[start:end])
+		if err != nil {
+			return nil, err
+		}
+		breakpoints := make(map[uint16]bool)
+		for _, bp := range c.Breakpoints() {
+			breakpoints[bp] = true
+		}
+		lines := make([]Line, len(disassembly))
+		for i, in := range disassembly {
+			a := uint16(start + 2*i)
+			lines[i] = Line{
+				Address:     a,
+				Opcode:      in.Opcode(),
+				Mnemonic:    in.String(),
+				Description: in.Description(),
+				Breakpoint:  breakpoints[a],
+				Current:     a == c.PC,
+			}
+		}
+		return lines, nil
+	})
+}
+
+// actionHandler returns a handler that runs action and returns the state.
+func (s *Server) actionHandler(
+	action func(c *hachi.Chip8) error) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		s.do(w, func(c *hachi.Chip8) (interface{}, error) {
+			return state(c, action(c)), nil
+		})
+	}
+}
@@ -0,0 +1,116 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+// pixelDecay implements the anti-flicker filter: pixels stay lit for a few
+// frames after being cleared, which hides the flicker of programs that erase
+// and redraw their sprites every frame.
+type pixelDecay struct {
+	frames int
+	// remaining frames each pixel stays lit for
+	left []uint8
+	// pixels that were lit at any point since the last frame, so that sprites
+	// that are drawn and erased within a single frame still show up
+	seen []byte
+	// pixels kept lit by the filter, in the same layout as Screen
+	lit []byte
+	// Screen | lit, returned by DisplayScreen
+	display []byte
+}
+
+func newPixelDecay(frames, screenSize int) *pixelDecay {
+	return &pixelDecay{
+		frames:  frames,
+		left:    make([]uint8, screenSize*8),
+		seen:    make([]byte, screenSize),
+		lit:     make([]byte, screenSize),
+		display: make([]byte, screenSize),
+	}
+}
+
+// draw records the pixels that are currently lit. Called after every DRW.
+func (d *pixelDecay) draw(screen []byte) {
+	for i, b := range screen {
+		d.seen[i] |= b
+	}
+}
+
+// tick advances the filter by one frame.
+// Returns true if the displayed screen changed.
+func (d *pixelDecay) tick(screen []byte) (changed bool) {
+	for i := range screen {
+		on := d.seen[i] | screen[i]
+		var lit byte
+		for bit := 0; bit < 8; bit++ {
+			mask := byte(0x80) >> uint(bit)
+			left := &d.left[i*8+bit]
+			switch {
+			case on&mask != 0:
+				*left = uint8(d.frames)
+			case *left > 0:
+				*left--
+			}
+			if *left > 0 {
+				lit |= mask
+			}
+		}
+		if lit != d.lit[i] {
+			changed = true
+			d.lit[i] = lit
+		}
+		d.seen[i] = screen[i]
+	}
+	return
+}
+
+// cls forgets every pixel, as clearing the screen is intentional.
+func (d *pixelDecay) cls() {
+	for i := range d.left {
+		d.left[i] = 0
+	}
+	for i := range d.seen {
+		d.seen[i] = 0
+		d.lit[i] = 0
+	}
+}
+
+// DisplayScreen returns the screen buffer as it should be shown, in the same
+// layout as Screen but with the size returned by DisplaySize. When the
+// PixelDecay anti-flicker filter is enabled, recently cleared pixels are
+// still lit, and the Transform setting is applied. Without either, this is
+// just Screen.
+// Drivers should render this instead of Screen. The returned buffer is reused
+// by the next call.
+func (c *Chip8) DisplayScreen() []byte {
+	if c.decay == nil {
+		return c.transformScreen(c.Screen)
+	}
+	for i := range c.Screen {
+		c.decay.display[i] = c.Screen[i] | c.decay.lit[i]
+	}
+	return c.transformScreen(c.decay.display)
+}
+
+// pixelOn returns whether the pixel at x, y of Screen is shown as lit, taking
+// the anti-flicker filter into account.
+func (c *Chip8) pixelOn(x, y int) bool {
+	i := y*(int(c.Width)/8) + x/8
+	b := c.Screen[i]
+	if c.decay != nil {
+		b |= c.decay.lit[i]
+	}
+	return b&(0x80>>uint(x%8)) != 0
+}
@@ -32,6 +32,7 @@ type Instruction interface {
 	ASCII() string
 
 	init()
+	raw() *RawData
 }
 
 // RawData holds 1 or 2 bytes of unrecognized raw data
@@ -41,7 +42,8 @@ type RawData struct {
 	s string
 }
 
-func (i RawData) init()          { i.s = fmt.Sprintf("DB % 02X", i.b) }
+func (i *RawData) init()         { i.s = fmt.Sprintf("DB % 02X", i.b) }
+func (i *RawData) raw() *RawData { return i }
 func (i RawData) String() string { return i.s }
 
 // Opcode returns the data as a 16-bit integer. Normally, this function is
@@ -79,6 +81,58 @@ func (i Sys) init() {
 		i.s += " (CLS)"
 	case 0x0EE:
 		i.s += " (RET)"
+	case 0x0FB:
+		i.s += " (SUPER-CHIP: SCR)"
+	case 0x0FC:
+		i.s += " (SUPER-CHIP: SCL)"
+	case 0x0FD:
+		i.s += " (SUPER-CHIP: EXIT)"
+	case 0x0FE:
+		i.s += " (SUPER-CHIP: LOW)"
+	case 0x0FF:
+		i.s += " (SUPER-CHIP: HIGH)"
+	case 0x0C0, 0x0C1, 0x0C2, 0x0C3, 0x0C4, 0x0C5, 0x0C6, 0x0C7,
+		0x0C8, 0x0C9, 0x0CA, 0x0CB, 0x0CC, 0x0CD, 0x0CE, 0x0CF:
+		i.s += fmt.Sprintf(" (SUPER-CHIP: SCD %X)", i.b[1]&0x0F)
+	case 0x010:
+		i.s += " (MegaChip: MEGAOFF)"
+	case 0x011:
+		i.s += " (MegaChip: MEGAON)"
+	case 0x700:
+		i.s += " (MegaChip: STOPSND)"
+	default:
+		i.initMegaChip()
+	}
+}
+
+// initMegaChip annotates the MegaChip calls that take an argument.
+func (i Sys) initMegaChip() {
+	n := i.b[1]
+	switch i.b[0] & 0x0F {
+	case 0x0:
+		if n&0xF0 == 0xB0 {
+			i.s += fmt.Sprintf(" (MegaChip: SCU %X)", n&0x0F)
+		}
+	case 0x1:
+		i.s += fmt.Sprintf(" (MegaChip: LDHI I,%02X....)", n)
+	case 0x2:
+		i.s += fmt.Sprintf(" (MegaChip: LDPAL %02X)", n)
+	case 0x3:
+		i.s += fmt.Sprintf(" (MegaChip: SPRW %02X)", n)
+	case 0x4:
+		i.s += fmt.Sprintf(" (MegaChip: SPRH %02X)", n)
+	case 0x5:
+		i.s += fmt.Sprintf(" (MegaChip: ALPHA %02X)", n)
+	case 0x6:
+		if n&0xF0 == 0 {
+			i.s += fmt.Sprintf(" (MegaChip: DIGISND %X)", n)
+		}
+	case 0x8:
+		if n&0xF0 == 0 {
+			i.s += fmt.Sprintf(" (MegaChip: BMODE %X)", n)
+		}
+	case 0x9:
+		i.s += fmt.Sprintf(" (MegaChip: CCOL %02X)", n)
 	}
 }
 func (i Sys) Address() uint16 { return i.Opcode() }
@@ -474,12 +528,147 @@ func (i LdMemory) Description() string {
 	return "FX65: Fills V0 to VX with values from memory starting at address I."
 }
 
+//
+
+type LdBigFont struct{ *RawData }
+
+func (i LdBigFont) init() {
+	i.s = fmt.Sprintf("LD HF,V%1X", i.Register())
+}
+func (i LdBigFont) Register() uint8 { return i.b[0] & 0x0F }
+func (i LdBigFont) Description() string {
+	return "FX30: Sets I to the location of the big sprite for the digit in " +
+		"VX (SUPER-CHIP)."
+}
+
+//
+
+type LdSetFlags struct{ *RawData }
+
+func (i LdSetFlags) init() {
+	i.s = fmt.Sprintf("LD R,V%1X", i.Register())
+}
+func (i LdSetFlags) Register() uint8 { return i.b[0] & 0x0F }
+func (i LdSetFlags) Description() string {
+	return "FX75: Stores V0 to VX in the RPL user flags (SUPER-CHIP)."
+}
+
+//
+
+type LdFlags struct{ *RawData }
+
+func (i LdFlags) init() {
+	i.s = fmt.Sprintf("LD V%1X,R", i.Register())
+}
+func (i LdFlags) Register() uint8 { return i.b[0] & 0x0F }
+func (i LdFlags) Description() string {
+	return "FX85: Fills V0 to VX with the RPL user flags (SUPER-CHIP)."
+}
+
 // -----------------------------------------------------------------------------
 
+// decode decodes a single 2-byte opcode into an initialized Instruction.
+// Unrecognized opcodes are returned as RawData.
+func decode(opcode []byte) Instruction {
+	rd := &RawData{b: opcode}
+	in := Instruction(rd)
+
+	switch opcode[0] & 0xF0 {
+	case 0x00:
+		in = Sys{rd}
+	case 0x10:
+		in = Jp{rd}
+	case 0x20:
+		in = Call{rd}
+	case 0x30:
+		in = Se{rd}
+	case 0x40:
+		in = Sne{rd}
+	case 0x50:
+		if opcode[1]&0x0F == 0 {
+			in = SeRegister{rd}
+		}
+	case 0x60:
+		in = Ld{rd}
+	case 0x70:
+		in = Add{rd}
+	case 0x80:
+		switch opcode[1] & 0x0F {
+		case 0x0:
+			in = LdRegister{rd}
+		case 0x1:
+			in = Or{rd}
+		case 0x2:
+			in = And{rd}
+		case 0x3:
+			in = Xor{rd}
+		case 0x4:
+			in = AddRegister{rd}
+		case 0x5:
+			in = SubRegister{rd}
+		case 0x6:
+			in = Shr{rd}
+		case 0x7:
+			in = Subn{rd}
+		case 0xE:
+			in = Shl{rd}
+		}
+	case 0x90:
+		if opcode[1]&0x0F == 0 {
+			in = SneRegister{rd}
+		}
+	case 0xA0:
+		in = LdI{rd}
+	case 0xB0:
+		in = JpV0{rd}
+	case 0xC0:
+		in = Rnd{rd}
+	case 0xD0:
+		in = Drw{rd}
+	case 0xE0:
+		switch opcode[1] {
+		case 0x9E:
+			in = Skp{rd}
+		case 0xA1:
+			in = Sknp{rd}
+		}
+	case 0xF0:
+		switch opcode[1] {
+		case 0x07:
+			in = LdDelayTimer{rd}
+		case 0x0A:
+			in = LdKeyboard{rd}
+		case 0x15:
+			in = LdSetDelayTimer{rd}
+		case 0x18:
+			in = LdSetSoundTimer{rd}
+		case 0x1E:
+			in = AddI{rd}
+		case 0x29:
+			in = LdFont{rd}
+		case 0x30:
+			in = LdBigFont{rd}
+		case 0x33:
+			in = LdBcd{rd}
+		case 0x55:
+			in = LdSetMemory{rd}
+		case 0x65:
+			in = LdMemory{rd}
+		case 0x75:
+			in = LdSetFlags{rd}
+		case 0x85:
+			in = LdFlags{rd}
+		}
+	}
+
+	in.init()
+	return in
+}
+
 // DisassembleSimple disassembles raw data and return an array of instructions.
 // It's fast but it cannot handle odd-aligned opcodes or recognize raw data
-// memory regions. For proper disassembly, see Disassemble [to be implemented]
-// which runs the program and analyzes it.
+// memory regions. For proper disassembly, see Disassemble, which analyzes the
+// program's control flow.
 func DisassembleSimple(b []byte) (res []Instruction, err error) {
 	if len(b)%2 != 0 {
 		err = fmt.Errorf("Odd-aligned opcodes are not supported. Please use " +
@@ -488,92 +677,105 @@ func DisassembleSimple(b []byte) (res []Instruction, err error) {
 	}
 
 	for i := 0; i < len(b); i += 2 {
-		opcode := b[i : i+2]
-
-		rd := &RawData{b: opcode}
-		in := Instruction(rd)
-
-		switch opcode[0] & 0xF0 {
-		case 0x00:
-			in = Sys{rd}
-		case 0x10:
-			in = Jp{rd}
-		case 0x20:
-			in = Call{rd}
-		case 0x30:
-			in = Se{rd}
-		case 0x40:
-			in = Sne{rd}
-		case 0x50:
-			in = SeRegister{rd}
-		case 0x60:
-			in = Ld{rd}
-		case 0x70:
-			in = Add{rd}
-		case 0x80:
-			switch opcode[1] & 0x0F {
-			case 0x0:
-				in = LdRegister{rd}
-			case 0x1:
-				in = Or{rd}
-			case 0x2:
-				in = And{rd}
-			case 0x3:
-				in = Xor{rd}
-			case 0x4:
-				in = AddRegister{rd}
-			case 0x5:
-				in = SubRegister{rd}
-			case 0x6:
-				in = Shr{rd}
-			case 0x7:
-				in = Subn{rd}
-			case 0xE:
-				in = Shl{rd}
+		res = append(res, decode(b[i:i+2]))
+	}
+
+	return
+}
+
+// Disassemble disassembles a program loaded at start by following its control
+// flow from start: jumps, calls, skips and returns are followed to find every
+// reachable instruction, even at odd addresses, and the remaining bytes are
+// returned as RawData. JP V0,NNN is assumed to jump somewhere after NNN, so
+// only NNN itself is followed.
+// The instructions cover the whole program in order, so their addresses are
+// start plus the sizes of the previous ones. Code that overlaps other code at
+// an odd offset is lost to the instruction that comes first.
+func Disassemble(program []byte, start uint16) []Instruction {
+	code := make([]bool, len(program))
+	pending := []int{0}
+	for len(pending) != 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for i >= 0 && i+1 < len(program) && !code[i] {
+			opcode := program[i : i+2]
+			next, targets, ok := flow(decode(opcode), int(start)+i)
+			if !ok {
+				// unknown instruction, it's most likely data
+				break
 			}
-		case 0x90:
-			in = Sne{rd}
-		case 0xA0:
-			in = LdI{rd}
-		case 0xB0:
-			in = JpV0{rd}
-		case 0xC0:
-			in = Rnd{rd}
-		case 0xD0:
-			in = Drw{rd}
-		case 0xE0:
-			switch opcode[1] {
-			case 0x9E:
-				in = Skp{rd}
-			case 0xA1:
-				in = Sknp{rd}
+			code[i] = true
+			for _, t := range targets {
+				pending = append(pending, t-int(start))
 			}
-		case 0xF0:
-			switch opcode[1] {
-			case 0x07:
-				in = LdDelayTimer{rd}
-			case 0x0A:
-				in = LdKeyboard{rd}
-			case 0x15:
-				in = LdSetDelayTimer{rd}
-			case 0x18:
-				in = LdSetSoundTimer{rd}
-			case 0x1E:
-				in = AddI{rd}
-			case 0x29:
-				in = LdFont{rd}
-			case 0x33:
-				in = LdBcd{rd}
-			case 0x55:
-				in = LdSetMemory{rd}
-			case 0x65:
-				in = LdMemory{rd}
+			if next < 0 {
+				break
 			}
+			i = next - int(start)
 		}
-
-		in.init()
-		res = append(res, in)
 	}
+	return decodeCode(program, code)
+}
+
+// DisassembleCovered disassembles a program loaded at start like Disassemble,
+// except that only the instructions that were executed according to cov
+// (see EnableCoverage) are decoded and the rest is returned as RawData, as
+// it's probably data. Code that didn't run during the session, such as a game
+// over screen that was never reached, is returned as data too.
+func DisassembleCovered(program []byte, start uint16,
+	cov CoverageMap) []Instruction {
 
+	code := make([]bool, len(program))
+	for i := 0; i+1 < len(code); i++ {
+		code[i] = cov.Executed(uint16(int(start) + i))
+	}
+	return decodeCode(program, code)
+}
+
+// decodeCode decodes the instructions of a program at the offsets marked in
+// code and returns the other bytes as RawData.
+func decodeCode(program []byte, code []bool) (res []Instruction) {
+	for i := 0; i < len(program); {
+		switch {
+		case code[i]:
+			res = append(res, decode(program[i:i+2]))
+		case i+1 < len(program) && !code[i+1]:
+			rd := &RawData{b: program[i : i+2]}
+			rd.init()
+			res = append(res, rd)
+		default:
+			rd := &RawData{b: program[i : i+1]}
+			rd.init()
+			res = append(res, rd)
+			i++
+			continue
+		}
+		i += 2
+	}
 	return
 }
+
+// flow returns the address of the instruction that runs after in at addr, or
+// -1 if execution doesn't fall through, and any other addresses it can go to.
+// Returns false for unknown instructions.
+func flow(in Instruction, addr int) (next int, targets []int, ok bool) {
+	next = addr + 2
+	switch in := in.(type) {
+	case Sys:
+		switch in.Address() {
+		case 0x0EE, 0x0FD: // RET, SUPER-CHIP EXIT
+			next = -1
+		}
+	case Jp:
+		next, targets = -1, []int{int(in.Address())}
+	case JpV0:
+		next, targets = -1, []int{int(in.Address())}
+	case Call:
+		targets = []int{int(in.Address())}
+	case Se, Sne, SeRegister, SneRegister, Skp, Sknp:
+		targets = []int{addr + 4}
+	case *RawData:
+		return -1, nil, false
+	}
+	return next, targets, true
+}
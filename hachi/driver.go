@@ -29,9 +29,15 @@ type Driver interface {
 	// Clears the screen.
 	Cls()
 	// Called on every clock cycle, should be used for input polling and similar
-	// tasks.
+	// tasks. Drivers that receive input on a goroutine of their own should
+	// report it with Chip8.KeyDown and Chip8.KeyUp instead of writing
+	// Keyboard directly.
 	OnUpdate(c *Chip8)
-	// Called when the program modifies the screen buffer.
+	// Called when the program modifies the screen buffer. Drivers that can
+	// show colors should draw pixels with Chip8.PixelColor and
+	// Chip8.BackgroundColor, which follow the Palette setting (see
+	// Chip8.DisplayPalette). The screen is also updated when the palette
+	// changes at runtime.
 	UpdateScreen(c *Chip8)
 	// Plays a beeping sound (this will be called every 1/60th of a second)
 	Beep()
@@ -43,6 +49,60 @@ type Driver interface {
 	SetData(key string, value interface{}) error
 }
 
+// A ShutdownDriver is a Driver that needs to clean up, for example to restore
+// the terminal, when the emulator is shut down. Drivers opt in by implementing
+// OnShutdown on top of Driver.
+type ShutdownDriver interface {
+	Driver
+	// Called by Chip8.Shutdown.
+	OnShutdown(c *Chip8)
+}
+
+// A HaltDriver is a Driver that wants to know when the emulator stops because
+// of an error, for example to show an error screen instead of letting the
+// front-end print over a corrupted display.
+type HaltDriver interface {
+	Driver
+	// Called once, the first time Tick, Frame or AdvanceFrame fail.
+	// err is the error that halted the emulator.
+	OnHalt(c *Chip8, err error)
+}
+
+// A SoundDriver is a Driver that wants to know when the tone starts and stops
+// instead of (or on top of) sampling Beep every 1/60th of a second, for
+// example to record or stream audio that matches the program exactly.
+type SoundDriver interface {
+	Driver
+	// Called when the program sets the sound timer to a non-zero value.
+	// The tone will play for the given amount of frames (1/60th of a
+	// second), unless it's stopped or restarted earlier. This is also called
+	// when the timer is set again while the tone is already playing.
+	OnSoundStart(c *Chip8, frames int)
+	// Called when the sound timer runs out or is set to zero.
+	OnSoundStop(c *Chip8)
+}
+
+// A ColorDriver is a Driver that can show the MegaChip color mode. Other
+// drivers only get the monochrome SUPER-CHIP screen, which MegaChip programs
+// stop drawing to once they switch to color.
+type ColorDriver interface {
+	Driver
+	// Called when a MegaChip program shows a new frame. See
+	// Chip8.ColorScreen.
+	UpdateColorScreen(c *Chip8)
+}
+
+// A SampleDriver is a Driver that can play the digitised sound of MegaChip
+// programs.
+type SampleDriver interface {
+	Driver
+	// Called when the program starts playing s, which replaces the sample
+	// that was playing, if any.
+	OnSampleStart(c *Chip8, s *Sample)
+	// Called when the program stops the sample or the machine is reset.
+	OnSampleStop(c *Chip8)
+}
+
 // -----------------------------------------------------------------------------
 
 var drivers map[string]Driver
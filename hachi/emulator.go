@@ -18,11 +18,14 @@
 package hachi
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"os"
 	"reflect"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -38,7 +41,8 @@ type OutOfMemoryErr struct {
 
 func (e *OutOfMemoryErr) Error() string {
 	return fmt.Sprintf("Not enough memory (program size: %v, free memory: %v)",
-		e.ProgramSize, len(e.Instance.Memory)-0x200)
+		e.ProgramSize,
+		len(e.Instance.Memory)-int(e.Instance.StartAddress()))
 }
 
 // An StackOverflowErr is returned when the stack pointer exceeds the stack.
@@ -62,35 +66,207 @@ func (e *OverflowErr) Error() string {
 	return "Overflow."
 }
 
+// A KeyWaitTimeoutErr is returned when LD VX,K waited longer than
+// KeyWaitTimeout for a key press, or when the wait was cancelled through
+// CancelKeyWait.
+type KeyWaitTimeoutErr struct {
+	// Register is the register that was waiting for the key.
+	Register uint8
+	// Waited is how much emulated time the program waited for.
+	Waited time.Duration
+	// Cancelled is true if the wait was cancelled by CancelKeyWait.
+	Cancelled bool
+}
+
+func (e *KeyWaitTimeoutErr) Error() string {
+	if e.Cancelled {
+		return fmt.Sprintf("LD V%X,K was cancelled after waiting %v for a "+
+			"key.", e.Register, e.Waited)
+	}
+	return fmt.Sprintf("LD V%X,K timed out after waiting %v for a key.",
+		e.Register, e.Waited)
+}
+
 // A AccessErr is returned when the program tries to access invalid or protected
 // memory regions.
-type AccessErr struct{}
+type AccessErr struct {
+	// Instruction that failed.
+	Instruction string
+	// Address and Size of the access.
+	Address uint16
+	Size    int
+	// Policy is the out of bounds policy that was in effect.
+	Policy BoundsPolicy
+}
 
 func (e *AccessErr) Error() string {
-	return "Tried to access invalid or protected memory."
+	return fmt.Sprintf("%s tried to access invalid or protected memory at "+
+		"0x%03X-0x%03X (out of bounds policy: %v).", e.Instruction,
+		e.Address, int(e.Address)+e.Size-1, e.Policy)
 }
 
 // -----------------------------------------------------------------------------
 
 // Chip8Settings holds the configuration parameters for a Chip8 instance.
+// Zero-valued numeric fields fall back to the values in DefaultSettings.
 type Chip8Settings struct {
-	// Memory size. Max. 0xFFFF (65535).
+	// Memory size. Min. 0x202, max. 0xFFFF (65535).
+	// In realistic mode, this must be at least 0x1000.
 	MemorySize uint16
-	// Stack size. Defines the maximum amount of nested calls.
+	// Stack size. Defines the maximum amount of nested calls. Min. 1.
 	StackSize int
-	// Screen width and height in pixels. Max. 255x255.
+	// Screen width and height in pixels. Min. 8x16, max. 248x248.
+	// Both must be multiples of 8.
 	Width, Height uint8
 	// Realistic, when enabled, makes the stack and screen buffers use the
 	// same memory regions as the original implementation. This limits the
-	// stack to max. 12 levels and the screen buffer to max. 2048 pixels.
+	// stack to max. 12 levels and the screen buffer to the variant's native
+	// resolution (2048 pixels, or 4096 for hires).
 	Realistic bool
-	// Enables old behaviour for SHL VX,VY , SHR VX,VY , LD [I],VX and LD VX,[I]
-	LegacyMode bool
+	// Quirks picks how the instructions that differ between interpreters
+	// behave. The default is the modern behaviour.
+	Quirks Quirks
+	// Logger receives all of the emulator's log output. If nil, logging is
+	// disabled.
+	Logger *log.Logger
+	// KeyLayout is the name of the host key layout drivers should use (see
+	// KeyLayouts). If empty, drivers use their own default bindings.
+	KeyLayout string
+	// Palette is the name of one of the Palettes or a list of colors in the
+	// ParsePalette format that monochrome screens are drawn with. If empty,
+	// they are white on black. CHIP-8X and MegaChip programs pick their own
+	// colors.
+	Palette string
+	// MaxFPS limits how many times per second the driver is notified of
+	// screen changes. Updates in between are coalesced and only the final
+	// result is pushed, which is useful for slow displays. 0 means no limit.
+	MaxFPS int
+	// PixelDecay enables the anti-flicker filter, which keeps pixels lit for
+	// this many frames after they are cleared. This only changes what the
+	// drivers show (see DisplayScreen), not what the program sees.
+	// 0 disables the filter.
+	PixelDecay int
+	// FixedTimestep, when enabled, makes the emulator never read the wall
+	// clock. The front-end drives it by calling AdvanceFrame once every
+	// 1/60th of a second, which runs CyclesPerFrame instructions and ticks
+	// the timers once. This makes execution deterministic, which is needed
+	// for replays, netplay and headless testing.
+	FixedTimestep bool
+	// CyclesPerFrame is the amount of instructions executed by each call to
+	// Frame or AdvanceFrame. Min. 1.
+	CyclesPerFrame int
+	// CyclesPerSecond, when non-zero, paces execution at this many
+	// instructions per second of emulated time instead: Tick and Run wait
+	// for the wall clock to catch up, Frame runs as many instructions as
+	// fit in 1/60th of a second, so programs that rely on instruction
+	// pacing run at the intended speed regardless of how often the
+	// front-end calls in. CyclesPerFrame is ignored. VIPTiming takes
+	// precedence.
+	CyclesPerSecond int
+	// DisplayWait enables Quirks.DisplayWait.
+	//
+	// Deprecated: it predates Quirks and is only kept so that existing
+	// settings keep working. Use Quirks.DisplayWait instead.
+	DisplayWait bool
+	// OutOfBounds decides what happens when memory instructions access
+	// memory out of bounds. The default is to halt with an error.
+	OutOfBounds BoundsPolicy
+	// KeyWaitTimeout makes LD VX,K halt the emulator with a
+	// KeyWaitTimeoutErr if no key is pressed within this much emulated time
+	// (as counted by the timers), so headless runs of interactive programs
+	// don't hang forever. 0 waits forever.
+	KeyWaitTimeout time.Duration
+	// RomLookup, if set, looks up loaded programs in a ROM database so
+	// drivers get their metadata (see LoadDriver).
+	RomLookup RomLookupFunc
+	// KeyOrder decides which key LD VX,K returns when several keys are
+	// pressed at once. The default is the lowest key number.
+	KeyOrder KeyOrder
+	// Random picks how RND VX,NN generates its numbers. The default uses
+	// math/rand.
+	Random RandomSource
+	// RandomSeed is the initial seed of the RandomVIP generator.
+	RandomSeed uint16
+	// Rand, if set, replaces math/rand's global source for RandomMath, so
+	// the random stream can be seeded deterministically, or recorded and
+	// replayed by wrapping another source. It's not reseeded by Reset.
+	Rand rand.Source
+	// VIPTiming paces execution by how long each instruction takes on the
+	// COSMAC VIP interpreter, instead of running one instruction per Tick
+	// or CyclesPerFrame instructions per Frame, so programs that depend on
+	// the original speed run as intended. Tick waits for the wall clock to
+	// catch up, Frame runs as many instructions as fit in 1/60th of a
+	// second. VIPCost returns what each instruction is charged.
+	VIPTiming bool
+	// StackWarning logs a warning when the stack gets deeper than this many
+	// levels, for example 12 to check that a program developed with a
+	// larger StackSize runs on the original interpreter. The depth reached
+	// is also available through StackStats. 0 disables the warning.
+	StackWarning int
+	// BadCode decides what happens on unknown instructions. The default is
+	// to halt with a BadCodeErr, the other policies log them and keep going,
+	// which makes many slightly corrupted programs playable.
+	BadCode BadCodePolicy
+	// RewindDepth is how many past states are kept for Rewind. 0 disables
+	// rewinding. Each state is a copy of memory and the screen, so 600
+	// states (10 seconds at the default interval) take about 3MB. MegaChip
+	// can't rewind, as each of its states holds 16MB of memory.
+	RewindDepth int
+	// RewindInterval is how many 60hz frames pass between the states kept
+	// for Rewind. 0 takes one state every frame.
+	RewindInterval int
+	// Transform rotates, mirrors and scales the screen shown by the
+	// drivers (see DisplayScreen).
+	Transform Transform
+	// Persistent lists the memory regions that are backed by files and
+	// survive across sessions.
+	Persistent []PersistentRegion
+	// Variant is the CHIP-8 dialect to emulate. Zero-valued Width and Height
+	// default to the variant's native resolution.
+	Variant Variant
+}
+
+// WithDefaults returns a copy of the settings where every zero-valued numeric
+// field is replaced by its value in DefaultSettings, except for Width and
+// Height which are replaced by the variant's native resolution. The
+// deprecated DisplayWait is carried over to Quirks.
+func (s *Chip8Settings) WithDefaults() *Chip8Settings {
+	res := *s
+	if res.MemorySize == 0 {
+		res.MemorySize = DefaultSettings.MemorySize
+	}
+	if res.StackSize == 0 {
+		res.StackSize = DefaultSettings.StackSize
+	}
+	if res.CyclesPerFrame == 0 {
+		res.CyclesPerFrame = DefaultSettings.CyclesPerFrame
+	}
+	width, height := res.Variant.ScreenSize()
+	if res.Width == 0 {
+		res.Width = width
+	}
+	if res.Height == 0 {
+		res.Height = height
+	}
+	if res.DisplayWait {
+		res.Quirks.DisplayWait = true
+	}
+	return &res
 }
 
-// Validate validates the settings.
+// Validate validates the settings. Zero-valued fields are validated as their
+// defaults (see WithDefaults).
 // Returns an error when the settings aren't valid.
 func (s *Chip8Settings) Validate() error {
+	s = s.WithDefaults()
+
+	if s.MemorySize < 0x202 {
+		return fmt.Errorf("MemorySize must be >= 0x202, got 0x%X.",
+			s.MemorySize)
+	}
+	if s.StackSize < 1 {
+		return fmt.Errorf("StackSize must be >= 1, got %v.", s.StackSize)
+	}
 	if s.Width%8 != 0 {
 		return fmt.Errorf("Width must be a multiple of 8, got %v.", s.Width)
 	}
@@ -98,20 +274,97 @@ func (s *Chip8Settings) Validate() error {
 		return fmt.Errorf("Height must be a multiple of 8, got %v.", s.Height)
 	}
 	if s.Width < 8 {
-		return fmt.Errorf("Height must be >= 8, got %v.", s.Width)
+		return fmt.Errorf("Width must be >= 8, got %v.", s.Width)
+	}
+	if s.Height < 16 {
+		return fmt.Errorf("Height must be >= 16, got %v.", s.Height)
+	}
+	if _, ok := boundsPolicyNames[s.OutOfBounds]; !ok {
+		return fmt.Errorf("Unknown out of bounds policy %v.", s.OutOfBounds)
+	}
+	if _, ok := variantNames[s.Variant]; !ok {
+		return fmt.Errorf("Unknown variant %v.", s.Variant)
+	}
+	if s.Variant.schip() && (s.Width != 128 || s.Height != 64) {
+		return fmt.Errorf("The SUPER-CHIP screen must be 128x64, got %vx%v.",
+			s.Width, s.Height)
+	}
+	if _, ok := randomSourceNames[s.Random]; !ok {
+		return fmt.Errorf("Unknown random source %v.", s.Random)
+	}
+	if _, ok := keyOrderNames[s.KeyOrder]; !ok {
+		return fmt.Errorf("Unknown key order %v.", s.KeyOrder)
+	}
+	if _, ok := badCodePolicyNames[s.BadCode]; !ok {
+		return fmt.Errorf("Unknown bad code policy %v.", s.BadCode)
+	}
+	if err := s.Transform.validate(); err != nil {
+		return err
+	}
+	if s.RewindDepth < 0 {
+		return fmt.Errorf("RewindDepth must be >= 0, got %v.", s.RewindDepth)
+	}
+	if s.RewindDepth > 0 && s.Variant == VariantMegaChip {
+		return fmt.Errorf("RewindDepth can't be used with MegaChip, " +
+			"whose states hold 16MB of memory each.")
+	}
+	if s.RewindInterval < 0 {
+		return fmt.Errorf("RewindInterval must be >= 0, got %v.",
+			s.RewindInterval)
+	}
+	if s.StackWarning < 0 {
+		return fmt.Errorf("StackWarning must be >= 0, got %v.",
+			s.StackWarning)
+	}
+	if s.KeyWaitTimeout < 0 {
+		return fmt.Errorf("KeyWaitTimeout must be >= 0, got %v.",
+			s.KeyWaitTimeout)
+	}
+	if s.MaxFPS < 0 {
+		return fmt.Errorf("MaxFPS must be >= 0, got %v.", s.MaxFPS)
+	}
+	if s.PixelDecay < 0 || s.PixelDecay > 255 {
+		return fmt.Errorf("PixelDecay must be between 0 and 255, got %v.",
+			s.PixelDecay)
+	}
+	if s.CyclesPerFrame < 1 {
+		return fmt.Errorf("CyclesPerFrame must be >= 1, got %v.",
+			s.CyclesPerFrame)
+	}
+	if s.CyclesPerSecond < 0 {
+		return fmt.Errorf("CyclesPerSecond must be >= 0, got %v.",
+			s.CyclesPerSecond)
+	}
+	if s.KeyLayout != "" {
+		if _, err := GetKeyLayout(s.KeyLayout); err != nil {
+			return err
+		}
 	}
-	if s.Height < 15 {
-		return fmt.Errorf("Height must be >= 15, got %v.", s.Height)
+	if s.Palette != "" {
+		if _, err := ParsePalette(s.Palette); err != nil {
+			return err
+		}
+	}
+	for i := range s.Persistent {
+		if err := s.Persistent[i].validate(s.MemorySize); err != nil {
+			return err
+		}
 	}
 	if s.Realistic {
+		if s.MemorySize < 0x1000 {
+			return fmt.Errorf("MemorySize must be >= 0x1000 in realistic "+
+				"mode, got 0x%X.", s.MemorySize)
+		}
 		if s.StackSize > 12 {
 			return fmt.Errorf("StackSize must be <= 12 in realistic mode"+
 				", got %v.", s.StackSize)
 		}
+		width, height := s.Variant.ScreenSize()
+		maxPixels := uint16(width) * uint16(height)
 		pixelCount := uint16(s.Width) * uint16(s.Height)
-		if pixelCount > 2048 {
-			return fmt.Errorf("Width*Height must be <= 2048 in realistic mode"+
-				", got %v.", pixelCount)
+		if pixelCount > maxPixels {
+			return fmt.Errorf("Width*Height must be <= %v in realistic mode"+
+				", got %v.", maxPixels, pixelCount)
 		}
 	}
 
@@ -124,8 +377,9 @@ var DefaultSettings = &Chip8Settings{
 	MemorySize: 0x1000,
 	StackSize:  12,
 	Width:      64, Height: 32,
-	Realistic:  true,
-	LegacyMode: false,
+	Realistic: true,
+	// roughly the speed of the original interpreter
+	CyclesPerFrame: 15,
 }
 
 // -----------------------------------------------------------------------------
@@ -190,7 +444,8 @@ type Chip8 struct {
 	I uint16
 	// The call stack, which holds return addresses.
 	// The original implementation allocated 48bytes for up to 12 nested calls.
-	// In realistic mode, this is at 0xEA0 through 0xEAC in memory.
+	// In realistic mode, this is at 0xEA0 through 0xEB7 in memory (0xDA0
+	// through 0xDB7 for hires).
 	Stack []uint16
 	// The stack pointer. Index of the last value that was pushed on stack.
 	SP int
@@ -210,16 +465,101 @@ type Chip8 struct {
 	// on or off.
 	// Because it's stored as an array of bytes, each element holds 8 pixels and
 	// the screen size must be a multiple of 8.
-	// In realistic mode, this is at 0xF00 through 0xFFF in memory.
+	// In realistic mode, this is at 0xF00 through 0xFFF in memory (0xE00
+	// through 0xFFF for hires).
 	Screen        []byte
 	Width, Height uint8
 	// The interval between each timer tick. The original implementation uses
-	// 60hz = time.Second / 60.
+	// 60hz = time.Second / 60. This is the interval at normal speed, the
+	// actual interval is divided by the time scale (see SetTimeScale).
 	TimerInterval time.Duration
-
-	lastTimerUpdate time.Time
-	driver          string
-	wii             *waitInputInfo
+	// Amount of instructions executed by Frame and AdvanceFrame.
+	CyclesPerFrame int
+	// The minimum interval between screen updates pushed to the driver.
+	// Zero pushes every update immediately.
+	ScreenInterval time.Duration
+	// CHIP-8X only. Colors holds the foreground color (0-7) of each 8x1 block
+	// of pixels, in the same layout as Screen. Background is the background
+	// color (0-3). See Chip8XForeground and Chip8XBackground. Colors is nil
+	// for other variants.
+	Colors     []uint8
+	Background uint8
+	// CHIP-8X only. IOPort holds the last value written to the I/O port by
+	// FXF8, and IOInput is the value read from the I/O port by FXFB.
+	// Drivers can use these to emulate the VP-595 sound board and other
+	// expansion devices.
+	IOPort, IOInput uint8
+	// TraceFunc, if set, is called before every instruction with its
+	// address, its opcode and its disassembly. See Tracer.
+	TraceFunc func(pc uint16, opcode uint16, inst Instruction)
+
+	lastTimerUpdate  time.Time
+	lastScreenUpdate time.Time
+	screenDirty      bool
+	driver           string
+	wii              *waitInputInfo
+	logger           *log.Logger
+	keyLayout        KeyLayout
+	palette          Palette
+	variant          Variant
+	timeScale        float64
+	fixedTimestep    bool
+	quirks           Quirks
+	inFrame          bool
+	drew             bool
+	halted           error
+	opcodeHandlers   []opcodeHandler
+	execCounts       []uint64
+	profiler         *profiler // see EnableProfiler
+	coverage         CoverageMap
+	accesses         *accessCounter // see EnableAccessCounters
+	flicker          *flickerTracker
+	decay            *pixelDecay
+	hle              []hleRoutine
+	bounds           BoundsPolicy
+	keyWaitTimeout   time.Duration
+	romLookup        RomLookupFunc
+	keyOrder         KeyOrder
+	randomSource     RandomSource
+	randomSeed       uint16
+	vipTiming        bool
+	stack            *stackTracker
+	settings         Chip8Settings // see Settings
+	transform        Transform
+	transformed      []byte // DisplayScreen buffer when transformed
+	stackWarning     int
+	badCodePolicy    BadCodePolicy
+	badCodeSeen      map[uint16]bool
+	hires            bool      // SUPER-CHIP 128x64 mode
+	rpl              [8]uint8  // SUPER-CHIP RPL user flags
+	mega             *megaChip // MegaChip state, nil for other variants
+	rewind           *rewindBuffer
+	rng              *rand.Rand // Chip8Settings.Rand
+	program          []byte     // last loaded program, for Reset
+	programPath      string     // where program was loaded from, for SetDriver
+	paused           bool
+	breakpoints      map[uint16]bool
+	breakResume      *BreakpointHit // last reported breakpoint
+	stepCycles       int            // cycles run by Step since the last tick
+	stats            Stats
+	timerTicks       uint64        // for Stats.Uptime
+	ipsCycles        uint64        // Cycles when the IPS second began
+	lastCost         time.Duration // time taken by the last instruction
+	cyclesPerSecond  int
+	timingClock      time.Time     // emulated time for Tick with VIPTiming
+	timingBudget     time.Duration // time left in the frame with VIPTiming
+	vblankWait       time.Time     // timer tick Tick waits past, see Quirks
+	lastKeyboard     uint16
+	newKeys          uint16
+	keyPressed       [16]uint64 // press order of each key, see PressKey
+	keySeq, keyPoll  uint64
+	keyMutex         sync.Mutex
+	keyQueue         []keyEvent // see KeyDown
+	keyLatched       uint16     // pressed by KeyDown and not read, see KeyDown
+	keyStale         uint16     // latched for a whole frame
+	keyReleases      uint16     // KeyUps waiting for a latch to go
+	input            *inputLog  // see RecordInput
+	persistent       []*persistentRegion
 
 	pLdMemory, pLdSetMemory func(c *Chip8, x uint8)
 	pShr, pShl              func(c *Chip8, x, y uint8)
@@ -235,12 +575,16 @@ type ldMemoryMap map[bool]func(c *Chip8, x uint8)
 var ldMemory = ldMemoryMap{
 	false: func(c *Chip8, x uint8) {
 		for i := uint8(0); i <= x; i++ {
-			c.V[i] = c.Memory[c.I+uint16(i)]
+			if addr := c.readAddr(int(i), true); addr >= 0 {
+				c.V[i] = c.Memory[addr]
+			}
 		}
 	},
 	true: func(c *Chip8, x uint8) {
 		for i := uint8(0); i <= x; i++ {
-			c.V[i] = c.Memory[c.I]
+			if addr := c.readAddr(0, true); addr >= 0 {
+				c.V[i] = c.Memory[addr]
+			}
 			c.I++
 		}
 	},
@@ -251,12 +595,16 @@ type ldSetMemoryMap map[bool]func(c *Chip8, x uint8)
 var ldSetMemory = ldSetMemoryMap{
 	false: func(c *Chip8, x uint8) {
 		for i := uint8(0); i <= x; i++ {
-			c.Memory[c.I+uint16(i)] = c.V[i]
+			if addr := c.writeAddr(int(i), true); addr >= 0 {
+				c.Memory[addr] = c.V[i]
+			}
 		}
 	},
 	true: func(c *Chip8, x uint8) {
 		for i := uint8(0); i <= x; i++ {
-			c.Memory[c.I] = c.V[i]
+			if addr := c.writeAddr(0, true); addr >= 0 {
+				c.Memory[addr] = c.V[i]
+			}
 			c.I++
 		}
 	},
@@ -266,12 +614,14 @@ type shlMap map[bool]func(c *Chip8, x, y uint8)
 
 var shl = shlMap{
 	false: func(c *Chip8, x, y uint8) {
-		c.V[0xF] = c.V[x] & 0x80 // most significant bit
+		flag := c.V[x] >> 7 // most significant bit
 		c.V[x] <<= 1
+		c.V[0xF] = flag
 	},
 	true: func(c *Chip8, x, y uint8) {
-		c.V[0xF] = c.V[y] & 0x80 // most significant bit
+		flag := c.V[y] >> 7 // most significant bit
 		c.V[x] = c.V[y] << 1
+		c.V[0xF] = flag
 	},
 }
 
@@ -279,92 +629,166 @@ type shrMap map[bool]func(c *Chip8, x, y uint8)
 
 var shr = shrMap{
 	false: func(c *Chip8, x, y uint8) {
-		c.V[0xF] = c.V[x] & 0x01 // least significant bit
+		flag := c.V[x] & 0x01 // least significant bit
 		c.V[x] >>= 1
+		c.V[0xF] = flag
 	},
 	true: func(c *Chip8, x, y uint8) {
-		c.V[0xF] = c.V[y] & 0x01 // least significant bit
+		flag := c.V[y] & 0x01 // least significant bit
 		c.V[x] = c.V[y] >> 1
+		c.V[0xF] = flag
 	},
 }
 
 // -----------------------------------------------------------------------------
 
+// the hex digits sprites, stored at 0x000
+var font = []byte{
+	0xF0, 0x90, 0x90, 0x90, 0xF0,
+	0x20, 0x60, 0x20, 0x20, 0x70,
+	0xF0, 0x10, 0xF0, 0x80, 0xF0,
+	0xF0, 0x10, 0xF0, 0x10, 0xF0,
+	0x90, 0x90, 0xF0, 0x10, 0x10,
+	0xF0, 0x80, 0xF0, 0x10, 0xF0,
+	0xF0, 0x80, 0xF0, 0x90, 0xF0,
+	0xF0, 0x10, 0x20, 0x40, 0x40,
+	0xF0, 0x90, 0xF0, 0x90, 0xF0,
+	0xF0, 0x90, 0xF0, 0x10, 0xF0,
+	0xF0, 0x90, 0xF0, 0x90, 0x90,
+	0xE0, 0x90, 0xE0, 0x90, 0xE0,
+	0xF0, 0x80, 0x80, 0x80, 0xF0,
+	0xE0, 0x90, 0x90, 0x90, 0xE0,
+	0xF0, 0x80, 0xF0, 0x80, 0xF0,
+	0xF0, 0x80, 0xF0, 0x80, 0x80,
+}
+
 // struct used to hold some info when waiting for input
 type waitInputInfo struct {
-	register uint8
-	zeroBits uint16
+	register  uint8
+	zeroBits  uint16
+	ticks     int // timer ticks spent waiting
+	cancelled bool
 }
 
 // New initializes a new instance of Chip8 with the given settings. If settings
-// is nil, DefaultSettings will be used.
+// is nil, DefaultSettings will be used. Zero-valued fields fall back to their
+// defaults.
 // driver is the name of the syscall driver that will be used.
 func New(driver string, s *Chip8Settings) (c *Chip8, err error) {
 	if drivers[driver] == nil {
-		err = fmt.Errorf("Driver %s not found.", c.driver)
+		err = fmt.Errorf("Driver %s not found.", driver)
 		return
 	}
 
 	if s == nil {
 		s = DefaultSettings
 	}
+	s = s.WithDefaults()
 
 	err = s.Validate()
 	if err != nil {
 		return
 	}
 
+	memorySize := int(s.MemorySize)
+	if s.Variant == VariantMegaChip {
+		memorySize = megaMemorySize
+	}
+
+	quirks := s.Variant.quirks(s.Quirks)
 	c = &Chip8{
-		Memory: make([]uint8, s.MemorySize),
+		Memory: make([]uint8, memorySize),
 		Width:  s.Width, Height: s.Height,
-		TimerInterval: time.Second / 60,
-		driver:        driver,
-		SP:            -1,
-		pLdMemory:     ldMemory[s.LegacyMode],
-		pLdSetMemory:  ldSetMemory[s.LegacyMode],
-		pShr:          shr[s.LegacyMode],
-		pShl:          shl[s.LegacyMode],
+		TimerInterval:  time.Second / 60,
+		CyclesPerFrame: s.CyclesPerFrame,
+		fixedTimestep:  s.FixedTimestep,
+		quirks:         quirks,
+		bounds:         s.OutOfBounds,
+		keyWaitTimeout: s.KeyWaitTimeout,
+		romLookup:      s.RomLookup,
+		keyOrder:       s.KeyOrder,
+		randomSource:   s.Random,
+		randomSeed:     s.RandomSeed,
+		rng:            newRand(s.Rand),
+		vipTiming:      s.VIPTiming,
+		stack:          newStackTracker(),
+		stackWarning:   s.StackWarning,
+		badCodePolicy:  s.BadCode,
+		timeScale:      1,
+		driver:         driver,
+		SP:             -1,
+		pLdMemory:      ldMemory[quirks.MemoryIncrement],
+		pLdSetMemory:   ldSetMemory[quirks.MemoryIncrement],
+		pShr:           shr[quirks.ShiftVY],
+		pShl:           shl[quirks.ShiftVY],
+		logger:         s.Logger,
+		keyLayout:      KeyLayouts[s.KeyLayout],
+		palette:        paletteFor(s),
+		settings:       *s,
+		transform:      s.Transform,
+	}
+	c.settings.Persistent = append([]PersistentRegion(nil), s.Persistent...)
+	c.cyclesPerSecond = s.CyclesPerSecond
+
+	c.variant = s.Variant
+	switch s.Variant {
+	case VariantChip8X:
+		c.initChip8X()
+	case VariantSChip:
+		c.initSChip()
+	case VariantMegaChip:
+		c.initSChip()
+		c.initMegaChip()
+	}
+
+	for _, r := range s.Persistent {
+		c.persistent = append(c.persistent, &persistentRegion{
+			PersistentRegion: r})
+	}
+
+	if s.MaxFPS > 0 {
+		c.ScreenInterval = time.Second / time.Duration(s.MaxFPS)
+	}
+
+	if c.logger == nil {
+		c.logger = log.New(io.Discard, "", 0)
 	}
 
 	// init realistic mode
 	if s.Realistic {
 		// ugly slice hack:
 		// make Stack point to an area of memory and interpret it as uint16's
-		stackmem := c.Memory[0xEA0 : 0xEA0+uint16(s.StackSize)]
+		// the screen ends at 0x1000 and is preceded by the stack area
+		screenSize := uint16(s.Width) * uint16(s.Height) / 8
+		width, height := s.Variant.ScreenSize()
+		screenStart := 0x1000 - uint16(width)*uint16(height)/8
+		stackStart := screenStart - 0x60
+		stackmem := c.Memory[stackStart : stackStart+2*uint16(s.StackSize)]
 		header := *(*reflect.SliceHeader)(unsafe.Pointer(&stackmem))
 		cbuint16 := int(unsafe.Sizeof(uint16(0)) / unsafe.Sizeof(byte(0)))
 		header.Len /= cbuint16
 		header.Cap /= cbuint16
 		c.Stack = *(*[]uint16)(unsafe.Pointer(&header))
 
-		c.Screen = c.Memory[0xF00 : 0xF00+uint16(s.Width)*uint16(s.Height)/8]
+		c.Screen = c.Memory[screenStart : screenStart+screenSize]
 	} else {
 		c.Stack = make([]uint16, s.StackSize)
 		c.Screen = make([]uint8, uint16(s.Width)*uint16(s.Height)/8)
 	}
 
+	if s.PixelDecay > 0 {
+		c.decay = newPixelDecay(s.PixelDecay, len(c.Screen))
+	}
+	if s.RewindDepth > 0 {
+		c.rewind = newRewindBuffer(s.RewindDepth, s.RewindInterval)
+	}
+
 	// init fonts
-	copy(c.Memory, []byte{
-		0xF0, 0x90, 0x90, 0x90, 0xF0,
-		0x20, 0x60, 0x20, 0x20, 0x70,
-		0xF0, 0x10, 0xF0, 0x80, 0xF0,
-		0xF0, 0x10, 0xF0, 0x10, 0xF0,
-		0x90, 0x90, 0xF0, 0x10, 0x10,
-		0xF0, 0x80, 0xF0, 0x10, 0xF0,
-		0xF0, 0x80, 0xF0, 0x90, 0xF0,
-		0xF0, 0x10, 0x20, 0x40, 0x40,
-		0xF0, 0x90, 0xF0, 0x90, 0xF0,
-		0xF0, 0x90, 0xF0, 0x10, 0xF0,
-		0xF0, 0x90, 0xF0, 0x90, 0x90,
-		0xE0, 0x90, 0xE0, 0x90, 0xE0,
-		0xF0, 0x80, 0x80, 0x80, 0xF0,
-		0xE0, 0x90, 0x90, 0x90, 0xE0,
-		0xF0, 0x80, 0xF0, 0x80, 0xF0,
-		0xF0, 0x80, 0xF0, 0x80, 0x80,
-	})
+	copy(c.Memory, font)
 
 	drivers[c.driver].OnInit(c)
-	log.Println(c)
+	c.checkColorDriver()
+	c.logger.Println(c)
 	return
 }
 
@@ -373,13 +797,119 @@ func (c *Chip8) String() string {
 	return fmt.Sprintf("Chip8{Memory: %v bytes, Registers: [% 02X] I: %04X, "+
 		"Stack: % 04X, SP: %v, PC: %04X, DT: %02X, ST: %02X, "+
 		"Keyboard: %016b, Screen: %v*%v}",
-		len(c.Memory), c.V, c.I, c.Stack[0:c.SP], c.SP, c.PC, c.DT,
+		len(c.Memory), c.V, c.I, c.Stack[:c.SP+1], c.SP, c.PC, c.DT,
 		c.ST, c.Keyboard, c.Width, c.Height)
 }
 
+// DebugString returns a detailed, multi-line dump of the emulator's state,
+// meant for crash dumps and bug reports. Along with everything String()
+// returns, it includes the disassembled current instruction, the pressed keys,
+// the stack contents with the disassembled return targets, the deepest the
+// stack has been and an ASCII rendering of the screen.
+func (c *Chip8) DebugString() string {
+	var b bytes.Buffer
+
+	fmt.Fprintln(&b, c)
+
+	// current instruction
+	fmt.Fprintf(&b, "PC: %04X", c.PC)
+	if in := c.CurrentInstruction(); in != nil {
+		fmt.Fprintf(&b, " %04X %v", in.Opcode(), in)
+	}
+	fmt.Fprintln(&b)
+
+	// keyboard
+	fmt.Fprint(&b, "Keys:")
+	for i, flag := range KeyFlags {
+		if c.Keyboard&flag != 0 {
+			fmt.Fprintf(&b, " %X", i)
+		}
+	}
+	fmt.Fprintln(&b)
+
+	// stack, from the most recent call
+	fmt.Fprintf(&b, "Stack (max depth %d):\n", c.stack.maxDepth)
+	if c.SP < 0 {
+		fmt.Fprintln(&b, "  empty")
+	}
+	for i := c.SP; i >= 0 && i < len(c.Stack); i-- {
+		fmt.Fprintf(&b, "  %2d: %04X", i, c.Stack[i])
+		if in := c.instructionAt(c.Stack[i]); in != nil {
+			fmt.Fprintf(&b, " %v", in)
+		}
+		fmt.Fprintln(&b)
+	}
+
+	// screen
+	byteWidth := int(c.Width) / 8
+	for y := 0; y < int(c.Height); y++ {
+		for x := 0; x < int(c.Width); x++ {
+			if c.Screen[y*byteWidth+x/8]&(0x80>>uint(x%8)) != 0 {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// CurrentInstruction returns the decoded instruction at PC, which is the next
+// instruction that will be executed. It uses the same types and rendering as
+// the disassembler. Returns nil if PC is out of bounds.
+func (c *Chip8) CurrentInstruction() Instruction { return c.instructionAt(c.PC) }
+
+// instructionAt decodes the instruction at addr. Returns nil if addr is out of
+// bounds.
+func (c *Chip8) instructionAt(addr uint16) Instruction {
+	if int(addr)+2 > len(c.Memory) {
+		return nil
+	}
+	return decode(c.Memory[addr : addr+2])
+}
+
 // Driver returns the name of the syscall driver in use by the emulator.
 func (c *Chip8) Driver() string { return c.driver }
 
+// Variant returns the CHIP-8 dialect being emulated.
+func (c *Chip8) Variant() Variant { return c.variant }
+
+// StartAddress returns the address at which programs are loaded, which
+// depends on the variant.
+func (c *Chip8) StartAddress() uint16 { return c.variant.StartAddress() }
+
+// EntryPoint returns the address at which execution starts after loading a
+// program, which depends on the variant.
+func (c *Chip8) EntryPoint() uint16 { return c.variant.entryPoint() }
+
+// TimeScale returns the current time scale (see SetTimeScale).
+func (c *Chip8) TimeScale() float64 { return c.timeScale }
+
+// SetTimeScale changes the speed of emulated time. 1 is normal speed, 0.5 is
+// half speed and 2 is double speed. Timers tick proportionally faster or
+// slower, and front-ends should scale the amount of instructions they run per
+// frame by TimeScale so that the relative timing stays correct.
+// In fixed timestep mode, the front-end controls the speed by calling
+// AdvanceFrame more or less often instead.
+// Returns an error if factor is not positive.
+func (c *Chip8) SetTimeScale(factor float64) error {
+	if !(factor > 0) {
+		return fmt.Errorf("Time scale must be > 0, got %v.", factor)
+	}
+	c.timeScale = factor
+	return nil
+}
+
+// Logger returns the logger the emulator writes to. Drivers should use this
+// instead of the global logger.
+func (c *Chip8) Logger() *log.Logger { return c.logger }
+
+// KeyLayout returns the host key layout selected in the settings, or nil if
+// the driver should use its default bindings.
+func (c *Chip8) KeyLayout() KeyLayout { return c.keyLayout }
+
 // GetDriverData gets custom data from the currently loaded driver.
 // Returns nil if the driver does not exist or if the data key is not found.
 func (c *Chip8) GetDriverData(key string) interface{} {
@@ -389,6 +919,52 @@ func (c *Chip8) GetDriverData(key string) interface{} {
 	return drivers[c.driver].GetData(key)
 }
 
+// SetDriverData sets custom data on the currently loaded driver.
+func (c *Chip8) SetDriverData(key string, value interface{}) error {
+	if drivers[c.driver] == nil {
+		return fmt.Errorf("Driver %s not found.", c.driver)
+	}
+	return drivers[c.driver].SetData(key, value)
+}
+
+// SetDriver switches to the driver registered under name in the middle of a
+// session, for example to fast-forward through an intro headless and then
+// attach a display. The state of the machine is left alone: the current driver
+// is shut down, then the new one is initialized, told about the loaded
+// program and the tone if one is playing, and shown the current screen.
+// Keys held through the old driver are released.
+func (c *Chip8) SetDriver(name string) error {
+	d := drivers[name]
+	if d == nil {
+		return fmt.Errorf("Driver %s not found.", name)
+	}
+	if name == c.driver {
+		return nil
+	}
+
+	old := drivers[c.driver]
+	if s, ok := old.(SoundDriver); ok && c.ST > 0 {
+		s.OnSoundStop(c)
+	}
+	if s, ok := old.(ShutdownDriver); ok {
+		s.OnShutdown(c)
+	}
+
+	c.driver = name
+	c.ReleaseKey(0xFFFF)
+	d.OnInit(c)
+	c.checkColorDriver()
+	if c.program != nil {
+		c.onLoad(c.programPath, c.program)
+	}
+	if s, ok := d.(SoundDriver); ok && c.ST > 0 {
+		s.OnSoundStart(c, int(c.ST))
+	}
+	d.UpdateScreen(c)
+	c.logger.Println("Switched to driver", name)
+	return nil
+}
+
 // Load opens a CHIP-8 binary file and loads it into memory.
 // Returns the size, in bytes, of the program and an error if any.
 func (c *Chip8) Load(path string) (size int64, err error) {
@@ -403,54 +979,163 @@ func (c *Chip8) Load(path string) (size int64, err error) {
 		return
 	}
 
+	start := c.StartAddress()
 	size = fi.Size()
-	if fi.Size() > int64(len(c.Memory)-0x200) {
+	if fi.Size() > int64(len(c.Memory)-int(start)) {
 		err = &OutOfMemoryErr{c, fi.Size()}
 		return
 	}
 
-	_, err = f.Read(c.Memory[0x200:])
-	c.PC = 0x200
-	log.Printf(`Loaded %v bytes of code from "%s"`, fi.Size(), path)
+	c.clearProgram()
+	n, err := f.Read(c.Memory[start:])
+	if err != nil {
+		return
+	}
+	c.program = append(c.program[:0], c.Memory[start:int(start)+n]...)
+	c.PC = c.EntryPoint()
+	c.logger.Printf(`Loaded %v bytes of code from "%s"`, fi.Size(), path)
+	err = c.loadPersistent()
+	if err != nil {
+		return
+	}
+	c.onLoad(path, c.Memory[start:int(start)+n])
 	return
 }
 
 // LoadRaw loads a byte array as a CHIP-8 binary into memory.
 func (c *Chip8) LoadRaw(program []byte) error {
-	if len(program) > len(c.Memory)-0x200 {
+	start := c.StartAddress()
+	if len(program) > len(c.Memory)-int(start) {
 		return &OutOfMemoryErr{c, int64(len(program))}
 	}
-	copy(c.Memory[0x200:], program)
-	log.Println("Loaded", len(program), "bytes of code")
+	c.clearProgram()
+	copy(c.Memory[start:], program)
+	c.program = append(c.program[:0], program...)
+	c.PC = c.EntryPoint()
+	c.logger.Println("Loaded", len(program), "bytes of code")
+	if err := c.loadPersistent(); err != nil {
+		return err
+	}
+	c.onLoad("", program)
 	return nil
 }
 
+// clearProgram erases the previously loaded program from memory, so that a
+// shorter one doesn't leave parts of it behind.
+func (c *Chip8) clearProgram() {
+	start := int(c.StartAddress())
+	for i := start; i < start+len(c.program) && i < len(c.Memory); i++ {
+		c.Memory[i] = 0
+	}
+}
+
 // Tick runs one CPU cycle, blocking the thread. Returns an error if any.
+// Once the emulator has halted on an error, Tick keeps returning it.
+// While paused, Tick does nothing.
+// When PC reaches a breakpoint, Tick returns a *BreakpointHit without running
+// the instruction, and the next call runs it (see AddBreakpoint).
 func (c *Chip8) Tick() error {
+	if c.halted != nil {
+		return c.halted
+	}
+	if c.paused {
+		return nil
+	}
+	if c.waitingForVBlank() {
+		return nil
+	}
+	paced := c.paced() && !c.fixedTimestep
+	if paced && !c.timingDue() {
+		c.updateTimers()
+		return nil
+	}
+	if hit := c.checkBreakpoint(); hit != nil {
+		return hit
+	}
+	c.drew = false
+	if err := c.step(); err != nil {
+		return c.halt(err)
+	}
+	if paced {
+		c.timingClock = c.timingClock.Add(
+			time.Duration(float64(c.lastCost) / c.timeScale))
+	}
+	if !c.fixedTimestep {
+		c.updateTimers()
+		if c.quirks.DisplayWait && c.drew {
+			c.vblankWait = c.lastTimerUpdate
+		}
+	}
+	return nil
+}
+
+// step polls the driver and executes one instruction, if not waiting for a key.
+func (c *Chip8) step() error {
+	c.lastCost = 0
 	drivers[c.driver].OnUpdate(c)
+	c.applyKeyEvents()
+	c.pollInput()
+	c.trackKeys()
+	c.flushScreen()
 	if c.wii != nil {
 		changed := c.Keyboard & c.wii.zeroBits
 		if changed == 0 {
-			return nil
-		}
-
-		// get first pressed key (in case multiple are pressed0
-		for mask := uint16(0x0001); ; mask <<= 1 {
-			c.V[c.wii.register] = KeyNumbers[changed&mask]
-			if c.V[c.wii.register] != 0 {
-				break
-			}
-			if mask == 0x8000 {
-				break
-			}
+			return c.checkKeyWait()
 		}
 
+		// pick one key in case multiple are pressed
+		c.V[c.wii.register] = c.pickKey(changed)
+		c.keyLatched &^= changed
 		c.wii = nil
 	}
 
+	if int(c.PC)+2 > len(c.Memory) {
+		// jumps and skips can leave PC anywhere, fetching past the end of
+		// memory fails regardless of the bounds policy
+		return &AccessErr{"instruction fetch", c.PC, 2, c.bounds}
+	}
+	if c.execCounts != nil {
+		c.execCounts[c.PC]++
+	}
+	if c.coverage != nil {
+		c.coverage[c.PC] = true
+	}
 	opcode := c.Memory[c.PC : c.PC+2]
+	if c.TraceFunc != nil {
+		// decoded from a copy, as the program can overwrite itself
+		code := []byte{opcode[0], opcode[1]}
+		c.TraceFunc(c.PC, uint16(code[0])<<8|uint16(code[1]), decode(code))
+	}
+	pc, sp := c.PC, c.SP
+	op := uint16(opcode[0])<<8 | uint16(opcode[1])
 	c.PC += 2
+	c.stats.Cycles++
+	if c.vipTiming {
+		c.lastCost = vipCost(opcode)
+	} else if c.cyclesPerSecond > 0 {
+		c.lastCost = time.Second / time.Duration(c.cyclesPerSecond)
+	}
 
+	var start time.Time
+	if c.profiler != nil && op&0xF000 == 0xD000 {
+		start = time.Now()
+	}
+	err := c.execute(opcode)
+	if _, ok := err.(*BadCodeErr); ok {
+		if handled, herr := c.customOpcode(opcode); handled {
+			err = herr
+		} else {
+			err = c.badCode(opcode)
+		}
+	}
+	if c.profiler != nil {
+		c.profile(pc, op, sp, start)
+	}
+	return err
+}
+
+// execute runs a single instruction. PC already points to the next one.
+func (c *Chip8) execute(opcode []byte) error {
 	// this has lots of code redundancy in favor of speed
 
 	switch opcode[0] & 0xF0 {
@@ -461,12 +1146,24 @@ func (c *Chip8) Tick() error {
 		// which are CLS and RET.
 		// todo: write CLS and RET in CHIP-8 assembly and allocate them in
 		//       memory for realism.
-		switch uint16(opcode[0]&0x0F)<<8 | uint16(opcode[1]) {
-		case 0x0E0: // CLS
-			for i := 0; i < len(c.Screen); i++ {
-				c.Screen[i] = 0
+		sys := uint16(opcode[0]&0x0F)<<8 | uint16(opcode[1])
+		if sys == 0x230 && c.variant == VariantHiRes {
+			// hires: clear the 64x64 screen
+			sys = 0x0E0
+		}
+		if c.mega != nil {
+			if handled, err := c.megaSys(sys); handled {
+				return err
+			}
+		}
+		if c.variant.schip() {
+			if handled, err := c.schipSys(sys); handled {
+				return err
 			}
-			drivers[c.driver].Cls()
+		}
+		switch sys {
+		case 0x0E0: // CLS
+			c.clearScreen()
 		case 0x0EE: // RET
 			// pop return address
 			if c.SP < 0 {
@@ -474,19 +1171,37 @@ func (c *Chip8) Tick() error {
 			}
 			c.PC = c.Stack[c.SP]
 			c.SP--
+		case 0x2A0: // CHIP-8X: step background color
+			if c.variant == VariantChip8X {
+				c.Background = (c.Background + 1) % 4
+				c.updateScreen()
+			}
+		default:
+			// unknown syscalls are ignored unless a custom handler takes them
+			if _, err := c.customOpcode(opcode); err != nil {
+				return err
+			}
 		}
 	case 0x10:
 		// JP NNN
 		c.PC = uint16(opcode[0]&0x0F)<<8 | uint16(opcode[1])
 	case 0x20:
 		// CALL NNN
+		target := uint16(opcode[0]&0x0F)<<8 | uint16(opcode[1])
+		if c.hle != nil {
+			replaced, err := c.callHLE(target)
+			if err != nil || replaced {
+				return err
+			}
+		}
 		if c.SP >= len(c.Stack)-1 {
 			return &StackOverflowErr{}
 		}
 		// push return address
 		c.SP++
 		c.Stack[c.SP] = c.PC
-		c.PC = uint16(opcode[0]&0x0F)<<8 | uint16(opcode[1])
+		c.PC = target
+		c.trackCall(target)
 	case 0x30:
 		// SE VX,NN
 		if c.V[opcode[0]&0x0F] == opcode[1] {
@@ -498,6 +1213,11 @@ func (c *Chip8) Tick() error {
 			c.PC += 2
 		}
 	case 0x50:
+		if c.variant == VariantChip8X && opcode[1]&0x0F == 1 {
+			// ADD VX,VY nibble-wise (CHIP-8X)
+			c.chip8XAddNibbles(opcode[0]&0x0F, opcode[1]&0xF0>>4)
+			break
+		}
 		// SE VX,VY
 		if c.V[opcode[0]&0x0F] == c.V[opcode[1]&0xF0>>4] {
 			c.PC += 2
@@ -516,12 +1236,15 @@ func (c *Chip8) Tick() error {
 		case 0x1:
 			// OR VX,VY
 			c.V[opcode[0]&0x0F] |= c.V[opcode[1]&0xF0>>4]
+			c.logicQuirk()
 		case 0x2:
 			// AND VX,VY
 			c.V[opcode[0]&0x0F] &= c.V[opcode[1]&0xF0>>4]
+			c.logicQuirk()
 		case 0x3:
 			// XOR VX,VY
 			c.V[opcode[0]&0x0F] ^= c.V[opcode[1]&0xF0>>4]
+			c.logicQuirk()
 		case 0x4:
 			// ADD VX,VY
 			reg := opcode[0] & 0x0F
@@ -542,13 +1265,13 @@ func (c *Chip8) Tick() error {
 			x := opcode[0] & 0x0F
 			y := opcode[1] & 0xF0 >> 4
 
-			// borrow
+			// borrow, written after the result so VF can be an operand
+			var flag uint8
 			if c.V[x] >= c.V[y] {
-				c.V[0xF] = 1
-			} else {
-				c.V[0xF] = 0
+				flag = 1
 			}
 			c.V[x] -= c.V[y]
+			c.V[0xF] = flag
 
 		case 0x6:
 			// SHR VX,VY (VX = VY >> 1 or VX >>= 1 in newer implementations)
@@ -558,13 +1281,13 @@ func (c *Chip8) Tick() error {
 			x := opcode[0] & 0x0F
 			y := opcode[1] & 0xF0 >> 4
 
-			// borrow
-			if c.V[x] > c.V[y] {
-				c.V[0xF] = 0
-			} else {
-				c.V[0xF] = 1
+			// borrow, written after the result so VF can be an operand
+			var flag uint8
+			if c.V[y] >= c.V[x] {
+				flag = 1
 			}
 			c.V[x] = c.V[y] - c.V[x]
+			c.V[0xF] = flag
 		case 0xE:
 			// SHL VX,VY (VX = VY << 1 or VX <<= 1 in newer implementations)
 			c.pShl(c, opcode[0]&0x0F, opcode[1]&0xF0>>4)
@@ -578,28 +1301,46 @@ func (c *Chip8) Tick() error {
 		}
 	case 0xA0:
 		// LD I,NNN
-		c.I = uint16(opcode[0]&0x0F)<<8 | uint16(opcode[1])
+		c.setAddrI(int(opcode[0]&0x0F)<<8 | int(opcode[1]))
 	case 0xB0:
-		// JP V0,NNN
+		if c.variant == VariantChip8X {
+			// COL VX,VY,N (CHIP-8X)
+			c.chip8XColor(opcode[0]&0x0F, opcode[1]&0xF0>>4, opcode[1]&0x0F)
+			break
+		}
+		// JP V0,NNN (or JP VX,XNN with the JumpVX quirk)
+		reg := uint8(0)
+		if c.quirks.JumpVX {
+			reg = opcode[0] & 0x0F
+		}
 		c.PC = uint16(opcode[0]&0x0F)<<8 | uint16(opcode[1]) +
-			uint16(c.V[0]) - 2
+			uint16(c.V[reg])
 	case 0xC0:
 		// RND VX,NN (VX = rand() & NN)
-		c.V[opcode[0]&0x0F] = uint8(rand.Uint32()) & opcode[1]
+		c.V[opcode[0]&0x0F] = c.random() & opcode[1]
 	case 0xD0:
 		// DRW VX,VY,N
+		c.stats.Draws++
+		if c.MegaChip() {
+			return c.megaDraw(opcode)
+		}
+		if c.variant.schip() {
+			return c.schipDraw(opcode)
+		}
 		x := c.V[opcode[0]&0x0F] % c.Width
 		y := c.V[opcode[1]&0xF0>>4] % c.Height
-		// we have to modulo everything by width and height, that's how
-		// the chip-8 handles drawing.
+		// the starting coordinates always wrap around, the rest of the
+		// sprite wraps too unless the Clip quirk is enabled, in which case
+		// it's cut off at the right and bottom edges.
 
 		rows := opcode[1] & 0x0F
-		if 0xFFFF-c.I < uint16(rows) {
-			return &OverflowErr{}
+		err := c.checkAccess(fmt.Sprintf("DRW V%X,V%X,%X", opcode[0]&0x0F,
+			opcode[1]>>4, rows), int(rows), false)
+		if err != nil {
+			return err
 		}
-
-		if int(c.I)+int(rows)-1 >= len(c.Memory) {
-			return &AccessErr{}
+		if c.flicker != nil {
+			c.flicker.draw(c.PC-2, c.I, x, y, rows)
 		}
 
 		/*
@@ -683,16 +1424,25 @@ func (c *Chip8) Tick() error {
 		*/
 
 		c.V[0xF] = 0
-		sprite := c.Memory[c.I : c.I+uint16(rows)]
+		var sprite [15]byte
+		for row := 0; row < int(rows); row++ {
+			if addr := c.readAddr(row, false); addr >= 0 {
+				sprite[row] = c.Memory[addr]
+			}
+		}
 
 		byteWidth := uint16(c.Width) / 8
 
 		for off := uint8(0); off < rows; off++ {
+			if c.quirks.Clip && off > 0 && y == 0 {
+				break // clipped at the bottom edge
+			}
 			// index in the screen byte array
 			byteColumn := uint16(y) * byteWidth
 			index := byteColumn + uint16(x)/8
 			nextIndex := byteColumn + (uint16(x)/8+1)%byteWidth
-			// make sure we modulo the next X for the wrap-around behaviour
+			// make sure we modulo the next X for the wrap-around behaviour,
+			// the next byte is skipped entirely when clipping
 
 			// start xoring at bitoff bits
 			bitoff := x % 8
@@ -706,7 +1456,8 @@ func (c *Chip8) Tick() error {
 			c.Screen[index] ^= sprite[off] >> bitoff
 
 			var oldval2 byte
-			if bitoff != 0 {
+			clipped := c.quirks.Clip && uint16(x)/8+1 >= byteWidth
+			if bitoff != 0 && !clipped {
 				oldval2 = c.Screen[nextIndex] & mask2
 				c.Screen[nextIndex] ^= sprite[off] << (8 - bitoff)
 			}
@@ -719,7 +1470,7 @@ func (c *Chip8) Tick() error {
 					c.V[0xF] = 1
 					break
 				}
-				if bitoff != 0 &&
+				if bitoff != 0 && !clipped &&
 					oldval2&mask > c.Screen[nextIndex]&mask2&mask {
 					// same as above
 					c.V[0xF] = 1
@@ -733,17 +1484,20 @@ func (c *Chip8) Tick() error {
 			y = (y + 1) % c.Height // don't forget to modulo
 		}
 
-		drivers[c.driver].UpdateScreen(c)
+		if c.decay != nil {
+			c.decay.draw(c.Screen)
+		}
+		c.updateScreen()
 	case 0xE0:
 		switch opcode[1] {
 		case 0x9E:
 			// SKP VX
-			if c.Keyboard&KeyFlags[c.V[opcode[0]&0x0F]] != 0 {
+			if c.readKey(c.V[opcode[0]&0x0F]) {
 				c.PC += 2
 			}
 		case 0xA1:
 			// SKNP VX
-			if c.Keyboard&KeyFlags[c.V[opcode[0]&0x0F]] == 0 {
+			if !c.readKey(c.V[opcode[0]&0x0F]) {
 				c.PC += 2
 			}
 		default:
@@ -757,13 +1511,14 @@ func (c *Chip8) Tick() error {
 		case 0x0A:
 			// LD VX,K
 			// wait for input
-			c.wii = &waitInputInfo{opcode[0] & 0x0F, ^c.Keyboard}
+			c.wii = &waitInputInfo{register: opcode[0] & 0x0F,
+				zeroBits: ^c.Keyboard}
 		case 0x15:
 			// LD DT,VX
 			c.DT = c.V[opcode[0]&0x0F]
 		case 0x18:
 			// LD ST,VX
-			c.ST = c.V[opcode[0]&0x0F]
+			c.setST(c.V[opcode[0]&0x0F])
 		case 0x1E:
 			// ADD I,VX
 			vx := uint16(c.V[opcode[0]&0x0F])
@@ -774,54 +1529,85 @@ func (c *Chip8) Tick() error {
 			} else {
 				//c.V[0xF] = 0
 			}
-			c.I += vx
+			c.setAddrI(c.addrI() + int(vx))
 		case 0x29:
 			// LD LD I,CHAR VX
 			// fonts are stored starting at 0x0000
-			c.I = uint16(c.V[opcode[0]&0x0F]) * 5
+			c.setAddrI(int(c.V[opcode[0]&0x0F]) * 5)
+		case 0x30:
+			// LD HF,VX (SUPER-CHIP)
+			if !c.variant.schip() {
+				return &BadCodeErr{}
+			}
+			c.setAddrI(schipFontStart + int(c.V[opcode[0]&0x0F]&0xF)*10)
+		case 0x75:
+			// LD R,VX (SUPER-CHIP)
+			if !c.variant.schip() {
+				return &BadCodeErr{}
+			}
+			return c.schipFlags(opcode[0]&0x0F, true)
+		case 0x85:
+			// LD VX,R (SUPER-CHIP)
+			if !c.variant.schip() {
+				return &BadCodeErr{}
+			}
+			return c.schipFlags(opcode[0]&0x0F, false)
 		case 0x33:
 			// LD [I],BCD VX
-			if int(c.I)+2 >= len(c.Memory) || c.I < 0x200 {
-				return &AccessErr{}
+			err := c.checkAccess(fmt.Sprintf("LD [I],BCD V%X",
+				opcode[0]&0x0F), 3, true)
+			if err != nil {
+				return err
 			}
 			value := c.V[opcode[0]&0x0F]
-			c.Memory[c.I+2] = value % 10 // ones
-			value /= 10
-			c.Memory[c.I+1] = value % 10 // tens
-			c.Memory[c.I] = value / 10   // hundreds
+			digits := [3]uint8{value / 100, value / 10 % 10, value % 10}
+			for i, digit := range digits {
+				if addr := c.writeAddr(i, true); addr >= 0 {
+					c.Memory[addr] = digit
+				}
+			}
+			c.persistWrite(c.I, c.I+2)
 
 		case 0x55:
 			// LD [I],VX
 			x := opcode[0] & 0x0F
 
-			// check for overflow
-			if 0xFFFF-c.I < uint16(x) {
-				return &OverflowErr{}
-			}
-
 			// check for out of bounds memory
-			if int(c.I)+int(x) >= len(c.Memory) || c.I < 0x200 {
-				return &AccessErr{}
+			err := c.checkAccess(fmt.Sprintf("LD [I],V%X", x), int(x)+1, true)
+			if err != nil {
+				return err
 			}
 
 			// copy memory to V0-VX
+			start := c.I
 			c.pLdSetMemory(c, x)
+			c.chip48Memory(x)
+			c.persistWrite(start, start+uint16(x))
 		case 0x65:
 			// LD VX,[I]
 			x := opcode[0] & 0x0F
 
-			// check for overflow
-			if 0xFFFF-c.I < uint16(x) {
-				return &OverflowErr{}
-			}
-
 			// check for out of bounds memory
-			if int(c.I)+int(x) >= len(c.Memory) || c.I < 0x200 {
-				return &AccessErr{}
+			err := c.checkAccess(fmt.Sprintf("LD V%X,[I]", x), int(x)+1, true)
+			if err != nil {
+				return err
 			}
 
 			// copy memory from V0-VX
 			c.pLdMemory(c, x)
+			c.chip48Memory(x)
+		case 0xF8:
+			// OUT VX (CHIP-8X)
+			if c.variant != VariantChip8X {
+				return &BadCodeErr{}
+			}
+			c.IOPort = c.V[opcode[0]&0x0F]
+		case 0xFB:
+			// IN VX (CHIP-8X)
+			if c.variant != VariantChip8X {
+				return &BadCodeErr{}
+			}
+			c.V[opcode[0]&0x0F] = c.IOInput
 		default:
 			return &BadCodeErr{}
 		}
@@ -829,31 +1615,397 @@ func (c *Chip8) Tick() error {
 		return &BadCodeErr{}
 	}
 
+	return nil
+}
+
+// updateTimers ticks the timers as many times as needed to catch up with the
+// wall clock.
+func (c *Chip8) updateTimers() {
 	now := time.Now()
 
 	if c.lastTimerUpdate.IsZero() {
 		c.lastTimerUpdate = now
 	}
 
-	for now.Sub(c.lastTimerUpdate) >= c.TimerInterval {
-		if c.DT > 0 {
-			c.DT--
+	interval := time.Duration(float64(c.TimerInterval) / c.timeScale)
+	for now.Sub(c.lastTimerUpdate) >= interval {
+		c.tickTimers()
+		c.lastTimerUpdate = c.lastTimerUpdate.Add(interval)
+	}
+}
+
+// tickTimers decrements the timers once, beeping if the sound timer is on.
+func (c *Chip8) tickTimers() {
+	c.countTick()
+	if c.rewind != nil {
+		c.rewind.tick(c)
+	}
+	if c.decay != nil && c.decay.tick(c.Screen) {
+		c.updateScreen()
+	}
+	if c.flicker != nil {
+		c.flicker.ticks++
+	}
+	if c.wii != nil {
+		c.wii.ticks++
+	}
+	c.expireKeyLatches()
+	if c.DT > 0 {
+		c.DT--
+	}
+	if c.ST > 0 {
+		c.ST--
+		drivers[c.driver].Beep()
+		if c.ST == 0 {
+			if d, ok := drivers[c.driver].(SoundDriver); ok {
+				d.OnSoundStop(c)
+			}
 		}
-		if c.ST > 0 {
-			c.ST--
-			drivers[c.driver].Beep()
+	}
+}
+
+// setST sets the sound timer and notifies the driver if the tone starts,
+// restarts or stops.
+func (c *Chip8) setST(value uint8) {
+	playing := c.ST > 0
+	c.ST = value
+	if value > 0 && !playing {
+		c.stats.Beeps++
+	}
+	d, ok := drivers[c.driver].(SoundDriver)
+	if !ok {
+		return
+	}
+	if value > 0 {
+		d.OnSoundStart(c, int(value))
+	} else if playing {
+		d.OnSoundStop(c)
+	}
+}
+
+// checkKeyWait returns a KeyWaitTimeoutErr if the current key wait was
+// cancelled or timed out.
+func (c *Chip8) checkKeyWait() error {
+	waited := time.Duration(c.wii.ticks) * c.TimerInterval
+	if c.wii.cancelled ||
+		(c.keyWaitTimeout > 0 && waited >= c.keyWaitTimeout) {
+		return &KeyWaitTimeoutErr{c.wii.register, waited, c.wii.cancelled}
+	}
+	return nil
+}
+
+// WaitingForKey returns true if the program is blocked on LD VX,K.
+func (c *Chip8) WaitingForKey() bool { return c.wii != nil }
+
+// CancelKeyWait makes the pending LD VX,K fail with a KeyWaitTimeoutErr on
+// the next Tick or Frame, which halts the emulator.
+// Returns false if the program isn't waiting for a key.
+func (c *Chip8) CancelKeyWait() bool {
+	if c.wii == nil {
+		return false
+	}
+	c.wii.cancelled = true
+	return true
+}
+
+// halt stops the emulator because of err and notifies the driver.
+// Returns err.
+func (c *Chip8) halt(err error) error {
+	c.halted = err
+	if d, ok := drivers[c.driver].(HaltDriver); ok {
+		d.OnHalt(c, err)
+	}
+	return err
+}
+
+// Halted returns the error that stopped the emulator, or nil if it's running.
+func (c *Chip8) Halted() error { return c.halted }
+
+// Shutdown writes back persistent memory and lets the driver clean up.
+// Front-ends should call this once they are done with the emulator, even if
+// it halted on an error.
+// Returns an error if persistent memory couldn't be written.
+func (c *Chip8) Shutdown() error {
+	err := c.Flush()
+	if d, ok := drivers[c.driver].(ShutdownDriver); ok {
+		d.OnShutdown(c)
+	}
+	return err
+}
+
+// Frame runs one 1/60th of a second worth of emulation and should be called
+// by the front-end at 60hz (scaled by TimeScale). It executes up to
+// CyclesPerFrame instructions (or as many as fit in the frame with
+// VIPTiming or CyclesPerSecond), ticks the timers once and notifies the driver
+// of screen changes at most once, at the end of the frame.
+// The frame ends early when the program starts waiting for a key, as nothing
+// will happen until the input is polled again, and after a draw when
+// the DisplayWait quirk is enabled.
+// While paused, Frame does nothing.
+// When PC reaches a breakpoint, the frame ends right there without ticking
+// the timers and Frame returns a *BreakpointHit. The next call starts a new
+// frame with the instruction at the breakpoint.
+// Returns an error if any.
+func (c *Chip8) Frame() error {
+	if c.halted != nil {
+		return c.halted
+	}
+	if c.paused {
+		return nil
+	}
+
+	c.inFrame = true
+	defer func() { c.inFrame = false }()
+
+	paced := c.paced()
+	if paced {
+		// instructions that overran the previous frame eat into this one,
+		// but time left over by a frame that ended early is lost
+		c.timingBudget += c.TimerInterval
+		if c.timingBudget > c.TimerInterval {
+			c.timingBudget = c.TimerInterval
 		}
-		c.lastTimerUpdate = c.lastTimerUpdate.Add(c.TimerInterval)
 	}
 
+	for i := 0; paced || i < c.CyclesPerFrame; i++ {
+		if paced && c.timingBudget <= 0 {
+			break
+		}
+		if hit := c.checkBreakpoint(); hit != nil {
+			// show what was drawn so far
+			if c.screenDirty {
+				c.screenDirty = false
+				c.stats.Frames++
+				drivers[c.driver].UpdateScreen(c)
+			}
+			return hit
+		}
+		c.drew = false
+		if err := c.step(); err != nil {
+			return c.halt(err)
+		}
+		c.timingBudget -= c.lastCost
+		if c.wii != nil || (c.quirks.DisplayWait && c.drew) {
+			break
+		}
+	}
+	c.tickTimers()
+
+	if c.screenDirty {
+		now := time.Now()
+		if c.fixedTimestep || now.Sub(c.lastScreenUpdate) >= c.ScreenInterval {
+			c.screenDirty = false
+			c.lastScreenUpdate = now
+			c.stats.Frames++
+			drivers[c.driver].UpdateScreen(c)
+		}
+	}
 	return nil
 }
 
+// AdvanceFrame is Frame for emulators created with FixedTimestep, which never
+// read the wall clock. MaxFPS has no effect in this mode, as the screen is
+// pushed at the end of every frame that changed it.
+// Returns an error if any, or if FixedTimestep is disabled.
+func (c *Chip8) AdvanceFrame() error {
+	if !c.fixedTimestep {
+		return fmt.Errorf("AdvanceFrame requires FixedTimestep.")
+	}
+	return c.Frame()
+}
+
+// clearScreen executes CLS.
+func (c *Chip8) clearScreen() {
+	for i := 0; i < len(c.Screen); i++ {
+		c.Screen[i] = 0
+	}
+	drivers[c.driver].Cls()
+	if c.flicker != nil {
+		c.flicker.cls()
+	}
+	if c.decay != nil {
+		c.decay.cls()
+	}
+}
+
+// updateScreen notifies the driver that the screen buffer changed, or marks it
+// as dirty if updates are being coalesced.
+func (c *Chip8) updateScreen() {
+	c.drew = true
+	if c.ScreenInterval <= 0 && !c.inFrame {
+		c.stats.Frames++
+		drivers[c.driver].UpdateScreen(c)
+		return
+	}
+	c.screenDirty = true
+	c.flushScreen()
+}
+
+// flushScreen pushes the pending screen update to the driver if enough time
+// has passed since the last one.
+func (c *Chip8) flushScreen() {
+	if !c.screenDirty || c.fixedTimestep || c.inFrame {
+		// Frame flushes at the end of the frame instead
+		return
+	}
+	now := time.Now()
+	if now.Sub(c.lastScreenUpdate) < c.ScreenInterval {
+		return
+	}
+	c.screenDirty = false
+	c.lastScreenUpdate = now
+	c.stats.Frames++
+	drivers[c.driver].UpdateScreen(c)
+}
+
+// Reset puts the machine back in its power-on state with the last loaded
+// program, which starts over: memory is cleared, the font and the program
+// are restored, the registers, stack, timers and screen are cleared, any key
+// wait is cancelled and PC points to the entry point. A halted or paused
+// emulator runs again after a reset. A different program can be loaded
+// afterwards with Load or LoadRaw. Persistent memory is written back first
+// and loaded again.
+// Returns an error if persistent memory couldn't be written or read, the
+// machine is reset anyway.
+func (c *Chip8) Reset() error {
+	err := c.Flush()
+
+	for i := range c.Memory {
+		c.Memory[i] = 0
+	}
+	copy(c.Memory, font)
+	copy(c.Memory[c.StartAddress():], c.program)
+	if c.program != nil {
+		if lerr := c.loadPersistent(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	c.V = [16]uint8{}
+	c.I = 0
+	for i := range c.Stack {
+		c.Stack[i] = 0
+	}
+	c.SP = -1
+	c.PC = c.EntryPoint()
+	c.DT = 0
+	c.setST(0)
+	c.wii = nil
+	c.halted = nil
+	c.paused = false
+	c.lastTimerUpdate = time.Time{}
+	c.timingBudget = 0
+	c.timingClock = time.Time{}
+	c.vblankWait = time.Time{}
+	c.randomSeed = c.settings.RandomSeed
+	c.stack = newStackTracker()
+	c.badCodeSeen = nil
+	c.stats, c.timerTicks, c.ipsCycles = Stats{}, 0, 0
+	c.breakResume = nil
+	if c.rewind != nil {
+		c.rewind = newRewindBuffer(len(c.rewind.states), c.rewind.interval)
+	}
+	if c.execCounts != nil {
+		c.EnableExecCounters()
+	}
+	if c.profiler != nil {
+		c.EnableProfiler()
+	}
+	if c.coverage != nil {
+		c.EnableCoverage()
+	}
+	if c.accesses != nil {
+		c.EnableAccessCounters()
+	}
+	if c.flicker != nil {
+		c.EnableFlickerAnalysis()
+	}
+	switch c.variant {
+	case VariantChip8X:
+		c.initChip8X()
+	case VariantSChip:
+		c.initSChip()
+	case VariantMegaChip:
+		c.initSChip()
+		c.initMegaChip()
+	}
+
+	for i := range c.Screen {
+		c.Screen[i] = 0
+	}
+	if c.decay != nil {
+		c.decay = newPixelDecay(c.decay.frames, len(c.Screen))
+	}
+	drivers[c.driver].Cls()
+	c.updateScreen()
+	return err
+}
+
 // Run runs the emulator, blocking the thread.
-// Exits and returns an error if any.
+// Exits and returns an error if any, or a *BreakpointHit when PC reaches a
+// breakpoint, in which case calling Run again resumes execution.
+// With VIPTiming or CyclesPerSecond, Run sleeps until the next instruction is
+// due instead of spinning.
 func (c *Chip8) Run() (err error) {
 	for err == nil {
 		err = c.Tick()
+		var next time.Time
+		if c.paced() && !c.fixedTimestep {
+			next = c.timingClock
+		}
+		if !c.vblankWait.IsZero() {
+			next = c.nextTimerTick()
+		}
+		if d := time.Until(next); d > 0 {
+			time.Sleep(d)
+		}
 	}
 	return
 }
+
+// RunFrame runs the emulator by calling Frame at 60hz (scaled by TimeScale),
+// blocking the thread. Unlike Run, the screen is pushed at most once per
+// frame.
+// Exits and returns an error if any, or a *BreakpointHit when PC reaches a
+// breakpoint, in which case calling RunFrame again resumes execution.
+// Returns an error if FixedTimestep is enabled, AdvanceFrame must be called
+// by the front-end instead.
+func (c *Chip8) RunFrame() error {
+	if c.fixedTimestep {
+		return fmt.Errorf("RunFrame can't be used with FixedTimestep.")
+	}
+	next := time.Now()
+	for {
+		if err := c.Frame(); err != nil {
+			return err
+		}
+		next = next.Add(time.Duration(float64(c.TimerInterval) / c.timeScale))
+		d := time.Until(next)
+		if d < -c.TimerInterval {
+			// don't try to catch up after the emulator was stalled
+			next = time.Now()
+		}
+		time.Sleep(d)
+	}
+}
+
+// Pause stops Tick, Frame and AdvanceFrame from running the program until
+// Resume is called. The timers are frozen as well, so DT and ST don't drain
+// while paused. Step, StepOver and StepOut still work, which is how a
+// debugger single-steps a paused program.
+func (c *Chip8) Pause() { c.paused = true }
+
+// Resume continues execution after Pause. The time spent paused doesn't
+// count towards the timers.
+func (c *Chip8) Resume() {
+	if !c.paused {
+		return
+	}
+	c.paused = false
+	if !c.lastTimerUpdate.IsZero() {
+		c.lastTimerUpdate = time.Now()
+	}
+	c.timingClock = time.Time{}
+}
+
+// Paused returns true if the emulator is paused.
+func (c *Chip8) Paused() bool { return c.paused }
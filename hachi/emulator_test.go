@@ -0,0 +1,86 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import "testing"
+
+func TestWithDefaultsZeroSettings(t *testing.T) {
+	s := (&Chip8Settings{}).WithDefaults()
+	if s.MemorySize != 0x1000 {
+		t.Errorf("MemorySize = 0x%X, want 0x1000", s.MemorySize)
+	}
+	if s.StackSize != 12 {
+		t.Errorf("StackSize = %v, want 12", s.StackSize)
+	}
+	if s.CyclesPerFrame != DefaultSettings.CyclesPerFrame {
+		t.Errorf("CyclesPerFrame = %v, want %v", s.CyclesPerFrame,
+			DefaultSettings.CyclesPerFrame)
+	}
+	if s.Width != 64 || s.Height != 32 {
+		t.Errorf("screen = %vx%v, want 64x32", s.Width, s.Height)
+	}
+	if err := s.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestWithDefaultsKeepsExplicitValues(t *testing.T) {
+	s := (&Chip8Settings{
+		MemorySize:     0x2000,
+		StackSize:      16,
+		CyclesPerFrame: 3,
+		Width:          128,
+		Height:         64,
+	}).WithDefaults()
+	if s.MemorySize != 0x2000 || s.StackSize != 16 || s.CyclesPerFrame != 3 ||
+		s.Width != 128 || s.Height != 64 {
+		t.Errorf("WithDefaults() overwrote explicit values: %+v", s)
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Chip8Settings
+		valid    bool
+	}{
+		{"MemorySize 0x201", Chip8Settings{MemorySize: 0x201}, false},
+		{"MemorySize 0x202", Chip8Settings{MemorySize: 0x202}, true},
+		// 0 falls back to the default, so the first invalid value is -1
+		{"StackSize -1", Chip8Settings{StackSize: -1}, false},
+		{"StackSize 1", Chip8Settings{StackSize: 1}, true},
+		{"CyclesPerFrame -1", Chip8Settings{CyclesPerFrame: -1}, false},
+		{"CyclesPerFrame 1", Chip8Settings{CyclesPerFrame: 1}, true},
+		{"Width 7", Chip8Settings{Width: 7}, false},
+		{"Width 8", Chip8Settings{Width: 8}, true},
+		{"Height 15", Chip8Settings{Height: 15}, false},
+		{"Height 8", Chip8Settings{Height: 8}, false},
+		{"Height 16", Chip8Settings{Height: 16}, true},
+		{"8x16", Chip8Settings{Width: 8, Height: 16}, true},
+		{"248x248", Chip8Settings{Width: 248, Height: 248}, true},
+		{"Width 249", Chip8Settings{Width: 249}, false},
+		{"Height 249", Chip8Settings{Height: 249}, false},
+	}
+	for _, test := range tests {
+		err := test.settings.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: Validate() = %v, want nil", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: Validate() = nil, want an error", test.name)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// A FlickerSite is a DRW instruction that erases sprites by drawing them
+// again at the same coordinates, which is the usual cause of flicker in
+// CHIP-8 programs.
+type FlickerSite struct {
+	// Address of the DRW instruction.
+	Address uint16
+	// Draws is the total amount of times the instruction was executed.
+	Draws uint64
+	// Erases is how many of those draws erased a sprite that was on screen.
+	Erases uint64
+	// PerSecond is the average amount of erases per second of emulated time.
+	PerSecond float64
+	// Lifetime is how long, in 60hz frames, the erased sprites stayed on
+	// screen on average. The shorter it is, the more visible the flicker.
+	Lifetime float64
+}
+
+// spriteKey identifies a sprite drawn at a given position.
+type spriteKey struct {
+	i    uint16
+	x, y uint8
+	rows uint8
+}
+
+type flickerTracker struct {
+	// sprites currently on screen and the tick at which they were drawn
+	live  map[spriteKey]uint64
+	sites map[uint16]*FlickerSite
+	// total frames spent on screen by erased sprites, per site
+	lifetimes map[uint16]uint64
+	ticks     uint64
+}
+
+// EnableFlickerAnalysis starts tracking sprites that are drawn and then
+// erased at the same coordinates. Calling it again resets the analysis.
+func (c *Chip8) EnableFlickerAnalysis() {
+	c.flicker = &flickerTracker{
+		live:      make(map[spriteKey]uint64),
+		sites:     make(map[uint16]*FlickerSite),
+		lifetimes: make(map[uint16]uint64),
+	}
+}
+
+// draw records a DRW executed at site.
+func (f *flickerTracker) draw(site, i uint16, x, y, rows uint8) {
+	s := f.sites[site]
+	if s == nil {
+		s = &FlickerSite{Address: site}
+		f.sites[site] = s
+	}
+	s.Draws++
+
+	// drawing the same sprite twice at the same spot xors it away
+	key := spriteKey{i, x, y, rows}
+	if drawn, ok := f.live[key]; ok {
+		s.Erases++
+		f.lifetimes[site] += f.ticks - drawn
+		delete(f.live, key)
+		return
+	}
+	f.live[key] = f.ticks
+}
+
+// cls forgets every sprite, as they were all erased at once.
+func (f *flickerTracker) cls() {
+	f.live = make(map[spriteKey]uint64)
+}
+
+// FlickerReport returns the DRW instructions that erased sprites since
+// EnableFlickerAnalysis was called, from the most to the least frequent.
+// Returns nil if the analysis is disabled.
+func (c *Chip8) FlickerReport() (res []FlickerSite) {
+	if c.flicker == nil {
+		return nil
+	}
+	f := c.flicker
+	for site, s := range f.sites {
+		if s.Erases == 0 {
+			continue
+		}
+		r := *s
+		if f.ticks > 0 {
+			r.PerSecond = float64(r.Erases) * 60 / float64(f.ticks)
+		}
+		r.Lifetime = float64(f.lifetimes[site]) / float64(r.Erases)
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Erases != res[j].Erases {
+			return res[i].Erases > res[j].Erases
+		}
+		return res[i].Address < res[j].Address
+	})
+	return
+}
+
+// WriteFlickerReport writes a flicker report as a table.
+func WriteFlickerReport(w io.Writer, sites []FlickerSite) error {
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, "addr\tdraws\terases\terases/s\tlifetime (frames)\t")
+	for _, s := range sites {
+		fmt.Fprintf(tw, "%04X\t%d\t%d\t%.1f\t%.1f\t\n", s.Address, s.Draws,
+			s.Erases, s.PerSecond, s.Lifetime)
+	}
+	return tw.Flush()
+}
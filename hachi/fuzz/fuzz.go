@@ -0,0 +1,144 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package fuzz runs arbitrary bytes as CHIP-8 programs to harden the
+// interpreter. Programs that halt with an error are expected, panics are
+// bugs: Run turns them into a *PanicError with the state of the machine, so
+// that they can be reported instead of crashing the fuzzer.
+//
+// With go test -fuzz:
+//
+//	func FuzzInterpreter(f *testing.F) {
+//		f.Add([]byte{0x00, 0xE0})
+//		f.Fuzz(func(t *testing.T, data []byte) {
+//			if err, ok := fuzz.Run(data, nil).(*fuzz.PanicError); ok {
+//				t.Fatal(err)
+//			}
+//		})
+//	}
+//
+// With go-fuzz, Fuzz can be used as the entry point directly.
+package fuzz
+
+import (
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	"math/rand"
+	"runtime/debug"
+)
+
+// Options configures Run.
+type Options struct {
+	// Settings to run the program with. FixedTimestep is always enabled and
+	// Rand defaults to a fixed seed, so every run of the same input is the
+	// same. If nil, DefaultSettings are used.
+	Settings *hachi.Chip8Settings
+	// Cycles is how many instructions to run. Defaults to 100000.
+	Cycles uint64
+	// Frames stops programs that spend their time waiting, such as on a
+	// key or on Quirks.DisplayWait. Defaults to Cycles / CyclesPerFrame * 4.
+	Frames int
+}
+
+// A PanicError is a panic of the interpreter.
+type PanicError struct {
+	// Value is what was passed to panic.
+	Value interface{}
+	// Stack is the goroutine's stack trace at the panic.
+	Stack []byte
+	// PC is the PC register at the panic, which points right after the
+	// instruction that panicked unless fetching it did.
+	PC uint16
+	// Opcode is the instruction before PC.
+	Opcode uint16
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Panic at %03X (%04X): %v\n%s", e.PC, e.Opcode,
+		e.Value, e.Stack)
+}
+
+// Run loads program with the null driver and runs it within the instruction
+// budget. While the program waits for a key, a different key is pressed
+// every frame.
+// Returns a *PanicError if the interpreter panicked, the error that halted
+// the program or nil if it ran out of budget.
+func Run(program []byte, opts *Options) (err error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	settings := *hachi.DefaultSettings
+	if opts.Settings != nil {
+		settings = *opts.Settings
+	}
+	settings.FixedTimestep = true
+	if settings.Rand == nil {
+		settings.Rand = rand.NewSource(1)
+	}
+	cycles := opts.Cycles
+	if cycles == 0 {
+		cycles = 100000
+	}
+
+	c, err := hachi.New("null", &settings)
+	if err != nil {
+		return
+	}
+	frames := opts.Frames
+	if frames == 0 {
+		// CyclesPerFrame has its default applied by now
+		frames = int(cycles) / c.CyclesPerFrame * 4
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack(), PC: c.PC,
+				Opcode: opcodeAt(c, c.PC-2)}
+		}
+	}()
+	if err = c.LoadRaw(program); err != nil {
+		return
+	}
+	for i := 0; i < frames && c.Stats().Cycles < cycles; i++ {
+		c.ReleaseKey(0xFFFF)
+		if c.WaitingForKey() {
+			c.PressKey(hachi.KeyFlags[i%len(hachi.KeyFlags)])
+		}
+		if err = c.AdvanceFrame(); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// opcodeAt returns the instruction at addr, or 0 if it's out of memory.
+func opcodeAt(c *hachi.Chip8, addr uint16) uint16 {
+	if int(addr)+1 >= len(c.Memory) {
+		return 0
+	}
+	return uint16(c.Memory[addr])<<8 | uint16(c.Memory[addr+1])
+}
+
+// Fuzz is a go-fuzz entry point. It panics again on interpreter panics, so
+// that go-fuzz reports them as crashes, and returns 1 for programs that ran
+// out of budget without halting, which are the most interesting to mutate.
+func Fuzz(data []byte) int {
+	switch err := Run(data, nil).(type) {
+	case nil:
+		return 1
+	case *PanicError:
+		panic(err.Error())
+	}
+	return 0
+}
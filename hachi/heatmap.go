@@ -0,0 +1,133 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// EnableExecCounters starts counting how many times the instruction at each
+// address is executed, which is what the heatmap listings are built from.
+// Calling it again resets the counters.
+func (c *Chip8) EnableExecCounters() {
+	c.execCounts = make([]uint64, len(c.Memory))
+}
+
+// ExecCount returns how many times the instruction at addr was executed since
+// EnableExecCounters was called. Returns 0 if counters are disabled.
+func (c *Chip8) ExecCount(addr uint16) uint64 {
+	if int(addr) >= len(c.execCounts) {
+		return 0
+	}
+	return c.execCounts[addr]
+}
+
+// A HeatmapLine is an instruction of a heatmap listing.
+type HeatmapLine struct {
+	Address     uint16
+	Instruction Instruction
+	// Count is how many times the instruction was executed.
+	Count uint64
+	// Heat is Count relative to the hottest instruction, from 0 to 1.
+	Heat float64
+}
+
+// Heatmap disassembles size bytes of memory starting at start (usually the
+// loaded program, see StartAddress) and pairs each instruction with its
+// execution count.
+func (c *Chip8) Heatmap(start uint16, size int) (res []HeatmapLine, err error) {
+	end := int(start) + size + size%2 // DisassembleSimple wants even sizes
+	if end > len(c.Memory) {
+		return nil, fmt.Errorf("Heatmap range 0x%X-0x%X is out of memory "+
+			"bounds.", start, end-1)
+	}
+	disassembly, err := DisassembleSimple(c.Memory[start:end])
+	if err != nil {
+		return
+	}
+
+	var max uint64
+	address := start
+	for _, i := range disassembly {
+		line := HeatmapLine{Address: address, Instruction: i,
+			Count: c.ExecCount(address)}
+		if line.Count > max {
+			max = line.Count
+		}
+		res = append(res, line)
+		address += uint16(i.Size())
+	}
+
+	if max > 0 {
+		for i := range res {
+			res[i].Heat = float64(res[i].Count) / float64(max)
+		}
+	}
+	return
+}
+
+// heatmapBarWidth is the width of the bars in text heatmaps.
+const heatmapBarWidth = 20
+
+// WriteHeatmap writes a heatmap as an annotated disassembly listing, with a
+// bar showing how hot each instruction is.
+func WriteHeatmap(w io.Writer, lines []HeatmapLine) error {
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, "addr\topcode\tcount\theat\tpseudo-code\t")
+	for _, l := range lines {
+		bar := strings.Repeat("#", int(l.Heat*heatmapBarWidth+0.5))
+		fmt.Fprintf(tw, "%04X\t%04X\t%d\t%-*s\t%v\t\n", l.Address,
+			l.Instruction.Opcode(), l.Count, heatmapBarWidth, bar,
+			l.Instruction)
+	}
+	return tw.Flush()
+}
+
+// WriteHeatmapHTML writes a heatmap as a standalone HTML page where each
+// instruction's background goes from white (never executed) to red (hottest).
+func WriteHeatmapHTML(w io.Writer, lines []HeatmapLine) error {
+	_, err := io.WriteString(w, `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>go-hachi heatmap</title>
+<style>
+body { font-family: monospace; }
+td { padding: 0 1em; }
+</style></head><body><table>
+<tr><th>addr</th><th>opcode</th><th>count</th><th>pseudo-code</th>`+
+		`<th>description</th></tr>
+`)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		// fade green and blue out as the instruction gets hotter
+		gb := 255 - int(l.Heat*255)
+		_, err = fmt.Fprintf(w, `<tr style="background: rgb(255,%d,%d)">`+
+			"<td>%04X</td><td>%04X</td><td>%d</td><td>%s</td><td>%s</td>"+
+			"</tr>\n", gb, gb, l.Address, l.Instruction.Opcode(), l.Count,
+			html.EscapeString(l.Instruction.String()),
+			html.EscapeString(l.Instruction.Description()))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "</table></body></html>\n")
+	return err
+}
@@ -0,0 +1,76 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// An HLEHandler is a native replacement for a CHIP-8 subroutine. It is called
+// instead of the CALL to the subroutine, with PC already pointing to the
+// instruction after the CALL, and must leave registers, memory and screen
+// as the subroutine would have.
+// Handlers that only observe the machine (for example to extract the score
+// when the score drawing routine is called) return replaced = false, and the
+// subroutine is then called as usual.
+// Returning an error halts the emulator.
+type HLEHandler func(c *Chip8) (replaced bool, err error)
+
+type hleRoutine struct {
+	hash uint64
+	size int
+	fn   HLEHandler
+}
+
+// HLEHash returns the hash used to recognize a subroutine from its code.
+func HLEHash(code []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(code)
+	return h.Sum64()
+}
+
+// RegisterHLE registers fn as the replacement for any subroutine whose first
+// size bytes of code hash to hash (see HLEHash). Matching on the code rather
+// than on an address recognizes well-known routines wherever a program puts
+// them. The code is hashed on every CALL, so self-modifying code is handled
+// correctly.
+// Returns an error if size is not positive.
+func (c *Chip8) RegisterHLE(hash uint64, size int, fn HLEHandler) error {
+	if size <= 0 {
+		return fmt.Errorf("HLE routine size must be > 0, got %v.", size)
+	}
+	c.hle = append(c.hle, hleRoutine{hash, size, fn})
+	return nil
+}
+
+// RegisterHLECode is RegisterHLE for the subroutine made of code.
+func (c *Chip8) RegisterHLECode(code []byte, fn HLEHandler) error {
+	return c.RegisterHLE(HLEHash(code), len(code), fn)
+}
+
+// callHLE runs the first registered routine that matches the code at addr.
+// Returns whether the call was replaced.
+func (c *Chip8) callHLE(addr uint16) (replaced bool, err error) {
+	for _, r := range c.hle {
+		end := int(addr) + r.size
+		if end > len(c.Memory) || HLEHash(c.Memory[addr:end]) != r.hash {
+			continue
+		}
+		return r.fn(c)
+	}
+	return false, nil
+}
@@ -0,0 +1,132 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"image"
+	"image/color"
+)
+
+// A Bitmap is a monochrome image backed by a buffer in the layout of
+// Chip8.Screen: one bit per pixel, 8 pixels per byte, the leftmost one in the
+// most significant bit. It implements draw.Image, so screens can be read and
+// drawn on with the standard library's image packages.
+type Bitmap struct {
+	Pix           []byte
+	Width, Height int
+	// On and Off are the colors of lit and unlit pixels. If nil, white and
+	// black are used.
+	On, Off color.Color
+}
+
+// NewBitmap returns a white on black Bitmap backed by pix, which must hold
+// width*height/8 bytes.
+func NewBitmap(pix []byte, width, height int) *Bitmap {
+	return &Bitmap{Pix: pix, Width: width, Height: height}
+}
+
+func (b *Bitmap) colors() (on, off color.Color) {
+	on, off = b.On, b.Off
+	if on == nil {
+		on = color.White
+	}
+	if off == nil {
+		off = color.Black
+	}
+	return
+}
+
+// ColorModel returns a palette with the Off and On colors, in this order.
+func (b *Bitmap) ColorModel() color.Model {
+	on, off := b.colors()
+	return color.Palette{off, on}
+}
+
+func (b *Bitmap) Bounds() image.Rectangle {
+	return image.Rect(0, 0, b.Width, b.Height)
+}
+
+func (b *Bitmap) At(x, y int) color.Color {
+	on, off := b.colors()
+	if b.Lit(x, y) {
+		return on
+	}
+	return off
+}
+
+// Set lights the pixel at x, y if c is closer to On than to Off, and clears
+// it otherwise.
+func (b *Bitmap) Set(x, y int, c color.Color) {
+	b.SetLit(x, y, b.ColorModel().(color.Palette).Index(c) == 1)
+}
+
+// Lit returns true if the pixel at x, y is lit. Pixels out of bounds are
+// never lit.
+func (b *Bitmap) Lit(x, y int) bool {
+	if !(image.Point{x, y}.In(b.Bounds())) {
+		return false
+	}
+	return b.Pix[y*(b.Width/8)+x/8]&(0x80>>uint(x%8)) != 0
+}
+
+// SetLit lights or clears the pixel at x, y. Pixels out of bounds are
+// ignored.
+func (b *Bitmap) SetLit(x, y int, lit bool) {
+	if !(image.Point{x, y}.In(b.Bounds())) {
+		return
+	}
+	mask := uint8(0x80) >> uint(x%8)
+	if lit {
+		b.Pix[y*(b.Width/8)+x/8] |= mask
+	} else {
+		b.Pix[y*(b.Width/8)+x/8] &^= mask
+	}
+}
+
+// ScreenImage returns the screen buffer as a Bitmap, which shares its memory
+// with Screen. Drawing on it changes what the program sees, but the driver
+// isn't notified until the program updates the screen.
+func (c *Chip8) ScreenImage() *Bitmap {
+	return NewBitmap(c.Screen, int(c.Width), int(c.Height))
+}
+
+// DisplayImage returns the screen as shown by the drivers: DisplayScreen with
+// the variant's colors (see PixelColor), or ColorScreen in the MegaChip
+// color mode. The image reads the emulator's state on every access, so it
+// changes as the program runs.
+func (c *Chip8) DisplayImage() image.Image {
+	if c.MegaChip() {
+		return c.ColorScreen()
+	}
+	return displayImage{c}
+}
+
+// displayImage is the image returned by DisplayImage.
+type displayImage struct{ c *Chip8 }
+
+func (d displayImage) ColorModel() color.Model { return color.RGBAModel }
+
+func (d displayImage) Bounds() image.Rectangle {
+	w, h := d.c.DisplaySize()
+	return image.Rect(0, 0, w, h)
+}
+
+func (d displayImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(d.Bounds())) {
+		return color.RGBA{}
+	}
+	return d.c.PixelColor(x, y)
+}
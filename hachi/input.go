@@ -0,0 +1,240 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// A KeyLayout maps characters typed on the host keyboard to CHIP-8 key flags
+// (Key0...KeyF). Drivers can use it to translate their own input events.
+// Control characters such as '\r' stand for the corresponding special keys
+// (Enter in this case).
+type KeyLayout map[rune]uint16
+
+// KeyLayouts holds the built-in key layouts by name. Custom layouts can be
+// added to it before creating the emulator.
+var KeyLayouts = map[string]KeyLayout{
+	// The de-facto standard layout used by Octo and most modern emulators,
+	// which maps the 4x4 hex keypad to the left side of a QWERTY keyboard:
+	//   1 2 3 4        1 2 3 C
+	//   Q W E R   ->   4 5 6 D
+	//   A S D F        7 8 9 E
+	//   Z X C V        A 0 B F
+	"octo": {
+		'1': Key1, '2': Key2, '3': Key3, '4': KeyC,
+		'q': Key4, 'w': Key5, 'e': Key6, 'r': KeyD,
+		'a': Key7, 's': Key8, 'd': Key9, 'f': KeyE,
+		'z': KeyA, 'x': Key0, 'c': KeyB, 'v': KeyF,
+	},
+	// Digits map to themselves, the numpad operators map to A-F.
+	"numpad": {
+		'0': Key0, '1': Key1, '2': Key2, '3': Key3, '4': Key4,
+		'5': Key5, '6': Key6, '7': Key7, '8': Key8, '9': Key9,
+		'/': KeyA, '*': KeyB, '-': KeyC, '+': KeyD, '.': KeyE, '\r': KeyF,
+	},
+	// Every key maps to the hex digit printed on the original COSMAC VIP
+	// keypad.
+	"cosmac": {
+		'0': Key0, '1': Key1, '2': Key2, '3': Key3, '4': Key4,
+		'5': Key5, '6': Key6, '7': Key7, '8': Key8, '9': Key9,
+		'a': KeyA, 'b': KeyB, 'c': KeyC, 'd': KeyD, 'e': KeyE, 'f': KeyF,
+	},
+}
+
+// GetKeyLayout returns the key layout registered under name.
+func GetKeyLayout(name string) (KeyLayout, error) {
+	l := KeyLayouts[name]
+	if l == nil {
+		return nil, fmt.Errorf("Unknown key layout '%s' (available: %v).",
+			name, KeyLayoutNames())
+	}
+	return l, nil
+}
+
+// KeyLayoutNames returns the sorted names of all the available key layouts.
+func KeyLayoutNames() []string {
+	names := make([]string, 0, len(KeyLayouts))
+	for name := range KeyLayouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// -----------------------------------------------------------------------------
+
+// A KeyOrder decides which key LD VX,K returns when several keys go down
+// while it's waiting.
+type KeyOrder int
+
+const (
+	// KeyOrderLowest picks the lowest key number.
+	KeyOrderLowest KeyOrder = iota
+	// KeyOrderHighest picks the highest key number.
+	KeyOrderHighest
+	// KeyOrderRecent picks the key that was pressed last. Keys reported
+	// through PressKey are ordered by event, keys that show up in Keyboard
+	// between two instructions count as pressed together, lowest first.
+	KeyOrderRecent
+)
+
+var keyOrderNames = map[KeyOrder]string{
+	KeyOrderLowest:  "lowest",
+	KeyOrderHighest: "highest",
+	KeyOrderRecent:  "recent",
+}
+
+func (o KeyOrder) String() string {
+	if name, ok := keyOrderNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("KeyOrder(%d)", int(o))
+}
+
+// ParseKeyOrder returns the key order with the given name (see
+// KeyOrder.String).
+func ParseKeyOrder(name string) (KeyOrder, error) {
+	for o, n := range keyOrderNames {
+		if n == name {
+			return o, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown key order '%s'.", name)
+}
+
+// PressKey sets key (a Key0...KeyF flag, or several of them) in Keyboard.
+// Drivers with key events should prefer it over setting Keyboard directly,
+// so that KeyOrderRecent knows in which order the keys were pressed.
+// Keys that are already held are left alone.
+func (c *Chip8) PressKey(key uint16) {
+	for i, flag := range KeyFlags {
+		if key&flag != 0 && c.Keyboard&flag == 0 {
+			c.keySeq++
+			c.keyPressed[i] = c.keySeq
+		}
+	}
+	c.Keyboard |= key
+}
+
+// ReleaseKey clears key (a Key0...KeyF flag, or several of them) in
+// Keyboard.
+func (c *Chip8) ReleaseKey(key uint16) { c.Keyboard &= ^key }
+
+// A keyEvent is a key press or release queued by KeyDown or KeyUp.
+type keyEvent struct {
+	key  uint16
+	down bool
+}
+
+// KeyDown queues a press of key (a Key0...KeyF flag, or several of them).
+// Unlike PressKey it's safe to call from any goroutine, such as a driver's
+// input handler: queued events are applied in order right after the next
+// OnUpdate, so the emulator only ever touches Keyboard from its own thread.
+// The key stays down until SKP, SKNP or LD VX,K read it, even if KeyUp comes
+// earlier, so taps shorter than the program's polling aren't lost. Keys the
+// program never reads are released after a frame or two.
+func (c *Chip8) KeyDown(key uint16) { c.queueKey(key, true) }
+
+// KeyUp queues a release of key (a Key0...KeyF flag, or several of them).
+// See KeyDown.
+func (c *Chip8) KeyUp(key uint16) { c.queueKey(key, false) }
+
+func (c *Chip8) queueKey(key uint16, down bool) {
+	c.keyMutex.Lock()
+	c.keyQueue = append(c.keyQueue, keyEvent{key, down})
+	c.keyMutex.Unlock()
+}
+
+// applyKeyEvents applies the events queued by KeyDown and KeyUp.
+func (c *Chip8) applyKeyEvents() {
+	c.keyMutex.Lock()
+	queue := c.keyQueue
+	c.keyQueue = nil
+	c.keyMutex.Unlock()
+	for _, ev := range queue {
+		if ev.down {
+			c.PressKey(ev.key)
+			c.keyLatched |= ev.key
+			c.keyStale &^= ev.key
+			c.keyReleases &^= ev.key
+		} else {
+			// releases of keys no instruction has read wait for one
+			c.keyReleases |= ev.key & c.keyLatched
+			c.ReleaseKey(ev.key &^ c.keyLatched)
+		}
+	}
+	if read := c.keyReleases &^ c.keyLatched; read != 0 {
+		c.ReleaseKey(read)
+		c.keyReleases &^= read
+	}
+}
+
+// readKey returns true if key number key is down, for SKP and SKNP, and
+// marks it as read by the program. Only the low nibble of key is used.
+func (c *Chip8) readKey(key uint8) bool {
+	flag := KeyFlags[key&0xF]
+	c.keyLatched &^= flag
+	return c.Keyboard&flag != 0
+}
+
+// expireKeyLatches drops the latches of the keys that stayed unread for a
+// whole frame, called on every timer tick. Their releases are applied with
+// the next key events.
+func (c *Chip8) expireKeyLatches() {
+	c.keyLatched &^= c.keyStale
+	c.keyStale = c.keyLatched
+}
+
+// NewKeys returns the keys that went down since the previous instruction.
+func (c *Chip8) NewKeys() uint16 { return c.newKeys }
+
+// trackKeys updates the set of new keys and the order they were pressed in.
+// Called once per instruction, after the driver polled the input.
+func (c *Chip8) trackKeys() {
+	c.newKeys = c.Keyboard &^ c.lastKeyboard
+	c.stats.KeyEvents += uint64(bits.OnesCount16(c.Keyboard ^ c.lastKeyboard))
+	c.lastKeyboard = c.Keyboard
+	for i, flag := range KeyFlags {
+		// keys pressed through PressKey already have a newer stamp
+		if c.newKeys&flag != 0 && c.keyPressed[i] <= c.keyPoll {
+			c.keySeq++
+			c.keyPressed[i] = c.keySeq
+		}
+	}
+	c.keyPoll = c.keySeq
+}
+
+// pickKey returns the number of the key in keys (a non-zero set of key
+// flags) that LD VX,K should return according to the key order.
+func (c *Chip8) pickKey(keys uint16) uint8 {
+	res := -1
+	for i, flag := range KeyFlags {
+		if keys&flag == 0 {
+			continue
+		}
+		switch {
+		case res < 0,
+			c.keyOrder == KeyOrderHighest,
+			c.keyOrder == KeyOrderRecent &&
+				c.keyPressed[i] > c.keyPressed[res]:
+			res = i
+		}
+	}
+	return uint8(res)
+}
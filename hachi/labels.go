@@ -0,0 +1,96 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import "fmt"
+
+// Labels maps addresses to symbolic names.
+type Labels map[uint16]string
+
+// ApplyLabels gives a name to every address that is the target of JP, CALL,
+// JP V0 or LD I and the start of an instruction in the disassembly of a
+// program loaded at start, such as L_0230 for code and DATA_0400 for data
+// only referenced by LD I. The instructions are changed to use the names, and
+// the String of the instructions at those addresses starts with the label
+// definition ("L_0230: LD V1,0A"), which makes the output valid input for
+// package hachi/asm.
+// Targets outside the program or in the middle of an instruction keep their
+// address. It should only be called once on a disassembly.
+// Returns the names that were given.
+func ApplyLabels(disassembly []Instruction, start uint16) Labels {
+	// where every instruction starts
+	index := map[uint16]Instruction{}
+	addr := start
+	for _, in := range disassembly {
+		index[addr] = in
+		addr += uint16(in.Size())
+	}
+
+	labels := Labels{}
+	for _, in := range disassembly {
+		switch in := in.(type) {
+		case Jp, Call, JpV0:
+			target := in.Opcode() & 0x0FFF
+			if index[target] != nil {
+				labels[target] = fmt.Sprintf("L_%04X", target)
+			}
+		}
+	}
+	for _, in := range disassembly {
+		if in, ok := in.(LdI); ok {
+			target := in.Value()
+			_, code := labels[target]
+			if index[target] != nil && !code {
+				labels[target] = fmt.Sprintf("DATA_%04X", target)
+			}
+		}
+	}
+
+	for _, in := range disassembly {
+		rd := in.raw()
+		switch in := in.(type) {
+		case Jp:
+			if name, ok := labels[in.Address()]; ok {
+				rd.s = "JP " + name
+			}
+		case Call:
+			if name, ok := labels[in.Address()]; ok {
+				rd.s = "CALL " + name
+			}
+		case JpV0:
+			if name, ok := labels[in.Address()]; ok {
+				rd.s = "JP V0," + name
+			}
+		case LdI:
+			if name, ok := labels[in.Value()]; ok {
+				rd.s = "LD I," + name
+			}
+		}
+	}
+	for addr, name := range labels {
+		rd := index[addr].raw()
+		rd.s = name + ": " + rd.s
+	}
+	return labels
+}
+
+// DisassembleLabels is Disassemble followed by ApplyLabels.
+func DisassembleLabels(program []byte, start uint16) ([]Instruction,
+	Labels) {
+
+	res := Disassemble(program, start)
+	return res, ApplyLabels(res, start)
+}
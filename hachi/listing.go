@@ -0,0 +1,167 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// A ListingFormat is an output format for disassembly listings.
+type ListingFormat string
+
+const (
+	// ListingText is a human readable table.
+	ListingText ListingFormat = "text"
+	// ListingCSV is a comma separated table, for spreadsheets and scripts.
+	ListingCSV ListingFormat = "csv"
+	// ListingJSON is a DisassemblyReport, for web tools.
+	ListingJSON ListingFormat = "json"
+	// ListingHTML is a page with cross-references (see
+	// WriteDisassemblyHTML).
+	ListingHTML ListingFormat = "html"
+)
+
+// ListingFormats lists the supported listing formats.
+var ListingFormats = []ListingFormat{ListingText, ListingCSV, ListingJSON,
+	ListingHTML}
+
+// ParseListingFormat returns the listing format with the given name.
+func ParseListingFormat(name string) (ListingFormat, error) {
+	for _, f := range ListingFormats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown listing format '%s'.", name)
+}
+
+// Ext returns the file extension for listings in this format.
+func (f ListingFormat) Ext() string {
+	switch f {
+	case ListingCSV:
+		return ".csv"
+	case ListingJSON:
+		return ".json"
+	case ListingHTML:
+		return ".html"
+	}
+	return ".txt"
+}
+
+// disassembleListing is DisassembleSimple, except that a trailing odd byte is
+// returned as raw data instead of failing.
+func disassembleListing(program []byte) ([]Instruction, error) {
+	even := len(program) &^ 1
+	res, err := DisassembleSimple(program[:even])
+	if err != nil {
+		return nil, err
+	}
+	if even != len(program) {
+		raw := &RawData{b: program[even:]}
+		raw.init()
+		res = append(res, raw)
+	}
+	return res, nil
+}
+
+// WriteListing disassembles a program loaded at start and writes the listing
+// in the given format. HTML listings follow the control flow (see
+// Disassemble), the others decode the program in order.
+func WriteListing(w io.Writer, program []byte, start uint16,
+	format ListingFormat) error {
+
+	if format == ListingHTML {
+		return WriteDisassemblyHTML(w, program, start)
+	}
+
+	disassembly, err := disassembleListing(program)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case ListingText:
+		return writeListingText(w, disassembly, start)
+	case ListingCSV:
+		return writeListingCSV(w, disassembly, start)
+	case ListingJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(DisassemblyReport{start, disassembly})
+	}
+	return fmt.Errorf("Unknown listing format '%s'.", format)
+}
+
+func writeListingText(w io.Writer, disassembly []Instruction,
+	start uint16) error {
+
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 8, 8, 0, '\t', 0)
+	fmt.Fprintln(tw, "addr\topcode\tpseudo-code\tascii\tdescription\t"+
+		"notes\t")
+
+	notes := Annotate(disassembly, start)
+	address := int(start)
+	for n, i := range disassembly {
+		asciitext := ""
+		ascii := i.ASCII()
+		if len(ascii) != 0 {
+			asciitext = fmt.Sprintf("`%s`", ascii)
+		}
+
+		opcodeFormatter := "%04X"
+		if i.Size() == 1 {
+			opcodeFormatter = "%02X"
+		}
+
+		fmt.Fprintf(tw, "%04X\t"+opcodeFormatter+"\t%v\t%s\t%s\t%s\n",
+			address, i.Opcode(), i, asciitext, i.Description(), notes[n])
+
+		address += i.Size()
+	}
+
+	return tw.Flush()
+}
+
+func writeListingCSV(w io.Writer, disassembly []Instruction,
+	start uint16) error {
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"addr", "opcode", "pseudo-code", "ascii",
+		"description", "notes"})
+
+	notes := Annotate(disassembly, start)
+	address := int(start)
+	for n, i := range disassembly {
+		opcodeFormatter := "%04X"
+		if i.Size() == 1 {
+			opcodeFormatter = "%02X"
+		}
+		cw.Write([]string{
+			fmt.Sprintf("%04X", address),
+			fmt.Sprintf(opcodeFormatter, i.Opcode()),
+			i.String(), i.ASCII(), i.Description(), notes[n],
+		})
+		address += i.Size()
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
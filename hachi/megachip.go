@@ -0,0 +1,453 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// size of the MegaChip display
+const (
+	MegaWidth  = 256
+	MegaHeight = 192
+)
+
+// MegaChip addresses are 24-bit
+const megaMemorySize = 0x1000000
+
+// A BlendMode is how MegaChip sprites are mixed with what's already on
+// screen, set by 080N.
+type BlendMode uint8
+
+const (
+	// BlendNormal replaces the pixels.
+	BlendNormal BlendMode = iota
+	// Blend25 mixes in 25% of the sprite.
+	Blend25
+	// Blend50 mixes in 50% of the sprite.
+	Blend50
+	// Blend75 mixes in 75% of the sprite.
+	Blend75
+	// BlendAdd adds the sprite to the screen.
+	BlendAdd
+	// BlendMultiply multiplies the screen by the sprite.
+	BlendMultiply
+)
+
+var blendModeNames = map[BlendMode]string{
+	BlendNormal:   "normal",
+	Blend25:       "25%",
+	Blend50:       "50%",
+	Blend75:       "75%",
+	BlendAdd:      "add",
+	BlendMultiply: "multiply",
+}
+
+func (b BlendMode) String() string {
+	if name, ok := blendModeNames[b]; ok {
+		return name
+	}
+	return fmt.Sprintf("BlendMode(%d)", int(b))
+}
+
+// A Sample is a digitised sound played by a MegaChip program.
+type Sample struct {
+	// Rate is the sample rate in hz.
+	Rate int
+	// Data holds the samples, 8-bit unsigned.
+	Data []byte
+	// Loop is true if the sample repeats until it's stopped.
+	Loop bool
+}
+
+// megaChip is the state of the MegaChip extension.
+type megaChip struct {
+	on            bool  // MegaChip mode, enabled by 0011
+	iHigh         uint8 // bits 16-23 of I
+	palette       [256]color.RGBA
+	width, height int // sprite size
+	alpha         uint8
+	blend         BlendMode
+	collision     uint8 // palette index that sets VF when drawn over
+	index         []uint8
+	back, front   *image.RGBA
+	sample        *Sample
+}
+
+// initMegaChip resets the MegaChip state. Programs start in SUPER-CHIP mode.
+func (c *Chip8) initMegaChip() {
+	if c.mega != nil && c.mega.sample != nil {
+		c.stopSample()
+	}
+	rect := image.Rect(0, 0, MegaWidth, MegaHeight)
+	c.mega = &megaChip{
+		width: 1, height: 1,
+		alpha: 0xFF,
+		index: make([]uint8, MegaWidth*MegaHeight),
+		back:  image.NewRGBA(rect),
+		front: image.NewRGBA(rect),
+	}
+	clearRGBA(c.mega.back)
+	clearRGBA(c.mega.front)
+}
+
+// copyInto copies m to dst, reusing its buffers, and returns dst. A nil dst
+// is allocated. The sample is shared, as it's never modified.
+func (m *megaChip) copyInto(dst *megaChip) *megaChip {
+	if dst == nil {
+		rect := image.Rect(0, 0, MegaWidth, MegaHeight)
+		dst = &megaChip{back: image.NewRGBA(rect), front: image.NewRGBA(rect)}
+	}
+	index, back, front := dst.index, dst.back, dst.front
+	*dst = *m
+	dst.index = append(index[:0], m.index...)
+	copy(back.Pix, m.back.Pix)
+	copy(front.Pix, m.front.Pix)
+	dst.back, dst.front = back, front
+	return dst
+}
+
+// restoreMegaChip puts back the MegaChip state saved in a State, restarting
+// its sample.
+func (c *Chip8) restoreMegaChip(saved *megaChip) {
+	c.stopSample()
+	saved.copyInto(c.mega)
+	if d, ok := drivers[c.driver].(SampleDriver); ok && c.mega.sample != nil {
+		d.OnSampleStart(c, c.mega.sample)
+	}
+}
+
+// MegaChip returns true if a MegaChip program switched to the 256x192 color
+// mode, in which it's shown through ColorScreen instead of Screen.
+func (c *Chip8) MegaChip() bool { return c.mega != nil && c.mega.on }
+
+// ColorScreen returns the MegaChip screen, or nil if not in MegaChip mode.
+// MegaChip programs draw to a hidden buffer which is only shown by CLS, at
+// which point ColorDriver.UpdateColorScreen is called. The image must not be
+// modified or kept past the next CLS.
+func (c *Chip8) ColorScreen() *image.RGBA {
+	if !c.MegaChip() {
+		return nil
+	}
+	return c.mega.front
+}
+
+// Palette returns the MegaChip palette, set by LDPAL. Index 0 is transparent.
+func (c *Chip8) Palette() [256]color.RGBA {
+	if c.mega == nil {
+		return [256]color.RGBA{}
+	}
+	return c.mega.palette
+}
+
+// ColorOutput returns true if the driver can show the MegaChip color mode
+// (see ColorDriver).
+func (c *Chip8) ColorOutput() bool {
+	_, ok := drivers[c.driver].(ColorDriver)
+	return ok
+}
+
+// checkColorDriver warns when a MegaChip program is run with a driver that
+// can't show the color mode.
+func (c *Chip8) checkColorDriver() {
+	if c.mega != nil && !c.ColorOutput() {
+		c.logger.Printf("Driver %s can't show the MegaChip color mode, "+
+			"only the SUPER-CHIP screen will be visible.", c.driver)
+	}
+}
+
+// addrI returns the address in I, which is 24-bit on MegaChip.
+func (c *Chip8) addrI() int {
+	if c.mega != nil {
+		return int(c.mega.iHigh)<<16 | int(c.I)
+	}
+	return int(c.I)
+}
+
+// setAddrI sets I to addr, truncated to 24 bits on MegaChip and 16 bits
+// otherwise.
+func (c *Chip8) setAddrI(addr int) {
+	c.I = uint16(addr)
+	if c.mega != nil {
+		c.mega.iHigh = uint8(addr >> 16)
+	}
+}
+
+// megaSys executes the MegaChip machine code calls. Returns false if sys
+// isn't one of them.
+func (c *Chip8) megaSys(sys uint16) (bool, error) {
+	m := c.mega
+	n := int(sys & 0xFF)
+	switch {
+	case m.on && sys == 0x0E0: // CLS
+		c.megaPresent()
+	case sys == 0x010: // MEGAOFF
+		m.on = false
+		c.clearScreen()
+	case sys == 0x011: // MEGAON
+		m.on = true
+		c.hires = true
+		c.clearScreen()
+	case m.on && sys == 0x0FB: // SCR
+		c.megaScroll(4, 0)
+	case m.on && sys == 0x0FC: // SCL
+		c.megaScroll(-4, 0)
+	case sys&0xFF0 == 0x0C0: // SCD N
+		if !m.on {
+			return false, nil
+		}
+		c.megaScroll(0, n&0xF)
+	case sys&0xFF0 == 0x0B0: // SCU N
+		if m.on {
+			c.megaScroll(0, -(n & 0xF))
+		} else {
+			c.scroll(0, -(n & 0xF))
+		}
+	case sys&0xF00 == 0x100: // LDHI I,NNNNNN
+		if int(c.PC)+2 > len(c.Memory) {
+			return true, &AccessErr{"LDHI", c.PC, 2, BoundsError}
+		}
+		c.setAddrI(n<<16 | int(c.Memory[c.PC])<<8 | int(c.Memory[c.PC+1]))
+		c.PC += 2
+	case sys&0xF00 == 0x200: // LDPAL NN
+		return true, c.megaPalette(n)
+	case sys&0xF00 == 0x300: // SPRW NN
+		m.width = n
+		if n == 0 {
+			m.width = 256
+		}
+	case sys&0xF00 == 0x400: // SPRH NN
+		m.height = n
+		if n == 0 {
+			m.height = 256
+		}
+	case sys&0xF00 == 0x500: // ALPHA NN
+		m.alpha = uint8(n)
+	case sys&0xFF0 == 0x600: // DIGISND N
+		return true, c.playSample(n&0xF == 0)
+	case sys == 0x700: // STOPSND
+		c.stopSample()
+	case sys&0xFF0 == 0x800: // BMODE N
+		if _, ok := blendModeNames[BlendMode(n&0xF)]; !ok {
+			return true, &BadCodeErr{}
+		}
+		m.blend = BlendMode(n & 0xF)
+	case sys&0xF00 == 0x900: // CCOL NN
+		m.collision = uint8(n)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// megaPalette executes LDPAL NN, which loads NN colors starting from index 1
+// from I, 4 bytes each in ARGB order.
+func (c *Chip8) megaPalette(n int) error {
+	err := c.checkAccess(fmt.Sprintf("LDPAL %02X", n), n*4, false)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		var argb [4]uint8
+		for j := range argb {
+			if addr := c.readAddr(i*4+j, false); addr >= 0 {
+				argb[j] = c.Memory[addr]
+			}
+		}
+		c.mega.palette[i+1] = color.RGBA{argb[1], argb[2], argb[3], argb[0]}
+	}
+	return nil
+}
+
+// playSample executes DIGISND N, which plays the sample at I. The sample
+// starts with a 6 bytes header: the sample rate (2 bytes), the length (3
+// bytes) and a reserved byte. N = 0 loops the sample.
+func (c *Chip8) playSample(loop bool) error {
+	err := c.checkAccess("DIGISND", 6, false)
+	if err != nil {
+		return err
+	}
+	var header [6]int
+	for i := range header {
+		if addr := c.readAddr(i, false); addr >= 0 {
+			header[i] = int(c.Memory[addr])
+		}
+	}
+	length := header[2]<<16 | header[3]<<8 | header[4]
+	err = c.checkAccess("DIGISND", 6+length, false)
+	if err != nil {
+		return err
+	}
+	s := &Sample{Rate: header[0]<<8 | header[1], Loop: loop}
+	for i := 0; i < length; i++ {
+		if addr := c.readAddr(6+i, false); addr >= 0 {
+			s.Data = append(s.Data, c.Memory[addr])
+		}
+	}
+	c.mega.sample = s
+	if d, ok := drivers[c.driver].(SampleDriver); ok {
+		d.OnSampleStart(c, s)
+	}
+	return nil
+}
+
+// stopSample stops the digitised sound, if any.
+func (c *Chip8) stopSample() {
+	if c.mega.sample == nil {
+		return
+	}
+	c.mega.sample = nil
+	if d, ok := drivers[c.driver].(SampleDriver); ok {
+		d.OnSampleStop(c)
+	}
+}
+
+// megaDraw executes DRW VX,VY,N in MegaChip mode, which draws a sprite of
+// the size set by SPRW and SPRH, one palette index per byte. Index 0 is
+// transparent and drawing over the CCOL index sets VF. Sprites in the font
+// area are drawn like SUPER-CHIP sprites, in white. Sprites are clipped at
+// the edges of the screen.
+func (c *Chip8) megaDraw(opcode []byte) error {
+	m := c.mega
+	w, h := m.width, m.height
+	mono := c.addrI() < int(c.StartAddress())
+	if mono {
+		w, h = 8, int(opcode[1]&0x0F)
+	}
+	err := c.checkAccess(fmt.Sprintf("DRW V%X,V%X,%X", opcode[0]&0x0F,
+		opcode[1]>>4, opcode[1]&0x0F), w*h/8, false)
+	if !mono {
+		err = c.checkAccess(fmt.Sprintf("DRW V%X,V%X,%X", opcode[0]&0x0F,
+			opcode[1]>>4, opcode[1]&0x0F), w*h, false)
+	}
+	if err != nil {
+		return err
+	}
+
+	x0 := int(c.V[opcode[0]&0x0F])
+	y0 := int(c.V[opcode[1]&0xF0>>4])
+	white := color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	c.V[0xF] = 0
+	for row := 0; row < h && y0+row < MegaHeight; row++ {
+		var bits uint8
+		if mono {
+			if addr := c.readAddr(row, false); addr >= 0 {
+				bits = c.Memory[addr]
+			}
+		}
+		for col := 0; col < w && x0+col < MegaWidth; col++ {
+			idx, src := uint8(0xFF), white
+			if mono {
+				if bits&(0x80>>uint(col)) == 0 {
+					continue
+				}
+			} else {
+				addr := c.readAddr(row*w+col, false)
+				if addr < 0 || c.Memory[addr] == 0 {
+					continue
+				}
+				idx = c.Memory[addr]
+				src = m.palette[idx]
+			}
+			i := (y0+row)*MegaWidth + x0 + col
+			if m.index[i] == m.collision {
+				c.V[0xF] = 1
+			}
+			m.index[i] = idx
+			dst := m.back.RGBAAt(x0+col, y0+row)
+			m.back.SetRGBA(x0+col, y0+row, m.blend.mix(dst, src))
+		}
+	}
+	return nil
+}
+
+// mix returns the color of a pixel of dst with src drawn over it.
+func (b BlendMode) mix(dst, src color.RGBA) color.RGBA {
+	var f func(d, s uint8) uint8
+	switch b {
+	case Blend25, Blend50, Blend75:
+		a := int(b) * 64
+		f = func(d, s uint8) uint8 {
+			return uint8((int(d)*(256-a) + int(s)*a) >> 8)
+		}
+	case BlendAdd:
+		f = func(d, s uint8) uint8 {
+			if int(d)+int(s) > 0xFF {
+				return 0xFF
+			}
+			return d + s
+		}
+	case BlendMultiply:
+		f = func(d, s uint8) uint8 { return uint8(int(d) * int(s) / 0xFF) }
+	default:
+		return color.RGBA{src.R, src.G, src.B, 0xFF}
+	}
+	return color.RGBA{f(dst.R, src.R), f(dst.G, src.G), f(dst.B, src.B), 0xFF}
+}
+
+// megaPresent executes CLS in MegaChip mode, which shows what was drawn
+// since the last one (faded by ALPHA) and clears the hidden buffer.
+func (c *Chip8) megaPresent() {
+	m := c.mega
+	for i := 0; i < len(m.back.Pix); i += 4 {
+		for j := 0; j < 3; j++ {
+			m.front.Pix[i+j] = uint8(int(m.back.Pix[i+j]) * int(m.alpha) /
+				0xFF)
+		}
+	}
+	clearRGBA(m.back)
+	for i := range m.index {
+		m.index[i] = 0
+	}
+	if d, ok := drivers[c.driver].(ColorDriver); ok {
+		d.UpdateColorScreen(c)
+	}
+}
+
+// megaScroll moves the hidden MegaChip buffer by dx, dy pixels. Pixels
+// scrolled in are blank.
+func (c *Chip8) megaScroll(dx, dy int) {
+	m := c.mega
+	oldIndex := append([]uint8(nil), m.index...)
+	oldPix := append([]uint8(nil), m.back.Pix...)
+	clearRGBA(m.back)
+	for i := range m.index {
+		m.index[i] = 0
+	}
+	for y := 0; y < MegaHeight; y++ {
+		for x := 0; x < MegaWidth; x++ {
+			sx, sy := x-dx, y-dy
+			if sx < 0 || sx >= MegaWidth || sy < 0 || sy >= MegaHeight {
+				continue
+			}
+			m.index[y*MegaWidth+x] = oldIndex[sy*MegaWidth+sx]
+			copy(m.back.Pix[m.back.PixOffset(x, y):][:4],
+				oldPix[m.back.PixOffset(sx, sy):])
+		}
+	}
+}
+
+// clearRGBA fills img with opaque black.
+func clearRGBA(img *image.RGBA) {
+	for i := range img.Pix {
+		img.Pix[i] = 0
+		if i%4 == 3 {
+			img.Pix[i] = 0xFF
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// octoState mirrors the machine state fields of the Emulator object of Octo
+// (https://github.com/JohnEarnest/Octo), as serialized by JSON.stringify.
+// Octo keeps the display as two planes of 128x64 pixels, one byte per pixel,
+// with rows of 64 pixels in low resolution and 128 in high resolution.
+type octoState struct {
+	PC      int     `json:"pc"`
+	I       int     `json:"i"`
+	V       []int   `json:"v"`
+	M       []int   `json:"m"`
+	R       []int   `json:"r"`
+	DT      int     `json:"dt"`
+	ST      int     `json:"st"`
+	Hires   bool    `json:"hires"`
+	P       [][]int `json:"p"`
+	Flags   []int   `json:"flags"`
+	Waiting bool    `json:"waiting"`
+	WaitReg int     `json:"waitReg"`
+	Halted  bool    `json:"halted"`
+}
+
+// ExportOcto writes the machine state in the format of Octo's serialized
+// Emulator object, so it can be loaded in Octo or another emulator that
+// understands it.
+func (c *Chip8) ExportOcto(w io.Writer) error {
+	s := octoState{
+		PC:      int(c.PC),
+		I:       int(c.I),
+		V:       make([]int, len(c.V)),
+		M:       make([]int, len(c.Memory)),
+		R:       make([]int, 0, c.SP+1),
+		DT:      int(c.DT),
+		ST:      int(c.ST),
+		Hires:   c.Width > 64,
+		Flags:   make([]int, 8),
+		Waiting: c.wii != nil,
+		Halted:  c.halted != nil,
+	}
+	for i, v := range c.V {
+		s.V[i] = int(v)
+	}
+	for i, b := range c.Memory {
+		s.M[i] = int(b)
+	}
+	for i := 0; i <= c.SP && i < len(c.Stack); i++ {
+		s.R = append(s.R, int(c.Stack[i]))
+	}
+	if c.wii != nil {
+		s.WaitReg = int(c.wii.register)
+	}
+
+	rowSize := 64
+	if s.Hires {
+		rowSize = 128
+	}
+	s.P = [][]int{make([]int, 128*64), make([]int, 128*64)}
+	for y := 0; y < int(c.Height) && y < 64; y++ {
+		for x := 0; x < int(c.Width) && x < rowSize; x++ {
+			if c.pixelSet(x, y) {
+				s.P[0][x+y*rowSize] = 1
+			}
+		}
+	}
+
+	return json.NewEncoder(w).Encode(&s)
+}
+
+// ImportOcto loads a machine state exported by Octo (or ExportOcto).
+// Memory, stack and screen are truncated to the sizes of this emulator.
+// The emulator is resumed even if the state was halted.
+func (c *Chip8) ImportOcto(r io.Reader) error {
+	var s octoState
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return fmt.Errorf("Failed to parse Octo state: %v", err)
+	}
+	if len(s.V) != len(c.V) {
+		return fmt.Errorf("Octo state has %d registers, expected %d.",
+			len(s.V), len(c.V))
+	}
+	if len(s.R) > len(c.Stack) {
+		return fmt.Errorf("Octo state has %d nested calls, the stack only "+
+			"holds %d.", len(s.R), len(c.Stack))
+	}
+
+	c.PC = uint16(s.PC)
+	c.I = uint16(s.I)
+	for i, v := range s.V {
+		c.V[i] = uint8(v)
+	}
+	for i := 0; i < len(s.M) && i < len(c.Memory); i++ {
+		c.Memory[i] = uint8(s.M[i])
+	}
+	c.SP = len(s.R) - 1
+	for i, addr := range s.R {
+		c.Stack[i] = uint16(addr)
+	}
+	c.DT = uint8(s.DT)
+	c.setST(uint8(s.ST))
+	c.wii = nil
+	if s.Waiting {
+		c.wii = &waitInputInfo{register: uint8(s.WaitReg) & 0xF,
+			zeroBits: ^c.Keyboard}
+	}
+	c.halted = nil
+
+	// the screen must be restored after memory, as it can live in it
+	for i := range c.Screen {
+		c.Screen[i] = 0
+	}
+	rowSize := 64
+	if s.Hires {
+		rowSize = 128
+	}
+	if len(s.P) > 0 {
+		byteWidth := int(c.Width) / 8
+		for y := 0; y < int(c.Height) && y < 64; y++ {
+			for x := 0; x < int(c.Width) && x < rowSize; x++ {
+				i := x + y*rowSize
+				if i < len(s.P[0]) && s.P[0][i] != 0 {
+					c.Screen[y*byteWidth+x/8] |= 0x80 >> uint(x%8)
+				}
+			}
+		}
+	}
+	drivers[c.driver].Cls()
+	c.updateScreen()
+	return nil
+}
+
+// pixelSet returns whether the pixel at x, y is set in the screen buffer.
+func (c *Chip8) pixelSet(x, y int) bool {
+	return c.Screen[y*(int(c.Width)/8)+x/8]&(0x80>>uint(x%8)) != 0
+}
@@ -0,0 +1,60 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import "fmt"
+
+// An OpcodeHandler executes a custom instruction. opcode is the instruction
+// being executed and PC already points to the next one.
+// Returns an error to halt the emulator.
+type OpcodeHandler func(c *Chip8, opcode uint16) error
+
+type opcodeHandler struct {
+	pattern, mask uint16
+	fn            OpcodeHandler
+}
+
+// RegisterOpcodeHandler registers fn to execute every instruction for which
+// opcode&mask == pattern and that the emulator would otherwise reject as
+// invalid code, or ignore as an unknown 0NNN syscall. This makes it possible
+// to prototype new instructions, implement obscure extensions or add calls
+// into the host program without forking the interpreter.
+// Handlers are tried in the order they were registered.
+// Returns an error if pattern has bits outside of mask, as it could never
+// match.
+func (c *Chip8) RegisterOpcodeHandler(pattern, mask uint16,
+	fn OpcodeHandler) error {
+
+	if pattern&^mask != 0 {
+		return fmt.Errorf("Pattern %04X has bits outside of mask %04X.",
+			pattern, mask)
+	}
+	c.opcodeHandlers = append(c.opcodeHandlers,
+		opcodeHandler{pattern, mask, fn})
+	return nil
+}
+
+// customOpcode runs the first registered handler that matches opcode.
+// Returns whether a handler was found and its error.
+func (c *Chip8) customOpcode(opcode []byte) (handled bool, err error) {
+	op := uint16(opcode[0])<<8 | uint16(opcode[1])
+	for _, h := range c.opcodeHandlers {
+		if op&h.mask == h.pattern {
+			return true, h.fn(c, op)
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,133 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+)
+
+// A Palette holds the colors monochrome screens are drawn with, indexed by
+// the planes a pixel is lit in: 0 is the background and 1 the foreground.
+// Palettes can have more colors for programs that draw to several planes,
+// like XO-CHIP ones, where 2 is the second plane and 3 both planes.
+type Palette []color.RGBA
+
+// Background returns the color of unlit pixels.
+func (p Palette) Background() color.RGBA { return p[0] }
+
+// Foreground returns the color of lit pixels.
+func (p Palette) Foreground() color.RGBA { return p[1] }
+
+// Palettes holds the built-in palettes by name. Custom palettes can be added
+// to it before creating the emulator.
+var Palettes = map[string]Palette{
+	// White on black, the default.
+	"mono": {
+		{0x00, 0x00, 0x00, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF},
+	},
+	// Black on white.
+	"paper": {
+		{0xFF, 0xFF, 0xFF, 0xFF}, {0x00, 0x00, 0x00, 0xFF},
+	},
+	// Monochrome monitors.
+	"amber": {
+		{0x00, 0x00, 0x00, 0xFF}, {0xFF, 0xB0, 0x00, 0xFF},
+	},
+	"green": {
+		{0x00, 0x00, 0x00, 0xFF}, {0x33, 0xFF, 0x33, 0xFF},
+	},
+	// Dark green on the pea green of old handheld LCDs.
+	"lcd": {
+		{0x9B, 0xBC, 0x0F, 0xFF}, {0x0F, 0x38, 0x0F, 0xFF},
+		{0x30, 0x62, 0x30, 0xFF}, {0x8B, 0xAC, 0x0F, 0xFF},
+	},
+	// Octo's default colors.
+	"octo": {
+		{0x99, 0x66, 0x00, 0xFF}, {0xFF, 0xCC, 0x00, 0xFF},
+		{0xFF, 0x66, 0x00, 0xFF}, {0x66, 0x22, 0x00, 0xFF},
+	},
+}
+
+// defaultPalette is used when the Palette setting is empty.
+var defaultPalette = Palette{
+	{0x00, 0x00, 0x00, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF},
+}
+
+// GetPalette returns the palette registered under name.
+func GetPalette(name string) (Palette, error) {
+	p := Palettes[name]
+	if len(p) < 2 {
+		return nil, fmt.Errorf("Unknown palette '%s' (available: %v).",
+			name, PaletteNames())
+	}
+	return p, nil
+}
+
+// PaletteNames returns the sorted names of all the available palettes.
+func PaletteNames() []string {
+	names := make([]string, 0, len(Palettes))
+	for name := range Palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParsePalette parses the name of one of the Palettes or a comma separated
+// list of at least two colors in the ParseColor format, background first,
+// such as "#000000,#FFB000".
+func ParsePalette(s string) (Palette, error) {
+	if !strings.Contains(s, ",") {
+		return GetPalette(s)
+	}
+	var p Palette
+	for _, field := range strings.Split(s, ",") {
+		col, err := ParseColor(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, col)
+	}
+	return p, nil
+}
+
+// ParseColor parses an opaque color in the #RRGGBB format. The # is
+// optional.
+func ParseColor(s string) (color.RGBA, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "#"))
+	if err != nil || len(b) != 3 {
+		return color.RGBA{}, fmt.Errorf(
+			"Invalid color '%s', expected #RRGGBB.", s)
+	}
+	return color.RGBA{b[0], b[1], b[2], 0xFF}, nil
+}
+
+// DisplayPalette returns the palette selected in the settings, which
+// PixelColor and BackgroundColor use for monochrome variants.
+func (c *Chip8) DisplayPalette() Palette { return c.palette }
+
+// paletteFor resolves the Palette setting, which must be valid.
+func paletteFor(s *Chip8Settings) Palette {
+	if s.Palette == "" {
+		return defaultPalette
+	}
+	p, _ := ParsePalette(s.Palette)
+	return p
+}
@@ -0,0 +1,122 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// A PersistentRegion is a range of memory backed by a file, which lets
+// programs keep data such as high scores across sessions.
+// The file is loaded into memory right after the program and is written back
+// whenever the program stores to the region and on shutdown.
+type PersistentRegion struct {
+	// Address of the first byte of the region.
+	Address uint16
+	// Size of the region in bytes. Min. 1.
+	Size uint16
+	// Path of the file that backs the region.
+	Path string
+}
+
+// validate checks that the region is well formed and fits in memorySize
+// bytes of memory.
+func (r *PersistentRegion) validate(memorySize uint16) error {
+	if r.Size == 0 {
+		return fmt.Errorf("Persistent region at 0x%X has zero size.",
+			r.Address)
+	}
+	if r.Path == "" {
+		return fmt.Errorf("Persistent region at 0x%X has no path.",
+			r.Address)
+	}
+	if int(r.Address)+int(r.Size) > int(memorySize) {
+		return fmt.Errorf("Persistent region 0x%X-0x%X is out of memory "+
+			"bounds.", r.Address, int(r.Address)+int(r.Size)-1)
+	}
+	return nil
+}
+
+// persistentRegion holds the state of a PersistentRegion at runtime.
+type persistentRegion struct {
+	PersistentRegion
+	// saved holds the contents of the file as last loaded or written, so
+	// that unchanged regions aren't rewritten.
+	saved []byte
+}
+
+func (r *persistentRegion) memory(c *Chip8) []byte {
+	return c.Memory[r.Address : r.Address+r.Size]
+}
+
+// loadPersistent loads every persistent region from its file. Missing files
+// are ignored, as they will be created on the first write.
+func (c *Chip8) loadPersistent() error {
+	for _, r := range c.persistent {
+		data, err := os.ReadFile(r.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n := copy(r.memory(c), data)
+		r.saved = append(r.saved[:0], r.memory(c)...)
+		c.logger.Printf(`Loaded %v bytes of persistent memory from "%s"`,
+			n, r.Path)
+	}
+	return nil
+}
+
+// persistWrite is called after the program writes memory from start to end
+// (inclusive) and flushes the persistent regions it touched.
+func (c *Chip8) persistWrite(start, end uint16) {
+	for _, r := range c.persistent {
+		if end < r.Address || start >= r.Address+r.Size {
+			continue
+		}
+		if err := c.flushRegion(r); err != nil {
+			c.logger.Println(err)
+		}
+	}
+}
+
+func (c *Chip8) flushRegion(r *persistentRegion) error {
+	mem := r.memory(c)
+	if r.saved != nil && bytes.Equal(mem, r.saved) {
+		return nil
+	}
+	err := os.WriteFile(r.Path, mem, 0644)
+	if err != nil {
+		return err
+	}
+	r.saved = append(r.saved[:0], mem...)
+	return nil
+}
+
+// Flush writes every persistent memory region that has changed back to its
+// file. This is done automatically by Shutdown.
+// Returns the first error encountered, if any.
+func (c *Chip8) Flush() (err error) {
+	for _, r := range c.persistent {
+		if ferr := c.flushRegion(r); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+	return
+}
@@ -0,0 +1,220 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// An AddressCount is how many times the instruction at Address ran.
+type AddressCount struct {
+	Address uint16
+	Count   uint64
+}
+
+// An OpcodeCount is how many instructions of an opcode class ran.
+type OpcodeCount struct {
+	// Class is the opcode pattern and the instructions it covers.
+	Class string
+	Count uint64
+}
+
+// A StackCount is how many instructions ran with a given call stack.
+type StackCount struct {
+	// Stack lists the entry points of the subroutines that were being
+	// executed, from the outermost. It's empty for the main program.
+	Stack []uint16
+	Count uint64
+}
+
+// A Profile is what the profiler collected since EnableProfiler was called.
+type Profile struct {
+	Cycles uint64
+	// Addresses lists every address that was executed, hottest first.
+	Addresses []AddressCount
+	// Opcodes lists the opcode classes that were executed, in opcode order.
+	Opcodes []OpcodeCount
+	// Draws is how many DRW instructions ran, DrawTime is how long they
+	// took in total and MaxDrawTime is the slowest one.
+	Draws                 uint64
+	DrawTime, MaxDrawTime time.Duration
+	// Stacks lists the call stacks instructions ran with, which is what
+	// flame graphs are built from.
+	Stacks []StackCount
+}
+
+// AverageDrawTime returns how long a DRW took on average.
+func (p *Profile) AverageDrawTime() time.Duration {
+	if p.Draws == 0 {
+		return 0
+	}
+	return p.DrawTime / time.Duration(p.Draws)
+}
+
+// profileNode is a subroutine in the call tree built by the profiler.
+type profileNode struct {
+	address  uint16 // entry point, unused for the root
+	parent   int
+	children map[uint16]int
+	count    uint64 // instructions run in the subroutine itself
+}
+
+// profiler collects a Profile.
+type profiler struct {
+	cycles            uint64
+	pcs               []uint64 // by address
+	classes           [16]uint64
+	draws             uint64
+	drawTime, maxDraw time.Duration
+	nodes             []profileNode // nodes[0] is the main program
+	cur               int           // node being executed
+}
+
+func newProfiler() *profiler {
+	return &profiler{
+		pcs:   make([]uint64, 0x10000),
+		nodes: []profileNode{{children: make(map[uint16]int)}},
+	}
+}
+
+// EnableProfiler starts profiling the program: how many times each address
+// and opcode class is executed, how long DRW instructions take and which
+// subroutines the instructions run in. Calling it again resets the profile.
+// DRW instructions are timed with the wall clock, which slows them down a
+// little.
+func (c *Chip8) EnableProfiler() { c.profiler = newProfiler() }
+
+// profile records an instruction that ran at pc, which started at start if
+// it's a DRW. sp is the stack pointer before it ran, which tells calls and
+// returns apart.
+func (c *Chip8) profile(pc, op uint16, sp int, start time.Time) {
+	p := c.profiler
+	p.cycles++
+	p.pcs[pc]++
+	p.classes[op>>12]++
+	p.nodes[p.cur].count++
+	if op&0xF000 == 0xD000 {
+		elapsed := time.Since(start)
+		p.draws++
+		p.drawTime += elapsed
+		if elapsed > p.maxDraw {
+			p.maxDraw = elapsed
+		}
+	}
+
+	switch {
+	case c.SP > sp:
+		// entered a subroutine, which is now at PC
+		node := &p.nodes[p.cur]
+		child, ok := node.children[c.PC]
+		if !ok {
+			child = len(p.nodes)
+			node.children[c.PC] = child
+			p.nodes = append(p.nodes, profileNode{address: c.PC,
+				parent: p.cur, children: make(map[uint16]int)})
+		}
+		p.cur = child
+	case c.SP < sp && p.cur != 0:
+		p.cur = p.nodes[p.cur].parent
+	}
+}
+
+// Profile returns what the profiler collected, or nil if it's disabled.
+func (c *Chip8) Profile() *Profile {
+	p := c.profiler
+	if p == nil {
+		return nil
+	}
+	res := &Profile{Cycles: p.cycles, Draws: p.draws,
+		DrawTime: p.drawTime, MaxDrawTime: p.maxDraw}
+	for addr, count := range p.pcs {
+		if count != 0 {
+			res.Addresses = append(res.Addresses,
+				AddressCount{uint16(addr), count})
+		}
+	}
+	sort.SliceStable(res.Addresses, func(i, j int) bool {
+		return res.Addresses[i].Count > res.Addresses[j].Count
+	})
+	for i, name := range opcodeClasses {
+		if p.classes[i] != 0 {
+			res.Opcodes = append(res.Opcodes,
+				OpcodeCount{name, p.classes[i]})
+		}
+	}
+	for i, node := range p.nodes {
+		if node.count == 0 {
+			continue
+		}
+		var stack []uint16
+		for n := i; n != 0; n = p.nodes[n].parent {
+			stack = append([]uint16{p.nodes[n].address}, stack...)
+		}
+		res.Stacks = append(res.Stacks, StackCount{stack, node.count})
+	}
+	return res
+}
+
+// WriteProfile writes a profile in a human readable form.
+func WriteProfile(w io.Writer, p *Profile) error {
+	share := func(count uint64) float64 {
+		return 100 * float64(count) / float64(p.Cycles)
+	}
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 8, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "cycles: %d\ndraws: %d in %v (%v average, %v max)\n\n",
+		p.Cycles, p.Draws, p.DrawTime, p.AverageDrawTime(), p.MaxDrawTime)
+	fmt.Fprintln(tw, "opcode class\tcount\tshare\t")
+	for _, o := range p.Opcodes {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t\n", o.Class, o.Count,
+			share(o.Count))
+	}
+	fmt.Fprintln(tw, "\naddr\tcount\tshare\t")
+	for _, a := range p.Addresses {
+		fmt.Fprintf(tw, "%04X\t%d\t%.1f%%\t\n", a.Address, a.Count,
+			share(a.Count))
+	}
+	return tw.Flush()
+}
+
+// WriteProfileFolded writes the call stacks of a profile in the folded
+// format read by flamegraph.pl and speedscope: one line per stack, with the
+// subroutines separated by semicolons and followed by the instruction count.
+// The main program is called main and subroutines are named after their
+// address.
+func WriteProfileFolded(w io.Writer, p *Profile) error {
+	lines := make([]string, 0, len(p.Stacks))
+	for _, s := range p.Stacks {
+		frames := []string{"main"}
+		for _, addr := range s.Stack {
+			frames = append(frames, fmt.Sprintf("%04X", addr))
+		}
+		lines = append(lines, fmt.Sprintf("%s %d",
+			strings.Join(frames, ";"), s.Count))
+	}
+	sort.Strings(lines)
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
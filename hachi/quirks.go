@@ -0,0 +1,268 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quirks are the behaviours that differ between CHIP-8 interpreters, which
+// programs of different eras rely on. The zero value is the modern
+// behaviour most programs expect, QuirksVIP and QuirksSChip match the
+// original interpreters.
+type Quirks struct {
+	// VFReset clears VF after OR, AND and XOR VX,VY, like the COSMAC VIP.
+	VFReset bool
+	// MemoryIncrement makes LD [I],VX and LD VX,[I] leave I pointing after
+	// the last register, like the COSMAC VIP, instead of unchanged.
+	MemoryIncrement bool
+	// ShiftVY makes SHR and SHL VX,VY shift VY into VX, like the COSMAC
+	// VIP, instead of shifting VX in place.
+	ShiftVY bool
+	// JumpVX makes JP V0,NNN (BXNN) jump to XNN plus VX, like SUPER-CHIP,
+	// instead of NNN plus V0.
+	JumpVX bool
+	// Clip makes sprites stop at the edges of the screen instead of
+	// wrapping around, only their starting coordinates wrap. SUPER-CHIP
+	// always clips.
+	Clip bool
+	// DisplayWait makes DRW wait for the next 60hz timer tick, like the
+	// COSMAC VIP which waited for the display interrupt before drawing
+	// sprites. This limits programs to one sprite per frame, which some
+	// rely on for pacing. With Frame the draw ends the current frame, with
+	// Tick nothing else runs until the timers tick.
+	DisplayWait bool
+}
+
+var (
+	// QuirksVIP is the behaviour of the original COSMAC VIP interpreter.
+	QuirksVIP = Quirks{VFReset: true, MemoryIncrement: true, ShiftVY: true,
+		Clip: true, DisplayWait: true}
+	// QuirksSChip is the behaviour of SUPER-CHIP 1.1 on the HP48.
+	QuirksSChip = Quirks{JumpVX: true, Clip: true}
+	// QuirksChip48 is the behaviour of CHIP-48 on the HP48, except for how
+	// it moves I in LD [I] (see VariantChip48).
+	QuirksChip48 = Quirks{JumpVX: true, Clip: true}
+)
+
+// the names of the quirks in String and ParseQuirks, in the order of the
+// fields
+var quirkNames = []string{"vfreset", "memory", "shift", "jump", "clip",
+	"vblank"}
+
+// the presets accepted by ParseQuirks
+var quirkPresets = map[string]Quirks{
+	"modern": {},
+	"vip":    QuirksVIP,
+	"schip":  QuirksSChip,
+	"chip48": QuirksChip48,
+}
+
+// flags returns pointers to the quirks in the order of quirkNames.
+func (q *Quirks) flags() []*bool {
+	return []*bool{&q.VFReset, &q.MemoryIncrement, &q.ShiftVY, &q.JumpVX,
+		&q.Clip, &q.DisplayWait}
+}
+
+// String returns the enabled quirks as a comma separated list (see
+// ParseQuirks), or "modern" if none is.
+func (q Quirks) String() string {
+	var names []string
+	for i, f := range q.flags() {
+		if *f {
+			names = append(names, quirkNames[i])
+		}
+	}
+	if len(names) == 0 {
+		return "modern"
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseQuirks parses a comma separated list of presets (modern, vip, schip
+// or chip48) and quirks (vfreset, memory, shift, jump, clip and vblank, the
+// fields of Quirks in order) which are all enabled, such as "schip,vblank".
+func ParseQuirks(list string) (q Quirks, err error) {
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if preset, ok := quirkPresets[name]; ok {
+			q = q.merge(preset)
+			continue
+		}
+		found := false
+		for i, f := range q.flags() {
+			if quirkNames[i] == name {
+				*f, found = true, true
+			}
+		}
+		if !found {
+			return q, fmt.Errorf("Unknown quirk '%s' (available: modern, "+
+				"vip, schip, chip48, %s).", name,
+				strings.Join(quirkNames, ", "))
+		}
+	}
+	return
+}
+
+// merge returns q with the quirks enabled in other enabled too.
+func (q Quirks) merge(other Quirks) Quirks {
+	flags := other.flags()
+	for i, f := range q.flags() {
+		*f = *f || *flags[i]
+	}
+	return q
+}
+
+// logicQuirk clears VF after OR, AND and XOR VX,VY if the VFReset quirk is
+// enabled.
+func (c *Chip8) logicQuirk() {
+	if c.quirks.VFReset {
+		c.V[0xF] = 0
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// A QuirkFinding is an instruction that hints at which behaviour a program
+// expects.
+type QuirkFinding struct {
+	Address uint16
+	Opcode  uint16
+	// Legacy is true if the instruction hints at the original COSMAC VIP
+	// behaviour (the ShiftVY and MemoryIncrement quirks), false if it hints
+	// at the modern one.
+	Legacy bool
+	// Info is true for findings that are only informational and don't count
+	// towards either behaviour.
+	Info   bool
+	Reason string
+}
+
+func (f QuirkFinding) String() string {
+	kind := "modern"
+	switch {
+	case f.Info:
+		kind = "info"
+	case f.Legacy:
+		kind = "legacy"
+	}
+	return fmt.Sprintf("%04X %04X %s: %s", f.Address, f.Opcode, kind,
+		f.Reason)
+}
+
+// A QuirkReport is the result of DetectQuirks.
+type QuirkReport struct {
+	Findings []QuirkFinding
+	// LegacyVotes and ModernVotes count the findings of each kind.
+	LegacyVotes, ModernVotes int
+}
+
+// Legacy returns whether the program most likely expects the ShiftVY and
+// MemoryIncrement quirks.
+func (r *QuirkReport) Legacy() bool { return r.LegacyVotes > r.ModernVotes }
+
+// Confident returns whether there were any findings and they all agree.
+func (r *QuirkReport) Confident() bool {
+	return (r.LegacyVotes == 0) != (r.ModernVotes == 0)
+}
+
+// Apply returns a copy of s with the suggested quirks applied. Settings are
+// left unchanged when nothing was found.
+func (r *QuirkReport) Apply(s *Chip8Settings) *Chip8Settings {
+	res := *s
+	if r.LegacyVotes != r.ModernVotes {
+		res.Quirks.ShiftVY = r.Legacy()
+		res.Quirks.MemoryIncrement = r.Legacy()
+	}
+	return &res
+}
+
+// quirkWindow is how many instructions after a LD [I],VX or LD VX,[I] are
+// checked for uses of I.
+const quirkWindow = 4
+
+// DetectQuirks scans a program for instruction patterns that only make sense
+// with one of the quirk behaviours:
+//
+//   - SHR/SHL VX,VY with X != Y, which only differs from SHR/SHL VX when VY
+//     is shifted into VX like the original interpreter did (legacy).
+//   - LD [I],VX or LD VX,[I] followed by another use of I without reloading
+//     it, which relies on I being left unchanged (modern).
+//   - JP V0,NNN with a non-zero X, which hints at the SUPER-CHIP BXNN jump.
+//     This is reported but doesn't vote, as it's a valid jump either way.
+//
+// Data is scanned as if it were code, so the result is only a hint.
+// Addresses in the findings assume the program is loaded at 0x200.
+func DetectQuirks(rom []byte) *QuirkReport {
+	r := &QuirkReport{}
+	add := func(addr int, op uint16, legacy bool, reason string) {
+		r.Findings = append(r.Findings, QuirkFinding{
+			Address: uint16(0x200 + addr), Opcode: op, Legacy: legacy,
+			Reason: reason})
+		if legacy {
+			r.LegacyVotes++
+		} else {
+			r.ModernVotes++
+		}
+	}
+
+	opcodeAt := func(i int) uint16 {
+		return uint16(rom[i])<<8 | uint16(rom[i+1])
+	}
+	for i := 0; i+1 < len(rom); i += 2 {
+		op := opcodeAt(i)
+		x, y := op>>8&0xF, op>>4&0xF
+		switch {
+		case op&0xF00F == 0x8006 || op&0xF00F == 0x800E:
+			if x != y {
+				add(i, op, true, "shift with VX != VY")
+			}
+		case op&0xF0FF == 0xF055 || op&0xF0FF == 0xF065:
+			for j := 1; j <= quirkWindow && i+2*j+1 < len(rom); j++ {
+				next := opcodeAt(i + 2*j)
+				if next&0xF000 == 0xA000 {
+					break // I was reloaded
+				}
+				if usesI(next) {
+					add(i, op, false, fmt.Sprintf(
+						"I used again by %04X without reloading", next))
+					break
+				}
+			}
+		case op&0xF000 == 0xB000 && x != 0:
+			r.Findings = append(r.Findings, QuirkFinding{
+				Address: uint16(0x200 + i), Opcode: op, Info: true,
+				Reason: "JP V0,NNN with non-zero X (SUPER-CHIP BXNN, " +
+					"see Quirks.JumpVX)"})
+		}
+	}
+	return r
+}
+
+// usesI returns whether an instruction reads the I register.
+func usesI(op uint16) bool {
+	switch {
+	case op&0xF000 == 0xD000:
+		return true
+	case op&0xF000 == 0xF000:
+		switch op & 0xFF {
+		case 0x1E, 0x33, 0x55, 0x65:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// A RandomSource picks how RND VX,NN generates its numbers.
+type RandomSource int
+
+const (
+	// RandomMath uses math/rand, or Chip8Settings.Rand if set.
+	RandomMath RandomSource = iota
+	// RandomVIP reproduces the COSMAC VIP interpreter's routine, which
+	// keeps a 16-bit seed (the 1802's R9 register) and doesn't really
+	// generate random numbers: it increments the seed, adds the byte of
+	// the interpreter's second page (0x100-0x1FF) addressed by the low
+	// byte of the seed to its high byte and stores the sum back as the new
+	// high byte, which is the result.
+	// The sequence only matches real hardware when the original interpreter
+	// is loaded at 0x000-0x1FF and the seed is the same (see RandomSeed).
+	RandomVIP
+)
+
+var randomSourceNames = map[RandomSource]string{
+	RandomMath: "math",
+	RandomVIP:  "vip",
+}
+
+func (r RandomSource) String() string {
+	if name, ok := randomSourceNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("RandomSource(%d)", int(r))
+}
+
+// ParseRandomSource returns the random source with the given name (see
+// RandomSource.String).
+func ParseRandomSource(name string) (RandomSource, error) {
+	for r, n := range randomSourceNames {
+		if n == name {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown random source '%s'.", name)
+}
+
+// random returns the next random byte for RND VX,NN.
+func (c *Chip8) random() uint8 {
+	if c.randomSource != RandomVIP {
+		if c.rng != nil {
+			return uint8(c.rng.Uint32())
+		}
+		return uint8(rand.Uint32())
+	}
+	c.randomSeed++
+	page := int(c.randomSeed&0xFF) | 0x100
+	var b uint8
+	if page < len(c.Memory) {
+		b = c.Memory[page]
+	}
+	hi := uint8(c.randomSeed>>8) + b
+	c.randomSeed = uint16(hi)<<8 | c.randomSeed&0xFF
+	return hi
+}
+
+// newRand wraps the user's random source, if any.
+func newRand(src rand.Source) *rand.Rand {
+	if src == nil {
+		return nil
+	}
+	return rand.New(src)
+}
+
+// RandomSeed returns the seed of the RandomVIP generator.
+func (c *Chip8) RandomSeed() uint16 { return c.randomSeed }
@@ -0,0 +1,461 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package remote
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The messages of remote.proto, see there for what the fields mean. They are
+// encoded by hand in the protobuf wire format, so that the package doesn't
+// need generated code.
+
+// A message is a request or response of the service.
+type message interface {
+	marshal(b []byte) []byte
+	unmarshal(b []byte) error
+}
+
+// LoadROMRequest is the request of LoadROM.
+type LoadROMRequest struct {
+	ID             uint64
+	ROM            []byte
+	Variant        string
+	CyclesPerFrame uint32
+	Seed           int64
+	Quirks         string
+}
+
+// LoadROMResponse is the response of LoadROM.
+type LoadROMResponse struct {
+	ID uint64
+}
+
+// InstanceRequest is the request of the calls that only need an instance.
+type InstanceRequest struct {
+	ID uint64
+}
+
+// StepRequest is the request of Step.
+type StepRequest struct {
+	ID    uint64
+	Count uint32
+}
+
+// RunForRequest is the request of RunFor.
+type RunForRequest struct {
+	ID     uint64
+	Frames uint32
+}
+
+// SetKeysRequest is the request of SetKeys.
+type SetKeysRequest struct {
+	ID   uint64
+	Keys uint32
+}
+
+// State is the state of an instance after a call.
+type State struct {
+	PC            uint32
+	I             uint32
+	V             []byte
+	SP            int32
+	Stack         []uint32
+	DT            uint32
+	ST            uint32
+	Keys          uint32
+	WaitingForKey bool
+	Halted        string
+	Error         string
+	Cycles        uint64
+	Frames        uint64
+}
+
+// Frame is the screen of an instance, as returned by GetFrame.
+type Frame struct {
+	Width  uint32
+	Height uint32
+	Screen []byte
+}
+
+// Empty is the response of the calls that return nothing.
+type Empty struct{}
+
+// -----------------------------------------------------------------------------
+
+// proto3 leaves out fields with the default value
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarint(b, num, 1)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// appendPacked appends a packed repeated varint field.
+func appendPacked(b []byte, num protowire.Number, v []uint32) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, x := range v {
+		packed = protowire.AppendVarint(packed, uint64(x))
+	}
+	return appendBytes(b, num, packed)
+}
+
+// skip is returned by field parsers for fields they don't know
+const skip = 0
+
+// parseFields calls field for every field in b with the data that follows
+// the tag. field returns how many bytes it consumed, skip to ignore the field
+// or a negative protowire error.
+func parseFields(b []byte,
+	field func(num protowire.Number, typ protowire.Type, b []byte) int) error {
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = field(num, typ, b)
+		if n == skip {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// the consume functions parse a field into v if it has the expected type
+
+func consumeVarint(typ protowire.Type, b []byte, v *uint64) int {
+	if typ != protowire.VarintType {
+		return skip
+	}
+	x, n := protowire.ConsumeVarint(b)
+	*v = x
+	return n
+}
+
+func consumeUint32(typ protowire.Type, b []byte, v *uint32) int {
+	var x uint64
+	n := consumeVarint(typ, b, &x)
+	*v = uint32(x)
+	return n
+}
+
+func consumeInt32(typ protowire.Type, b []byte, v *int32) int {
+	var x uint64
+	n := consumeVarint(typ, b, &x)
+	*v = int32(x)
+	return n
+}
+
+func consumeInt64(typ protowire.Type, b []byte, v *int64) int {
+	var x uint64
+	n := consumeVarint(typ, b, &x)
+	*v = int64(x)
+	return n
+}
+
+func consumeBool(typ protowire.Type, b []byte, v *bool) int {
+	var x uint64
+	n := consumeVarint(typ, b, &x)
+	*v = x != 0
+	return n
+}
+
+func consumeBytes(typ protowire.Type, b []byte, v *[]byte) int {
+	if typ != protowire.BytesType {
+		return skip
+	}
+	x, n := protowire.ConsumeBytes(b)
+	*v = append([]byte(nil), x...)
+	return n
+}
+
+func consumeString(typ protowire.Type, b []byte, v *string) int {
+	if typ != protowire.BytesType {
+		return skip
+	}
+	x, n := protowire.ConsumeString(b)
+	*v = x
+	return n
+}
+
+// consumeRepeated parses a repeated varint field, packed or not, and appends
+// it to v.
+func consumeRepeated(typ protowire.Type, b []byte, v *[]uint32) int {
+	if typ == protowire.VarintType {
+		var x uint32
+		n := consumeUint32(typ, b, &x)
+		*v = append(*v, x)
+		return n
+	}
+	var packed []byte
+	n := consumeBytes(typ, b, &packed)
+	for len(packed) > 0 {
+		x, m := protowire.ConsumeVarint(packed)
+		if m < 0 {
+			return m
+		}
+		*v = append(*v, uint32(x))
+		packed = packed[m:]
+	}
+	return n
+}
+
+// -----------------------------------------------------------------------------
+
+func (m *LoadROMRequest) marshal(b []byte) []byte {
+	b = appendVarint(b, 1, m.ID)
+	b = appendBytes(b, 2, m.ROM)
+	b = appendString(b, 3, m.Variant)
+	b = appendVarint(b, 5, uint64(m.CyclesPerFrame))
+	b = appendVarint(b, 6, uint64(m.Seed))
+	return appendString(b, 7, m.Quirks)
+}
+
+func (m *LoadROMRequest) unmarshal(b []byte) error {
+	*m = LoadROMRequest{}
+	return parseFields(b, func(num protowire.Number, typ protowire.Type,
+		b []byte) int {
+
+		switch num {
+		case 1:
+			return consumeVarint(typ, b, &m.ID)
+		case 2:
+			return consumeBytes(typ, b, &m.ROM)
+		case 3:
+			return consumeString(typ, b, &m.Variant)
+		case 5:
+			return consumeUint32(typ, b, &m.CyclesPerFrame)
+		case 6:
+			return consumeInt64(typ, b, &m.Seed)
+		case 7:
+			return consumeString(typ, b, &m.Quirks)
+		}
+		return skip
+	})
+}
+
+func (m *LoadROMResponse) marshal(b []byte) []byte {
+	return appendVarint(b, 1, m.ID)
+}
+
+func (m *LoadROMResponse) unmarshal(b []byte) error {
+	*m = LoadROMResponse{}
+	return parseFields(b, func(num protowire.Number, typ protowire.Type,
+		b []byte) int {
+
+		if num == 1 {
+			return consumeVarint(typ, b, &m.ID)
+		}
+		return skip
+	})
+}
+
+func (m *InstanceRequest) marshal(b []byte) []byte {
+	return appendVarint(b, 1, m.ID)
+}
+
+func (m *InstanceRequest) unmarshal(b []byte) error {
+	*m = InstanceRequest{}
+	return parseFields(b, func(num protowire.Number, typ protowire.Type,
+		b []byte) int {
+
+		if num == 1 {
+			return consumeVarint(typ, b, &m.ID)
+		}
+		return skip
+	})
+}
+
+func (m *StepRequest) marshal(b []byte) []byte {
+	b = appendVarint(b, 1, m.ID)
+	return appendVarint(b, 2, uint64(m.Count))
+}
+
+func (m *StepRequest) unmarshal(b []byte) error {
+	*m = StepRequest{}
+	return parseFields(b, func(num protowire.Number, typ protowire.Type,
+		b []byte) int {
+
+		switch num {
+		case 1:
+			return consumeVarint(typ, b, &m.ID)
+		case 2:
+			return consumeUint32(typ, b, &m.Count)
+		}
+		return skip
+	})
+}
+
+func (m *RunForRequest) marshal(b []byte) []byte {
+	b = appendVarint(b, 1, m.ID)
+	return appendVarint(b, 2, uint64(m.Frames))
+}
+
+func (m *RunForRequest) unmarshal(b []byte) error {
+	*m = RunForRequest{}
+	return parseFields(b, func(num protowire.Number, typ protowire.Type,
+		b []byte) int {
+
+		switch num {
+		case 1:
+			return consumeVarint(typ, b, &m.ID)
+		case 2:
+			return consumeUint32(typ, b, &m.Frames)
+		}
+		return skip
+	})
+}
+
+func (m *SetKeysRequest) marshal(b []byte) []byte {
+	b = appendVarint(b, 1, m.ID)
+	return appendVarint(b, 2, uint64(m.Keys))
+}
+
+func (m *SetKeysRequest) unmarshal(b []byte) error {
+	*m = SetKeysRequest{}
+	return parseFields(b, func(num protowire.Number, typ protowire.Type,
+		b []byte) int {
+
+		switch num {
+		case 1:
+			return consumeVarint(typ, b, &m.ID)
+		case 2:
+			return consumeUint32(typ, b, &m.Keys)
+		}
+		return skip
+	})
+}
+
+func (m *State) marshal(b []byte) []byte {
+	b = appendVarint(b, 1, uint64(m.PC))
+	b = appendVarint(b, 2, uint64(m.I))
+	b = appendBytes(b, 3, m.V)
+	// negative int32s are sign extended to 64 bits
+	b = appendVarint(b, 4, uint64(int64(m.SP)))
+	b = appendPacked(b, 5, m.Stack)
+	b = appendVarint(b, 6, uint64(m.DT))
+	b = appendVarint(b, 7, uint64(m.ST))
+	b = appendVarint(b, 8, uint64(m.Keys))
+	b = appendBool(b, 9, m.WaitingForKey)
+	b = appendString(b, 10, m.Halted)
+	b = appendString(b, 11, m.Error)
+	b = appendVarint(b, 12, m.Cycles)
+	return appendVarint(b, 13, m.Frames)
+}
+
+func (m *State) unmarshal(b []byte) error {
+	*m = State{}
+	return parseFields(b, func(num protowire.Number, typ protowire.Type,
+		b []byte) int {
+
+		switch num {
+		case 1:
+			return consumeUint32(typ, b, &m.PC)
+		case 2:
+			return consumeUint32(typ, b, &m.I)
+		case 3:
+			return consumeBytes(typ, b, &m.V)
+		case 4:
+			return consumeInt32(typ, b, &m.SP)
+		case 5:
+			return consumeRepeated(typ, b, &m.Stack)
+		case 6:
+			return consumeUint32(typ, b, &m.DT)
+		case 7:
+			return consumeUint32(typ, b, &m.ST)
+		case 8:
+			return consumeUint32(typ, b, &m.Keys)
+		case 9:
+			return consumeBool(typ, b, &m.WaitingForKey)
+		case 10:
+			return consumeString(typ, b, &m.Halted)
+		case 11:
+			return consumeString(typ, b, &m.Error)
+		case 12:
+			return consumeVarint(typ, b, &m.Cycles)
+		case 13:
+			return consumeVarint(typ, b, &m.Frames)
+		}
+		return skip
+	})
+}
+
+func (m *Frame) marshal(b []byte) []byte {
+	b = appendVarint(b, 1, uint64(m.Width))
+	b = appendVarint(b, 2, uint64(m.Height))
+	return appendBytes(b, 3, m.Screen)
+}
+
+func (m *Frame) unmarshal(b []byte) error {
+	*m = Frame{}
+	return parseFields(b, func(num protowire.Number, typ protowire.Type,
+		b []byte) int {
+
+		switch num {
+		case 1:
+			return consumeUint32(typ, b, &m.Width)
+		case 2:
+			return consumeUint32(typ, b, &m.Height)
+		case 3:
+			return consumeBytes(typ, b, &m.Screen)
+		}
+		return skip
+	})
+}
+
+func (m *Empty) marshal(b []byte) []byte { return b }
+
+func (m *Empty) unmarshal(b []byte) error {
+	return parseFields(b, func(num protowire.Number, typ protowire.Type,
+		b []byte) int {
+
+		return skip
+	})
+}
@@ -0,0 +1,368 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package remote implements the Emulator gRPC service defined in
+// remote.proto, to run fleets of emulator instances for automated analysis
+// from any language without going through tl-hachi.
+//
+// Every instance runs with FixedTimestep on the null driver, so results only
+// depend on the calls made. Different instances can be driven concurrently.
+//
+// The messages are encoded without generated code, so the service must run
+// on a server created by NewServer (or with the ServerOption returned by
+// Codec). Clients are generated from remote.proto as usual.
+//
+//	log.Fatal(remote.ListenAndServe(":7000"))
+package remote
+
+import (
+	"context"
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"net"
+	"sync"
+)
+
+// A Service is the Emulator service of remote.proto.
+type Service struct {
+	mutex     sync.Mutex
+	instances map[uint64]*instance
+	lastID    uint64
+}
+
+// an instance is an emulator that can only be used by one call at a time
+type instance struct {
+	mutex sync.Mutex
+	c     *hachi.Chip8
+}
+
+// NewService creates a service with no instances.
+func NewService() *Service {
+	return &Service{instances: make(map[uint64]*instance)}
+}
+
+// NewServer creates a gRPC server that serves a new Service.
+func NewServer(opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(append(opts, Codec())...)
+	server.RegisterService(&serviceDesc, NewService())
+	return server
+}
+
+// ListenAndServe serves a new Service on addr until an error occurs.
+func ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return NewServer().Serve(l)
+}
+
+// Codec returns the server option that makes gRPC encode the messages of
+// this package.
+func Codec() grpc.ServerOption { return grpc.ForceServerCodec(codec{}) }
+
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported message type %T.", v)
+	}
+	return m.marshal(nil), nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(message)
+	if !ok {
+		return fmt.Errorf("Unsupported message type %T.", v)
+	}
+	return m.unmarshal(data)
+}
+
+// the messages are compatible with protobuf's
+func (codec) Name() string { return "proto" }
+
+// -----------------------------------------------------------------------------
+
+// get returns the instance with the given id, locked.
+func (s *Service) get(id uint64) (*instance, error) {
+	s.mutex.Lock()
+	inst := s.instances[id]
+	s.mutex.Unlock()
+	if inst == nil {
+		return nil, status.Errorf(codes.NotFound, "No instance %d.", id)
+	}
+	inst.mutex.Lock()
+	return inst, nil
+}
+
+// state returns the state of c, with err as the error if not nil.
+func state(c *hachi.Chip8, err error) *State {
+	stats := c.Stats()
+	s := &State{
+		PC:            uint32(c.PC),
+		I:             uint32(c.I),
+		V:             append([]byte(nil), c.V[:]...),
+		SP:            int32(c.SP),
+		DT:            uint32(c.DT),
+		ST:            uint32(c.ST),
+		Keys:          uint32(c.Keyboard),
+		WaitingForKey: c.WaitingForKey(),
+		Cycles:        stats.Cycles,
+		Frames:        stats.Frames,
+	}
+	for _, addr := range c.Stack {
+		s.Stack = append(s.Stack, uint32(addr))
+	}
+	if herr := c.Halted(); herr != nil {
+		s.Halted = herr.Error()
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	return s
+}
+
+// LoadROM creates an instance running the program, or restarts an existing
+// one with it.
+func (s *Service) LoadROM(ctx context.Context,
+	in *LoadROMRequest) (*LoadROMResponse, error) {
+
+	settings := &hachi.Chip8Settings{
+		Realistic:      true,
+		FixedTimestep:  true,
+		CyclesPerFrame: int(in.CyclesPerFrame),
+	}
+	if in.Variant != "" {
+		v, err := hachi.ParseVariant(in.Variant)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		settings.Variant = v
+	}
+	if in.Quirks != "" {
+		q, err := hachi.ParseQuirks(in.Quirks)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		settings.Quirks = q
+	}
+	if in.Seed != 0 {
+		settings.Rand = rand.NewSource(in.Seed)
+		settings.RandomSeed = uint16(in.Seed)
+	}
+	c, err := hachi.New("null", settings)
+	if err == nil {
+		err = c.LoadRaw(in.ROM)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	if in.ID != 0 {
+		inst, err := s.get(in.ID)
+		if err != nil {
+			return nil, err
+		}
+		inst.c = c
+		inst.mutex.Unlock()
+		return &LoadROMResponse{ID: in.ID}, nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastID++
+	s.instances[s.lastID] = &instance{c: c}
+	return &LoadROMResponse{ID: s.lastID}, nil
+}
+
+// Unload destroys an instance.
+func (s *Service) Unload(ctx context.Context,
+	in *InstanceRequest) (*Empty, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.instances[in.ID] == nil {
+		return nil, status.Errorf(codes.NotFound, "No instance %d.", in.ID)
+	}
+	delete(s.instances, in.ID)
+	return &Empty{}, nil
+}
+
+// Step runs Count instructions (at least one), stopping early on errors and
+// breakpoints.
+func (s *Service) Step(ctx context.Context, in *StepRequest) (*State, error) {
+	inst, err := s.get(in.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer inst.mutex.Unlock()
+	for i := uint32(0); i == 0 || i < in.Count; i++ {
+		if err = inst.c.Step(); err != nil {
+			break
+		}
+	}
+	return state(inst.c, err), nil
+}
+
+// RunFor runs Frames frames, stopping early on errors and breakpoints.
+func (s *Service) RunFor(ctx context.Context,
+	in *RunForRequest) (*State, error) {
+
+	inst, err := s.get(in.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer inst.mutex.Unlock()
+	for i := uint32(0); i < in.Frames; i++ {
+		if err = inst.c.AdvanceFrame(); err != nil {
+			break
+		}
+		// long runs can be cancelled by the client
+		if err = ctx.Err(); err != nil {
+			break
+		}
+	}
+	return state(inst.c, err), nil
+}
+
+// GetState returns the state of an instance.
+func (s *Service) GetState(ctx context.Context,
+	in *InstanceRequest) (*State, error) {
+
+	inst, err := s.get(in.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer inst.mutex.Unlock()
+	return state(inst.c, nil), nil
+}
+
+// SetKeys sets the keys that are held down.
+func (s *Service) SetKeys(ctx context.Context,
+	in *SetKeysRequest) (*State, error) {
+
+	inst, err := s.get(in.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer inst.mutex.Unlock()
+	// the null driver doesn't touch Keyboard, so it stays as set
+	inst.c.Keyboard = uint16(in.Keys)
+	return state(inst.c, nil), nil
+}
+
+// GetFrame returns the screen of an instance.
+func (s *Service) GetFrame(ctx context.Context,
+	in *InstanceRequest) (*Frame, error) {
+
+	inst, err := s.get(in.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer inst.mutex.Unlock()
+	w, h := inst.c.DisplaySize()
+	return &Frame{
+		Width:  uint32(w),
+		Height: uint32(h),
+		Screen: append([]byte(nil), inst.c.DisplayScreen()...),
+	}, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// newRequest returns an empty request for every method of the service.
+var newRequest = map[string]func() message{
+	"LoadROM":  func() message { return &LoadROMRequest{} },
+	"Unload":   func() message { return &InstanceRequest{} },
+	"Step":     func() message { return &StepRequest{} },
+	"RunFor":   func() message { return &RunForRequest{} },
+	"GetState": func() message { return &InstanceRequest{} },
+	"SetKeys":  func() message { return &SetKeysRequest{} },
+	"GetFrame": func() message { return &InstanceRequest{} },
+}
+
+// call runs a method with a request created by newRequest.
+func (s *Service) call(ctx context.Context, method string,
+	in message) (message, error) {
+
+	switch method {
+	case "LoadROM":
+		return s.LoadROM(ctx, in.(*LoadROMRequest))
+	case "Unload":
+		return s.Unload(ctx, in.(*InstanceRequest))
+	case "Step":
+		return s.Step(ctx, in.(*StepRequest))
+	case "RunFor":
+		return s.RunFor(ctx, in.(*RunForRequest))
+	case "GetState":
+		return s.GetState(ctx, in.(*InstanceRequest))
+	case "SetKeys":
+		return s.SetKeys(ctx, in.(*SetKeysRequest))
+	case "GetFrame":
+		return s.GetFrame(ctx, in.(*InstanceRequest))
+	}
+	return nil, status.Errorf(codes.Unimplemented, "No method %s.", method)
+}
+
+// handler returns the grpc handler of a method, like the ones protoc
+// generates.
+func handler(method string) func(srv interface{}, ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/hachi.Emulator/" + method}
+	return func(srv interface{}, ctx context.Context,
+		dec func(interface{}) error,
+		interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+
+		in := newRequest[method]()
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		call := func(ctx context.Context, req interface{}) (interface{},
+			error) {
+
+			return srv.(*Service).call(ctx, method, req.(message))
+		}
+		if interceptor == nil {
+			return call(ctx, in)
+		}
+		info := *info
+		info.Server = srv
+		return interceptor(ctx, in, &info, call)
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "hachi.Emulator",
+	HandlerType: (*Service)(nil),
+	Metadata:    "remote.proto",
+}
+
+func init() {
+	for _, method := range []string{"LoadROM", "Unload", "Step", "RunFor",
+		"GetState", "SetKeys", "GetFrame"} {
+
+		serviceDesc.Methods = append(serviceDesc.Methods, grpc.MethodDesc{
+			MethodName: method,
+			Handler:    handler(method),
+		})
+	}
+}
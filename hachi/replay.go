@@ -0,0 +1,220 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// An InputEvent is a change of the keyboard, stamped with the cycle it was
+// polled at. Cycles count every time the emulator polls the driver, which is
+// once per instruction and also while LD VX,K waits for a key, so events
+// that happen during a key wait replay at the same point.
+type InputEvent struct {
+	Cycle uint64
+	Keys  uint16
+}
+
+// An InputRecording is the keyboard input of a session, see RecordInput.
+// Replaying it with the same program, settings and random seed reproduces
+// the session exactly, for regression tests of games and bug reports.
+//
+// Recordings are saved as text, with the seed, the length of the recording
+// in cycles and one cycle:keys event per line, where keys is a list of hex
+// keypad digits or - for no keys (like the headless driver's key scripts):
+//
+//	seed 42
+//	cycles 5400
+//	1200:5
+//	1236:-
+type InputRecording struct {
+	// Seed of the random number generators, see Settings.
+	Seed int64
+	// Cycles is how long the recording lasts. The replay keeps control of
+	// the keyboard until then.
+	Cycles uint64
+	Events []InputEvent
+}
+
+// Settings returns a copy of base with the random number generators seeded
+// with the recording's seed, which is needed to replay it faithfully.
+func (r *InputRecording) Settings(base *Chip8Settings) *Chip8Settings {
+	s := *base
+	s.Rand = rand.NewSource(r.Seed)
+	s.RandomSeed = uint16(r.Seed)
+	return &s
+}
+
+// WriteTo saves the recording in the text format.
+func (r *InputRecording) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "seed %d\ncycles %d\n", r.Seed, r.Cycles)
+	for _, e := range r.Events {
+		keys := ""
+		for i, flag := range KeyFlags {
+			if e.Keys&flag != 0 {
+				keys += strconv.FormatInt(int64(i), 16)
+			}
+		}
+		if keys == "" {
+			keys = "-"
+		}
+		fmt.Fprintf(&b, "%d:%s\n", e.Cycle, strings.ToUpper(keys))
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ReadInputRecording reads a recording saved by WriteTo.
+func ReadInputRecording(r io.Reader) (*InputRecording, error) {
+	rec := &InputRecording{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if err := rec.parseLine(text); err != nil {
+			return nil, fmt.Errorf("Input recording line %d: %v", line, err)
+		}
+	}
+	return rec, scanner.Err()
+}
+
+func (rec *InputRecording) parseLine(text string) (err error) {
+	switch {
+	case text == "" || strings.HasPrefix(text, "#"):
+		return
+	case strings.HasPrefix(text, "seed "):
+		rec.Seed, err = strconv.ParseInt(text[5:], 10, 64)
+		return
+	case strings.HasPrefix(text, "cycles "):
+		rec.Cycles, err = strconv.ParseUint(text[7:], 10, 64)
+		return
+	}
+
+	parts := strings.SplitN(text, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("Invalid input event '%s'.", text)
+	}
+	var e InputEvent
+	if e.Cycle, err = strconv.ParseUint(parts[0], 10, 64); err != nil {
+		return fmt.Errorf("Invalid cycle in '%s'.", text)
+	}
+	n := len(rec.Events)
+	if n != 0 && e.Cycle < rec.Events[n-1].Cycle {
+		return fmt.Errorf("Input event '%s' is out of order.", text)
+	}
+	if parts[1] != "-" {
+		for _, r := range parts[1] {
+			key, perr := strconv.ParseUint(string(r), 16, 4)
+			if perr != nil {
+				return fmt.Errorf("Invalid key '%c' in '%s'.", r, text)
+			}
+			e.Keys |= KeyFlags[key]
+		}
+	}
+	rec.Events = append(rec.Events, e)
+	return
+}
+
+// inputLog records or replays the keyboard, see RecordInput and
+// ReplayInput.
+type inputLog struct {
+	rec    *InputRecording
+	replay bool
+	cycle  uint64
+	next   int // next event to replay
+}
+
+// RecordInput starts recording the keyboard into a new recording, which is
+// returned and grows until StopInput is called. seed is saved in it and
+// should be the seed of the random number generators (see
+// InputRecording.Settings).
+// Recordings are meant to start right after Load or Reset, and to be
+// replayed from the same point. Rewinding or changing the machine state
+// while recording makes the replay diverge.
+func (c *Chip8) RecordInput(seed int64) *InputRecording {
+	rec := &InputRecording{Seed: seed}
+	c.input = &inputLog{rec: rec}
+	if c.Keyboard != 0 {
+		rec.Events = append(rec.Events, InputEvent{0, c.Keyboard})
+	}
+	return rec
+}
+
+// ReplayInput makes the keyboard follow rec, ignoring the driver's input,
+// until rec.Cycles cycles have passed. The emulator should be in the state
+// the recording started from, with the settings returned by rec.Settings.
+// Keys pressed in the same cycle are pressed lowest first.
+func (c *Chip8) ReplayInput(rec *InputRecording) {
+	c.input = &inputLog{rec: rec, replay: true}
+	c.ReleaseKey(0xFFFF)
+}
+
+// StopInput stops recording or replaying. A recording ends at the current
+// cycle.
+func (c *Chip8) StopInput() {
+	if c.input != nil && !c.input.replay {
+		c.input.rec.Cycles = c.input.cycle
+	}
+	c.input = nil
+}
+
+// Replaying returns true while a recording is being replayed.
+func (c *Chip8) Replaying() bool { return c.input != nil && c.input.replay }
+
+// Recording returns true while the keyboard is being recorded.
+func (c *Chip8) Recording() bool { return c.input != nil && !c.input.replay }
+
+// pollInput records or replays the keyboard, once per cycle, after the
+// driver updated it.
+func (c *Chip8) pollInput() {
+	in := c.input
+	if in == nil {
+		return
+	}
+	rec := in.rec
+	if !in.replay {
+		n := len(rec.Events)
+		last := uint16(0)
+		if n != 0 {
+			last = rec.Events[n-1].Keys
+		}
+		if c.Keyboard != last {
+			rec.Events = append(rec.Events, InputEvent{in.cycle, c.Keyboard})
+		}
+		in.cycle++
+		return
+	}
+
+	if in.cycle >= rec.Cycles {
+		// hand the keyboard back to the driver
+		c.input = nil
+		return
+	}
+	keys := c.lastKeyboard
+	for in.next < len(rec.Events) && rec.Events[in.next].Cycle <= in.cycle {
+		keys = rec.Events[in.next].Keys
+		in.next++
+	}
+	c.Keyboard = c.lastKeyboard
+	c.ReleaseKey(^keys)
+	c.PressKey(keys)
+	in.cycle++
+}
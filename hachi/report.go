@@ -0,0 +1,92 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// A DisassemblyReport is the disassembly of a program loaded at Start, which
+// serializes to JSON for web tools and scripts.
+type DisassemblyReport struct {
+	Start        uint16
+	Instructions []Instruction
+}
+
+// reportEntry is the JSON form of one instruction
+type reportEntry struct {
+	Address     int      `json:"address"`
+	Size        int      `json:"size"`
+	Opcode      uint16   `json:"opcode"`
+	Label       string   `json:"label,omitempty"`
+	Mnemonic    string   `json:"mnemonic"`
+	Operands    []string `json:"operands"`
+	Comment     string   `json:"comment,omitempty"`
+	Description string   `json:"description"`
+	ASCII       string   `json:"ascii,omitempty"`
+	Notes       string   `json:"notes,omitempty"`
+}
+
+// MarshalJSON serializes the report as an object with the start address and
+// an array of instructions, each with its address, size, opcode, label (see
+// ApplyLabels), mnemonic, operands, comment (such as CLS for SYS 0E0),
+// description, ASCII representation and notes (see Annotate). The
+// mnemonic and operands are split from the instruction's pseudo-asm.
+func (r DisassemblyReport) MarshalJSON() ([]byte, error) {
+	entries := make([]reportEntry, len(r.Instructions))
+	notes := Annotate(r.Instructions, r.Start)
+	address := int(r.Start)
+	for n, in := range r.Instructions {
+		e := &entries[n]
+		e.Address, e.Size, e.Opcode = address, in.Size(), in.Opcode()
+		e.Description, e.ASCII, e.Notes = in.Description(), in.ASCII(),
+			notes[n]
+		e.Label, e.Mnemonic, e.Operands, e.Comment = splitPseudoCode(in)
+		address += in.Size()
+	}
+	return json.Marshal(struct {
+		Start        uint16        `json:"start"`
+		Instructions []reportEntry `json:"instructions"`
+	}{r.Start, entries})
+}
+
+// splitPseudoCode splits the pseudo-asm of an instruction into its parts.
+func splitPseudoCode(in Instruction) (label, mnemonic string,
+	operands []string, comment string) {
+
+	text := in.String()
+	if i := strings.Index(text, " ("); i >= 0 && strings.HasSuffix(text, ")") {
+		text, comment = text[:i], text[i+2:len(text)-1]
+	}
+	if i := strings.Index(text, ": "); i >= 0 {
+		label, text = text[:i], text[i+2:]
+	}
+	fields := strings.SplitN(text, " ", 2)
+	mnemonic = fields[0]
+	operands = []string{}
+	if len(fields) < 2 {
+		return
+	}
+	sep := ","
+	if _, raw := in.(*RawData); raw {
+		sep = " "
+	}
+	for _, op := range strings.Split(fields[1], sep) {
+		operands = append(operands, strings.TrimSpace(op))
+	}
+	return
+}
@@ -0,0 +1,90 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import "fmt"
+
+// rewindBuffer is a ring buffer of the most recent states, see
+// Chip8Settings.RewindDepth.
+type rewindBuffer struct {
+	states   []*State
+	start    int // oldest state
+	count    int
+	interval int
+	frames   int // frames since the last snapshot
+}
+
+func newRewindBuffer(depth, interval int) *rewindBuffer {
+	if interval < 1 {
+		interval = 1
+	}
+	return &rewindBuffer{states: make([]*State, depth), interval: interval}
+}
+
+// tick takes a snapshot every interval frames, overwriting the oldest one
+// when the buffer is full. The buffers of the overwritten state are reused so
+// snapshots don't allocate once the buffer is full.
+func (r *rewindBuffer) tick(c *Chip8) {
+	r.frames++
+	if r.frames < r.interval {
+		return
+	}
+	r.frames = 0
+	i := (r.start + r.count) % len(r.states)
+	if r.count == len(r.states) {
+		r.start = (r.start + 1) % len(r.states)
+	} else {
+		r.count++
+	}
+	if r.states[i] == nil {
+		r.states[i] = &State{}
+	}
+	c.snapshotInto(r.states[i])
+}
+
+// Rewind goes back about frames 60hz frames, to the closest snapshot at or
+// before that point, or to the oldest one if there isn't enough history. The
+// snapshots newer than the restored one are dropped, so calling Rewind
+// repeatedly keeps going back, for example while a rewind key is held.
+// Returns an error if rewinding is disabled or there's no history left.
+func (c *Chip8) Rewind(frames int) error {
+	r := c.rewind
+	if r == nil {
+		return fmt.Errorf("Rewinding is disabled, see RewindDepth.")
+	}
+	if r.count == 0 {
+		return fmt.Errorf("No rewind history left.")
+	}
+	// the newest snapshot is r.frames frames old
+	back := 1
+	if frames > r.frames {
+		back += (frames - r.frames + r.interval - 1) / r.interval
+	}
+	if back > r.count {
+		back = r.count
+	}
+	r.count -= back
+	r.frames = 0
+	return c.Restore(r.states[(r.start+r.count)%len(r.states)])
+}
+
+// RewindFrames returns how many frames back Rewind can go.
+func (c *Chip8) RewindFrames() int {
+	if c.rewind == nil || c.rewind.count == 0 {
+		return 0
+	}
+	return (c.rewind.count-1)*c.rewind.interval + c.rewind.frames
+}
@@ -0,0 +1,91 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+)
+
+// RomMetadata is what a ROM database knows about a program.
+type RomMetadata struct {
+	Title       string
+	Authors     []string
+	Release     string
+	Description string
+}
+
+// RomInfo describes a program that was just loaded, see LoadDriver.
+type RomInfo struct {
+	// Path is the file the program was loaded from, empty for LoadRaw.
+	Path string
+	// Size of the program in bytes.
+	Size int
+	// Hash is the hex SHA-1 of the program.
+	Hash string
+	// Base is the address the program was loaded at.
+	Base uint16
+	// Metadata is the program's entry in the ROM database set through
+	// Chip8Settings.RomLookup, or nil if it's unknown.
+	Metadata *RomMetadata
+}
+
+// Title returns the program's title from the metadata, or its file name if
+// it's unknown.
+func (info *RomInfo) Title() string {
+	if info.Metadata != nil && info.Metadata.Title != "" {
+		return info.Metadata.Title
+	}
+	if info.Path == "" {
+		return ""
+	}
+	base := filepath.Base(info.Path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// A RomLookupFunc returns the metadata for a program, or nil if it's
+// unknown. Every field of info except Metadata is set.
+type RomLookupFunc func(info *RomInfo) *RomMetadata
+
+// A LoadDriver is a Driver that wants to know which program is loaded, for
+// example to set the window title or pick a key layout.
+type LoadDriver interface {
+	Driver
+	// Called by Load and LoadRaw after the program is in memory.
+	OnLoad(c *Chip8, info RomInfo)
+}
+
+// onLoad builds the RomInfo for program and hands it to the driver.
+func (c *Chip8) onLoad(path string, program []byte) {
+	c.programPath = path
+	d, ok := drivers[c.driver].(LoadDriver)
+	if !ok {
+		return
+	}
+	sum := sha1.Sum(program)
+	info := RomInfo{
+		Path: path,
+		Size: len(program),
+		Hash: hex.EncodeToString(sum[:]),
+		Base: c.StartAddress(),
+	}
+	if c.romLookup != nil {
+		info.Metadata = c.romLookup(&info)
+	}
+	d.OnLoad(c, info)
+}
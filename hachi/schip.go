@@ -0,0 +1,182 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import "fmt"
+
+// An ExitErr is returned when a SUPER-CHIP program exits through 00FD.
+type ExitErr struct{}
+
+func (e *ExitErr) Error() string {
+	return "Program exited."
+}
+
+// address of the SUPER-CHIP big font, right after the small font
+const schipFontStart = 0x50
+
+// schipFont holds the SUPER-CHIP 8x10 sprites for the digits 0-9, which are
+// pointed to by LD HF,VX.
+var schipFont = []byte{
+	0x3C, 0x7E, 0xE7, 0xC3, 0xC3, 0xC3, 0xC3, 0xE7, 0x7E, 0x3C, // 0
+	0x18, 0x38, 0x58, 0x18, 0x18, 0x18, 0x18, 0x18, 0x18, 0x3C, // 1
+	0x3E, 0x7F, 0xC3, 0x06, 0x0C, 0x18, 0x30, 0x60, 0xFF, 0xFF, // 2
+	0x3C, 0x7E, 0xC3, 0x03, 0x0E, 0x0E, 0x03, 0xC3, 0x7E, 0x3C, // 3
+	0x06, 0x0E, 0x1E, 0x36, 0x66, 0xC6, 0xFF, 0xFF, 0x06, 0x06, // 4
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFC, 0xFE, 0x03, 0xC3, 0x7E, 0x3C, // 5
+	0x3E, 0x7C, 0xE0, 0xC0, 0xFC, 0xFE, 0xC3, 0xC3, 0x7E, 0x3C, // 6
+	0xFF, 0xFF, 0x03, 0x06, 0x0C, 0x18, 0x30, 0x60, 0x60, 0x60, // 7
+	0x3C, 0x7E, 0xC3, 0xC3, 0x7E, 0x7E, 0xC3, 0xC3, 0x7E, 0x3C, // 8
+	0x3C, 0x7E, 0xC3, 0xC3, 0x7F, 0x3F, 0x03, 0x03, 0x3E, 0x7C, // 9
+}
+
+// initSChip loads the big font and starts in low resolution.
+func (c *Chip8) initSChip() {
+	copy(c.Memory[schipFontStart:], schipFont)
+	c.hires = false
+	c.rpl = [8]uint8{}
+}
+
+// Hires returns true if a SUPER-CHIP program switched to the 128x64 mode.
+// The screen buffer is always 128x64, in low resolution every pixel is
+// drawn as a 2x2 block.
+func (c *Chip8) Hires() bool { return c.hires }
+
+// schipSys executes the SUPER-CHIP machine code calls. Returns false if sys
+// isn't one of them.
+func (c *Chip8) schipSys(sys uint16) (bool, error) {
+	switch {
+	case sys == 0x0FE: // LOW
+		c.hires = false
+		c.clearScreen()
+	case sys == 0x0FF: // HIGH
+		c.hires = true
+		c.clearScreen()
+	case sys == 0x0FB: // SCR
+		c.scroll(4, 0)
+	case sys == 0x0FC: // SCL
+		c.scroll(-4, 0)
+	case sys&0xFF0 == 0x0C0: // SCD N
+		c.scroll(0, int(sys&0xF))
+	case sys == 0x0FD: // EXIT
+		return true, &ExitErr{}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// scroll moves the screen by dx, dy pixels of the 128x64 display, like the
+// original SUPER-CHIP 1.1 which scrolls by half a pixel in low resolution.
+// Pixels scrolled in are blank.
+func (c *Chip8) scroll(dx, dy int) {
+	w, h := int(c.Width), int(c.Height)
+	old := append([]byte(nil), c.Screen...)
+	for i := range c.Screen {
+		c.Screen[i] = 0
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := x-dx, y-dy
+			if sx < 0 || sx >= w || sy < 0 || sy >= h {
+				continue
+			}
+			if old[sy*(w/8)+sx/8]&(0x80>>uint(sx%8)) != 0 {
+				c.Screen[y*(w/8)+x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+	}
+	if c.decay != nil {
+		c.decay.draw(c.Screen)
+	}
+	c.updateScreen()
+}
+
+// schipDraw executes DRW VX,VY,N for SUPER-CHIP. DRW VX,VY,0 draws a 16x16
+// sprite (2 bytes per row) in both resolutions, like Octo does. Sprites are
+// clipped at the edges of the screen instead of wrapping around, only the
+// starting coordinates wrap.
+func (c *Chip8) schipDraw(opcode []byte) error {
+	rows, width := int(opcode[1]&0x0F), 1
+	if rows == 0 {
+		rows, width = 16, 2
+	}
+	err := c.checkAccess(fmt.Sprintf("DRW V%X,V%X,%X", opcode[0]&0x0F,
+		opcode[1]>>4, opcode[1]&0x0F), rows*width, false)
+	if err != nil {
+		return err
+	}
+
+	scale := 2
+	if c.hires {
+		scale = 1
+	}
+	w, h := int(c.Width)/scale, int(c.Height)/scale
+	x0 := int(c.V[opcode[0]&0x0F]) % w
+	y0 := int(c.V[opcode[1]&0xF0>>4]) % h
+	if c.flicker != nil {
+		c.flicker.draw(c.PC-2, c.I, uint8(x0), uint8(y0), uint8(rows))
+	}
+
+	byteWidth := int(c.Width) / 8
+	c.V[0xF] = 0
+	for row := 0; row < rows && y0+row < h; row++ {
+		var bits uint16
+		for b := 0; b < width; b++ {
+			if addr := c.readAddr(row*width+b, false); addr >= 0 {
+				bits |= uint16(c.Memory[addr]) << uint(8*(width-1-b))
+			}
+		}
+		for col := 0; col < 8*width && x0+col < w; col++ {
+			if bits&(1<<uint(8*width-1-col)) == 0 {
+				continue
+			}
+			for sy := 0; sy < scale; sy++ {
+				for sx := 0; sx < scale; sx++ {
+					x := (x0+col)*scale + sx
+					y := (y0+row)*scale + sy
+					i := y*byteWidth + x/8
+					mask := uint8(0x80) >> uint(x%8)
+					if c.Screen[i]&mask != 0 {
+						c.V[0xF] = 1
+					}
+					c.Screen[i] ^= mask
+				}
+			}
+		}
+	}
+
+	if c.decay != nil {
+		c.decay.draw(c.Screen)
+	}
+	c.updateScreen()
+	return nil
+}
+
+// schipFlags executes LD R,VX (save is true) and LD VX,R, which copy V0 to VX
+// to and from the 8 RPL user flags of the HP48.
+func (c *Chip8) schipFlags(x uint8, save bool) error {
+	if x > 7 {
+		return &BadCodeErr{}
+	}
+	for i := uint8(0); i <= x; i++ {
+		if save {
+			c.rpl[i] = c.V[i]
+		} else {
+			c.V[i] = c.rpl[i]
+		}
+	}
+	return nil
+}
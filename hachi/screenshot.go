@@ -0,0 +1,89 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// ScreenshotOptions configures WriteScreenshot.
+type ScreenshotOptions struct {
+	// Scale is the size of each pixel of DisplayScreen in the image.
+	// Defaults to 1.
+	Scale int
+	// Foreground and Background are the colors of lit and unlit pixels. If
+	// nil, the variant's colors are used (see PixelColor). They don't apply
+	// to the MegaChip color mode.
+	Foreground, Background color.Color
+}
+
+// Screenshot writes the screen, as shown by the drivers, to w as a PNG image
+// with one pixel per CHIP-8 pixel and the variant's colors.
+func (c *Chip8) Screenshot(w io.Writer) error {
+	return c.WriteScreenshot(w, nil)
+}
+
+// WriteScreenshot is Screenshot with a configurable scale and colors. A nil
+// opts uses the defaults.
+func (c *Chip8) WriteScreenshot(w io.Writer, opts *ScreenshotOptions) error {
+	if opts == nil {
+		opts = &ScreenshotOptions{}
+	}
+	scale := opts.Scale
+	if scale <= 0 {
+		scale = 1
+	}
+
+	display := c.DisplayImage()
+	if c.MegaChip() || (opts.Foreground == nil && opts.Background == nil) {
+		return png.Encode(w, scaleImage(display, scale))
+	}
+
+	width, height := c.DisplaySize()
+	screen := NewBitmap(c.DisplayScreen(), width, height)
+	img := image.NewRGBA(image.Rect(0, 0, width*scale, height*scale))
+	for y := 0; y < height*scale; y++ {
+		for x := 0; x < width*scale; x++ {
+			col := display.At(x/scale, y/scale)
+			switch lit := screen.Lit(x/scale, y/scale); {
+			case lit && opts.Foreground != nil:
+				col = opts.Foreground
+			case !lit && opts.Background != nil:
+				col = opts.Background
+			}
+			img.Set(x, y, col)
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// scaleImage returns img with each pixel drawn as a scale x scale block.
+func scaleImage(img image.Image, scale int) image.Image {
+	if scale == 1 {
+		return img
+	}
+	b := img.Bounds()
+	res := image.NewRGBA(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale))
+	for y := 0; y < b.Dy()*scale; y++ {
+		for x := 0; x < b.Dx()*scale; x++ {
+			res.Set(x, y, img.At(b.Min.X+x/scale, b.Min.Y+y/scale))
+		}
+	}
+	return res
+}
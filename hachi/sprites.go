@@ -0,0 +1,128 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"image"
+	"image/color"
+	"sort"
+	"strings"
+)
+
+// A Sprite is sprite data found in a program by FindSprites.
+type Sprite struct {
+	Address uint16
+	// Width is 8, or 16 for the 16x16 sprites drawn by DRW VX,VY,0 in
+	// SUPER-CHIP.
+	Width  int
+	Height int
+	// Data holds Height rows of Width/8 bytes each.
+	Data []byte
+}
+
+// FindSprites looks for LD I,NNN followed by DRW in the code of a program
+// loaded at start (see Disassemble) and returns the sprites they draw, sorted
+// by address. When I is loaded conditionally, the LD I that was found is
+// taken. Sprites drawn with several heights are returned with the tallest,
+// sprites that aren't entirely in the program are left out.
+func FindSprites(program []byte, start uint16) (res []Sprite) {
+	disassembly := Disassemble(program, start)
+	xrefs := CrossReferences(disassembly, start)
+	found := map[uint16]int{} // address to height
+
+	i := -1 // known value of I
+	address := start
+	for _, in := range disassembly {
+		for _, r := range xrefs[address] {
+			if r.Kind != XRefLoad {
+				// anything can happen to I before jumping here
+				i = -1
+			}
+		}
+		address += uint16(in.Size())
+
+		switch in := in.(type) {
+		case LdI:
+			i = int(in.Value())
+		case Drw:
+			height := int(in.Rows())
+			if height == 0 {
+				height = 16
+			}
+			if i >= 0 && height > found[uint16(i)] {
+				found[uint16(i)] = height
+			}
+		case AddI, LdFont, LdBigFont, LdSetMemory, LdMemory, Call:
+			i = -1
+		}
+	}
+
+	for addr, height := range found {
+		width := 8
+		if height == 16 {
+			width = 16
+		}
+		offset := int(addr) - int(start)
+		size := height * width / 8
+		if offset < 0 || offset+size > len(program) {
+			continue
+		}
+		res = append(res, Sprite{addr, width, height,
+			program[offset : offset+size]})
+	}
+	sort.Slice(res, func(a, b int) bool {
+		return res[a].Address < res[b].Address
+	})
+	return
+}
+
+// at returns true if the pixel at x, y is lit.
+func (s *Sprite) at(x, y int) bool {
+	b := s.Data[y*s.Width/8+x/8]
+	return b&(0x80>>uint(x%8)) != 0
+}
+
+// Text renders the sprite as ASCII art, one line per row, with # for lit
+// pixels and . for unlit ones.
+func (s Sprite) Text() string {
+	var b strings.Builder
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; x++ {
+			if s.at(x, y) {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Image renders the sprite as a black and white bitmap, one pixel per sprite
+// pixel.
+func (s Sprite) Image() image.Image {
+	img := image.NewPaletted(image.Rect(0, 0, s.Width, s.Height),
+		color.Palette{color.Black, color.White})
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; x++ {
+			if s.at(x, y) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
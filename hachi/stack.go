@@ -0,0 +1,117 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// A SubroutineDepth holds how deep the stack got in calls to a subroutine.
+type SubroutineDepth struct {
+	Address uint16
+	Calls   uint64
+	// MaxDepth is the deepest the stack was right after calling the
+	// subroutine, counting its own return address.
+	MaxDepth int
+}
+
+// StackStats are statistics about the stack usage of a program, meant to
+// check that it fits the 12 levels of the original interpreter when it's
+// developed with a larger StackSize.
+type StackStats struct {
+	// MaxDepth is the deepest the stack has been.
+	MaxDepth int
+	// Warning is the StackWarning setting, 0 if disabled.
+	Warning int
+	// Subroutines lists every subroutine that was called, from the deepest.
+	Subroutines []SubroutineDepth
+}
+
+// Exceeded returns true if the stack went deeper than the warning
+// threshold.
+func (s *StackStats) Exceeded() bool {
+	return s.Warning > 0 && s.MaxDepth > s.Warning
+}
+
+// stackTracker collects StackStats.
+type stackTracker struct {
+	maxDepth int
+	subs     map[uint16]*SubroutineDepth
+}
+
+func newStackTracker() *stackTracker {
+	return &stackTracker{subs: make(map[uint16]*SubroutineDepth)}
+}
+
+// trackCall records a call to target which was just pushed on the stack.
+func (c *Chip8) trackCall(target uint16) {
+	t := c.stack
+	depth := c.SP + 1
+	sub := t.subs[target]
+	if sub == nil {
+		sub = &SubroutineDepth{Address: target}
+		t.subs[target] = sub
+	}
+	sub.Calls++
+	if depth > sub.MaxDepth {
+		sub.MaxDepth = depth
+	}
+	if depth <= t.maxDepth {
+		return
+	}
+	t.maxDepth = depth
+	if c.stackWarning > 0 && depth > c.stackWarning {
+		c.logger.Printf("Warning: stack depth %d exceeds %d in call to "+
+			"%03X from %03X.", depth, c.stackWarning, target, c.Stack[c.SP]-2)
+	}
+}
+
+// StackStats returns the stack usage statistics collected since the
+// emulator was created.
+func (c *Chip8) StackStats() StackStats {
+	res := StackStats{MaxDepth: c.stack.maxDepth, Warning: c.stackWarning}
+	for _, sub := range c.stack.subs {
+		res.Subroutines = append(res.Subroutines, *sub)
+	}
+	sort.Slice(res.Subroutines, func(i, j int) bool {
+		a, b := res.Subroutines[i], res.Subroutines[j]
+		if a.MaxDepth != b.MaxDepth {
+			return a.MaxDepth > b.MaxDepth
+		}
+		return a.Address < b.Address
+	})
+	return res
+}
+
+// WriteStackStats writes stack statistics as a table.
+func WriteStackStats(w io.Writer, s StackStats) error {
+	fmt.Fprintf(w, "max stack depth: %d", s.MaxDepth)
+	if s.Exceeded() {
+		fmt.Fprintf(w, " (exceeds %d)", s.Warning)
+	}
+	fmt.Fprintln(w)
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, "addr\tcalls\tmax depth\t")
+	for _, sub := range s.Subroutines {
+		fmt.Fprintf(tw, "%04X\t%d\t%d\t\n", sub.Address, sub.Calls,
+			sub.MaxDepth)
+	}
+	return tw.Flush()
+}
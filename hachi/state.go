@@ -0,0 +1,149 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import "fmt"
+
+// State is a snapshot of the emulator's state at a given point in time.
+// All of its fields are copies, so it can be freely read (for example from a
+// UI goroutine) while the emulator keeps running.
+type State struct {
+	V             [16]uint8
+	I             uint16
+	Stack         []uint16
+	SP            int
+	PC            uint16
+	DT, ST        uint8
+	Keyboard      uint16
+	Screen        []byte
+	Width, Height uint8
+	Memory        []byte
+	// WaitingForKey is true if the program is blocked on LD VX,K, which
+	// stores the key in WaitRegister.
+	WaitingForKey bool
+	WaitRegister  uint8
+	// CHIP-8X only, see Chip8.
+	Colors     []uint8
+	Background uint8
+	// SUPER-CHIP only, see Chip8.Hires.
+	Hires bool
+	RPL   [8]uint8
+	// MegaChip only, see Chip8.MegaChip. The rest of the MegaChip state
+	// (palette, color screen, sprite size, blending and sample) is saved
+	// but not exposed.
+	MegaChip bool
+	mega     *megaChip
+}
+
+// Snapshot returns a copy of the current state of the emulator.
+// This is not thread-safe by itself, so it should be called from the same
+// goroutine that calls Tick(), but the returned State is safe to pass around.
+func (c *Chip8) Snapshot() *State {
+	return c.snapshotInto(&State{})
+}
+
+// snapshotInto is Snapshot, except that it reuses the buffers of s when they
+// are big enough. Returns s.
+func (c *Chip8) snapshotInto(s *State) *State {
+	colors, mega := s.Colors[:0], s.mega
+	*s = State{
+		V:          c.V,
+		I:          c.I,
+		Stack:      append(s.Stack[:0], c.Stack...),
+		SP:         c.SP,
+		PC:         c.PC,
+		DT:         c.DT,
+		ST:         c.ST,
+		Keyboard:   c.Keyboard,
+		Screen:     append(s.Screen[:0], c.Screen...),
+		Memory:     append(s.Memory[:0], c.Memory...),
+		Background: c.Background,
+		Hires:      c.hires,
+		RPL:        c.rpl,
+		Width:      c.Width, Height: c.Height,
+	}
+	if c.Colors != nil {
+		s.Colors = append(colors, c.Colors...)
+	}
+	if c.mega != nil {
+		s.MegaChip = c.mega.on
+		s.mega = c.mega.copyInto(mega)
+	}
+	if c.wii != nil {
+		s.WaitingForKey = true
+		s.WaitRegister = c.wii.register
+	}
+	return s
+}
+
+// Restore puts the emulator back in a state taken by Snapshot, which works as
+// a save state. The state must come from an emulator with the same settings.
+// A halted emulator runs again after restoring a state, and the keys held
+// at the time of the snapshot are ignored in favor of the current ones.
+// Returns an error if the state doesn't fit this emulator.
+func (c *Chip8) Restore(s *State) error {
+	switch {
+	case len(s.Memory) != len(c.Memory):
+		return fmt.Errorf("State has %d bytes of memory, expected %d.",
+			len(s.Memory), len(c.Memory))
+	case len(s.Stack) != len(c.Stack):
+		return fmt.Errorf("State has %d stack levels, expected %d.",
+			len(s.Stack), len(c.Stack))
+	case s.Width != c.Width || s.Height != c.Height:
+		return fmt.Errorf("State has a %dx%d screen, expected %dx%d.",
+			s.Width, s.Height, c.Width, c.Height)
+	case len(s.Colors) != len(c.Colors):
+		return fmt.Errorf("State has %d color blocks, expected %d.",
+			len(s.Colors), len(c.Colors))
+	case (s.mega == nil) != (c.mega == nil):
+		return fmt.Errorf("State and emulator disagree on MegaChip.")
+	}
+
+	// the screen and stack can live in memory, so memory goes first
+	copy(c.Memory, s.Memory)
+	c.V = s.V
+	c.I = s.I
+	copy(c.Stack, s.Stack)
+	c.SP = s.SP
+	c.PC = s.PC
+	c.DT = s.DT
+	c.setST(s.ST)
+	copy(c.Screen, s.Screen)
+	copy(c.Colors, s.Colors)
+	c.Background = s.Background
+	c.hires = s.Hires
+	c.rpl = s.RPL
+	if c.mega != nil {
+		c.restoreMegaChip(s.mega)
+	}
+	c.wii = nil
+	if s.WaitingForKey {
+		c.wii = &waitInputInfo{register: s.WaitRegister & 0xF,
+			zeroBits: ^c.Keyboard}
+	}
+	c.halted = nil
+
+	if c.decay != nil {
+		c.decay.cls()
+		c.decay.draw(c.Screen)
+	}
+	drivers[c.driver].Cls()
+	c.updateScreen()
+	if d, ok := drivers[c.driver].(ColorDriver); ok && c.MegaChip() {
+		d.UpdateColorScreen(c)
+	}
+	return nil
+}
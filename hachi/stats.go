@@ -0,0 +1,69 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Stats are runtime statistics kept by the emulator, cheap enough to be
+// always on. They are meant for HUDs, exporters and benchmarks.
+type Stats struct {
+	// Cycles is how many instructions were executed.
+	Cycles uint64
+	// Frames is how many screen updates were pushed to the driver.
+	Frames uint64
+	// Draws is how many DRW instructions were executed.
+	Draws uint64
+	// Beeps is how many times the sound timer was started from silence.
+	Beeps uint64
+	// KeyEvents is how many key presses and releases the emulator polled.
+	KeyEvents uint64
+	// Uptime is how much emulated time has passed, as counted by the 60hz
+	// timers.
+	Uptime time.Duration
+	// IPS is how many instructions ran during the last second of Uptime.
+	IPS float64
+}
+
+// Stats returns the runtime statistics since the emulator was created or
+// reset.
+func (c *Chip8) Stats() Stats {
+	s := c.stats
+	s.Uptime = time.Duration(c.timerTicks) * c.TimerInterval
+	return s
+}
+
+// countTick updates the tick based statistics once per timer tick.
+func (c *Chip8) countTick() {
+	c.timerTicks++
+	if c.timerTicks%60 != 0 {
+		return
+	}
+	elapsed := 60 * c.TimerInterval
+	c.stats.IPS = float64(c.stats.Cycles-c.ipsCycles) / elapsed.Seconds()
+	c.ipsCycles = c.stats.Cycles
+}
+
+// WriteStats writes the statistics in a human readable form.
+func WriteStats(w io.Writer, s Stats) error {
+	_, err := fmt.Fprintf(w, "uptime: %v\ncycles: %d (%.0f per second)\n"+
+		"frames: %d\ndraws: %d\nbeeps: %d\nkey events: %d\n",
+		s.Uptime, s.Cycles, s.IPS, s.Frames, s.Draws, s.Beeps, s.KeyEvents)
+	return err
+}
@@ -0,0 +1,100 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"image"
+	"math/bits"
+)
+
+// ThumbnailOptions configures Thumbnail.
+type ThumbnailOptions struct {
+	// Settings to run the program with. FixedTimestep is always enabled so
+	// thumbnails are reproducible. If nil, DefaultSettings are used.
+	Settings *Chip8Settings
+	// Frames is how many 60hz frames to run. Defaults to 300 (5 seconds).
+	Frames int
+	// Scale is the size of each CHIP-8 pixel in the image. Defaults to 1.
+	Scale int
+}
+
+// Thumbnail runs a program headlessly and returns the frame with the most
+// lit pixels, which is usually the title screen or a busy gameplay scene.
+// A nil opts uses the defaults.
+// If the program crashes, the best frame so far is returned, or the error if
+// nothing was drawn before the crash.
+func Thumbnail(rom []byte, opts *ThumbnailOptions) (image.Image, error) {
+	if opts == nil {
+		opts = &ThumbnailOptions{}
+	}
+	settings := *DefaultSettings
+	if opts.Settings != nil {
+		settings = *opts.Settings
+	}
+	settings.FixedTimestep = true
+	frames := opts.Frames
+	if frames <= 0 {
+		frames = 300
+	}
+	scale := opts.Scale
+	if scale <= 0 {
+		scale = 1
+	}
+
+	c, err := New("null", &settings)
+	if err != nil {
+		return nil, err
+	}
+	err = c.LoadRaw(rom)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *image.RGBA
+	bestCount := -1
+	for i := 0; i < frames; i++ {
+		if err = c.AdvanceFrame(); err != nil {
+			break
+		}
+		count := 0
+		for _, b := range c.DisplayScreen() {
+			count += bits.OnesCount8(b)
+		}
+		if count > bestCount {
+			bestCount = count
+			best = c.render(best, scale)
+		}
+	}
+
+	if best == nil || (err != nil && bestCount == 0) {
+		return nil, err
+	}
+	return best, nil
+}
+
+// render draws the screen into img, which is allocated if nil.
+func (c *Chip8) render(img *image.RGBA, scale int) *image.RGBA {
+	w, h := c.DisplaySize()
+	if img == nil {
+		img = image.NewRGBA(image.Rect(0, 0, w*scale, h*scale))
+	}
+	for y := 0; y < h*scale; y++ {
+		for x := 0; x < w*scale; x++ {
+			img.SetRGBA(x, y, c.PixelColor(x/scale, y/scale))
+		}
+	}
+	return img
+}
@@ -0,0 +1,113 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import "time"
+
+// vipCosts holds how long each instruction takes on the COSMAC VIP
+// interpreter, in microseconds, indexed by the first nibble of the opcode.
+// These are averages: the real cost of some instructions depends on their
+// operands, and DRW includes the wait for the display interrupt.
+var vipCosts = [16]int{
+	0x0: 105, // RET (CLS is handled separately)
+	0x1: 105,
+	0x2: 105,
+	0x3: 55,
+	0x4: 55,
+	0x5: 73,
+	0x6: 27,
+	0x7: 45,
+	0x8: 200,
+	0x9: 73,
+	0xA: 55,
+	0xB: 105,
+	0xC: 164,
+	0xD: 22734,
+	0xE: 73,
+	// 0xF depends on the second byte, see vipCostsF
+}
+
+// vipCostsF holds the costs of the FX?? instructions by their second byte.
+var vipCostsF = map[byte]int{
+	0x07: 45,
+	0x0A: 45,
+	0x15: 45,
+	0x18: 45,
+	0x1E: 86,
+	0x29: 91,
+	0x33: 927,
+	0x55: 605,
+	0x65: 605,
+}
+
+// vipCost returns how long opcode takes on the COSMAC VIP.
+// Unknown instructions cost as much as a jump.
+func vipCost(opcode []byte) time.Duration {
+	cost := vipCosts[opcode[0]>>4]
+	switch {
+	case opcode[0] == 0x00 && opcode[1] == 0xE0:
+		cost = 109 // CLS
+	case opcode[0]&0xF0 == 0xF0:
+		var ok bool
+		if cost, ok = vipCostsF[opcode[1]]; !ok {
+			cost = vipCosts[0x1]
+		}
+	}
+	return time.Duration(cost) * time.Microsecond
+}
+
+// VIPCost returns roughly how long opcode takes on the COSMAC VIP, which is
+// what VIPTiming charges for it. Programs meant for the real machine can use
+// it to check how much of a frame their loops take.
+func VIPCost(opcode uint16) time.Duration {
+	return vipCost([]byte{byte(opcode >> 8), byte(opcode)})
+}
+
+// waitingForVBlank returns true if Tick is waiting for the next timer tick
+// (the display interrupt of the original interpreter) after a draw with the
+// DisplayWait quirk, ticking the timers meanwhile.
+func (c *Chip8) waitingForVBlank() bool {
+	if c.vblankWait.IsZero() {
+		return false
+	}
+	c.updateTimers()
+	if c.lastTimerUpdate.Equal(c.vblankWait) {
+		return true
+	}
+	c.vblankWait = time.Time{}
+	return false
+}
+
+// nextTimerTick returns when Tick will next decrement the timers.
+func (c *Chip8) nextTimerTick() time.Time {
+	interval := time.Duration(float64(c.TimerInterval) / c.timeScale)
+	return c.lastTimerUpdate.Add(interval)
+}
+
+// paced returns true if instructions take emulated time, either from the VIP
+// timing model or from CyclesPerSecond.
+func (c *Chip8) paced() bool { return c.vipTiming || c.cyclesPerSecond > 0 }
+
+// timingDue returns true if the wall clock caught up with the timing model,
+// which means the next instruction can be executed.
+func (c *Chip8) timingDue() bool {
+	now := time.Now()
+	if c.timingClock.IsZero() || now.Sub(c.timingClock) > c.TimerInterval {
+		// don't try to catch up after the emulator was stalled
+		c.timingClock = now
+	}
+	return !c.timingClock.After(now)
+}
@@ -0,0 +1,57 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// A Tracer writes an execution trace, one line per instruction with its
+// address, opcode and disassembly:
+//
+//	0200 6005 LD V0,05
+//
+// It's meant to be set as TraceFunc, to compare traces between emulators.
+type Tracer struct {
+	w   *bufio.Writer
+	err error
+}
+
+// TraceWriter returns a Tracer that writes to w. Trace lines are buffered,
+// Flush must be called once the emulator is done.
+func TraceWriter(w io.Writer) *Tracer {
+	return &Tracer{w: bufio.NewWriter(w)}
+}
+
+// Trace writes one line, it has the signature of TraceFunc.
+// Write errors are kept for Flush.
+func (t *Tracer) Trace(pc uint16, opcode uint16, inst Instruction) {
+	if t.err != nil {
+		return
+	}
+	_, t.err = fmt.Fprintf(t.w, "%04X %04X %v\n", pc, opcode, inst)
+}
+
+// Flush writes any buffered lines.
+// Returns the first error that happened while tracing, if any.
+func (t *Tracer) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+	return t.w.Flush()
+}
@@ -0,0 +1,122 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import "fmt"
+
+// A Transform changes how the screen is shown by the drivers, for displays
+// that are mounted sideways or upside down, LED matrices wired in odd ways or
+// terminals with unusual aspect ratios. Like the anti-flicker filter, it only
+// affects DisplayScreen, DisplaySize and PixelColor, programs still see the
+// untouched Screen.
+// The screen is flipped first, then rotated, then scaled.
+type Transform struct {
+	// FlipH and FlipV mirror the screen horizontally and vertically.
+	FlipH, FlipV bool
+	// Rotate rotates the screen clockwise by 0, 90, 180 or 270 degrees.
+	Rotate int
+	// Scale makes every pixel Scale*Scale pixels big. 0 and 1 mean no
+	// scaling. Max. 8.
+	Scale int
+}
+
+func (t Transform) validate() error {
+	switch t.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("Transform.Rotate must be 0, 90, 180 or 270, "+
+			"got %v.", t.Rotate)
+	}
+	if t.Scale < 0 || t.Scale > 8 {
+		return fmt.Errorf("Transform.Scale must be between 0 and 8, got %v.",
+			t.Scale)
+	}
+	return nil
+}
+
+// identity returns true if the transform doesn't change anything.
+func (t Transform) identity() bool {
+	return !t.FlipH && !t.FlipV && t.Rotate == 0 && t.Scale <= 1
+}
+
+func (t Transform) scale() int {
+	if t.Scale < 1 {
+		return 1
+	}
+	return t.Scale
+}
+
+// size returns the size of a w*h screen once transformed.
+func (t Transform) size(w, h int) (int, int) {
+	if t.Rotate == 90 || t.Rotate == 270 {
+		w, h = h, w
+	}
+	return w * t.scale(), h * t.scale()
+}
+
+// source returns the coordinates on a w*h screen of the pixel shown at x, y.
+func (t Transform) source(x, y, w, h int) (int, int) {
+	x, y = x/t.scale(), y/t.scale()
+	switch t.Rotate {
+	case 90:
+		x, y = y, h-1-x
+	case 180:
+		x, y = w-1-x, h-1-y
+	case 270:
+		x, y = w-1-y, x
+	}
+	if t.FlipH {
+		x = w - 1 - x
+	}
+	if t.FlipV {
+		y = h - 1 - y
+	}
+	return x, y
+}
+
+// DisplaySize returns the size in pixels of the buffer returned by
+// DisplayScreen, which is the screen's size once transformed (see
+// Transform).
+func (c *Chip8) DisplaySize() (width, height int) {
+	return c.transform.size(int(c.Width), int(c.Height))
+}
+
+// transformScreen returns screen, in the Screen layout, transformed
+// according to the Transform setting.
+func (c *Chip8) transformScreen(screen []byte) []byte {
+	if c.transform.identity() {
+		return screen
+	}
+	w, h := c.DisplaySize()
+	if len(c.transformed) != w*h/8 {
+		c.transformed = make([]byte, w*h/8)
+	}
+	srcByteWidth := int(c.Width) / 8
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x += 8 {
+			var b byte
+			for bit := 0; bit < 8; bit++ {
+				sx, sy := c.transform.source(x+bit, y, int(c.Width),
+					int(c.Height))
+				if screen[sy*srcByteWidth+sx/8]&(0x80>>uint(sx%8)) != 0 {
+					b |= 0x80 >> uint(bit)
+				}
+			}
+			c.transformed[(y*w+x)/8] = b
+		}
+	}
+	return c.transformed
+}
@@ -0,0 +1,239 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"image/color"
+)
+
+// A Variant is a CHIP-8 dialect, which can add or change instructions and
+// machine features.
+type Variant int
+
+const (
+	// VariantChip8 is the original CHIP-8 interpreter for the COSMAC VIP.
+	VariantChip8 Variant = iota
+	// VariantChip8X is CHIP-8X, the interpreter for the VIP with the VP-590
+	// color board and the VP-595 sound board. Programs start at 0x300.
+	VariantChip8X
+	// VariantHiRes is the two-page hires CHIP-8 interpreter for the VIP,
+	// which has a 64x64 display. Programs are loaded at 0x200 but execution
+	// starts at 0x2C0, skipping the interpreter patch at the beginning.
+	VariantHiRes
+	// VariantSChip is SUPER-CHIP 1.1 for the HP48 calculators, which adds a
+	// 128x64 mode, 16x16 sprites, scrolling, a big font and the RPL user
+	// flags. Most programs written after the VIP era need it.
+	VariantSChip
+	// VariantChip48 is CHIP-48, the first interpreter for the HP48
+	// calculators. It has the original instruction set and display, but
+	// always has the QuirksChip48 quirks on top of the ones in the settings
+	// and LD [I],VX and LD VX,[I] leave I pointing at the last register
+	// (one less than the MemoryIncrement quirk).
+	VariantChip48
+	// VariantMegaChip is MegaChip, an extension of SUPER-CHIP with a 256x192
+	// color mode, which is switched on by the program. It adds color
+	// sprites, blending, digitised sound and 24-bit addresses into 16MB of
+	// memory. MemorySize is ignored. See Chip8.ColorScreen.
+	VariantMegaChip
+)
+
+var variantNames = map[Variant]string{
+	VariantChip8:    "chip8",
+	VariantChip8X:   "chip8x",
+	VariantHiRes:    "hires",
+	VariantSChip:    "schip",
+	VariantChip48:   "chip48",
+	VariantMegaChip: "megachip",
+}
+
+func (v Variant) String() string {
+	if name, ok := variantNames[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("Variant(%d)", int(v))
+}
+
+// ParseVariant returns the variant with the given name (see Variant.String).
+func ParseVariant(name string) (Variant, error) {
+	for v, n := range variantNames {
+		if n == name {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown variant '%s'.", name)
+}
+
+// ScreenSize returns the native display resolution of the variant.
+func (v Variant) ScreenSize() (width, height uint8) {
+	switch v {
+	case VariantHiRes:
+		return 64, 64
+	case VariantSChip, VariantMegaChip:
+		return 128, 64
+	}
+	return 64, 32
+}
+
+// schip returns true if the variant has the SUPER-CHIP instructions.
+func (v Variant) schip() bool {
+	return v == VariantSChip || v == VariantMegaChip
+}
+
+// StartAddress returns the address at which programs are loaded.
+func (v Variant) StartAddress() uint16 {
+	if v == VariantChip8X {
+		return 0x300
+	}
+	return 0x200
+}
+
+// quirks returns the quirks programs of the variant run with, given the ones
+// in the settings.
+func (v Variant) quirks(q Quirks) Quirks {
+	if v == VariantChip48 {
+		q = q.merge(QuirksChip48)
+		q.MemoryIncrement = false // see chip48Memory
+	}
+	return q
+}
+
+// chip48Memory moves I after LD [I],VX and LD VX,[I] on CHIP-48, which adds
+// X to it instead of X+1 like the COSMAC VIP.
+func (c *Chip8) chip48Memory(x uint8) {
+	if c.variant == VariantChip48 {
+		c.I += uint16(x)
+	}
+}
+
+// entryPoint returns the address at which execution starts.
+func (v Variant) entryPoint() uint16 {
+	if v == VariantHiRes {
+		return 0x2C0
+	}
+	return v.StartAddress()
+}
+
+// hiresSignature is the first instruction of two-page hires programs, which
+// jumps over the interpreter patch when the program is run by the original
+// interpreter.
+const hiresSignature = 0x1260
+
+// DetectVariant guesses the variant of a CHIP-8 program from its first
+// instruction, for programs that don't come with one. Two-page hires programs
+// start with JP 260, anything else is reported as VariantChip8.
+func DetectVariant(rom []byte) Variant {
+	if len(rom) >= 2 && uint16(rom[0])<<8|uint16(rom[1]) == hiresSignature {
+		return VariantHiRes
+	}
+	return VariantChip8
+}
+
+// -----------------------------------------------------------------------------
+
+// CHIP-8X background colors, which are cycled by 02A0.
+var Chip8XBackground = [4]color.RGBA{
+	{0x00, 0x00, 0x80, 0xFF}, // dark blue
+	{0x00, 0x00, 0x00, 0xFF}, // black
+	{0x00, 0x80, 0x00, 0xFF}, // green
+	{0x80, 0x00, 0x00, 0xFF}, // red
+}
+
+// CHIP-8X foreground colors, which are set by BXYN.
+var Chip8XForeground = [8]color.RGBA{
+	{0x00, 0x00, 0x00, 0xFF}, // black
+	{0xFF, 0x00, 0x00, 0xFF}, // red
+	{0x00, 0x00, 0xFF, 0xFF}, // blue
+	{0xFF, 0x00, 0xFF, 0xFF}, // violet
+	{0x00, 0xFF, 0x00, 0xFF}, // green
+	{0xFF, 0xFF, 0x00, 0xFF}, // yellow
+	{0x00, 0xFF, 0xFF, 0xFF}, // aqua
+	{0xFF, 0xFF, 0xFF, 0xFF}, // white
+}
+
+// foreground color of every block on startup. CLS doesn't reset it.
+const chip8XDefaultForeground = 1 // red
+
+// initChip8X allocates the color attribute memory, which holds one foreground
+// color for every 8x1 block of pixels.
+func (c *Chip8) initChip8X() {
+	c.Colors = make([]uint8, int(c.Width)/8*int(c.Height))
+	for i := range c.Colors {
+		c.Colors[i] = chip8XDefaultForeground
+	}
+	c.Background = 0
+}
+
+// chip8XAddNibbles executes 5XY1, which adds each nibble of VY to VX
+// separately, discarding the carry (each nibble wraps at 8).
+func (c *Chip8) chip8XAddNibbles(x, y uint8) {
+	hi := (c.V[x]>>4 + c.V[y]>>4) & 0x7
+	lo := (c.V[x]&0xF + c.V[y]&0xF) & 0x7
+	c.V[x] = hi<<4 | lo
+}
+
+// chip8XColor executes BXYN, which sets the foreground color to VY & 7.
+// With N = 0, the color is set for the 8x4 zones from column VX & F to VX >> 4
+// and from row V(X+1) & F to V(X+1) >> 4.
+// Otherwise, the color is set for N rows of the 8x1 block at VX, V(X+1).
+func (c *Chip8) chip8XColor(x, y, n uint8) {
+	color := c.V[y] & 0x7
+	byteWidth := int(c.Width) / 8
+	set := func(col, row int) {
+		col %= byteWidth
+		row %= int(c.Height)
+		c.Colors[row*byteWidth+col] = color
+	}
+
+	vx, vx1 := c.V[x], c.V[(x+1)&0xF]
+	if n == 0 {
+		for col := int(vx & 0xF); col <= int(vx>>4); col++ {
+			for zone := int(vx1 & 0xF); zone <= int(vx1>>4); zone++ {
+				for row := zone * 4; row < zone*4+4; row++ {
+					set(col, row)
+				}
+			}
+		}
+	} else {
+		for row := int(vx1); row < int(vx1)+int(n); row++ {
+			set(int(vx)/8, row)
+		}
+	}
+	c.updateScreen()
+}
+
+// PixelColor returns the color of the pixel at x, y of DisplayScreen, taking
+// the variant's color features into account. Monochrome variants use the
+// DisplayPalette.
+func (c *Chip8) PixelColor(x, y int) color.RGBA {
+	x, y = c.transform.source(x, y, int(c.Width), int(c.Height))
+	byteWidth := int(c.Width) / 8
+	switch {
+	case !c.pixelOn(x, y):
+		return c.BackgroundColor()
+	case c.Colors == nil:
+		return c.palette.Foreground()
+	}
+	return Chip8XForeground[c.Colors[y*byteWidth+x/8]&0x7]
+}
+
+// BackgroundColor returns the color of unlit pixels.
+func (c *Chip8) BackgroundColor() color.RGBA {
+	if c.Colors == nil {
+		return c.palette.Background()
+	}
+	return Chip8XBackground[c.Background&0x3]
+}
@@ -0,0 +1,201 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hachi
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+// An XRefKind is the way an instruction refers to an address.
+type XRefKind int
+
+const (
+	// XRefJump is JP NNN or JP V0,NNN.
+	XRefJump XRefKind = iota
+	// XRefCall is CALL NNN.
+	XRefCall
+	// XRefLoad is LD I,NNN.
+	XRefLoad
+)
+
+var xrefKindNames = map[XRefKind]string{
+	XRefJump: "jump",
+	XRefCall: "call",
+	XRefLoad: "load",
+}
+
+func (k XRefKind) String() string {
+	if name, ok := xrefKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("XRefKind(%d)", int(k))
+}
+
+// An XRef is a reference to an address by the instruction at From.
+type XRef struct {
+	From uint16
+	Kind XRefKind
+}
+
+// CrossReferences returns every JP, CALL, JP V0 and LD I in the disassembly
+// of a program loaded at start, indexed by the address they refer to. The
+// references to each address are sorted by From.
+func CrossReferences(disassembly []Instruction,
+	start uint16) map[uint16][]XRef {
+
+	res := map[uint16][]XRef{}
+	address := start
+	for _, in := range disassembly {
+		var kind XRefKind
+		ref := true
+		switch in.(type) {
+		case Jp, JpV0:
+			kind = XRefJump
+		case Call:
+			kind = XRefCall
+		case LdI:
+			kind = XRefLoad
+		default:
+			ref = false
+		}
+		if ref {
+			target := in.Opcode() & 0x0FFF
+			res[target] = append(res[target], XRef{address, kind})
+		}
+		address += uint16(in.Size())
+	}
+	for _, refs := range res {
+		sort.SliceStable(refs, func(i, j int) bool {
+			return refs[i].From < refs[j].From
+		})
+	}
+	return res
+}
+
+// xrefVerbs describe the references in HTML reports
+var xrefVerbs = map[XRefKind]string{
+	XRefJump: "jumped to from",
+	XRefCall: "called from",
+	XRefLoad: "loaded from",
+}
+
+// WriteDisassemblyHTML disassembles a program loaded at start (see
+// DisassembleLabels) and writes it as a standalone HTML page for reverse
+// engineering: jump, call and LD I targets link to the instruction they
+// refer to, every referenced instruction lists where it's referenced from
+// ("called from 2A4, 31C"), the bytes that are never executed are
+// highlighted as data and the sprites found by FindSprites are drawn under
+// the data they start in.
+func WriteDisassemblyHTML(w io.Writer, program []byte, start uint16) error {
+	disassembly, labels := DisassembleLabels(program, start)
+	xrefs := CrossReferences(disassembly, start)
+	sprites := FindSprites(program, start)
+
+	_, err := io.WriteString(w, `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>go-hachi disassembly</title>
+<style>
+body { font-family: monospace; }
+td { padding: 0 1em; }
+tr.data { background: #ffe8b0; }
+tr:target { background: #b0d8ff; }
+td.xref { color: #666; }
+tr.sprite pre { margin: 0; line-height: 1; }
+</style></head><body><table>
+<tr><th>addr</th><th>opcode</th><th>pseudo-code</th><th>ascii</th>`+
+		`<th>description</th><th>references</th></tr>
+`)
+	if err != nil {
+		return err
+	}
+
+	address := start
+	for _, in := range disassembly {
+		class := ""
+		if _, raw := in.(*RawData); raw {
+			class = ` class="data"`
+		}
+		opcodeFormatter := "%04X"
+		if in.Size() == 1 {
+			opcodeFormatter = "%02X"
+		}
+		_, err = fmt.Fprintf(w, `<tr id="a%04X"%s><td>%04X</td>`+
+			"<td>"+opcodeFormatter+"</td><td>%s</td><td>%s</td><td>%s</td>"+
+			`<td class="xref">%s</td></tr>`+"\n", address, class, address,
+			in.Opcode(), pseudoCodeHTML(in, labels),
+			html.EscapeString(in.ASCII()),
+			html.EscapeString(in.Description()),
+			xrefsHTML(xrefs[address]))
+		if err != nil {
+			return err
+		}
+		address += uint16(in.Size())
+
+		for len(sprites) != 0 && sprites[0].Address < address {
+			sp := sprites[0]
+			sprites = sprites[1:]
+			_, err = fmt.Fprintf(w, `<tr class="sprite"><td colspan="2">`+
+				`%dx%d sprite at %04X</td><td colspan="4"><pre>%s</pre>`+
+				"</td></tr>\n", sp.Width, sp.Height, sp.Address, sp.Text())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = io.WriteString(w, "</table></body></html>\n")
+	return err
+}
+
+// pseudoCodeHTML returns the pseudo-asm of an instruction with its target
+// label, if any, linked to the instruction it refers to.
+func pseudoCodeHTML(in Instruction, labels Labels) string {
+	text := html.EscapeString(in.String())
+	switch in.(type) {
+	case Jp, JpV0, Call, LdI:
+	default:
+		return text
+	}
+	target := in.Opcode() & 0x0FFF
+	name, ok := labels[target]
+	i := strings.LastIndex(text, name)
+	if !ok || i < 0 {
+		return text
+	}
+	return fmt.Sprintf(`%s<a href="#a%04X">%s</a>%s`, text[:i], target,
+		name, text[i+len(name):])
+}
+
+// xrefsHTML describes references with links to where they come from.
+func xrefsHTML(refs []XRef) string {
+	var parts []string
+	for _, kind := range []XRefKind{XRefCall, XRefJump, XRefLoad} {
+		var links []string
+		for _, r := range refs {
+			if r.Kind == kind {
+				links = append(links, fmt.Sprintf(`<a href="#a%04X">%X</a>`,
+					r.From, r.From))
+			}
+		}
+		if links != nil {
+			parts = append(parts, xrefVerbs[kind]+" "+
+				strings.Join(links, ", "))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
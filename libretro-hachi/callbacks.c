@@ -0,0 +1,132 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+/*
+	The parts of the libretro API that don't need the emulator: the frontend's
+	callbacks are stored here and called through the hachi_* functions, as Go
+	can't call C function pointers directly.
+*/
+
+#include "libretro.h"
+
+static retro_environment_t environ_cb;
+static retro_video_refresh_t video_cb;
+static retro_audio_sample_batch_t audio_batch_cb;
+static retro_input_poll_t input_poll_cb;
+static retro_input_state_t input_state_cb;
+
+unsigned retro_api_version(void) { return RETRO_API_VERSION; }
+
+void retro_set_environment(retro_environment_t cb) { environ_cb = cb; }
+void retro_set_video_refresh(retro_video_refresh_t cb) { video_cb = cb; }
+void retro_set_audio_sample(retro_audio_sample_t cb) { (void)cb; }
+void retro_set_input_poll(retro_input_poll_t cb) { input_poll_cb = cb; }
+void retro_set_input_state(retro_input_state_t cb) { input_state_cb = cb; }
+
+void retro_set_audio_sample_batch(retro_audio_sample_batch_t cb) {
+	audio_batch_cb = cb;
+}
+
+void retro_get_system_info(struct retro_system_info *info) {
+	info->library_name = "hachi";
+	info->library_version = "1.0";
+	info->valid_extensions = "ch8|c8|c8x|sc8";
+	info->need_fullpath = false;
+	info->block_extract = false;
+}
+
+void retro_set_controller_port_device(unsigned port, unsigned device) {
+	(void)port;
+	(void)device;
+}
+
+unsigned retro_get_region(void) { return RETRO_REGION_NTSC; }
+
+bool retro_load_game_special(unsigned type,
+                             const struct retro_game_info *info,
+                             size_t num) {
+	(void)type;
+	(void)info;
+	(void)num;
+	return false;
+}
+
+void *retro_get_memory_data(unsigned id) {
+	(void)id;
+	return NULL;
+}
+
+size_t retro_get_memory_size(unsigned id) {
+	(void)id;
+	return 0;
+}
+
+void retro_cheat_reset(void) {}
+
+void retro_cheat_set(unsigned index, bool enabled, const char *code) {
+	(void)index;
+	(void)enabled;
+	(void)code;
+}
+
+/* ------------------------------------------------------------------------- */
+
+bool hachi_set_pixel_format(void) {
+	enum retro_pixel_format format = RETRO_PIXEL_FORMAT_XRGB8888;
+	return environ_cb(RETRO_ENVIRONMENT_SET_PIXEL_FORMAT, &format);
+}
+
+/* must match padKeys in main.go */
+void hachi_set_input_descriptors(void) {
+	static struct retro_input_descriptor desc[] = {
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_UP, "2"},
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_DOWN, "8"},
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_LEFT, "4"},
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_RIGHT, "6"},
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_A, "5"},
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_B, "0"},
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_X, "A"},
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_Y, "B"},
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_L, "C"},
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_R, "D"},
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_SELECT, "E"},
+		{0, RETRO_DEVICE_JOYPAD, 0, RETRO_DEVICE_ID_JOYPAD_START, "F"},
+		{0, 0, 0, 0, NULL},
+	};
+	environ_cb(RETRO_ENVIRONMENT_SET_INPUT_DESCRIPTORS, desc);
+}
+
+void hachi_video(const void *data, unsigned width, unsigned height,
+                 size_t pitch) {
+	video_cb(data, width, height, pitch);
+}
+
+void hachi_audio(const int16_t *data, size_t frames) {
+	/* the frontend can take less than a whole frame at once */
+	while (frames > 0) {
+		size_t n = audio_batch_cb(data, frames);
+		if (n == 0) {
+			break;
+		}
+		data += n * 2;
+		frames -= n;
+	}
+}
+
+void hachi_input_poll(void) { input_poll_cb(); }
+
+int16_t hachi_input(unsigned device, unsigned id) {
+	return input_state_cb(0, device, 0, id);
+}
@@ -0,0 +1,100 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+)
+
+const (
+	frequency = 440
+	volume    = 0x1000
+	period    = sampleRate / frequency // in samples
+)
+
+// A LibretroDriver is the syscall driver used by the core. It draws into a
+// frame buffer and remembers whether the program beeped, then retro_run hands
+// both to the frontend once per frame.
+type LibretroDriver struct {
+	hachi.Driver
+	frame         []uint32 // XRGB8888
+	width, height int
+	keys          uint16 // keys held on the frontend, set by retro_run
+	beeping       bool   // Beep was called during this frame
+	phase         int    // position in the square wave, in samples
+	samples       []int16
+}
+
+func (d *LibretroDriver) OnInit(c *hachi.Chip8) {
+	d.width, d.height = c.DisplaySize()
+	d.frame = make([]uint32, d.width*d.height)
+	d.draw(c)
+}
+
+func (d *LibretroDriver) Cls() {}
+
+func (d *LibretroDriver) OnUpdate(c *hachi.Chip8) { c.Keyboard = d.keys }
+
+func (d *LibretroDriver) UpdateScreen(c *hachi.Chip8) { d.draw(c) }
+
+func (d *LibretroDriver) draw(c *hachi.Chip8) {
+	for y := 0; y < d.height; y++ {
+		for x := 0; x < d.width; x++ {
+			col := c.PixelColor(x, y)
+			d.frame[y*d.width+x] = uint32(col.R)<<16 | uint32(col.G)<<8 |
+				uint32(col.B)
+		}
+	}
+}
+
+// Beep is called once per frame while the sound timer is running.
+func (d *LibretroDriver) Beep() { d.beeping = true }
+
+// audio returns the given amount of interleaved stereo samples for the frame
+// that just ran: a square wave if the program beeped, silence otherwise.
+// The returned buffer is reused by the next call.
+func (d *LibretroDriver) audio(frames int) []int16 {
+	if cap(d.samples) < frames*2 {
+		d.samples = make([]int16, frames*2)
+	}
+	d.samples = d.samples[:frames*2]
+	for i := 0; i < frames; i++ {
+		var s int16
+		if d.beeping {
+			s = volume
+			if d.phase < period/2 {
+				s = -volume
+			}
+			d.phase = (d.phase + 1) % period
+		}
+		d.samples[i*2], d.samples[i*2+1] = s, s
+	}
+	d.beeping = false
+	return d.samples
+}
+
+func (d *LibretroDriver) GetData(key string) interface{} {
+	switch key {
+	case "frame":
+		return d.frame
+	}
+	return nil
+}
+
+func (d *LibretroDriver) SetData(key string, value interface{}) error {
+	return fmt.Errorf("Unknown data key '%s'.", key)
+}
@@ -0,0 +1,119 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+/*
+	The subset of the libretro API used by the core, with the same layouts
+	and values as the official libretro.h, so that the core doesn't need the
+	libretro sources to build.
+*/
+
+#ifndef HACHI_LIBRETRO_H
+#define HACHI_LIBRETRO_H
+
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+
+#define RETRO_API_VERSION 1
+
+#define RETRO_DEVICE_JOYPAD   1
+#define RETRO_DEVICE_KEYBOARD 3
+
+#define RETRO_DEVICE_ID_JOYPAD_B      0
+#define RETRO_DEVICE_ID_JOYPAD_Y      1
+#define RETRO_DEVICE_ID_JOYPAD_SELECT 2
+#define RETRO_DEVICE_ID_JOYPAD_START  3
+#define RETRO_DEVICE_ID_JOYPAD_UP     4
+#define RETRO_DEVICE_ID_JOYPAD_DOWN   5
+#define RETRO_DEVICE_ID_JOYPAD_LEFT   6
+#define RETRO_DEVICE_ID_JOYPAD_RIGHT  7
+#define RETRO_DEVICE_ID_JOYPAD_A      8
+#define RETRO_DEVICE_ID_JOYPAD_X      9
+#define RETRO_DEVICE_ID_JOYPAD_L      10
+#define RETRO_DEVICE_ID_JOYPAD_R      11
+
+#define RETRO_REGION_NTSC 0
+
+#define RETRO_ENVIRONMENT_SET_PIXEL_FORMAT      10
+#define RETRO_ENVIRONMENT_SET_INPUT_DESCRIPTORS 11
+
+enum retro_pixel_format {
+	RETRO_PIXEL_FORMAT_0RGB1555 = 0,
+	RETRO_PIXEL_FORMAT_XRGB8888 = 1,
+	RETRO_PIXEL_FORMAT_RGB565   = 2,
+	RETRO_PIXEL_FORMAT_UNKNOWN  = INT32_MAX
+};
+
+struct retro_system_info {
+	const char *library_name;
+	const char *library_version;
+	const char *valid_extensions;
+	bool need_fullpath;
+	bool block_extract;
+};
+
+struct retro_game_geometry {
+	unsigned base_width;
+	unsigned base_height;
+	unsigned max_width;
+	unsigned max_height;
+	float aspect_ratio;
+};
+
+struct retro_system_timing {
+	double fps;
+	double sample_rate;
+};
+
+struct retro_system_av_info {
+	struct retro_game_geometry geometry;
+	struct retro_system_timing timing;
+};
+
+struct retro_game_info {
+	const char *path;
+	const void *data;
+	size_t size;
+	const char *meta;
+};
+
+struct retro_input_descriptor {
+	unsigned port;
+	unsigned device;
+	unsigned index;
+	unsigned id;
+	const char *description;
+};
+
+typedef bool (*retro_environment_t)(unsigned cmd, void *data);
+typedef void (*retro_video_refresh_t)(const void *data, unsigned width,
+                                      unsigned height, size_t pitch);
+typedef void (*retro_audio_sample_t)(int16_t left, int16_t right);
+typedef size_t (*retro_audio_sample_batch_t)(const int16_t *data,
+                                             size_t frames);
+typedef void (*retro_input_poll_t)(void);
+typedef int16_t (*retro_input_state_t)(unsigned port, unsigned device,
+                                       unsigned index, unsigned id);
+
+/* implemented in callbacks.c, these call the frontend's callbacks */
+bool hachi_set_pixel_format(void);
+void hachi_set_input_descriptors(void);
+void hachi_video(const void *data, unsigned width, unsigned height,
+                 size_t pitch);
+void hachi_audio(const int16_t *data, size_t frames);
+void hachi_input_poll(void);
+int16_t hachi_input(unsigned device, unsigned id);
+
+#endif
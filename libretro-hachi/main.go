@@ -0,0 +1,255 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// libretro-hachi is a libretro core, which runs go-hachi inside RetroArch
+// and other libretro frontends with their save states, rewind, shaders and
+// input remapping. Build it as a shared library:
+//
+//	go build -buildmode=c-shared -o hachi_libretro.so
+//
+// The program runs with a fixed timestep, one Chip8.AdvanceFrame per
+// retro_run, so save states and rewind are exact. The variant is picked by
+// the file extension (.c8x for CHIP-8X, .sc8 for SUPER-CHIP, .mc8 for
+// MegaChip) or detected from the program (see hachi.DetectVariant), and the
+// quirks are detected from the program (see hachi.DetectQuirks).
+//
+// The d-pad is mapped to 2, 8, 4 and 6, which are laid out like arrows on the
+// hex keypad, and the other buttons to 5, 0 and A-F. The keyboard uses the
+// octo layout.
+package main
+
+/*
+#include "libretro.h"
+*/
+import "C"
+
+import (
+	"github.com/Francesco149/go-hachi/hachi"
+	"log"
+	"path/filepath"
+	"strings"
+	"unsafe"
+)
+
+const (
+	fps        = 60
+	sampleRate = 44100
+)
+
+var (
+	ha     *hachi.Chip8
+	driver = &LibretroDriver{}
+	// the error that halted the emulator, logged once
+	halted error
+)
+
+// variants by file extension, anything else is plain CHIP-8
+var extVariants = map[string]hachi.Variant{
+	".c8x": hachi.VariantChip8X,
+	".sc8": hachi.VariantSChip,
+	".mc8": hachi.VariantMegaChip,
+}
+
+// joypad buttons and the keys they press, must match
+// hachi_set_input_descriptors in callbacks.c
+var padKeys = []struct {
+	id  C.unsigned
+	key uint16
+}{
+	{C.RETRO_DEVICE_ID_JOYPAD_UP, hachi.Key2},
+	{C.RETRO_DEVICE_ID_JOYPAD_DOWN, hachi.Key8},
+	{C.RETRO_DEVICE_ID_JOYPAD_LEFT, hachi.Key4},
+	{C.RETRO_DEVICE_ID_JOYPAD_RIGHT, hachi.Key6},
+	{C.RETRO_DEVICE_ID_JOYPAD_A, hachi.Key5},
+	{C.RETRO_DEVICE_ID_JOYPAD_B, hachi.Key0},
+	{C.RETRO_DEVICE_ID_JOYPAD_X, hachi.KeyA},
+	{C.RETRO_DEVICE_ID_JOYPAD_Y, hachi.KeyB},
+	{C.RETRO_DEVICE_ID_JOYPAD_L, hachi.KeyC},
+	{C.RETRO_DEVICE_ID_JOYPAD_R, hachi.KeyD},
+	{C.RETRO_DEVICE_ID_JOYPAD_SELECT, hachi.KeyE},
+	{C.RETRO_DEVICE_ID_JOYPAD_START, hachi.KeyF},
+}
+
+// libretro key codes for the keypad characters used by hachi.KeyLayouts,
+// letters and digits are their own key code
+var runeKeys = map[rune]C.unsigned{
+	'/': 267, '*': 268, '-': 269, '+': 270, '.': 266, '\r': 271,
+}
+
+// pollKeys returns the keys held on the joypad and on the keyboard.
+func pollKeys() (keys uint16) {
+	C.hachi_input_poll()
+	for _, b := range padKeys {
+		if C.hachi_input(C.RETRO_DEVICE_JOYPAD, b.id) != 0 {
+			keys |= b.key
+		}
+	}
+	for r, key := range hachi.KeyLayouts["octo"] {
+		code, ok := runeKeys[r]
+		if !ok {
+			code = C.unsigned(r)
+		}
+		if C.hachi_input(C.RETRO_DEVICE_KEYBOARD, code) != 0 {
+			keys |= key
+		}
+	}
+	return
+}
+
+//export retro_init
+func retro_init() {}
+
+//export retro_deinit
+func retro_deinit() {}
+
+//export retro_get_system_av_info
+func retro_get_system_av_info(info *C.struct_retro_system_av_info) {
+	w, h := 64, 32
+	if ha != nil {
+		w, h = ha.DisplaySize()
+	}
+	info.geometry = C.struct_retro_game_geometry{
+		base_width:   C.unsigned(w),
+		base_height:  C.unsigned(h),
+		max_width:    C.unsigned(w),
+		max_height:   C.unsigned(h),
+		aspect_ratio: C.float(float64(w) / float64(h)),
+	}
+	info.timing = C.struct_retro_system_timing{
+		fps:         fps,
+		sample_rate: sampleRate,
+	}
+}
+
+//export retro_load_game
+func retro_load_game(info *C.struct_retro_game_info) C.bool {
+	if info == nil || info.data == nil {
+		return false
+	}
+	if !C.hachi_set_pixel_format() {
+		log.Println("libretro-hachi: XRGB8888 is not supported.")
+		return false
+	}
+	C.hachi_set_input_descriptors()
+
+	program := C.GoBytes(info.data, C.int(info.size))
+	settings := &hachi.Chip8Settings{Realistic: true, FixedTimestep: true}
+	if info.path != nil {
+		ext := strings.ToLower(filepath.Ext(C.GoString(info.path)))
+		settings.Variant = extVariants[ext]
+	}
+	if settings.Variant == hachi.VariantChip8 {
+		settings.Variant = hachi.DetectVariant(program)
+	}
+	settings = hachi.DetectQuirks(program).Apply(settings)
+
+	var err error
+	ha, err = hachi.New("libretro", settings)
+	if err == nil {
+		err = ha.LoadRaw(program)
+	}
+	if err != nil {
+		log.Println("libretro-hachi:", err)
+		ha = nil
+		return false
+	}
+	halted = nil
+	return true
+}
+
+//export retro_unload_game
+func retro_unload_game() {
+	if ha != nil {
+		ha.Shutdown()
+		ha = nil
+	}
+}
+
+//export retro_reset
+func retro_reset() {
+	if ha == nil {
+		return
+	}
+	if err := ha.Reset(); err != nil {
+		log.Println("libretro-hachi:", err)
+	}
+	halted = nil
+}
+
+//export retro_run
+func retro_run() {
+	driver.keys = pollKeys()
+	err := ha.AdvanceFrame()
+	if err != nil && err != halted {
+		// a halted emulator keeps returning the same error
+		log.Println("libretro-hachi:", err)
+		halted = err
+	}
+
+	frame, w, h := driver.frame, driver.width, driver.height
+	C.hachi_video(unsafe.Pointer(&frame[0]), C.unsigned(w), C.unsigned(h),
+		C.size_t(w*4))
+	samples := driver.audio(sampleRate / fps)
+	C.hachi_audio((*C.int16_t)(unsafe.Pointer(&samples[0])),
+		C.size_t(len(samples)/2))
+}
+
+//export retro_serialize_size
+func retro_serialize_size() C.size_t {
+	if ha == nil {
+		return 0
+	}
+	return C.size_t(len(encodeState(ha.Snapshot())))
+}
+
+//export retro_serialize
+func retro_serialize(data unsafe.Pointer, size C.size_t) C.bool {
+	if ha == nil {
+		return false
+	}
+	state := encodeState(ha.Snapshot())
+	if len(state) > int(size) {
+		return false
+	}
+	copy(unsafe.Slice((*byte)(data), len(state)), state)
+	return true
+}
+
+//export retro_unserialize
+func retro_unserialize(data unsafe.Pointer, size C.size_t) C.bool {
+	if ha == nil {
+		return false
+	}
+	s, err := decodeState(C.GoBytes(data, C.int(size)))
+	if err == nil {
+		err = ha.Restore(s)
+	}
+	if err != nil {
+		log.Println("libretro-hachi:", err)
+		return false
+	}
+	halted = nil
+	return true
+}
+
+func init() {
+	err := hachi.RegisterDriver("libretro", driver)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// required by -buildmode=c-shared
+func main() {}
@@ -0,0 +1,137 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	"io"
+)
+
+// A save state is a stateHeader followed by the stack, screen, memory and
+// CHIP-8X colors of a hachi.State, each prefixed by its length. libretro wants
+// every state of a game to have the same size, which holds as long as the
+// settings don't change.
+type stateHeader struct {
+	Magic         [4]byte
+	V             [16]uint8
+	I             uint16
+	SP            int32
+	PC            uint16
+	DT, ST        uint8
+	Width, Height uint8
+	WaitingForKey bool
+	WaitRegister  uint8
+	Background    uint8
+	Hires         bool
+	RPL           [8]uint8
+}
+
+var stateMagic = [4]byte{'H', 'A', 'C', '8'}
+
+var order = binary.LittleEndian
+
+// encodeState serializes s as a save state.
+func encodeState(s *hachi.State) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, order, &stateHeader{
+		Magic:         stateMagic,
+		V:             s.V,
+		I:             s.I,
+		SP:            int32(s.SP),
+		PC:            s.PC,
+		DT:            s.DT,
+		ST:            s.ST,
+		Width:         s.Width,
+		Height:        s.Height,
+		WaitingForKey: s.WaitingForKey,
+		WaitRegister:  s.WaitRegister,
+		Background:    s.Background,
+		Hires:         s.Hires,
+		RPL:           s.RPL,
+	})
+	binary.Write(&buf, order, uint32(len(s.Stack)))
+	binary.Write(&buf, order, s.Stack)
+	for _, b := range [][]byte{s.Screen, s.Memory, s.Colors} {
+		binary.Write(&buf, order, uint32(len(b)))
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+// decodeState parses a save state written by encodeState.
+func decodeState(data []byte) (s *hachi.State, err error) {
+	r := bytes.NewReader(data)
+	var h stateHeader
+	if err = binary.Read(r, order, &h); err != nil {
+		return
+	}
+	if h.Magic != stateMagic {
+		return nil, fmt.Errorf("Not a hachi save state.")
+	}
+	s = &hachi.State{
+		V:             h.V,
+		I:             h.I,
+		SP:            int(h.SP),
+		PC:            h.PC,
+		DT:            h.DT,
+		ST:            h.ST,
+		Width:         h.Width,
+		Height:        h.Height,
+		WaitingForKey: h.WaitingForKey,
+		WaitRegister:  h.WaitRegister,
+		Background:    h.Background,
+		Hires:         h.Hires,
+		RPL:           h.RPL,
+	}
+
+	n, err := readLength(r)
+	if err != nil {
+		return
+	}
+	s.Stack = make([]uint16, n)
+	if err = binary.Read(r, order, s.Stack); err != nil {
+		return
+	}
+	for _, b := range []*[]byte{&s.Screen, &s.Memory, &s.Colors} {
+		if n, err = readLength(r); err != nil {
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		*b = make([]byte, n)
+		if _, err = io.ReadFull(r, *b); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readLength reads the length of a slice, which can't be longer than what's
+// left of the state.
+func readLength(r *bytes.Reader) (int, error) {
+	var n uint32
+	if err := binary.Read(r, order, &n); err != nil {
+		return 0, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return 0, fmt.Errorf("Truncated save state.")
+	}
+	return int(n), nil
+}
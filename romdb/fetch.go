@@ -0,0 +1,136 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package romdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// URL is where the archive's programs.json is downloaded from.
+const URL = "https://raw.githubusercontent.com/JohnEarnest/chip8Archive/" +
+	"master/programs.json"
+
+// A Fetcher downloads the archive and caches it on disk.
+type Fetcher struct {
+	// URL to download from. Defaults to URL.
+	URL string
+	// CacheFile is where the downloaded archive is stored. Defaults to
+	// go-hachi/programs.json in the user's cache directory.
+	CacheFile string
+	// MaxAge is how long the cache is used before downloading the archive
+	// again. Defaults to a week.
+	MaxAge time.Duration
+	// Client is the HTTP client to use. Defaults to a client with a 10
+	// second timeout.
+	Client *http.Client
+}
+
+// Load returns the cached archive if it's fresh enough, and downloads it
+// otherwise. If the download fails, a stale cache or the embedded snapshot
+// is returned along with the error, so callers can log it and carry on.
+func (f *Fetcher) Load() (*Archive, error) {
+	cacheFile, err := f.cacheFile()
+	if err != nil {
+		return Snapshot(), err
+	}
+
+	maxAge := f.MaxAge
+	if maxAge == 0 {
+		maxAge = 7 * 24 * time.Hour
+	}
+	fi, statErr := os.Stat(cacheFile)
+	if statErr == nil && time.Since(fi.ModTime()) < maxAge {
+		if a, err := loadFile(cacheFile); err == nil {
+			return a, nil
+		}
+	}
+
+	a, err := f.download(cacheFile)
+	if a != nil {
+		// err, if any, is about saving the cache
+		return a, err
+	}
+
+	// offline: fall back to whatever we have
+	if statErr == nil {
+		if stale, serr := loadFile(cacheFile); serr == nil {
+			return stale, err
+		}
+	}
+	return Snapshot(), err
+}
+
+func (f *Fetcher) cacheFile() (string, error) {
+	if f.CacheFile != "" {
+		return f.CacheFile, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-hachi", "programs.json"), nil
+}
+
+// download fetches the archive and saves it to cacheFile once it's known to
+// parse. The archive is returned even if it couldn't be cached.
+func (f *Fetcher) download(cacheFile string) (*Archive, error) {
+	url := f.URL
+	if url == "" {
+		url = URL
+	}
+	client := f.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to download %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(cacheFile), 0755)
+	if err == nil {
+		err = os.WriteFile(cacheFile, data, 0644)
+	}
+	return a, err
+}
+
+func loadFile(path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
@@ -0,0 +1,39 @@
+//go:build ignore
+
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// gen.go refreshes the embedded snapshot of the archive.
+package main
+
+import (
+	"github.com/Francesco149/go-hachi/romdb"
+	"log"
+	"os"
+)
+
+func main() {
+	os.Remove("programs.json.tmp")
+	f := &romdb.Fetcher{CacheFile: "programs.json.tmp", MaxAge: -1}
+	a, err := f.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = os.Rename("programs.json.tmp", "programs.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Saved", a.Len(), "programs")
+}
@@ -0,0 +1,175 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package romdb provides metadata about known CHIP-8 programs from the
+// community chip8Archive (https://github.com/JohnEarnest/chip8Archive):
+// titles, authors, platform tags and the options each program is meant to
+// be run with.
+//
+// The archive's programs.json can be downloaded and cached through a Fetcher.
+// An embedded snapshot is used when offline. The snapshot is refreshed with:
+//
+//	go generate github.com/Francesco149/go-hachi/romdb
+package romdb
+
+//go:generate go run gen.go
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Options are the settings a program is meant to be run with, as used by
+// the Octo emulator. Quirk flags that are true select the SCHIP behaviour.
+type Options struct {
+	// TickRate is the amount of instructions per frame.
+	TickRate        int    `json:"tickrate"`
+	FillColor       string `json:"fillColor"`
+	BackgroundColor string `json:"backgroundColor"`
+	ShiftQuirks     bool   `json:"shiftQuirks"`
+	LoadStoreQuirks bool   `json:"loadStoreQuirks"`
+	VFOrderQuirks   bool   `json:"vfOrderQuirks"`
+	ClipQuirks      bool   `json:"clipQuirks"`
+	JumpQuirks      bool   `json:"jumpQuirks"`
+	LogicQuirks     bool   `json:"logicQuirks"`
+	VBlankQuirks    bool   `json:"vBlankQuirks"`
+}
+
+// A Program is an entry of the archive.
+type Program struct {
+	// ID is the program's key in the archive, which is also the base name
+	// of its ROM file.
+	ID          string   `json:"-"`
+	Title       string   `json:"title"`
+	Authors     []string `json:"authors"`
+	Event       string   `json:"event"`
+	Release     string   `json:"release"`
+	Description string   `json:"desc"`
+	// Platform is the dialect the program targets: chip8, schip or xochip.
+	Platform string  `json:"platform"`
+	Options  Options `json:"options"`
+}
+
+// Settings returns a copy of base with the recommended options applied.
+// Octo's quirks map to the matching Quirks, its load/store and shift quirks
+// being the modern behaviour (the opposite of MemoryIncrement and ShiftVY),
+// and its colors to the Palette, unless they're missing or invalid.
+func (p *Program) Settings(base *hachi.Chip8Settings) *hachi.Chip8Settings {
+	s := *base
+	if p.Options.TickRate > 0 {
+		s.CyclesPerFrame = p.Options.TickRate
+	}
+	s.Quirks = hachi.Quirks{
+		VFReset:         p.Options.LogicQuirks,
+		MemoryIncrement: !p.Options.LoadStoreQuirks,
+		ShiftVY:         !p.Options.ShiftQuirks,
+		JumpVX:          p.Options.JumpQuirks,
+		Clip:            p.Options.ClipQuirks,
+		DisplayWait:     p.Options.VBlankQuirks,
+	}
+	if p.Options.FillColor != "" && p.Options.BackgroundColor != "" {
+		palette := p.Options.BackgroundColor + "," + p.Options.FillColor
+		if _, err := hachi.ParsePalette(palette); err == nil {
+			s.Palette = palette
+		}
+	}
+	return &s
+}
+
+func (p *Program) String() string {
+	res := p.Title
+	if len(p.Authors) != 0 {
+		res += " by " + strings.Join(p.Authors, ", ")
+	}
+	if p.Release != "" {
+		res += " (" + p.Release + ")"
+	}
+	return res
+}
+
+// An Archive is a set of programs indexed by ID.
+type Archive struct {
+	programs map[string]*Program
+}
+
+// Parse reads an archive in the programs.json format.
+func Parse(r io.Reader) (*Archive, error) {
+	programs := make(map[string]*Program)
+	err := json.NewDecoder(r).Decode(&programs)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse programs.json: %v", err)
+	}
+	for id, p := range programs {
+		p.ID = id
+	}
+	return &Archive{programs}, nil
+}
+
+//go:embed programs.json
+var snapshot string
+
+// Snapshot returns the archive embedded at build time.
+func Snapshot() *Archive {
+	a, err := Parse(strings.NewReader(snapshot))
+	if err != nil {
+		// the snapshot is checked by gen.go, this can't happen
+		panic(err)
+	}
+	return a
+}
+
+// Get returns the program with the given ID, or nil if it's unknown.
+func (a *Archive) Get(id string) *Program { return a.programs[id] }
+
+// Lookup returns the program whose ROM is at path, matching the file name
+// without extension against the program IDs. Returns nil if it's unknown.
+func (a *Archive) Lookup(path string) *Program {
+	base := filepath.Base(path)
+	return a.Get(strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+// Metadata returns the metadata of the program loaded from info.Path (see
+// Lookup). It can be used as Chip8Settings.RomLookup.
+func (a *Archive) Metadata(info *hachi.RomInfo) *hachi.RomMetadata {
+	p := a.Lookup(info.Path)
+	if p == nil || info.Path == "" {
+		return nil
+	}
+	return &hachi.RomMetadata{
+		Title:       p.Title,
+		Authors:     p.Authors,
+		Release:     p.Release,
+		Description: p.Description,
+	}
+}
+
+// IDs returns the sorted IDs of every program in the archive.
+func (a *Archive) IDs() []string {
+	res := make([]string, 0, len(a.programs))
+	for id := range a.programs {
+		res = append(res, id)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Len returns the number of programs in the archive.
+func (a *Archive) Len() int { return len(a.programs) }
@@ -0,0 +1,128 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package testsuite
+
+import "github.com/Francesco149/go-hachi/hachi/asm"
+
+// opcodesSource checks the flags of the arithmetic instructions, including
+// the edge cases where VF is also an operand, and draws a check mark for
+// each check that passed or a cross for each that failed.
+const opcodesSource = `
+	; SUBN with VX = VY doesn't borrow
+	LD V0,5
+	LD V1,5
+	SUBN V0,V1
+	LD VE,1
+	SE VF,1
+	LD VE,0
+	CALL report
+	; SUBN borrows when VY < VX
+	LD V0,6
+	LD V1,5
+	SUBN V0,V1
+	LD VE,1
+	SE VF,0
+	LD VE,0
+	SE V0,FF
+	LD VE,0
+	CALL report
+	; the flag of SUBN VF,VY overwrites the result
+	LD V0,5
+	LD VF,3
+	SUBN VF,V0
+	LD VE,1
+	SE VF,1
+	LD VE,0
+	CALL report
+	; SUB with VX = VY doesn't borrow
+	LD V0,5
+	LD V1,5
+	SUB V0,V1
+	LD VE,1
+	SE VF,1
+	LD VE,0
+	SE V0,0
+	LD VE,0
+	CALL report
+	; SHR shifts the low bit into VF
+	LD V0,5
+	SHR V0,V0
+	LD VE,1
+	SE VF,1
+	LD VE,0
+	SE V0,2
+	LD VE,0
+	CALL report
+	; the flag of SHR VF overwrites the result
+	LD VF,5
+	SHR VF,VF
+	LD VE,1
+	SE VF,1
+	LD VE,0
+	CALL report
+	; SHL shifts the high bit into VF
+	LD V0,81
+	SHL V0,V0
+	LD VE,1
+	SE VF,1
+	LD VE,0
+	SE V0,2
+	LD VE,0
+	CALL report
+	; ADD carries into VF
+	LD V0,FF
+	LD V1,1
+	ADD V0,V1
+	LD VE,1
+	SE VF,1
+	LD VE,0
+	SE V0,0
+	LD VE,0
+	CALL report
+done:
+	JP done
+
+; draws the pass or fail marker for VE at VC,VD and moves right
+report:
+	LD I,pass
+	SE VE,1
+	LD I,fail
+	DRW VC,VD,5
+	ADD VC,6
+	RET
+
+pass:
+	DB 00 08 10 A0 40
+fail:
+	DB 88 50 20 50 88
+`
+
+// checkMark and cross are the pass and fail markers drawn by the built-in
+// programs.
+var (
+	checkMark = Marker{0x00, 0x08, 0x10, 0xA0, 0x40}
+	cross     = Marker{0x88, 0x50, 0x20, 0x50, 0x88}
+)
+
+// assemble assembles one of the built-in programs, which are loaded at 0x200.
+func assemble(src string) []byte {
+	prog, err := asm.AssembleString(src, 0x200)
+	if err != nil {
+		// the built-in programs are known to assemble
+		panic(err)
+	}
+	return prog
+}
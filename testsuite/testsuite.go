@@ -0,0 +1,480 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+/*
+Package testsuite runs the ROMs of Timendus' CHIP-8 test suite
+(https://github.com/Timendus/chip8-test-suite) and BestCoder's BC_test
+headlessly and reports which of them pass.
+
+Each test is run on the null driver for a fixed amount of frames in fixed
+timestep mode, pressing keys and skipping menus where needed, so results are
+deterministic and tests run as fast as the host allows. The SHA-1 of the final
+framebuffer is then compared to the reference hash listed in the golden
+directory's hashes.txt, falling back to the hashes.txt built into the package,
+and a test without a reference hash fails. Reference hashes are written by
+running the suite in record mode on a known good configuration, which also
+saves each final screen as a plain text file made of '#' and '.' characters so
+that it can be checked by eye.
+*/
+package testsuite
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// HashFile is the name of the file in the golden directory that holds the
+// framebuffer hashes, one "name sha1" pair per line. Lines starting with #
+// are comments.
+const HashFile = "hashes.txt"
+
+// defaultHashes are the reference hashes checked into the repository, which
+// the golden directory's HashFile overrides.
+//
+//go:embed hashes.txt
+var defaultHashes string
+
+// A KeyPress holds a key down for a number of frames.
+type KeyPress struct {
+	// Frame at which the key is pressed.
+	Frame int
+	// Number of frames the key is held for.
+	Frames int
+	// Key flag, see hachi.Key0 through hachi.KeyF.
+	Key uint16
+}
+
+// A Marker is a sprite a test draws to report the result of a check, one
+// byte per row like the sprites drawn by DRW.
+type Marker []byte
+
+// A Test describes how to run one of the suite's ROMs.
+type Test struct {
+	// Name of the test, also used as the name of the reference screen.
+	Name string
+	// File name of the ROM in the suite's bin directory.
+	File string
+	// Number of 60hz frames to run before reading the result.
+	Frames int
+	// Selection, if non-zero, is written to 0x1FF before starting so that
+	// the ROM skips its menu and runs the selected test right away.
+	Selection uint8
+	// Keys pressed while the test is running.
+	Keys []KeyPress
+	// Optional tests, which aren't part of Timendus' suite, are skipped
+	// when their ROM isn't in the directory.
+	Optional bool
+	// Program, if set, is run instead of File. It's used by the built-in
+	// checks, which test single instructions without a ROM.
+	Program []byte
+	// Check, if set, decides the result from the final state of the
+	// emulator instead of the reference screen. It returns why the test
+	// failed, or nil if it passed.
+	Check func(c *hachi.Chip8) error
+	// Pass and Fail, if set, are the markers the test draws for each check
+	// that passed or failed. The final screen is scanned for them and the
+	// test fails if it shows a Fail marker or no Pass marker, whatever its
+	// hash, and isn't recorded. Only the built-in programs set them, the
+	// suite's ROMs are still checked by their hashes alone.
+	Pass, Fail Marker
+}
+
+// Tests is the list of tests in the order the suite numbers them.
+var Tests = []Test{
+	{Name: "chip8-logo", File: "1-chip8-logo.ch8", Frames: 30},
+	{Name: "ie-logo", File: "2-ie-logo.ch8", Frames: 30},
+	{Name: "corax+", File: "3-corax+.ch8", Frames: 60},
+	{Name: "flags", File: "4-flags.ch8", Frames: 60},
+	{Name: "quirks", File: "5-quirks.ch8", Frames: 300, Selection: 1},
+	{Name: "keypad", File: "6-keypad.ch8", Frames: 90, Selection: 3,
+		Keys: []KeyPress{{Frame: 30, Frames: 10, Key: hachi.Key5}}},
+	// shows BON when every opcode passed, or the number of the failed check
+	// (SUBN and SHR carry edge cases among them)
+	{Name: "bc-test", File: "BC_test.ch8", Frames: 120, Optional: true},
+	// JP V0,NNN jumps to exactly NNN plus V0. V2 holds the same offset so
+	// that the test also passes with the JumpVX quirk.
+	{Name: "jump-v0", Frames: 1, Program: []byte{
+		0x60, 0x04, // 200: LD V0,4
+		0x62, 0x04, // 202: LD V2,4
+		0xB2, 0x08, // 204: JP V0,208
+		0x12, 0x06, // 206: JP 206
+		0x12, 0x08, // 208: JP 208
+		0x12, 0x0A, // 20A: JP 20A
+		0x63, 0x01, // 20C: LD V3,1
+		0x12, 0x0E, // 20E: JP 20E
+	}, Check: func(c *hachi.Chip8) error {
+		if c.V[3] != 1 {
+			return fmt.Errorf("JP V0,208 with V0 = 4 ended up at %03X, "+
+				"expected 20C", c.PC)
+		}
+		return nil
+	}},
+	// draws a check mark for each flag check that passed and a cross for
+	// each that failed
+	{Name: "opcodes", Frames: 60, Program: assemble(opcodesSource),
+		Pass: checkMark, Fail: cross},
+}
+
+// A Status is the outcome of a test.
+type Status int
+
+const (
+	// Pass means the final framebuffer matches the reference hash, or the
+	// test's Check passed.
+	Pass Status = iota
+	// Fail means the final framebuffer differs from the reference hash, or
+	// the test's Check failed.
+	Fail
+	// NoReference means there is no reference hash for the test, which
+	// counts as a failure.
+	NoReference
+	// Error means the test could not be run or the emulator crashed.
+	Error
+	// Recorded means the final screen was saved as the reference screen.
+	Recorded
+	// Skipped means an optional test's ROM wasn't found.
+	Skipped
+)
+
+var statusNames = map[Status]string{
+	Pass:        "pass",
+	Fail:        "FAIL",
+	NoReference: "no reference",
+	Error:       "ERROR",
+	Recorded:    "recorded",
+	Skipped:     "skipped",
+}
+
+func (s Status) String() string { return statusNames[s] }
+
+// A Result is the outcome of running a single test.
+type Result struct {
+	Test   *Test
+	Status Status
+	// Screen is the final screen, rendered as text.
+	Screen string
+	// Hash is the hex SHA-1 of the final framebuffer.
+	Hash string
+	// Err is set when Status is Error, or why the test failed or has no
+	// reference.
+	Err error
+}
+
+// A Runner runs tests from a directory of ROMs.
+type Runner struct {
+	// Dir is the directory containing the suite's ROMs.
+	Dir string
+	// Golden is the directory containing the reference hashes and screens.
+	Golden string
+	// Settings are the emulator settings to test. If nil, DefaultSettings
+	// are used. FixedTimestep is always enabled.
+	Settings *hachi.Chip8Settings
+	// CyclesPerFrame, if non-zero, overrides the amount of instructions
+	// executed every frame.
+	CyclesPerFrame int
+	// Record, when enabled, saves the final framebuffer hashes and screens
+	// as references instead of comparing them.
+	Record bool
+}
+
+// RunAll runs every test in Tests.
+func (r *Runner) RunAll() []Result {
+	results := make([]Result, len(Tests))
+	for i := range Tests {
+		results[i] = r.Run(&Tests[i])
+	}
+	return results
+}
+
+// Run runs a single test and compares or records the hash of its final
+// framebuffer.
+func (r *Runner) Run(t *Test) Result {
+	res := Result{Test: t}
+
+	rom := filepath.Join(r.Dir, t.File)
+	if _, err := os.Stat(rom); t.Optional && os.IsNotExist(err) {
+		res.Status = Skipped
+		return res
+	}
+	c, err := r.run(t)
+	if err != nil {
+		res.Status = Error
+		res.Err = err
+		return res
+	}
+	sum := sha1.Sum(c.Screen)
+	res.Screen, res.Hash = screenText(c), hex.EncodeToString(sum[:])
+	if t.Check != nil {
+		// nothing to record, the check is the reference
+		res.Status = Pass
+		if res.Err = t.Check(c); res.Err != nil {
+			res.Status = Fail
+		}
+		return res
+	}
+	if res.Err = t.readMarkers(c); res.Err != nil {
+		res.Status = Fail
+		return res
+	}
+	screen, hash := res.Screen, res.Hash
+
+	golden := filepath.Join(r.Golden, t.Name+".txt")
+	if r.Record {
+		err = os.WriteFile(golden, []byte(screen), 0644)
+		if err == nil {
+			err = r.recordHash(t.Name, hash)
+		}
+		if err != nil {
+			res.Status = Error
+			res.Err = err
+			return res
+		}
+		res.Status = Recorded
+		return res
+	}
+
+	hashes, err := r.hashes()
+	if err != nil {
+		res.Status = Error
+		res.Err = err
+		return res
+	}
+	expected, ok := hashes[t.Name]
+	switch {
+	case !ok:
+		res.Status = NoReference
+		res.Err = fmt.Errorf("no hash in %s, record one first",
+			filepath.Join(r.Golden, HashFile))
+	case expected == hash:
+		res.Status = Pass
+	default:
+		res.Status = Fail
+	}
+	return res
+}
+
+// hashes reads the reference hashes, which are the defaultHashes
+// overridden by the golden directory's HashFile, if any.
+func (r *Runner) hashes() (map[string]string, error) {
+	res := make(map[string]string)
+	err := readHashes(strings.NewReader(defaultHashes), res)
+	if err == nil {
+		err = r.goldenHashes(res)
+	}
+	return res, err
+}
+
+// goldenHashes adds the hashes in the golden directory's HashFile, if any, to
+// hashes.
+func (r *Runner) goldenHashes(hashes map[string]string) error {
+	f, err := os.Open(filepath.Join(r.Golden, HashFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readHashes(f, hashes)
+}
+
+// readHashes adds the hashes listed in r to hashes.
+func readHashes(r io.Reader, hashes map[string]string) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) == 2 {
+			hashes[fields[0]] = fields[1]
+		}
+	}
+	return scanner.Err()
+}
+
+// recordHash saves hash as the reference hash of the test called name,
+// keeping the others.
+func (r *Runner) recordHash(name, hash string) error {
+	hashes := make(map[string]string)
+	if err := r.goldenHashes(hashes); err != nil {
+		return err
+	}
+	hashes[name] = hash
+	var b bytes.Buffer
+	for _, t := range Tests {
+		if h, ok := hashes[t.Name]; ok {
+			fmt.Fprintln(&b, t.Name, h)
+		}
+	}
+	return os.WriteFile(filepath.Join(r.Golden, HashFile), b.Bytes(), 0644)
+}
+
+// run executes the test and returns the emulator in its final state.
+func (r *Runner) run(t *Test) (c *hachi.Chip8, err error) {
+	settings := *hachi.DefaultSettings
+	if r.Settings != nil {
+		settings = *r.Settings
+	}
+	settings.FixedTimestep = true
+	if r.CyclesPerFrame != 0 {
+		settings.CyclesPerFrame = r.CyclesPerFrame
+	}
+
+	c, err = hachi.New("null", &settings)
+	if err != nil {
+		return
+	}
+	if t.Program != nil {
+		err = c.LoadRaw(t.Program)
+	} else {
+		_, err = c.Load(filepath.Join(r.Dir, t.File))
+	}
+	if err != nil {
+		return
+	}
+	if t.Selection != 0 {
+		c.Memory[0x1FF] = t.Selection
+	}
+
+	for frame := 0; frame < t.Frames; frame++ {
+		c.Keyboard = 0
+		for _, k := range t.Keys {
+			if frame >= k.Frame && frame < k.Frame+k.Frames {
+				c.Keyboard |= k.Key
+			}
+		}
+
+		err = c.AdvanceFrame()
+		if err != nil {
+			err = fmt.Errorf("%s at frame %d: %v", t.Name, frame, err)
+			return
+		}
+	}
+	return
+}
+
+// readMarkers scans the screen for the test's markers and returns why it
+// failed, if it did.
+func (t *Test) readMarkers(c *hachi.Chip8) error {
+	if t.Pass == nil && t.Fail == nil {
+		return nil
+	}
+	passed, failed := countMarker(c, t.Pass), countMarker(c, t.Fail)
+	switch {
+	case failed != 0:
+		return fmt.Errorf("%d of %d checks failed", failed, passed+failed)
+	case passed == 0:
+		return fmt.Errorf("no result on the screen")
+	}
+	return nil
+}
+
+// countMarker returns how many times m is drawn on the screen. Only the
+// columns up to the rightmost pixel of m are compared, so that markers can
+// be drawn next to each other.
+func countMarker(c *hachi.Chip8, m Marker) (n int) {
+	var mask byte
+	for _, row := range m {
+		mask |= row
+	}
+	if mask == 0 {
+		return
+	}
+	mask = ^(mask&-mask - 1)
+
+	width, height := int(c.Width), int(c.Height)
+	for y := 0; y+len(m) <= height; y++ {
+	scan:
+		for x := 0; x < width; x++ {
+			for i, row := range m {
+				if screenByte(c, x, y+i)&mask != row {
+					continue scan
+				}
+			}
+			n++
+		}
+	}
+	return
+}
+
+// screenByte returns the 8 pixels starting at x, y as a sprite row. Pixels
+// past the right edge are off.
+func screenByte(c *hachi.Chip8, x, y int) (b byte) {
+	byteWidth := int(c.Width) / 8
+	for i := 0; i < 8; i++ {
+		if x+i >= int(c.Width) {
+			break
+		}
+		if c.Screen[y*byteWidth+(x+i)/8]&(0x80>>uint((x+i)%8)) != 0 {
+			b |= 0x80 >> uint(i)
+		}
+	}
+	return
+}
+
+// screenText renders the screen as lines of '#' (on) and '.' (off).
+func screenText(c *hachi.Chip8) string {
+	var b bytes.Buffer
+	byteWidth := int(c.Width) / 8
+	for y := 0; y < int(c.Height); y++ {
+		for x := 0; x < int(c.Width); x++ {
+			if c.Screen[y*byteWidth+x/8]&(0x80>>uint(x%8)) != 0 {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// WriteReport writes a table of results followed by the score, which
+// leaves out skipped tests.
+// Returns the number of tests that passed.
+func WriteReport(w io.Writer, results []Result) (passed int) {
+	total := 0
+	tw := new(tabwriter.Writer)
+	tw.Init(w, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, "test\trom\tscreen sha1\tresult\t")
+	for _, res := range results {
+		status := res.Status.String()
+		if res.Err != nil {
+			status += ": " + res.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", res.Test.Name, res.Test.File,
+			res.Hash, status)
+		if res.Status == Pass {
+			passed++
+		}
+		if res.Status != Skipped {
+			total++
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d/%d tests passed\n", passed, total)
+	return
+}
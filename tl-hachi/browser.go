@@ -0,0 +1,198 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	"github.com/Francesco149/go-hachi/romdb"
+	tl "github.com/JoelOtter/termloop"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// rows of programs and preview shown by the ROM browser
+const browserRows = 20
+
+const browserHelp = "up/down select  enter run  esc, enter quit"
+
+// a program found by the ROM browser
+type romEntry struct {
+	path    string
+	title   string
+	size    int
+	preview []string
+}
+
+// listRoms returns the programs in dir sorted by file name, with their
+// titles from the chip8Archive snapshot and the first instructions of their
+// disassembly.
+func listRoms(dir string) (res []romEntry, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	archive := romdb.Snapshot()
+	for _, e := range entries {
+		if e.IsDir() || !hachi.IsROM(e.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		rom, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		info := &hachi.RomInfo{Path: path, Size: len(rom)}
+		info.Metadata = archive.Metadata(info)
+		entry := romEntry{path: path, title: info.Title(), size: len(rom)}
+		addr := 0x200
+		for _, in := range hachi.Disassemble(rom, uint16(addr)) {
+			if len(entry.preview) == browserRows {
+				break
+			}
+			entry.preview = append(entry.preview,
+				fmt.Sprintf("%03X  %s", addr, in))
+			addr += in.Size()
+		}
+		res = append(res, entry)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].path < res[j].path })
+	return
+}
+
+// a romBrowser is a termloop entity that lists programs next to a preview
+// of the selected one's code. The last entry quits without running
+// anything.
+type romBrowser struct {
+	roms     []romEntry
+	selected int
+	top      int // first entry on screen
+	lines    []*tl.Text
+}
+
+func newRomBrowser(roms []romEntry) *romBrowser {
+	b := &romBrowser{roms: roms}
+	// help and rows
+	for i := 0; i < browserRows+1; i++ {
+		b.lines = append(b.lines,
+			tl.NewText(0, i, "", tl.ColorDefault, tl.ColorDefault))
+	}
+	return b
+}
+
+func (b *romBrowser) Draw(s *tl.Screen) {
+	var preview []string
+	if b.selected < len(b.roms) {
+		preview = b.roms[b.selected].preview
+	}
+	text := []string{browserHelp}
+	for i := 0; i < browserRows; i++ {
+		entry := ""
+		mark := " "
+		if b.top+i == b.selected {
+			mark = ">"
+		}
+		switch n := b.top + i; {
+		case n < len(b.roms):
+			entry = fmt.Sprintf("%s %-32.32s %5d", mark, b.roms[n].title,
+				b.roms[n].size)
+		case n == len(b.roms):
+			entry = mark + " (quit)"
+		}
+		code := ""
+		if i < len(preview) {
+			code = preview[i]
+		}
+		text = append(text, fmt.Sprintf("%-40s  %s", entry, code))
+	}
+
+	for i, line := range b.lines {
+		if line.Text() != text[i] {
+			line.SetText(text[i])
+		}
+		line.Draw(s)
+	}
+}
+
+// Tick moves the selection. Enter is the game's end key, so it's handled by
+// termloop.
+func (b *romBrowser) Tick(ev tl.Event) {
+	if ev.Type != tl.EventKey {
+		return
+	}
+	switch ev.Key {
+	case tl.KeyArrowUp:
+		b.selected--
+	case tl.KeyArrowDown:
+		b.selected++
+	case tl.KeyPgup:
+		b.selected -= browserRows
+	case tl.KeyPgdn:
+		b.selected += browserRows
+	case tl.KeyHome:
+		b.selected = 0
+	case tl.KeyEnd, tl.KeyEsc:
+		b.selected = len(b.roms)
+	}
+	// the quit entry is one past the programs
+	if b.selected < 0 {
+		b.selected = 0
+	}
+	if b.selected > len(b.roms) {
+		b.selected = len(b.roms)
+	}
+	if b.selected < b.top {
+		b.top = b.selected
+	}
+	if b.selected >= b.top+browserRows {
+		b.top = b.selected - browserRows + 1
+	}
+}
+
+// browseRoms shows the programs in dir and returns the one picked by the
+// user, or an empty string if they quit.
+func browseRoms(dir string) (string, error) {
+	roms, err := listRoms(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(roms) == 0 {
+		return "", fmt.Errorf("No programs found in %s.", dir)
+	}
+	b := newRomBrowser(roms)
+	g := tl.NewGame()
+	g.SetEndKey(tl.KeyEnter)
+	g.Screen().AddEntity(b)
+	g.Start()
+	if b.selected == len(roms) {
+		return "", nil
+	}
+	return roms[b.selected].path, nil
+}
+
+// browseDir returns the directory to show in the ROM browser when the
+// command line has no programs or a single directory.
+func browseDir(files []string) (string, bool) {
+	switch {
+	case len(files) == 0:
+		return ".", true
+	case len(files) == 1:
+		fi, err := os.Stat(files[0])
+		return files[0], err == nil && fi.IsDir()
+	}
+	return "", false
+}
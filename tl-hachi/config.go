@@ -0,0 +1,226 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// options that can be overridden for a single program in a [rom] table,
+// the rest apply to the whole session
+var romOptionNames = map[string]bool{
+	"layout": true, "variant": true, "quirks": true, "bounds": true,
+	"key-order": true, "random": true, "seed": true, "bad-code": true,
+	"rewind": true, "vip-timing": true, "ips": true, "stack-warn": true,
+	"rotate": true, "flip": true, "zoom": true, "persist": true,
+	"decay": true, "detect-quirks": true, "palette": true,
+}
+
+// config holds the contents of a tl-hachi config file. Top level keys are
+// command line options without the dash, the [keys] table maps host keys
+// to CHIP-8 keys like -keymap and each [rom."name"] table overrides options
+// for the programs whose file name, path or SHA-1 is name.
+type config struct {
+	path    string
+	options map[string]interface{}
+	keys    map[string]interface{}
+	roms    map[string]interface{}
+}
+
+// defaultConfigPath returns the path of the config file that is read when
+// -config isn't given, or an empty string if there is no config directory.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "hachi", "config.toml")
+}
+
+// loadConfig reads the config file at path.
+func loadConfig(path string) (c *config, err error) {
+	c = &config{path: path}
+	if _, err = toml.DecodeFile(path, &c.options); err != nil {
+		return nil, err
+	}
+	if c.keys, err = c.table("keys"); err != nil {
+		return nil, err
+	}
+	if c.roms, err = c.table("rom"); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// table removes the table called name from the top level options and
+// returns it.
+func (c *config) table(name string) (map[string]interface{}, error) {
+	v, ok := c.options[name]
+	if !ok {
+		return nil, nil
+	}
+	delete(c.options, name)
+	t, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Expected '%s' to be a table in %s.", name,
+			c.path)
+	}
+	return t, nil
+}
+
+// sortedKeys returns the keys of m in alphabetical order, so that options
+// are always applied in the same order.
+func sortedKeys(m map[string]interface{}) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// apply sets the flags in fs to the values in values, skipping the ones
+// that were given on the command line. Arrays set a flag once per element,
+// which is how -persist is repeated.
+func (c *config) apply(fs *flag.FlagSet, values map[string]interface{},
+	given map[string]bool) error {
+
+	for _, name := range sortedKeys(values) {
+		if given[name] {
+			continue
+		}
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("Unknown option '%s' in %s.", name, c.path)
+		}
+		list, ok := values[name].([]interface{})
+		if !ok {
+			list = []interface{}{values[name]}
+		}
+		for _, v := range list {
+			if err := fs.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("Invalid option '%s' in %s: %v", name,
+					c.path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyFlags sets the command line options that weren't given on the
+// command line to the values in the config file.
+func (c *config) applyFlags(opts *options) error {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if err := c.apply(flag.CommandLine, c.options, given); err != nil {
+		return err
+	}
+	keys, err := c.keyMap()
+	if err != nil {
+		return err
+	}
+	opts.configKeys = keys
+	return nil
+}
+
+// keyMap returns the [keys] table as bindings in the -keymap syntax.
+func (c *config) keyMap() (string, error) {
+	var bindings []string
+	for _, host := range sortedKeys(c.keys) {
+		key, ok := c.keys[host].(string)
+		if !ok {
+			return "", fmt.Errorf("Expected a key name for '%s' in the "+
+				"keys table of %s.", host, c.path)
+		}
+		bindings = append(bindings, host+"="+key)
+	}
+	return strings.Join(bindings, ","), nil
+}
+
+// romTable returns the [rom] table matching file, or nil if there is none.
+func (c *config) romTable(file string) (map[string]interface{}, error) {
+	if len(c.roms) == 0 {
+		return nil, nil
+	}
+	names := []string{file, filepath.Base(file)}
+	if data, err := os.ReadFile(file); err == nil {
+		sum := sha1.Sum(data)
+		names = append(names, hex.EncodeToString(sum[:]))
+	}
+	for _, name := range names {
+		for k, v := range c.roms {
+			if !strings.EqualFold(k, name) {
+				continue
+			}
+			t, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Expected rom '%s' to be a table in "+
+					"%s.", k, c.path)
+			}
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+// romOptions returns a copy of opts with the overrides for file applied, or
+// opts itself if the config file has none for it.
+func (c *config) romOptions(opts *options, file string) (*options, error) {
+	values, err := c.romTable(file)
+	if err != nil || values == nil {
+		return opts, err
+	}
+	for name := range values {
+		if !romOptionNames[name] {
+			return nil, fmt.Errorf("Option '%s' can't be set for a single "+
+				"program in %s.", name, c.path)
+		}
+	}
+	o := &options{}
+	fs := flag.NewFlagSet(file, flag.ContinueOnError)
+	defineFlags(fs, o)
+	*o = *opts
+	if _, ok := values["persist"]; ok {
+		o.persistent = nil
+	}
+	if err := c.apply(fs, values, nil); err != nil {
+		return nil, err
+	}
+	o.variantSet = o.variantSet || variantGiven(fs)
+	return o, nil
+}
+
+// readConfig loads the config file given with -config, or the default one
+// if it exists, and applies its options to opts.
+func readConfig(opts *options) (err error) {
+	path := opts.configPath
+	if path == "" {
+		path = defaultConfigPath()
+		if _, err = os.Stat(path); path == "" || os.IsNotExist(err) {
+			return nil
+		}
+	}
+	if opts.config, err = loadConfig(path); err != nil {
+		return
+	}
+	return opts.config.applyFlags(opts)
+}
@@ -0,0 +1,276 @@
+/*
+	Copyright 2015 Franc[e]sco (lolisamurai@tfwno.gf)
+	This file is part of go-hachi.
+	go-hachi is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+	go-hachi is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+	You should have received a copy of the GNU General Public License
+	along with go-hachi. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"github.com/Francesco149/go-hachi/hachi"
+	tl "github.com/JoelOtter/termloop"
+	"strconv"
+	"strings"
+)
+
+// lines of disassembly shown around the cursor
+const debuggerLines = 15
+
+const debuggerHelp = "p pause  n step  o over  u out  j/k move  g PC  " +
+	"b breakpoint  : edit"
+
+// a debugger is a termloop entity that shows the disassembly around PC, the
+// registers and the breakpoints of an emulator under its pane, and takes
+// over the keyboard while the emulator is paused to step through the program
+// and edit it.
+type debugger struct {
+	ha     *hachi.Chip8
+	x, y   int
+	cursor uint16 // address the disassembly is centered on
+	follow bool   // cursor follows PC
+	prompt []rune // command being typed, nil if not typing
+	status string
+	lines  []*tl.Text
+}
+
+func newDebugger(ha *hachi.Chip8, x, y int) *debugger {
+	d := &debugger{ha: ha, x: x, y: y, follow: true}
+	// help, registers, disassembly, prompt and status
+	for i := 0; i < debuggerLines+5; i++ {
+		d.lines = append(d.lines,
+			tl.NewText(x, y+i, "", tl.ColorDefault, tl.ColorDefault))
+	}
+	return d
+}
+
+// typing returns true while a command is being typed, so that other
+// entities can ignore the keys.
+func (d *debugger) typing() bool { return d.prompt != nil }
+
+func (d *debugger) Draw(s *tl.Screen) {
+	ha := d.ha
+	if d.follow {
+		d.cursor = ha.PC
+	}
+	state := "running"
+	switch {
+	case ha.Halted() != nil:
+		state = "halted"
+	case ha.Paused():
+		state = "paused"
+	}
+
+	text := []string{
+		fmt.Sprintf("Debugger (%s)  %s", state, debuggerHelp),
+		fmt.Sprintf("PC %04X  I %04X  SP %d  DT %02X  ST %02X  V % 02X",
+			ha.PC, ha.I, ha.SP, ha.DT, ha.ST, ha.V),
+	}
+	text = append(text, d.disassembly()...)
+	if d.prompt != nil {
+		text = append(text, ":"+string(d.prompt)+"_")
+	} else {
+		text = append(text, "")
+	}
+	text = append(text, d.status)
+
+	for i, line := range d.lines {
+		str := ""
+		if i < len(text) {
+			str = text[i]
+		}
+		if line.Text() != str {
+			line.SetText(str)
+		}
+		line.Draw(s)
+	}
+}
+
+// disassembly returns debuggerLines lines of disassembly centered on the
+// cursor. > marks PC and * marks breakpoints.
+func (d *debugger) disassembly() (res []string) {
+	breakpoints := make(map[uint16]bool)
+	for _, addr := range d.ha.Breakpoints() {
+		breakpoints[addr] = true
+	}
+	memory := d.ha.Memory
+	addr := int(d.cursor) - debuggerLines/2*2
+	for addr < 0 {
+		addr += 2
+	}
+	for i := 0; i < debuggerLines; i++ {
+		if addr+2 > len(memory) {
+			res = append(res, "")
+			continue
+		}
+		mark := " "
+		if uint16(addr) == d.ha.PC {
+			mark = ">"
+		}
+		bp := " "
+		if breakpoints[uint16(addr)] {
+			bp = "*"
+		}
+		cursor := " "
+		if uint16(addr) == d.cursor && !d.follow {
+			cursor = "-"
+		}
+		code := memory[addr : addr+2]
+		in, _ := hachi.DisassembleSimple(code)
+		res = append(res, fmt.Sprintf("%s%s%s %04X  %02X%02X  %s", cursor,
+			mark, bp, addr, code[0], code[1], in[0]))
+		addr += 2
+	}
+	return
+}
+
+// Tick handles the debugger keys, only while the emulator is paused.
+func (d *debugger) Tick(ev tl.Event) {
+	if ev.Type != tl.EventKey {
+		return
+	}
+	if d.prompt != nil {
+		d.edit(ev)
+		return
+	}
+	if !d.ha.Paused() {
+		return
+	}
+
+	var err error
+	switch ev.Ch {
+	case 'n':
+		err = d.ha.Step()
+		d.follow = true
+	case 'o':
+		err = d.ha.StepOver()
+		d.follow = true
+	case 'u':
+		err = d.ha.StepOut()
+		d.follow = true
+	case 'j':
+		d.cursor += 2
+		d.follow = false
+	case 'k':
+		d.cursor -= 2
+		d.follow = false
+	case 'g':
+		d.follow = true
+	case 'b':
+		d.toggleBreakpoint()
+		return
+	case ':':
+		d.prompt = []rune{}
+		d.status = "v0-vf, i, pc, sp, dt, st = hex value, e.g. v3=1f"
+		return
+	default:
+		return
+	}
+	d.status = ""
+	if err != nil {
+		d.status = err.Error()
+	}
+}
+
+// toggleBreakpoint adds or removes a breakpoint at the cursor.
+func (d *debugger) toggleBreakpoint() {
+	for _, addr := range d.ha.Breakpoints() {
+		if addr == d.cursor {
+			d.ha.RemoveBreakpoint(addr)
+			d.status = fmt.Sprintf("Removed breakpoint at %03X.", addr)
+			return
+		}
+	}
+	d.ha.AddBreakpoint(d.cursor)
+	d.status = fmt.Sprintf("Added breakpoint at %03X.", d.cursor)
+}
+
+// edit handles the keys typed at the prompt.
+func (d *debugger) edit(ev tl.Event) {
+	switch {
+	case ev.Key == tl.KeyEsc:
+		d.prompt = nil
+		d.status = ""
+	case ev.Key == tl.KeyEnter:
+		d.status = ""
+		if err := d.run(string(d.prompt)); err != nil {
+			d.status = err.Error()
+		}
+		d.prompt = nil
+	case ev.Key == tl.KeyBackspace || ev.Key == tl.KeyBackspace2:
+		if len(d.prompt) > 0 {
+			d.prompt = d.prompt[:len(d.prompt)-1]
+		}
+	case ev.Ch != 0:
+		d.prompt = append(d.prompt, ev.Ch)
+	}
+}
+
+// run runs a command typed at the prompt, which sets a register:
+// "v3=1f", "pc=200", "i=300", "sp=0", "dt=3c" or "st=0". Values are hex,
+// SP can also be set to -1 to empty the stack.
+func (d *debugger) run(cmd string) error {
+	eq := strings.IndexByte(cmd, '=')
+	if eq < 0 {
+		return fmt.Errorf("Expected register=value.")
+	}
+	reg := strings.ToLower(strings.TrimSpace(cmd[:eq]))
+	str := strings.TrimSpace(cmd[eq+1:])
+	if reg == "sp" && str == "-1" {
+		d.ha.SP = -1
+		return nil
+	}
+	value, err := strconv.ParseUint(str, 16, 16)
+	if err != nil {
+		return fmt.Errorf("Invalid value '%s'.", str)
+	}
+
+	ha := d.ha
+	byteValue := func() (uint8, error) {
+		if value > 0xFF {
+			return 0, fmt.Errorf("%s only holds one byte.",
+				strings.ToUpper(reg))
+		}
+		return uint8(value), nil
+	}
+	switch {
+	case len(reg) == 2 && reg[0] == 'v':
+		x, err := strconv.ParseUint(reg[1:], 16, 4)
+		if err != nil {
+			return fmt.Errorf("Unknown register '%s'.", reg)
+		}
+		ha.V[x], err = byteValue()
+		return err
+	case reg == "i":
+		ha.I = uint16(value)
+	case reg == "pc":
+		if int(value)+2 > len(ha.Memory) {
+			return fmt.Errorf("PC %03X is out of memory.", value)
+		}
+		ha.PC = uint16(value)
+		d.follow = true
+	case reg == "sp":
+		if int(value) >= len(ha.Stack) {
+			return fmt.Errorf("SP %d is out of the stack.", value)
+		}
+		ha.SP = int(value)
+	case reg == "dt":
+		ha.DT, err = byteValue()
+		return err
+	case reg == "st":
+		ha.ST, err = byteValue()
+		return err
+	default:
+		return fmt.Errorf("Unknown register '%s'.", reg)
+	}
+	return nil
+}
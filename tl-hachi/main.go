@@ -16,42 +16,636 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"github.com/Francesco149/go-hachi/drivers/beep"
+	_ "github.com/Francesco149/go-hachi/drivers/framedump"
 	_ "github.com/Francesco149/go-hachi/drivers/termloop"
 	"github.com/Francesco149/go-hachi/hachi"
+	"github.com/Francesco149/go-hachi/hachi/asm"
+	"github.com/Francesco149/go-hachi/hachi/debughttp"
+	"github.com/Francesco149/go-hachi/romdb"
 	tl "github.com/JoelOtter/termloop"
+	"image/png"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
-	"text/tabwriter"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// just a wrapper entity to call the emulator's tick function on every frame
-type emulatorWrapper struct{ ha *hachi.Chip8 }
+// how many frames the rewind hotkey goes back
+const rewindStep = 30
+
+// time scale limits for the speed hotkeys
+const (
+	minTimeScale = 1.0 / 8
+	maxTimeScale = 8
+)
+
+// termloop frame rate, which is also the emulator's frame rate at normal speed
+const fps = 60
+
+// just a wrapper entity to run one emulator frame on every termloop frame
+type emulatorWrapper struct {
+	ha *hachi.Chip8
+	// fractional frames left over from previous draws, so that slow motion
+	// still runs one frame every few draws
+	budget float64
+	// set in playlist mode
+	playlist *playlist
+	// set with -debug-http, for the first program only
+	debug *debughttp.Server
+	// set with -debugger, for the first program only
+	debugger *debugger
+	// index of the program, which screenshots are named after
+	pane int
+	// -screenshot-scale
+	shotScale int
+	// the session's logger, which is printed after termloop exits
+	logger *log.Logger
+}
 
 func (e *emulatorWrapper) Draw(s *tl.Screen) {
-	err := e.ha.Tick()
+	// we must use Draw because Tick is only called on input
+	// a halted emulator is left on screen with the error shown by the driver
+	// until the user quits, the error is reported after termloop exits
+	if e.debug != nil {
+		e.debug.Poll()
+	}
+	if e.playlist != nil && e.playlist.err != nil {
+		return
+	}
+	e.budget += e.ha.TimeScale()
+	for ; e.budget >= 1; e.budget-- {
+		if err := e.ha.Frame(); err != nil {
+			// stay on the breakpoint until the debugger resumes
+			_, hit := err.(*hachi.BreakpointHit)
+			if hit && (e.debug != nil || e.debugger != nil) {
+				e.ha.Pause()
+			}
+			if hit && e.debugger != nil {
+				e.debugger.status = err.Error()
+			}
+			e.budget = 0
+			return
+		}
+	}
+}
+
+// Tick handles the speed hotkeys: [ halves the speed, ] doubles it and =
+// resets it. In playlist mode, < and > switch to the previous and next
+// program. With -rewind, backspace goes back half a second. p pauses and
+// resumes, ctrl+r restarts the program and ctrl+s saves a screenshot.
+func (e *emulatorWrapper) Tick(ev tl.Event) {
+	if ev.Type != tl.EventKey {
+		return
+	}
+	if e.debugger != nil && e.debugger.typing() {
+		return
+	}
+	if ev.Key == tl.KeyCtrlR {
+		e.ha.Reset()
+		e.budget = 0
+		return
+	}
+	if ev.Key == tl.KeyCtrlS {
+		if err := e.screenshot(); err != nil {
+			e.logger.Println("screenshot:", err)
+		}
+		return
+	}
+	if ev.Ch == 'p' {
+		if e.ha.Paused() {
+			e.ha.Resume()
+		} else {
+			e.ha.Pause()
+		}
+		return
+	}
+	if ev.Key == tl.KeyBackspace || ev.Key == tl.KeyBackspace2 {
+		// fails harmlessly when rewinding is off or there's no history
+		e.ha.Rewind(rewindStep)
+		return
+	}
+	if e.playlist != nil && (ev.Ch == '<' || ev.Ch == '>') {
+		step := 1
+		if ev.Ch == '<' {
+			step = -1
+		}
+		e.playlist.skip(step)
+		e.budget = 0
+		return
+	}
+	scale := e.ha.TimeScale()
+	switch ev.Ch {
+	case '[':
+		scale /= 2
+	case ']':
+		scale *= 2
+	case '=':
+		scale = 1
+	default:
+		return
+	}
+	if scale < minTimeScale || scale > maxTimeScale {
+		return
+	}
+	e.ha.SetTimeScale(scale)
+}
+
+// screenshot saves the screen to a PNG file in the current directory, named
+// after the time and the program's pane.
+func (e *emulatorWrapper) screenshot() error {
+	name := fmt.Sprintf("hachi-%s-%d.png",
+		time.Now().Format("20060102-150405"), e.pane)
+	f, err := os.Create(name)
 	if err != nil {
-		log.Println(e.ha)
-		log.Fatal(err)
+		return err
 	}
-	// we must use Draw because Tick is only called on input
+	err = e.ha.WriteScreenshot(f,
+		&hachi.ScreenshotOptions{Scale: e.shotScale})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// persistentFlag collects -persist flags in the form addr:size:path
+type persistentFlag []hachi.PersistentRegion
+
+func (p *persistentFlag) String() string { return fmt.Sprint(*p) }
+
+func (p *persistentFlag) Set(value string) error {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("Expected addr:size:path, got '%s'.", value)
+	}
+	addr, err := strconv.ParseUint(parts[0], 0, 16)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseUint(parts[1], 0, 16)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, hachi.PersistentRegion{
+		Address: uint16(addr), Size: uint16(size), Path: parts[2]})
+	return nil
+}
+
+// setBeepFrequency sets the pitch of the audio beeper. It does nothing
+// unless tl-hachi is built with the audio beeper, see audio.go.
+var setBeepFrequency = func(hz int) {}
+
+// command line options
+type options struct {
+	layout     string
+	keymap     string
+	keymapFile string
+	beeper     string
+	beepFreq   int
+	variant    string
+	// set when -variant was given, which turns off variant detection
+	variantSet bool
+	quirks     string
+	bounds     string
+	keyOrder   string
+	random     string
+	seed       int64
+	badCode    string
+	stats      bool
+	rewind     int
+	trace      string
+	record     string
+	replay     string
+	exitState  string
+	vipTiming  bool
+	ips        int
+	stackWarn  int
+	rotate     int
+	flip       string
+	zoom       int
+	palette    string
+	shotScale  int
+	persistent persistentFlag
+	heatmap    string
+	profile    string
+	coverage   string
+	access     string
+	flicker    bool
+	decay      int
+	archive    bool
+	detect     bool
+	disasm     bool
+	disasmFrom string
+	disasmOut  string
+	disasmDir  string
+	format     string
+	asmOut     string
+	callGraph  string
+	benchmark  float64
+	dumpFrames string
+	debugHTTP  string
+	debugger   bool
+	playlist   bool
+	listFile   string
+	configPath string
+	// bindings from the [keys] table of the config file
+	configKeys string
+	config     *config
+}
+
+// an emulator instance and the size of the program it's running
+type instance struct {
+	ha       *hachi.Chip8
+	file     string
+	progSize int64
+}
+
+// what's needed to build the settings of each program
+type session struct {
+	base    hachi.Chip8Settings
+	opts    *options
+	archive *romdb.Archive
+	// one key layout per program, the last one is reused for the rest
+	layouts []string
+	// used by programs that skip invalid instructions and for what's
+	// detected about each program, which can be loaded while termloop is
+	// running
+	logger *log.Logger
+	// set with -replay
+	replay *hachi.InputRecording
+	// set in playlist mode once the emulator is created
+	playlist *hachi.Chip8
+}
+
+// settings returns the settings for the i-th program, which is file.
+func (s *session) settings(file string, i int) (*hachi.Chip8Settings, error) {
+	opts, settings := s.opts, s.base
+	if s.opts.config != nil {
+		o, err := s.opts.config.romOptions(s.opts, file)
+		if err != nil {
+			return nil, err
+		}
+		if o != s.opts {
+			opts = o
+			if settings, err = baseSettings(o); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if settings.BadCode != hachi.BadCodeError {
+		settings.Logger = s.logger
+	}
+	switch {
+	case opts.variantSet:
+	case s.playlist != nil:
+		// the variant can't change at runtime, so the rest of the playlist
+		// runs on the one detected for the first program
+		settings.Variant = s.playlist.Variant()
+		settings.Width, settings.Height = settings.Variant.ScreenSize()
+	default:
+		rom, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if v := hachi.DetectVariant(rom); v != settings.Variant {
+			s.logger.Println("variant:", v)
+			settings.Variant = v
+			settings.Width, settings.Height = v.ScreenSize()
+		}
+	}
+	if opts.detect {
+		rom, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		report := hachi.DetectQuirks(rom)
+		for _, f := range report.Findings {
+			s.logger.Println("quirks:", f)
+		}
+		settings = *report.Apply(&settings)
+		s.logger.Println("quirks:", settings.Quirks)
+	}
+	if s.archive != nil {
+		settings.RomLookup = s.archive.Metadata
+		if p := s.archive.Lookup(file); p != nil {
+			s.logger.Println("chip8Archive:", p)
+			settings = *p.Settings(&settings)
+		}
+		if opts.palette != "" {
+			// the archive's colors are only a default
+			settings.Palette = opts.palette
+		}
+	}
+	settings.KeyLayout = s.layouts[len(s.layouts)-1]
+	if i < len(s.layouts) {
+		settings.KeyLayout = s.layouts[i]
+	}
+	if opts.layout != s.opts.layout {
+		settings.KeyLayout = opts.layout
+	}
+	if i == 0 {
+		settings.Persistent = opts.persistent
+	}
+	if opts.seed != 0 {
+		// every program gets the same random stream
+		settings.Rand = rand.NewSource(opts.seed)
+		settings.RandomSeed = uint16(opts.seed)
+	}
+	if i == 0 && (s.opts.record != "" || s.replay != nil) {
+		// recordings must not depend on the wall clock
+		settings.FixedTimestep = true
+	}
+	if i == 0 && s.replay != nil {
+		settings = *s.replay.Settings(&settings)
+	}
+	return &settings, nil
+}
+
+// a playlist runs programs one at a time in the same emulator instance
+type playlist struct {
+	files   []string
+	current int
+	inst    *instance
+	session *session
+	// set when a program failed to load, which stops the emulator
+	err error
+}
+
+// skip resets the emulator and loads the program step places away from the
+// current one, wrapping around the ends of the playlist.
+func (p *playlist) skip(step int) {
+	p.current = (p.current + step + len(p.files)) % len(p.files)
+	file := p.files[p.current]
+	ha := p.inst.ha
+
+	// the quirks and speed can change from program to program, but the key
+	// layout and persistent memory stay the same. Settings that can't
+	// change are checked before the current program is reset.
+	settings, err := p.session.settings(file, 0)
+	if err == nil {
+		err = ha.Reconfigure(settings)
+	}
+	if err == nil {
+		err = ha.Reset()
+	}
+	if err == nil {
+		p.inst.file = file
+		p.inst.progSize, err = ha.Load(file)
+	}
+	p.err = err
 }
-func (e *emulatorWrapper) Tick(ev tl.Event) {}
 
-func runEmulator(file string) (err error) {
-	// initialize emulator
-	ha, err := hachi.New("termloop", nil)
+// readPlaylist reads a list of programs, one per line. Empty lines and lines
+// starting with # are skipped, relative paths are relative to the playlist.
+func readPlaylist(path string) (res []string, err error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
 		return
 	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+		res = append(res, line)
+	}
+	if len(res) == 0 {
+		err = fmt.Errorf("Playlist %s is empty.", path)
+	}
+	return
+}
 
-	// load program
-	progSize, err := ha.Load(file)
+// baseSettings returns the emulator settings chosen by the command line
+// options, before the per-program ones are applied.
+func baseSettings(opts *options) (s hachi.Chip8Settings, err error) {
+	s = *hachi.DefaultSettings
+	if s.Variant, err = hachi.ParseVariant(opts.variant); err != nil {
+		return
+	}
+	if s.OutOfBounds, err = hachi.ParseBoundsPolicy(opts.bounds); err != nil {
+		return
+	}
+	if s.KeyOrder, err = hachi.ParseKeyOrder(opts.keyOrder); err != nil {
+		return
+	}
+	if s.Random, err = hachi.ParseRandomSource(opts.random); err != nil {
+		return
+	}
+	if s.BadCode, err = hachi.ParseBadCodePolicy(opts.badCode); err != nil {
+		return
+	}
+	if s.Quirks, err = hachi.ParseQuirks(opts.quirks); err != nil {
+		return
+	}
+	if strings.Trim(opts.flip, "hv") != "" {
+		err = fmt.Errorf("Invalid -flip '%s', expected h, v or hv.",
+			opts.flip)
+		return
+	}
+	s.Width, s.Height = s.Variant.ScreenSize()
+	s.PixelDecay = opts.decay
+	s.RewindDepth = opts.rewind * 60
+	s.VIPTiming = opts.vipTiming
+	s.CyclesPerSecond = opts.ips
+	s.StackWarning = opts.stackWarn
+	s.Palette = opts.palette
+	s.Transform = hachi.Transform{
+		FlipH:  strings.Contains(opts.flip, "h"),
+		FlipV:  strings.Contains(opts.flip, "v"),
+		Rotate: opts.rotate,
+		Scale:  opts.zoom,
+	}
+	return
+}
+
+func runEmulator(files []string, opts *options) (err error) {
+	base, err := baseSettings(opts)
 	if err != nil {
 		return
 	}
 
+	var archive *romdb.Archive
+	if opts.archive {
+		var aerr error
+		archive, aerr = (&romdb.Fetcher{}).Load()
+		if aerr != nil {
+			log.Println("chip8Archive:", aerr)
+		}
+	}
+
+	sess := &session{
+		base:    base,
+		opts:    opts,
+		archive: archive,
+		layouts: strings.Split(opts.layout, ","),
+	}
+
+	// the skipped instructions and the detected settings are logged after
+	// termloop exits so they don't mess up the screen
+	var skipped bytes.Buffer
+	sess.logger = log.New(&skipped, "", 0)
+
+	if opts.replay != "" {
+		if sess.replay, err = readRecording(opts.replay); err != nil {
+			return
+		}
+	}
+	if opts.record != "" && opts.seed == 0 {
+		// the recording needs to know the seed
+		opts.seed = rand.Int63n(1<<31) + 1
+	}
+
+	// in playlist mode, only the first program is loaded at startup
+	var list *playlist
+	if opts.playlist {
+		if len(opts.persistent) > 0 && len(files) > 1 {
+			return fmt.Errorf("-persist can't be used with a playlist of " +
+				"more than one program.")
+		}
+		if (opts.record != "" || opts.replay != "") && len(files) > 1 {
+			return fmt.Errorf("-record and -replay can't be used with a " +
+				"playlist of more than one program.")
+		}
+		list = &playlist{files: files, session: sess}
+		files = files[:1]
+	}
+
+	// frames are saved by running framedump alongside termloop
+	driver := "termloop"
+	if opts.dumpFrames != "" {
+		if len(files) > 1 {
+			return fmt.Errorf("-dump-frames only works with one program.")
+		}
+		var d *hachi.CompositeDriver
+		d, err = hachi.NewCompositeDriver("termloop", "framedump")
+		if err != nil {
+			return
+		}
+		driver = "termloop+framedump"
+		if err = hachi.RegisterDriver(driver, d); err != nil {
+			return
+		}
+	}
+
+	var instances []instance
+	for i, file := range files {
+		// initialize emulator
+		var settings *hachi.Chip8Settings
+		settings, err = sess.settings(file, i)
+		if err != nil {
+			return
+		}
+		var ha *hachi.Chip8
+		ha, err = hachi.New(driver, settings)
+		if err != nil {
+			return
+		}
+		if opts.dumpFrames != "" {
+			err = ha.SetDriverData("framedump.dir", opts.dumpFrames)
+			if err != nil {
+				return
+			}
+		}
+
+		// every program after the first one is added as a new pane
+		if i == 0 {
+			err = ha.SetDriverData("split", len(files) > 1)
+			if err != nil {
+				return
+			}
+		}
+
+		// load program
+		var progSize int64
+		progSize, err = ha.Load(file)
+		if err != nil {
+			return
+		}
+		if i == 0 && opts.heatmap != "" {
+			ha.EnableExecCounters()
+		}
+		if i == 0 && opts.profile != "" {
+			ha.EnableProfiler()
+		}
+		if i == 0 && opts.coverage != "" {
+			ha.EnableCoverage()
+		}
+		if i == 0 && opts.access != "" {
+			ha.EnableAccessCounters()
+		}
+		if i == 0 && opts.trace != "" {
+			var f *os.File
+			f, err = os.Create(opts.trace)
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			tracer := hachi.TraceWriter(f)
+			ha.TraceFunc = tracer.Trace
+			defer func() {
+				if terr := tracer.Flush(); terr != nil && err == nil {
+					err = terr
+				}
+			}()
+		}
+		if opts.flicker {
+			ha.EnableFlickerAnalysis()
+		}
+		if i == 0 && sess.replay != nil {
+			ha.ReplayInput(sess.replay)
+		}
+		if i == 0 && opts.record != "" {
+			rec := ha.RecordInput(opts.seed)
+			defer func() {
+				ha.StopInput()
+				if werr := writeRecording(opts.record, rec); err == nil {
+					err = werr
+				}
+			}()
+		}
+
+		instances = append(instances, instance{ha, file, progSize})
+	}
+
+	ha := instances[0].ha
+	setBeepFrequency(opts.beepFreq)
+	if opts.beeper != "" {
+		err = ha.SetDriverData("beeper", opts.beeper)
+		if err != nil {
+			return
+		}
+	}
+	keymap := opts.keymap
+	if opts.keymapFile != "" {
+		var b []byte
+		b, err = os.ReadFile(opts.keymapFile)
+		if err != nil {
+			return
+		}
+		keymap = string(b) + "\n" + keymap
+	}
+	if opts.configKeys != "" {
+		keymap = opts.configKeys + "\n" + keymap
+	}
+	if keymap != "" {
+		err = ha.SetDriverData("key_map", keymap)
+		if err != nil {
+			return
+		}
+	}
+
 	// initialize termloop
 	ctx := ha.GetDriverData("ctx")
 	g, ok := ctx.(*tl.Game)
@@ -62,55 +656,665 @@ func runEmulator(file string) (err error) {
 		return fmt.Errorf("Driver context is nil.")
 	}
 
-	// add emulator entity
-	g.Screen().AddEntity(&emulatorWrapper{ha})
+	g.Screen().SetFps(fps)
+
+	// add emulator entities
+	if list != nil {
+		list.inst = &instances[0]
+		sess.playlist = list.inst.ha
+	}
+	var debug *debughttp.Server
+	if opts.debugHTTP != "" {
+		debug = debughttp.New(ha)
+		if err = debug.ListenAndServe(opts.debugHTTP); err != nil {
+			return
+		}
+		defer debug.Close()
+	}
+	for i, inst := range instances {
+		wrapper := &emulatorWrapper{ha: inst.ha, playlist: list, pane: i,
+			shotScale: opts.shotScale, logger: sess.logger}
+		if i == 0 {
+			wrapper.debug = debug
+		}
+		if i == 0 && opts.debugger {
+			// under the screen and the sound meter, starting paused so
+			// that breakpoints can be set first
+			_, h := inst.ha.DisplaySize()
+			wrapper.debugger = newDebugger(inst.ha, 0, h+8)
+			g.Screen().AddEntity(wrapper.debugger)
+			inst.ha.Pause()
+		}
+		g.Screen().AddEntity(wrapper)
+	}
 
 	// start termloop
 	g.Start()
+	os.Stdout.Write(skipped.Bytes())
+
+	// written last so it's not mixed up with the other output on stdout
+	if opts.exitState != "" {
+		defer func() {
+			werr := writeExitState(opts.exitState, instances, list)
+			if werr != nil && err == nil {
+				err = werr
+			}
+		}()
+	}
+
+	// save persistent memory and report the first crash, if any
+	if list != nil {
+		err = list.err
+	}
+	for _, inst := range instances {
+		if herr := inst.ha.Halted(); herr != nil && err == nil {
+			log.Println(inst.ha.DebugString())
+			err = herr
+		}
+		if serr := inst.ha.Shutdown(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	if opts.heatmap != "" {
+		err = writeHeatmap(opts.heatmap, instances[0])
+		if err != nil {
+			return
+		}
+	}
+	if opts.profile != "" {
+		err = writeProfile(opts.profile, instances[0])
+		if err != nil {
+			return
+		}
+	}
+	if opts.coverage != "" {
+		err = writeCoverage(opts.coverage, instances[0])
+		if err != nil {
+			return
+		}
+	}
+	if opts.access != "" {
+		err = writeAccessHeatmap(opts.access, instances[0])
+		if err != nil {
+			return
+		}
+	}
 
 	// -------
 
-	disassembly, err := hachi.DisassembleSimple(
-		ha.Memory[0x200 : 0x200+progSize])
+	for _, inst := range instances {
+		if opts.flicker {
+			fmt.Println("flicker report:")
+			err = hachi.WriteFlickerReport(os.Stdout, inst.ha.FlickerReport())
+			if err != nil {
+				return
+			}
+			fmt.Println()
+		}
+		if opts.stats {
+			fmt.Println("statistics:")
+			err = hachi.WriteStats(os.Stdout, inst.ha.Stats())
+			if err != nil {
+				return
+			}
+			fmt.Println()
+		}
+		if opts.stackWarn > 0 {
+			fmt.Println("stack usage:")
+			err = hachi.WriteStackStats(os.Stdout, inst.ha.StackStats())
+			if err != nil {
+				return
+			}
+			fmt.Println()
+		}
+
+		err = printDisassembly(inst.ha, inst.progSize)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readRecording loads the input recording saved by -record at path.
+func readRecording(path string) (*hachi.InputRecording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rec, err := hachi.ReadInputRecording(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rec, nil
+}
+
+// writeRecording saves the input recording of -record to path.
+func writeRecording(path string, rec *hachi.InputRecording) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = rec.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeHeatmap saves the execution heatmap of a program, as HTML if the file
+// name ends in .html and as a text listing otherwise.
+func writeHeatmap(path string, inst instance) (err error) {
+	lines, err := inst.ha.Heatmap(inst.ha.StartAddress(), int(inst.progSize))
+	if err != nil {
+		return
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".html") {
+		return hachi.WriteHeatmapHTML(f, lines)
+	}
+	return hachi.WriteHeatmap(f, lines)
+}
+
+// writeProfile saves the profile of a program, as folded call stacks for
+// flame graphs if the file name ends in .folded and as a report otherwise.
+func writeProfile(path string, inst instance) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".folded") {
+		return hachi.WriteProfileFolded(f, inst.ha.Profile())
+	}
+	return hachi.WriteProfile(f, inst.ha.Profile())
+}
+
+// writeCoverage saves the listing of a program where the code that never ran
+// is shown as data.
+func writeCoverage(path string, inst instance) (err error) {
+	f, err := os.Create(path)
 	if err != nil {
 		return
 	}
+	defer f.Close()
 
-	w := new(tabwriter.Writer)
+	start := int(inst.ha.StartAddress())
+	program := inst.ha.Memory[start : start+int(inst.progSize)]
+	return hachi.WriteCoverage(f, program, uint16(start), inst.ha.Coverage())
+}
 
-	w.Init(os.Stdout, 8, 8, 0, '\t', 0)
-	fmt.Fprintln(w, "addr\topcode\tpseudo-code\tascii\tdescription\t")
+// size of the memory access heatmap images: 64 bytes per row, each 4x4
+// pixels, up to the first 64k of memory
+const (
+	accessWidth = 64
+	accessScale = 4
+	accessMax   = 0x10000
+)
 
-	address := 0x200
-	for _, i := range disassembly {
-		asciitext := ""
-		ascii := i.ASCII()
-		if len(ascii) != 0 {
-			asciitext = fmt.Sprintf("`%s`", ascii)
+// writeAccessHeatmap saves the memory accesses of a program, as a PNG image
+// if the file name ends in .png and as CSV otherwise.
+func writeAccessHeatmap(path string, inst instance) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	accesses := inst.ha.MemoryAccesses()
+	if !strings.EqualFold(filepath.Ext(path), ".png") {
+		return hachi.WriteMemoryAccessCSV(f, accesses)
+	}
+	size := len(inst.ha.Memory)
+	if size > accessMax {
+		size = accessMax
+	}
+	return png.Encode(f, hachi.MemoryAccessImage(accesses, 0, size,
+		accessWidth, accessScale))
+}
+
+// exitState is the final state of a program, for scripts
+type exitState struct {
+	Program string `json:"program"`
+	// quit, halted or load (the playlist failed to load the program)
+	Reason     string   `json:"reason"`
+	Error      string   `json:"error,omitempty"`
+	PC         uint16   `json:"pc"`
+	I          uint16   `json:"i"`
+	V          []int    `json:"v"`
+	Stack      []uint16 `json:"stack"`
+	DT         uint8    `json:"dt"`
+	ST         uint8    `json:"st"`
+	ScreenHash string   `json:"screen_sha1"`
+	Cycles     uint64   `json:"cycles"`
+	Frames     uint64   `json:"frames"`
+}
+
+// writeExitState saves the final state of every program as a JSON array to
+// path, or to stdout if path is -
+func writeExitState(path string, instances []instance,
+	list *playlist) (err error) {
+
+	var states []exitState
+	for _, inst := range instances {
+		snap := inst.ha.Snapshot()
+		screen := sha1.Sum(snap.Screen)
+		st := exitState{
+			Program:    inst.file,
+			Reason:     "quit",
+			PC:         snap.PC,
+			I:          snap.I,
+			V:          make([]int, len(snap.V)),
+			Stack:      snap.Stack[:snap.SP+1],
+			DT:         snap.DT,
+			ST:         snap.ST,
+			ScreenHash: hex.EncodeToString(screen[:]),
+			Cycles:     inst.ha.Stats().Cycles,
+			Frames:     inst.ha.Stats().Frames,
+		}
+		for i, v := range snap.V {
+			st.V[i] = int(v)
 		}
+		if herr := inst.ha.Halted(); herr != nil {
+			st.Reason, st.Error = "halted", herr.Error()
+		} else if list != nil && list.err != nil {
+			st.Reason, st.Error = "load", list.err.Error()
+		}
+		states = append(states, st)
+	}
+
+	w := os.Stdout
+	if path != "-" {
+		w, err = os.Create(path)
+		if err != nil {
+			return
+		}
+		defer w.Close()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(states)
+}
+
+func printDisassembly(ha *hachi.Chip8, progSize int64) error {
+	start := int(ha.StartAddress())
+	return hachi.WriteListing(os.Stdout, ha.Memory[start:start+int(progSize)],
+		uint16(start), hachi.ListingText)
+}
+
+// disasmRange parses a -disasm-range in the form from:to, either of which
+// can be omitted to start at start or stop at end.
+func disasmRange(spec string, start, end int) (from, to int, err error) {
+	from, to = start, end
+	if spec == "" {
+		return
+	}
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		err = fmt.Errorf("Expected from:to, got '%s'.", spec)
+		return
+	}
+	bounds := []*int{&from, &to}
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		var addr uint64
+		if addr, err = strconv.ParseUint(part, 0, 16); err != nil {
+			return
+		}
+		*bounds[i] = int(addr)
+	}
+	if from < start || to > end || from >= to {
+		err = fmt.Errorf("Range %03X:%03X is outside of the program "+
+			"(%03X:%03X).", from, to, start, end)
+	}
+	return
+}
+
+// disassembleFile writes the listing of a program without running it.
+func disassembleFile(file string, opts *options) (err error) {
+	variant, err := hachi.ParseVariant(opts.variant)
+	if err != nil {
+		return
+	}
+	format, err := hachi.ParseListingFormat(opts.format)
+	if err != nil {
+		return
+	}
+	program, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	start := int(variant.StartAddress())
+	from, to, err := disasmRange(opts.disasmFrom, start, start+len(program))
+	if err != nil {
+		return
+	}
+	w := os.Stdout
+	if opts.disasmOut != "" {
+		if w, err = os.Create(opts.disasmOut); err != nil {
+			return
+		}
+		defer func() {
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+	return hachi.WriteListing(w, program[from-start:to-start], uint16(from),
+		format)
+}
+
+// benchmarkFiles runs each program headless for -benchmark million cycles
+// and prints how fast it went.
+func benchmarkFiles(files []string, opts *options) error {
+	settings, err := baseSettings(opts)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		rom, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		res, err := hachi.Benchmark(rom, &hachi.BenchmarkOptions{
+			Settings: &settings,
+			Cycles:   uint64(opts.benchmark * 1e6),
+		})
+		if res == nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		fmt.Println(file)
+		if err != nil {
+			fmt.Println("stopped early:", err)
+		}
+		if err = hachi.WriteBenchmark(os.Stdout, res); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	return nil
+}
 
-		opcodeFormatter := "%04X"
-		if i.Size() == 1 {
-			opcodeFormatter = "%02X"
+// disassembleDirs writes listings for every ROM in the given directories
+func disassembleDirs(dirs []string, opts *options) error {
+	format, err := hachi.ParseListingFormat(opts.format)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, dir := range dirs {
+		results, err := hachi.DisassembleDir(dir, opts.disasmDir,
+			&hachi.BatchOptions{Format: format})
+		if err != nil {
+			return err
 		}
+		for _, r := range results {
+			if r.Err != nil {
+				log.Printf("%s: %v", r.ROM, r.Err)
+				failed++
+				continue
+			}
+			log.Println(r.ROM, "->", r.Listing)
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("Failed to disassemble %d ROMs.", failed)
+	}
+	return nil
+}
 
-		fmt.Fprintf(w, "%04X\t"+opcodeFormatter+"\t%v\t%s\t%s\n",
-			address, i.Opcode(), i, asciitext, i.Description())
+// assembleFile assembles a source file into a program for the chosen variant
+func assembleFile(file string, opts *options) error {
+	variant, err := hachi.ParseVariant(opts.variant)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	program, err := asm.Assemble(f, variant.StartAddress())
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	if err = os.WriteFile(opts.asmOut, program, 0644); err != nil {
+		return err
+	}
+	log.Println(file, "->", opts.asmOut, len(program), "bytes")
+	return nil
+}
 
-		address += i.Size()
+// writeCallGraph saves the call graph of a program as a Graphviz DOT file
+func writeCallGraph(file string, opts *options) error {
+	variant, err := hachi.ParseVariant(opts.variant)
+	if err != nil {
+		return err
+	}
+	program, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(opts.callGraph)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
+	graph := hachi.CallGraph(program, variant.StartAddress())
+	return graph.WriteDOT(f)
+}
 
-	w.Flush()
+// variantGiven returns true if -variant was set in fs, on the command line
+// or in the config file.
+func variantGiven(fs *flag.FlagSet) (given bool) {
+	fs.Visit(func(f *flag.Flag) { given = given || f.Name == "variant" })
 	return
 }
 
+// defineFlags defines the command line options on fs, storing them in opts.
+// The config file sets options through the same flags.
+func defineFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.layout, "layout", "", fmt.Sprintf(
+		"key layout, one of %v (default: termloop driver bindings). "+
+			"Comma separated list for multiple programs",
+		hachi.KeyLayoutNames()))
+	fs.StringVar(&opts.keymap, "keymap", "", "custom key bindings for "+
+		"every program, such as 1=Key1,q=Key4,up=Key8 (overrides -layout)")
+	fs.StringVar(&opts.keymapFile, "keymap-file", "", "read custom key "+
+		"bindings from a file, one or more per line (see -keymap)")
+	fs.StringVar(&opts.beeper, "beeper", "", fmt.Sprintf(
+		"beep backend, one of %v (default: silent)", beep.Names()))
+	fs.IntVar(&opts.beepFreq, "beep-freq", 440,
+		"pitch of the audio beep in hz")
+	fs.StringVar(&opts.variant, "variant", "chip8",
+		"CHIP-8 dialect, chip8, chip8x, hires, schip, chip48 or megachip. "+
+			"If not given, hires programs are detected")
+	fs.StringVar(&opts.quirks, "quirks", "modern", "comma separated "+
+		"quirks (vfreset, memory, shift, jump, clip, vblank) or presets "+
+		"(modern, vip, schip, chip48)")
+	fs.StringVar(&opts.bounds, "bounds", "error", "what to do when "+
+		"programs access memory out of bounds, error, wrap or ignore")
+	fs.StringVar(&opts.keyOrder, "key-order", "lowest", "which key "+
+		"LD VX,K gets when several are pressed, lowest, highest or recent")
+	fs.BoolVar(&opts.vipTiming, "vip-timing", false, "run instructions "+
+		"at the speed of the COSMAC VIP interpreter")
+	fs.IntVar(&opts.ips, "ips", 0, "run this many instructions per "+
+		"second instead of a fixed amount per frame")
+	fs.IntVar(&opts.stackWarn, "stack-warn", 0, "print how deep the "+
+		"stack got in each subroutine and whether it exceeded this many "+
+		"levels (12 on the original interpreter)")
+	fs.IntVar(&opts.rotate, "rotate", 0, "rotate the screen clockwise "+
+		"by 90, 180 or 270 degrees")
+	fs.StringVar(&opts.flip, "flip", "", "mirror the screen "+
+		"horizontally (h), vertically (v) or both (hv)")
+	fs.IntVar(&opts.zoom, "zoom", 1, "draw every pixel this many times "+
+		"bigger (max. 8)")
+	fs.StringVar(&opts.palette, "palette", "", fmt.Sprintf(
+		"screen colors, one of %v or a comma separated list of #RRGGBB "+
+			"colors, background first (default: white on black)",
+		hachi.PaletteNames()))
+	fs.IntVar(&opts.shotScale, "screenshot-scale", 8, "size of each "+
+		"pixel in the screenshots saved by ctrl+s")
+	fs.Int64Var(&opts.seed, "seed", 0, "seed the random number "+
+		"generator so every run is the same (0 picks a random seed)")
+	fs.StringVar(&opts.random, "random", "math", "random number "+
+		"generator, math or vip (the COSMAC VIP interpreter's routine)")
+	fs.StringVar(&opts.exitState, "exit-state", "", "write the final "+
+		"registers, timers, stack, screen hash and exit reason of each "+
+		"program as JSON to this file (- for stdout) when exiting")
+	fs.IntVar(&opts.rewind, "rewind", 0, "keep this many seconds of "+
+		"history, which backspace rewinds half a second at a time")
+	fs.BoolVar(&opts.stats, "stats", false, "print runtime statistics "+
+		"(instructions, frames, draws, beeps, key events) when exiting")
+	fs.StringVar(&opts.badCode, "bad-code", "error", "what to do with "+
+		"invalid instructions: error, nop (log and skip them) or skip "+
+		"(also skip the 2 bytes after them)")
+	fs.Var(&opts.persistent, "persist", "addr:size:path, keeps size bytes "+
+		"of memory at addr in a file across sessions (first program only). "+
+		"Can be repeated")
+	fs.StringVar(&opts.trace, "trace", "", "write every instruction "+
+		"run by the first program to this file, to compare execution with "+
+		"other emulators")
+	fs.StringVar(&opts.record, "record", "", "save the keys pressed "+
+		"while running the first program to this file, with the random "+
+		"seed, so that -replay can reproduce the session")
+	fs.StringVar(&opts.replay, "replay", "", "replay the keys saved by "+
+		"-record in this file on the first program, ignoring the keyboard "+
+		"until the recording ends")
+	fs.StringVar(&opts.heatmap, "heatmap", "", "save how many times each "+
+		"instruction of the first program ran to this file when exiting "+
+		"(HTML if it ends in .html)")
+	fs.StringVar(&opts.profile, "profile", "", "save a profile of the "+
+		"first program to this file when exiting, with the hottest "+
+		"addresses, opcode classes and DRW timings (folded call stacks for "+
+		"flame graphs if it ends in .folded)")
+	fs.StringVar(&opts.coverage, "coverage", "", "save a listing of the "+
+		"first program to this file when exiting, where the code that "+
+		"never ran is shown as data")
+	fs.StringVar(&opts.access, "access", "", "save how many times the "+
+		"first program read and wrote each byte of memory to this file "+
+		"when exiting (a heatmap image if it ends in .png, CSV otherwise)")
+	fs.BoolVar(&opts.debugger, "debugger", false, "start paused with a "+
+		"debugger under the first program's screen, see the README")
+	fs.StringVar(&opts.debugHTTP, "debug-http", "", "serve a debugging "+
+		"API for the first program on this address, such as localhost:6060 "+
+		"(see package hachi/debughttp)")
+	fs.BoolVar(&opts.flicker, "flicker", false, "print which DRW "+
+		"instructions cause flicker by erasing and redrawing sprites")
+	fs.BoolVar(&opts.archive, "archive", false, "run programs known to "+
+		"the chip8Archive with their recommended options")
+	fs.BoolVar(&opts.detect, "detect-quirks", false, "guess the shift "+
+		"and memory quirks from the instructions used by each program")
+	fs.BoolVar(&opts.disasm, "disasm", false, "instead of running, "+
+		"print the listing of the given program in the -format format")
+	fs.StringVar(&opts.disasmFrom, "disasm-range", "", "only list the "+
+		"addresses from:to of -disasm (to excluded), such as 0x200:0x300")
+	fs.StringVar(&opts.disasmOut, "disasm-out", "", "write the listing of "+
+		"-disasm to this file instead of stdout")
+	fs.StringVar(&opts.disasmDir, "disasm-dir", "", "instead of running, "+
+		"disassemble every ROM in the given directories into this directory")
+	fs.StringVar(&opts.asmOut, "asm", "", "instead of running, assemble "+
+		"the given source file into this file (see package hachi/asm)")
+	fs.StringVar(&opts.dumpFrames, "dump-frames", "", "also save every "+
+		"frame as a numbered PNG file in this directory")
+	fs.Float64Var(&opts.benchmark, "benchmark", 0, "instead of running, "+
+		"run the given programs headless for this many million cycles and "+
+		"print their speed and the time spent on each opcode class")
+	fs.StringVar(&opts.callGraph, "callgraph", "", "instead of running, "+
+		"save the call graph of the given program to this Graphviz file")
+	fs.StringVar(&opts.format, "format", string(hachi.ListingText),
+		fmt.Sprintf("listing format for -disasm and -disasm-dir, one of %v",
+			hachi.ListingFormats))
+	fs.IntVar(&opts.decay, "decay", 0, "anti-flicker filter, keeps pixels "+
+		"lit for this many frames after they are cleared (0 = off)")
+	fs.BoolVar(&opts.playlist, "playlist", false, "run the programs "+
+		"one at a time instead of in split screen, < and > switch to the "+
+		"previous and next one")
+	fs.StringVar(&opts.listFile, "playlist-file", "", "add the programs "+
+		"listed in this file (one per line) to the playlist, implies "+
+		"-playlist")
+	fs.StringVar(&opts.configPath, "config", "", "read default options, "+
+		"key bindings and per-program options from this TOML file "+
+		"(default: hachi/config.toml in the user config directory)")
+}
+
 func main() {
 	log.SetOutput(os.Stdout)
-	if len(os.Args) != 2 {
-		log.Fatalf("Usage: %s path/to/program", filepath.Base(os.Args[0]))
-		return
+	opts := &options{}
+	defineFlags(flag.CommandLine, opts)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] path/to/program "+
+			"[path/to/program2...]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(os.Stderr, "Multiple programs run in split screen.")
+		fmt.Fprintln(os.Stderr, "Without programs, a ROM browser lists the "+
+			"ones in the current directory (or in the given directory).")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := readConfig(opts); err != nil {
+		log.Fatal(err)
+	}
+	opts.variantSet = variantGiven(flag.CommandLine)
+	files := flag.Args()
+	if opts.listFile != "" {
+		list, err := readPlaylist(opts.listFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		files = append(files, list...)
+		opts.playlist = true
+	}
+	modes := opts.asmOut != "" || opts.callGraph != "" ||
+		opts.disasmDir != "" || opts.disasm || opts.benchmark > 0
+	if dir, ok := browseDir(files); ok && !modes {
+		file, err := browseRoms(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if file == "" {
+			return
+		}
+		files = []string{file}
+	}
+	if len(files) < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var err error
+	switch {
+	case opts.asmOut != "" && len(files) != 1:
+		err = fmt.Errorf("-asm takes exactly one source file.")
+	case opts.asmOut != "":
+		err = assembleFile(files[0], opts)
+	case opts.callGraph != "" && len(files) != 1:
+		err = fmt.Errorf("-callgraph takes exactly one program.")
+	case opts.callGraph != "":
+		err = writeCallGraph(files[0], opts)
+	case opts.benchmark > 0:
+		err = benchmarkFiles(files, opts)
+	case opts.disasm && len(files) != 1:
+		err = fmt.Errorf("-disasm takes exactly one program.")
+	case opts.disasm:
+		err = disassembleFile(files[0], opts)
+	case opts.disasmDir != "":
+		err = disassembleDirs(files, opts)
+	default:
+		err = runEmulator(files, opts)
 	}
-	err := runEmulator(os.Args[1])
 	if err != nil {
 		log.Fatal(err)
 	}